@@ -9,6 +9,7 @@ import (
 
 	internalcli "github.com/flatmapit/crgodicom/internal/cli"
 	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
@@ -20,6 +21,8 @@ var (
 )
 
 func main() {
+	dicom.SetImplementationVersion(Version)
+
 	// Create context with signal handling
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -100,7 +103,9 @@ func main() {
 			internalcli.CreateCheckDCMTKCommand(),
 			internalcli.CreateORMCommand(),
 			internalcli.CreatePACSCFindCommand(),
-			// Future: internalcli.QueryCommand(),
+			internalcli.RetrieveCommand(),
+			internalcli.ServeCommand(),
+			internalcli.ServeWebCommand(),
 		},
 	}
 
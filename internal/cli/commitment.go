@@ -0,0 +1,223 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// Per-instance storage commitment outcomes written to the report
+const (
+	commitmentCommitted = "committed"
+	commitmentFailed    = "failed"
+	commitmentNoResult  = "no-result"
+)
+
+// commitmentReport is the storage commitment report written after send and store
+type commitmentReport struct {
+	TransactionUID string                     `json:"transaction_uid"`
+	StudyUID       string                     `json:"study_uid"`
+	Destination    string                     `json:"destination"`
+	RequestedAt    time.Time                  `json:"requested_at"`
+	CompletedAt    time.Time                  `json:"completed_at"`
+	Committed      int                        `json:"committed"`
+	Failed         int                        `json:"failed"`
+	NoResult       int                        `json:"no_result"`
+	Instances      []commitmentReportInstance `json:"instances"`
+}
+
+// commitmentReportInstance is the commitment outcome of a single instance
+type commitmentReportInstance struct {
+	pacs.ReferencedInstance
+	Status string `json:"status"`
+}
+
+// commitFlags returns the storage commitment flags shared by send and store
+func commitFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "commit",
+			Usage: "Request Storage Commitment for the sent instances and wait for the result",
+		},
+		&cli.IntFlag{
+			Name:  "commit-timeout",
+			Usage: "Seconds to wait for the storage commitment result",
+			Value: 60,
+		},
+		&cli.IntFlag{
+			Name:  "commit-port",
+			Usage: "Release the association and receive the result on a new association at this port (0: wait on the same association)",
+		},
+		&cli.StringFlag{
+			Name:  "commit-ae",
+			Usage: "AE title to receive the result under when --commit-port is set (default: --aec)",
+		},
+		&cli.StringFlag{
+			Name: "commit-report",
+			Usage: "Storage commitment report file (default: storage_commitment.json in the study directory, " +
+				"suffixed with the AE title of each destination of a fan-out send)",
+		},
+	}
+}
+
+// commitmentReportPath returns the --commit-report path or the default in the
+// study directory, suffixed with the destination AE title when one is given
+func commitmentReportPath(c *cli.Context, studyDir, destination string) string {
+	path := c.String("commit-report")
+	if path == "" {
+		path = filepath.Join(studyDir, "storage_commitment.json")
+	}
+	if destination == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_" + destination + ext
+}
+
+// requestStorageCommitment asks the PACS to commit the sent instances, waits
+// for the result and writes the per-instance report. The association is
+// released first when the result is to arrive on a new association.
+func requestStorageCommitment(c *cli.Context, client *pacs.Client, studyUID, reportPath string, sent []pacs.ReferencedInstance) error {
+	cfg, ok := c.Context.Value("config").(*config.Config)
+	if !ok {
+		return fmt.Errorf("configuration not found in context")
+	}
+	if len(sent) == 0 {
+		return fmt.Errorf("no instances were sent, nothing to commit")
+	}
+
+	pacsConfig := client.GetConfig()
+	transactionUID := dicom.NewUIDGenerator(cfg.DICOM.OrgRoot).GenerateInstanceUID()
+	report := &commitmentReport{
+		TransactionUID: transactionUID,
+		StudyUID:       studyUID,
+		Destination:    pacsConfig.AET,
+		RequestedAt:    time.Now(),
+	}
+
+	waitCtx, cancel := context.WithTimeout(c.Context, time.Duration(c.Int("commit-timeout"))*time.Second)
+	defer cancel()
+
+	// Listen for the report on a new association before asking for it
+	var results chan *pacs.CommitmentResult
+	if port := c.Int("commit-port"); port > 0 {
+		commitAE := c.String("commit-ae")
+		if commitAE == "" {
+			commitAE = pacsConfig.AEC
+		}
+
+		results = make(chan *pacs.CommitmentResult, 1)
+		server := pacs.NewServer(&pacs.ServerConfig{AETitle: commitAE, Timeout: pacsConfig.Timeout}, nil)
+		server.SetCommitmentHandler(func(callingAE string, result *pacs.CommitmentResult) {
+			if result.TransactionUID == transactionUID {
+				select {
+				case results <- result:
+				default:
+				}
+			}
+		})
+		if err := server.Listen(fmt.Sprintf(":%d", port)); err != nil {
+			return err
+		}
+
+		serveCtx, stopServer := context.WithCancel(c.Context)
+		served := make(chan struct{})
+		go func() {
+			server.Serve(serveCtx)
+			close(served)
+		}()
+		defer func() {
+			stopServer()
+			<-served
+		}()
+	}
+
+	if err := client.RequestCommitment(c.Context, transactionUID, sent); err != nil {
+		return err
+	}
+
+	var result *pacs.CommitmentResult
+	var err error
+	if results == nil {
+		logrus.Infof("Waiting up to %ds for the storage commitment result on this association", c.Int("commit-timeout"))
+		result, err = client.WaitCommitment(waitCtx, transactionUID)
+	} else {
+		logrus.Infof("Releasing association; waiting up to %ds for the storage commitment result on port %d",
+			c.Int("commit-timeout"), c.Int("commit-port"))
+		client.Disconnect()
+		select {
+		case result = <-results:
+		case <-waitCtx.Done():
+			err = fmt.Errorf("no storage commitment report received: %w", waitCtx.Err())
+		}
+	}
+	if err != nil {
+		logrus.Warnf("%v", err)
+		result = &pacs.CommitmentResult{TransactionUID: transactionUID}
+	}
+
+	report.CompletedAt = time.Now()
+	report.addInstances(sent, result)
+
+	if err := report.write(reportPath); err != nil {
+		return err
+	}
+	logrus.Infof("Storage commitment report written to %s", reportPath)
+
+	fmt.Printf("Storage commitment: %d committed, %d failed, %d without result\n",
+		report.Committed, report.Failed, report.NoResult)
+	if report.Failed > 0 || report.NoResult > 0 {
+		return fmt.Errorf("storage commitment incomplete: %d of %d instances committed", report.Committed, len(sent))
+	}
+	return nil
+}
+
+// addInstances records the commitment outcome of each sent instance
+func (r *commitmentReport) addInstances(sent []pacs.ReferencedInstance, result *pacs.CommitmentResult) {
+	committed := make(map[string]bool)
+	for _, instance := range result.Committed {
+		committed[instance.SOPInstanceUID] = true
+	}
+	failed := make(map[string]pacs.ReferencedInstance)
+	for _, instance := range result.Failed {
+		failed[instance.SOPInstanceUID] = instance
+	}
+
+	for _, instance := range sent {
+		entry := commitmentReportInstance{ReferencedInstance: instance}
+		if failure, ok := failed[instance.SOPInstanceUID]; ok {
+			entry.Status = commitmentFailed
+			entry.FailureReason = failure.FailureReason
+			r.Failed++
+		} else if committed[instance.SOPInstanceUID] {
+			entry.Status = commitmentCommitted
+			r.Committed++
+		} else {
+			entry.Status = commitmentNoResult
+			r.NoResult++
+		}
+		r.Instances = append(r.Instances, entry)
+	}
+}
+
+// write saves the report as JSON
+func (r *commitmentReport) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal storage commitment report: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write storage commitment report: %w", err)
+	}
+	return nil
+}
@@ -15,7 +15,7 @@ func CreateCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "create",
 		Usage: "Create synthetic DICOM studies",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.IntFlag{
 				Name:  "study-count",
 				Usage: "Number of studies to create",
@@ -40,6 +40,14 @@ func CreateCommand() *cli.Command {
 				Name:  "template",
 				Usage: "Study template name",
 			},
+			&cli.StringFlag{
+				Name:  "transfer-syntax",
+				Usage: "Transfer syntax: implicit-le, explicit-le, explicit-be, deflated, rle, jpeg-baseline, jpeg-lossless, jpeg-ls (default: the template's, else implicit-le)",
+			},
+			&cli.StringFlag{
+				Name:  "compression",
+				Usage: "Compress pixel data: rle (RLE Lossless), jpeg-baseline (8-bit, lossy), jpeg-lossless (JPEG Lossless SV1) or jpeg-ls (JPEG-LS Lossless); selects the transfer syntax",
+			},
 			&cli.StringFlag{
 				Name:  "anatomical-region",
 				Usage: "Anatomical region",
@@ -66,8 +74,8 @@ func CreateCommand() *cli.Command {
 				Usage: "Output directory",
 				Value: "studies",
 			},
-		},
-		Action: createAction,
+		}, worklistFlags()...),
+		Action: traced(createAction),
 	}
 }
 
@@ -89,6 +97,15 @@ func createAction(c *cli.Context) error {
 		logrus.Infof("Using template: %s", templateName)
 	}
 
+	transferSyntax, err := resolveTransferSyntax(c, template)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("from-worklist") {
+		return createFromWorklist(c, cfg, template, transferSyntax)
+	}
+
 	// Create study parameters
 	params := StudyCreateParams{
 		StudyCount:       c.Int("study-count"),
@@ -121,17 +138,19 @@ func createAction(c *cli.Context) error {
 	// Create studies
 	for i := 0; i < params.StudyCount; i++ {
 		studyParams := types.StudyParams{
-			StudyCount:       1,
-			SeriesCount:      params.SeriesCount,
-			ImageCount:       params.ImageCount,
-			Modality:         params.Modality,
-			AnatomicalRegion: params.AnatomicalRegion,
-			PatientName:      params.PatientName,
-			PatientID:        params.PatientID,
-			AccessionNumber:  params.AccessionNumber,
-			StudyDescription: params.StudyDescription,
-			OutputDir:        params.OutputDir,
-			Template:         params.Template,
+			StudyCount:        1,
+			SeriesCount:       params.SeriesCount,
+			ImageCount:        params.ImageCount,
+			Modality:          params.Modality,
+			AnatomicalRegion:  params.AnatomicalRegion,
+			PatientName:       params.PatientName,
+			PatientID:         params.PatientID,
+			AccessionNumber:   params.AccessionNumber,
+			StudyDescription:  params.StudyDescription,
+			OutputDir:         params.OutputDir,
+			Template:          params.Template,
+			TemplateName:      c.String("template"),
+			TransferSyntaxUID: transferSyntax,
 		}
 
 		// Generate study
@@ -141,7 +160,7 @@ func createAction(c *cli.Context) error {
 		}
 
 		// Write study to disk
-		if err := writer.WriteStudy(study, params.OutputDir); err != nil {
+		if err := writer.WriteStudy(study, &studyParams, params.OutputDir); err != nil {
 			return fmt.Errorf("failed to write study %d: %w", i+1, err)
 		}
 
@@ -152,6 +171,31 @@ func createAction(c *cli.Context) error {
 	return nil
 }
 
+// resolveTransferSyntax returns the transfer syntax of --compression or
+// --transfer-syntax, falling back to the template's and then to Implicit VR
+// Little Endian
+func resolveTransferSyntax(c *cli.Context, template *config.TemplateConfig) (string, error) {
+	if compression := c.String("compression"); compression != "" {
+		if c.IsSet("transfer-syntax") {
+			return "", fmt.Errorf("--compression selects the transfer syntax and cannot be combined with --transfer-syntax")
+		}
+		uid, err := dicom.ParseTransferSyntax(compression)
+		if err != nil || !dicom.IsEncapsulatedTransferSyntax(uid) {
+			return "", fmt.Errorf("invalid compression '%s'. Valid compressions: %v", compression, []string{"rle", "jpeg-baseline", "jpeg-lossless", "jpeg-ls"})
+		}
+		return uid, nil
+	}
+
+	name := c.String("transfer-syntax")
+	if name == "" && template != nil {
+		name = template.TransferSyntax
+	}
+	if name == "" {
+		return dicom.ImplicitVRLittleEndian, nil
+	}
+	return dicom.ParseTransferSyntax(name)
+}
+
 // StudyCreateParams represents parameters for study creation
 type StudyCreateParams struct {
 	StudyCount       int
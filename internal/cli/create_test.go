@@ -43,6 +43,44 @@ func TestCreateCommand(t *testing.T) {
 			args: []string{"create", "--image-count", "0"},
 			wantErr: true,
 		},
+		{
+			name: "explicit big endian",
+			args: []string{"create", "--modality", "CT", "--transfer-syntax", "explicit-be"},
+			wantErr: false,
+		},
+		{
+			name: "invalid transfer syntax",
+			args: []string{"create", "--transfer-syntax", "jpeg"},
+			wantErr: true,
+			errMsg: "unsupported transfer syntax",
+		},
+		{
+			name: "rle compression",
+			args: []string{"create", "--modality", "CT", "--compression", "rle"},
+			wantErr: false,
+		},
+		{
+			name: "jpeg baseline compression",
+			args: []string{"create", "--modality", "MR", "--compression", "jpeg-baseline"},
+			wantErr: false,
+		},
+		{
+			name: "jpeg-ls compression",
+			args: []string{"create", "--modality", "CT", "--compression", "jpeg-ls"},
+			wantErr: false,
+		},
+		{
+			name: "invalid compression",
+			args: []string{"create", "--compression", "explicit-le"},
+			wantErr: true,
+			errMsg: "invalid compression",
+		},
+		{
+			name: "compression with transfer syntax",
+			args: []string{"create", "--compression", "rle", "--transfer-syntax", "explicit-le"},
+			wantErr: true,
+			errMsg: "cannot be combined",
+		},
 	}
 
 	for _, tt := range tests {
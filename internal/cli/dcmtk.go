@@ -18,48 +18,23 @@ func DCMTKCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "dcmtk",
 		Usage: "Send DICOM files using DCMTK storescu subprocess (100% compatibility)",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:     "study-id",
 				Usage:    "Study Instance UID (required)",
 				Required: true,
 			},
-			&cli.StringFlag{
-				Name:  "host",
-				Usage: "PACS host address",
-				Value: "localhost",
-			},
-			&cli.IntFlag{
-				Name:  "port",
-				Usage: "PACS port",
-				Value: 4242,
-			},
-			&cli.StringFlag{
-				Name:  "aec",
-				Usage: "Application Entity Caller",
-				Value: "DICOM_CLIENT",
-			},
-			&cli.StringFlag{
-				Name:  "aet",
-				Usage: "Application Entity Title",
-				Value: "PACS1",
-			},
 			&cli.StringFlag{
 				Name:  "output-dir",
 				Usage: "Studies directory",
 				Value: "studies",
 			},
-			&cli.IntFlag{
-				Name:  "timeout",
-				Usage: "Connection timeout in seconds",
-				Value: 30,
-			},
 			&cli.BoolFlag{
 				Name:  "verbose",
 				Usage: "Verbose DCMTK output",
 				Value: false,
 			},
-		},
+		}, destinationFlags()...),
 		Action: dcmtkAction,
 	}
 }
@@ -68,8 +43,17 @@ func dcmtkAction(c *cli.Context) error {
 	studyID := c.String("study-id")
 	outputDir := c.String("output-dir")
 
+	pacsConfig, err := resolveDestination(c, "")
+	if err != nil {
+		return err
+	}
+
+	if pacsConfig.TLS.Enabled {
+		return fmt.Errorf("the dcmtk command does not support TLS destinations; use send or store")
+	}
+
 	logrus.Infof("Sending study %s using DCMTK storescu to PACS %s:%d",
-		studyID, c.String("host"), c.Int("port"))
+		studyID, pacsConfig.Host, pacsConfig.Port)
 
 	// Check DCMTK availability using the manager
 	if err := CheckDCMTKAvailability(); err != nil {
@@ -91,7 +75,7 @@ func dcmtkAction(c *cli.Context) error {
 
 	// Test connectivity first with echoscu
 	logrus.Info("Testing PACS connectivity with DCMTK echoscu...")
-	if err := runEchoSCU(c.String("host"), c.Int("port"), c.String("aec"), c.String("aet"), c.Bool("verbose")); err != nil {
+	if err := runEchoSCU(pacsConfig.Host, pacsConfig.Port, pacsConfig.AEC, pacsConfig.AET, c.Bool("verbose")); err != nil {
 		return fmt.Errorf("PACS connectivity test failed: %w", err)
 	}
 
@@ -102,7 +86,7 @@ func dcmtkAction(c *cli.Context) error {
 	for i, filePath := range dicomFiles {
 		logrus.Infof("Sending file %d/%d via DCMTK storescu: %s", i+1, len(dicomFiles), filepath.Base(filePath))
 
-		if err := runStoreSCU(c.String("host"), c.Int("port"), c.String("aec"), c.String("aet"), filePath, c.Bool("verbose")); err != nil {
+		if err := runStoreSCU(pacsConfig.Host, pacsConfig.Port, pacsConfig.AEC, pacsConfig.AET, filePath, c.Bool("verbose")); err != nil {
 			logrus.Errorf("Failed to send %s via DCMTK: %v", filepath.Base(filePath), err)
 			continue
 		}
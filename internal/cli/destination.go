@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/urfave/cli/v2"
+)
+
+// connectionFlags returns the flags that select the peer of a network
+// command: a named destination from the configuration and the connection
+// settings that override it. Flag names are prefixed with prefix.
+func connectionFlags(prefix, peer string) []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  prefix + "dest",
+			Usage: fmt.Sprintf("Named %s destination from the configuration (default: default_pacs)", peer),
+		},
+		&cli.StringFlag{
+			Name:  prefix + "host",
+			Usage: peer + " host address",
+		},
+		&cli.IntFlag{
+			Name:  prefix + "port",
+			Usage: peer + " port",
+		},
+		&cli.StringFlag{
+			Name:  prefix + "aec",
+			Usage: "Application Entity Caller",
+		},
+		&cli.StringFlag{
+			Name:  prefix + "aet",
+			Usage: peer + " Application Entity Title",
+		},
+		&cli.IntFlag{
+			Name:  prefix + "timeout",
+			Usage: "Connection timeout in seconds",
+		},
+		&cli.BoolFlag{
+			Name:  prefix + "tls",
+			Usage: fmt.Sprintf("Connect to the %s over TLS (registered port %d); implied by the other TLS flags", peer, pacs.DefaultTLSPort),
+		},
+		&cli.StringFlag{
+			Name:  prefix + "tls-server-name",
+			Usage: "Name to verify the server certificate against (default: the host)",
+		},
+		&cli.StringFlag{
+			Name:  prefix + "username",
+			Usage: "Username to present in the User Identity of the association",
+		},
+		&cli.StringFlag{
+			Name:  prefix + "password",
+			Usage: "Passcode to present with --" + prefix + "username",
+		},
+		&cli.StringFlag{
+			Name:  prefix + "jwt",
+			Usage: "JSON Web Token to present in the User Identity of the association",
+		},
+		&cli.BoolFlag{
+			Name:  prefix + "identity-response",
+			Usage: "Require the " + peer + " to acknowledge the User Identity",
+		},
+	}, tlsFlags(prefix)...)
+}
+
+// tlsFlags returns the certificate flags shared by TLS clients and servers
+func tlsFlags(prefix string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  prefix + "tls-ca",
+			Usage: "PEM CA bundle to verify the peer certificate with",
+		},
+		&cli.StringFlag{
+			Name:  prefix + "tls-cert",
+			Usage: "PEM certificate to present to the peer",
+		},
+		&cli.StringFlag{
+			Name:  prefix + "tls-key",
+			Usage: "PEM private key of --" + prefix + "tls-cert",
+		},
+		&cli.StringFlag{
+			Name:  prefix + "tls-min-version",
+			Usage: "Minimum TLS version: 1.2 or 1.3",
+		},
+	}
+}
+
+// applyTLSFlags overrides the TLS settings with the prefixed TLS flags set on
+// the command line; any of them enables TLS
+func applyTLSFlags(c *cli.Context, prefix string, tlsConfig *config.TLSConfig) {
+	settings := []struct {
+		flag  string
+		value *string
+	}{
+		{"tls-ca", &tlsConfig.CAFile},
+		{"tls-cert", &tlsConfig.CertFile},
+		{"tls-key", &tlsConfig.KeyFile},
+		{"tls-server-name", &tlsConfig.ServerName},
+		{"tls-min-version", &tlsConfig.MinVersion},
+	}
+	for _, setting := range settings {
+		if c.IsSet(prefix + setting.flag) {
+			*setting.value = c.String(prefix + setting.flag)
+			tlsConfig.Enabled = true
+		}
+	}
+	if c.IsSet(prefix + "tls") {
+		tlsConfig.Enabled = c.Bool(prefix + "tls")
+	}
+}
+
+// applyIdentityFlags overrides the User Identity with the prefixed identity
+// flags set on the command line. A username or token replaces the configured
+// identity as a whole, so its type is inferred again.
+func applyIdentityFlags(c *cli.Context, prefix string, identity *config.IdentityConfig) {
+	if c.IsSet(prefix+"username") || c.IsSet(prefix+"jwt") {
+		*identity = config.IdentityConfig{
+			Username:         c.String(prefix + "username"),
+			Password:         c.String(prefix + "password"),
+			Token:            c.String(prefix + "jwt"),
+			PositiveResponse: identity.PositiveResponse,
+		}
+	} else if c.IsSet(prefix + "password") {
+		identity.Password = c.String(prefix + "password")
+	}
+	if c.IsSet(prefix + "identity-response") {
+		identity.PositiveResponse = c.Bool(prefix + "identity-response")
+	}
+}
+
+// destinationFlags returns the connection flags of commands talking to a PACS
+func destinationFlags() []cli.Flag {
+	return connectionFlags("", "PACS")
+}
+
+// resolveDestinations returns the PACS configurations selected by the
+// prefixed --dest flag, or the default PACS, with the connection flags set
+// on the command line applied to each
+func resolveDestinations(c *cli.Context, prefix string) ([]config.PACSConfig, error) {
+	cfg, ok := c.Context.Value("config").(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("configuration not found in context")
+	}
+
+	destinations, err := cfg.ResolveDestination(c.String(prefix + "dest"))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range destinations {
+		dest := &destinations[i]
+		if c.IsSet(prefix + "host") {
+			dest.Host = c.String(prefix + "host")
+		}
+		if c.IsSet(prefix + "port") {
+			dest.Port = c.Int(prefix + "port")
+		}
+		if c.IsSet(prefix + "aec") {
+			dest.AEC = c.String(prefix + "aec")
+		}
+		if c.IsSet(prefix + "aet") {
+			dest.AET = c.String(prefix + "aet")
+		}
+		if c.IsSet(prefix + "timeout") {
+			dest.Timeout = c.Int(prefix + "timeout")
+		}
+		applyTLSFlags(c, prefix, &dest.TLS)
+		applyIdentityFlags(c, prefix, &dest.Identity)
+
+		if dest.Host == "" || dest.Port == 0 || dest.AEC == "" || dest.AET == "" {
+			return nil, fmt.Errorf("PACS connection requires host, port, aec, and aet parameters")
+		}
+	}
+	return destinations, nil
+}
+
+// resolveDestination returns the single PACS configuration selected by the
+// prefixed --dest flag, rejecting fan-out lists
+func resolveDestination(c *cli.Context, prefix string) (*config.PACSConfig, error) {
+	destinations, err := resolveDestinations(c, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(destinations) != 1 {
+		return nil, fmt.Errorf("destination %q is a list of %d destinations; %s connects to one",
+			c.String(prefix+"dest"), len(destinations), c.Command.Name)
+	}
+	return &destinations[0], nil
+}
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestResolveDestinations(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected []config.PACSConfig
+		wantErr  bool
+	}{
+		{
+			name:     "default PACS",
+			args:     []string{"echo"},
+			expected: []config.PACSConfig{{Host: "localhost", Port: 11112, AEC: "CRGODICOM", AET: "PACS_SERVER", Timeout: 30}},
+		},
+		{
+			name:     "named destination",
+			args:     []string{"echo", "--dest", "orthanc_2"},
+			expected: []config.PACSConfig{{Host: "localhost", Port: 4901, AEC: "CRGODICOM", AET: "ORTHANC2", Timeout: 30}},
+		},
+		{
+			name:     "flags override the destination",
+			args:     []string{"echo", "--dest", "orthanc_1", "--host", "pacs.local", "--aec", "CT01"},
+			expected: []config.PACSConfig{{Host: "pacs.local", Port: 4900, AEC: "CT01", AET: "ORTHANC1", Timeout: 30}},
+		},
+		{
+			name: "fan-out list",
+			args: []string{"echo", "--dest", "all-test", "--timeout", "5"},
+			expected: []config.PACSConfig{
+				{Host: "localhost", Port: 4900, AEC: "CRGODICOM", AET: "ORTHANC1", Timeout: 5},
+				{Host: "localhost", Port: 4901, AEC: "CRGODICOM", AET: "ORTHANC2", Timeout: 5},
+			},
+		},
+		{
+			name: "TLS flags enable TLS",
+			args: []string{"echo", "--port", "2762", "--tls-ca", "ca.pem", "--tls-server-name", "pacs.example.com"},
+			expected: []config.PACSConfig{{Host: "localhost", Port: 2762, AEC: "CRGODICOM", AET: "PACS_SERVER", Timeout: 30,
+				TLS: config.TLSConfig{Enabled: true, CAFile: "ca.pem", ServerName: "pacs.example.com"}}},
+		},
+		{
+			name: "user identity",
+			args: []string{"echo", "--username", "tech", "--password", "secret", "--identity-response"},
+			expected: []config.PACSConfig{{Host: "localhost", Port: 11112, AEC: "CRGODICOM", AET: "PACS_SERVER", Timeout: 30,
+				Identity: config.IdentityConfig{Username: "tech", Password: "secret", PositiveResponse: true}}},
+		},
+		{
+			name:    "unknown destination",
+			args:    []string{"echo", "--dest", "nowhere"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resolved []config.PACSConfig
+			var resolveErr error
+			app := &cli.App{
+				Before: func(c *cli.Context) error {
+					c.Context = context.WithValue(c.Context, "config", config.DefaultConfig())
+					return nil
+				},
+				Commands: []*cli.Command{{
+					Name:  "echo",
+					Flags: destinationFlags(),
+					Action: func(c *cli.Context) error {
+						resolved, resolveErr = resolveDestinations(c, "")
+						return nil
+					},
+				}},
+			}
+
+			assert.NoError(t, app.Run(append([]string{"crgodicom"}, tt.args...)))
+			if tt.wantErr {
+				assert.Error(t, resolveErr)
+				return
+			}
+			assert.NoError(t, resolveErr)
+			assert.Equal(t, tt.expected, resolved)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicomweb"
+	"github.com/urfave/cli/v2"
+)
+
+// protocolFlags returns the flags selecting between DIMSE and DICOMweb
+func protocolFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "protocol",
+			Usage: "Transport: dimse (C-STORE, C-FIND, C-GET/C-MOVE) or dicomweb (STOW-RS, QIDO-RS, WADO-RS)",
+			Value: "dimse",
+		},
+		&cli.StringFlag{
+			Name:  "dicomweb-url",
+			Usage: "Base URL of the DICOMweb service (default: dicomweb.url of the destination)",
+		},
+	}
+}
+
+// validateProtocol checks the --protocol flag
+func validateProtocol(c *cli.Context) error {
+	switch c.String("protocol") {
+	case "dimse", "dicomweb":
+		return nil
+	}
+	return fmt.Errorf("unsupported protocol %q (supported: dimse, dicomweb)", c.String("protocol"))
+}
+
+// newDICOMwebClient creates a client of the DICOMweb service of a destination,
+// which --dicomweb-url overrides
+func newDICOMwebClient(c *cli.Context, pacsConfig *config.PACSConfig) (*dicomweb.Client, error) {
+	webConfig := pacsConfig.DICOMweb
+	if c.IsSet("dicomweb-url") {
+		webConfig.URL = c.String("dicomweb-url")
+	}
+	if webConfig.URL == "" {
+		return nil, fmt.Errorf("destination %s has no DICOMweb URL: set dicomweb.url in the configuration or --dicomweb-url", pacsConfig.AET)
+	}
+	return dicomweb.NewClient(webConfig, time.Duration(pacsConfig.Timeout)*time.Second)
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestRetrieveDICOMweb(t *testing.T) {
+	files := writeTestInstances(t, t.TempDir(), 3)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/dicom-web/studies/1.2.3", r.URL.Path)
+		parts := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", `multipart/related; type="application/dicom"; boundary=`+parts.Boundary())
+		for _, file := range files {
+			data, _ := os.ReadFile(file)
+			part, _ := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/dicom"}})
+			part.Write(data)
+		}
+		parts.Close()
+	}))
+	defer server.Close()
+
+	app := &cli.App{
+		Before: func(c *cli.Context) error {
+			c.Context = context.WithValue(c.Context, "config", config.DefaultConfig())
+			return nil
+		},
+		Commands: []*cli.Command{RetrieveCommand()},
+	}
+	outputDir := filepath.Join(t.TempDir(), "studies")
+	err := app.Run([]string{"crgodicom", "retrieve", "--study-uid", "1.2.3",
+		"--protocol", "dicomweb", "--dicomweb-url", server.URL + "/dicom-web", "--output-dir", outputDir})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	instances, err := dicom.NewStudyStore(outputDir, "CRGODICOM").Instances("1.2.3")
+	assert.NoError(t, err)
+	assert.Len(t, instances, 3)
+	assert.Contains(t, instances, "1.2.3.1.2")
+}
+
+func TestStudiesFromMatches(t *testing.T) {
+	match := func(studyUID, modality string) *dicom.Dataset {
+		ds := dicom.NewDataset()
+		ds.SetString(dicom.TagStudyInstanceUID, studyUID)
+		ds.SetString(dicom.TagModality, modality)
+		return ds
+	}
+
+	studies := studiesFromMatches([]*dicom.Dataset{match("1.2.3", "CT"), match("1.2.3", "SR"), match("1.2.4", "MR")})
+	if assert.Len(t, studies, 2) {
+		assert.Equal(t, "1.2.3", studies[0].StudyInstanceUID)
+		assert.Equal(t, "CT", studies[0].Modality)
+		assert.Equal(t, "MR", studies[1].Modality)
+	}
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/flatmapit/crgodicom/internal/config"
@@ -13,57 +14,46 @@ import (
 // EchoCommand returns the C-ECHO command
 func EchoCommand() *cli.Command {
 	return &cli.Command{
-		Name:  "echo",
-		Usage: "Send C-ECHO request to PACS server",
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:  "host",
-				Usage: "PACS host address",
-				Value: "localhost",
-			},
-			&cli.IntFlag{
-				Name:  "port",
-				Usage: "PACS port",
-				Value: 4242,
-			},
-			&cli.StringFlag{
-				Name:  "aec",
-				Usage: "Application Entity Caller",
-				Value: "DICOM_CLIENT",
-			},
-			&cli.StringFlag{
-				Name:  "aet",
-				Usage: "Application Entity Title",
-				Value: "PACS1",
-			},
-			&cli.IntFlag{
-				Name:  "timeout",
-				Usage: "Connection timeout in seconds",
-				Value: 30,
-			},
-		},
-		Action: echoAction,
+		Name:   "echo",
+		Usage:  "Send C-ECHO request to PACS server",
+		Flags:  append(destinationFlags(), traceFlags()...),
+		Action: traced(echoAction),
 	}
 }
 
 func echoAction(c *cli.Context) error {
-	logrus.Infof("Sending C-ECHO to PACS %s:%d (AEC: %s, AET: %s)",
-		c.String("host"), c.Int("port"), c.String("aec"), c.String("aet"))
+	destinations, err := resolveDestinations(c, "")
+	if err != nil {
+		return err
+	}
 
-	// Create PACS configuration
-	pacsConfig := &config.PACSConfig{
-		Host:    c.String("host"),
-		Port:    c.Int("port"),
-		AEC:     c.String("aec"),
-		AET:     c.String("aet"),
-		Timeout: c.Int("timeout"),
+	// A fan-out destination is echoed member by member
+	failed := 0
+	for i := range destinations {
+		if err := echoPACS(c.Context, &destinations[i]); err != nil {
+			if len(destinations) == 1 {
+				return err
+			}
+			logrus.Errorf("C-ECHO to %s failed: %v", destinations[i].AET, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("C-ECHO failed for %d of %d destinations", failed, len(destinations))
 	}
+	return nil
+}
+
+// echoPACS associates with a PACS and sends a C-ECHO request
+func echoPACS(ctx context.Context, pacsConfig *config.PACSConfig) error {
+	logrus.Infof("Sending C-ECHO to PACS %s:%d (AEC: %s, AET: %s)",
+		pacsConfig.Host, pacsConfig.Port, pacsConfig.AEC, pacsConfig.AET)
 
 	// Create PACS client
 	client := pacs.NewClient(pacsConfig)
 
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(pacsConfig.Timeout)*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(pacsConfig.Timeout)*time.Second)
 	defer cancel()
 
 	// Connect to PACS
@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/sirupsen/logrus"
+)
+
+// transferJournalFile is the name of the transfer journal in the study directory
+const transferJournalFile = "transfer_journal.jsonl"
+
+// journalEntry records the outcome of one attempt to send an instance to a destination
+type journalEntry struct {
+	SOPInstanceUID string    `json:"sop_instance_uid"`
+	CallingAE      string    `json:"calling_ae,omitempty"`
+	DestinationAE  string    `json:"destination_ae"`
+	Address        string    `json:"address"`
+	File           string    `json:"file"`
+	Status         string    `json:"status"`
+	StatusCode     uint16    `json:"status_code"`
+	ErrorComment   string    `json:"error_comment,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// transferJournal is an append-only log of send outcomes kept in the study
+// directory, so that an interrupted send can resume without resending the
+// instances a destination already acknowledged. Acknowledgements count only
+// for the same calling AE, destination AE and address; the source study is
+// the one the journal belongs to. A nil journal records nothing.
+type transferJournal struct {
+	mu           sync.Mutex
+	file         *os.File
+	callingAE    string
+	destination  string
+	address      string
+	acknowledged map[string]bool
+}
+
+// openTransferJournal opens the journal of a study directory and loads the
+// instances the destination has acknowledged to the calling AE
+func openTransferJournal(studyDir string, pacsConfig *config.PACSConfig) (*transferJournal, error) {
+	return openJournal(studyDir, pacsConfig.AEC, pacsConfig.AET, fmt.Sprintf("%s:%d", pacsConfig.Host, pacsConfig.Port))
+}
+
+// openJournal opens the journal of a study directory for the destination
+// reached at address, which is host:port for DIMSE and the URL for DICOMweb.
+// callingAE is empty for DICOMweb, which has no calling AE.
+func openJournal(studyDir, callingAE, destination, address string) (*transferJournal, error) {
+	path := filepath.Join(studyDir, transferJournalFile)
+	j := &transferJournal{
+		callingAE:    callingAE,
+		destination:  destination,
+		address:      address,
+		acknowledged: make(map[string]bool),
+	}
+
+	if err := j.load(path); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transfer journal: %w", err)
+	}
+	if err := terminateLastLine(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to repair transfer journal: %w", err)
+	}
+	j.file = file
+	return j, nil
+}
+
+// terminateLastLine ends a truncated last line, so that the next entry is
+// appended on a line of its own rather than onto the broken one
+func terminateLastLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = file.Write([]byte{'\n'})
+	}
+	return err
+}
+
+// load replays the journal; the latest entry of an instance decides whether it is acknowledged
+func (j *transferJournal) load(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read transfer journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A send killed mid-write leaves a truncated last line
+			logrus.Warnf("Ignoring invalid transfer journal line %d: %v", line, err)
+			continue
+		}
+		if entry.CallingAE != j.callingAE || entry.DestinationAE != j.destination || entry.Address != j.address {
+			continue
+		}
+		category := pacs.StatusCategory(entry.Status)
+		j.acknowledged[entry.SOPInstanceUID] = category == pacs.CategorySuccess || category == pacs.CategoryWarning
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read transfer journal: %w", err)
+	}
+	return nil
+}
+
+// isAcknowledged reports whether the destination already acknowledged the instance
+func (j *transferJournal) isAcknowledged(sopInstanceUID string) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.acknowledged[sopInstanceUID]
+}
+
+// record appends the outcome of a send attempt to the journal
+func (j *transferJournal) record(result *sendResult) {
+	if j == nil || result.Instance.SOPInstanceUID == "" {
+		return
+	}
+
+	entry := journalEntry{
+		SOPInstanceUID: result.Instance.SOPInstanceUID,
+		CallingAE:      j.callingAE,
+		DestinationAE:  j.destination,
+		Address:        j.address,
+		File:           filepath.Base(result.File),
+		Status:         string(result.Category),
+		StatusCode:     result.Status.Code,
+		ErrorComment:   result.Status.ErrorComment,
+		Timestamp:      time.Now().UTC(),
+	}
+	if result.Err != nil {
+		entry.Error = result.Err.Error()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logrus.Errorf("Failed to encode transfer journal entry: %v", err)
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.acknowledged[entry.SOPInstanceUID] = result.stored()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		logrus.Errorf("Failed to write transfer journal: %v", err)
+	}
+}
+
+// Close closes the journal file
+func (j *transferJournal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
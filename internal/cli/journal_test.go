@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferJournalResumes(t *testing.T) {
+	dir := t.TempDir()
+	dest := &config.PACSConfig{Host: "pacs", Port: 11112, AET: "PACS1", AEC: "MODALITY1"}
+	other := &config.PACSConfig{Host: "pacs", Port: 11112, AET: "PACS2", AEC: "MODALITY1"}
+	otherCaller := &config.PACSConfig{Host: "pacs", Port: 11112, AET: "PACS1", AEC: "MODALITY2"}
+
+	result := func(sop string, status pacs.Status, err error) *sendResult {
+		r := &sendResult{File: sop + ".dcm", Instance: pacs.ReferencedInstance{SOPInstanceUID: sop}}
+		r.setOutcome(status, err)
+		return r
+	}
+
+	journal, err := openTransferJournal(dir, dest)
+	if !assert.NoError(t, err) {
+		return
+	}
+	journal.record(result("1.1", pacs.Status{Code: pacs.StatusSuccess}, nil))
+	journal.record(result("1.2", pacs.Status{Code: 0xB000}, nil))
+	journal.record(result("1.3", pacs.Status{}, &pacs.StatusError{Status: pacs.Status{Code: 0xA700}}))
+	// A later failure overrides an earlier acknowledgement
+	journal.record(result("1.4", pacs.Status{Code: pacs.StatusSuccess}, nil))
+	journal.record(result("1.4", pacs.Status{}, &pacs.StatusError{Status: pacs.Status{Code: 0xA900}}))
+	assert.NoError(t, journal.Close())
+
+	// A send killed mid-write leaves a truncated line behind
+	f, err := os.OpenFile(filepath.Join(dir, transferJournalFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if assert.NoError(t, err) {
+		f.WriteString(`{"sop_instance_uid":"1.5","destin`)
+		f.Close()
+	}
+
+	resumed, err := openTransferJournal(dir, dest)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, resumed.isAcknowledged("1.1"))
+	assert.True(t, resumed.isAcknowledged("1.2"))
+	assert.False(t, resumed.isAcknowledged("1.3"))
+	assert.False(t, resumed.isAcknowledged("1.4"))
+	assert.False(t, resumed.isAcknowledged("1.5"))
+
+	// Entries appended after the truncated line survive the next resume
+	resumed.record(result("1.5", pacs.Status{Code: pacs.StatusSuccess}, nil))
+	assert.NoError(t, resumed.Close())
+	resumed, err = openTransferJournal(dir, dest)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resumed.Close()
+	assert.True(t, resumed.isAcknowledged("1.1"))
+	assert.True(t, resumed.isAcknowledged("1.5"))
+
+	// Acknowledgements are per destination and calling AE
+	for _, elsewhereConfig := range []*config.PACSConfig{other, otherCaller} {
+		elsewhere, err := openTransferJournal(dir, elsewhereConfig)
+		if assert.NoError(t, err) {
+			assert.False(t, elsewhere.isAcknowledged("1.1"))
+			assert.NoError(t, elsewhere.Close())
+		}
+	}
+
+	var none *transferJournal
+	assert.False(t, none.isAcknowledged("1.1"))
+	none.record(result("1.1", pacs.Status{}, nil))
+	assert.NoError(t, none.Close())
+}
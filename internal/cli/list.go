@@ -1,12 +1,15 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
@@ -72,8 +75,6 @@ func listAction(c *cli.Context) error {
 		return nil
 	}
 
-	// TODO: Implement actual study listing
-	// For now, just show directory structure
 	studies, err := listStudies(outputDir)
 	if err != nil {
 		return fmt.Errorf("failed to list studies: %w", err)
@@ -114,34 +115,31 @@ type StudyInfo struct {
 func listStudies(outputDir string) ([]StudyInfo, error) {
 	var studies []StudyInfo
 
-	// Walk through the studies directory
-	err := filepath.Walk(outputDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return nil, err
+	}
 
+	for _, entry := range entries {
 		// Look for study directories (directories with UID-like names)
-		if info.IsDir() && path != outputDir {
-			// Check if this looks like a study directory
-			studyUID := filepath.Base(path)
-			if isUIDFormat(studyUID) {
-				studyInfo, err := getStudyInfo(path)
-				if err != nil {
-					logrus.Warnf("Failed to read study info for %s: %v", studyUID, err)
-					// Still include it but with minimal info
-					studies = append(studies, StudyInfo{
-						StudyUID: studyUID,
-					})
-				} else {
-					studies = append(studies, studyInfo)
-				}
-			}
+		studyUID := entry.Name()
+		if !entry.IsDir() || !isUIDFormat(studyUID) {
+			continue
 		}
 
-		return nil
-	})
+		studyInfo, err := getStudyInfo(filepath.Join(outputDir, studyUID))
+		if err != nil {
+			logrus.Warnf("Failed to read study info for %s: %v", studyUID, err)
+			// Still include it but with minimal info
+			studies = append(studies, StudyInfo{
+				StudyUID: studyUID,
+			})
+		} else {
+			studies = append(studies, studyInfo)
+		}
+	}
 
-	return studies, err
+	return studies, nil
 }
 
 // isUIDFormat checks if a string looks like a DICOM UID
@@ -154,25 +152,57 @@ func isUIDFormat(s string) bool {
 	return true
 }
 
-// getStudyInfo reads study information from directory
+// getStudyInfo reads study information from the study manifest, falling
+// back to parsing the DICOM files of studies without one
 func getStudyInfo(studyPath string) (StudyInfo, error) {
-	studyUID := filepath.Base(studyPath)
+	manifest, err := dicom.ReadManifest(studyPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("Ignoring study manifest of %s: %v", studyPath, err)
+		}
+		return readStudyInfo(studyPath)
+	}
 
-	// TODO: Read actual DICOM metadata from files
-	// For now, return placeholder info
 	return StudyInfo{
-		StudyUID:         studyUID,
-		PatientName:      "DOE^JOHN^M",
-		PatientID:        "P123456",
-		StudyDate:        "20250101",
-		StudyDescription: "Generated Study",
-		SeriesCount:      1,
-		ImageCount:       1,
-		Modality:         "CR",
-		AccessionNumber:  "ACC123456",
+		StudyUID:         manifest.Study.StudyInstanceUID,
+		PatientName:      manifest.Patient.Name,
+		PatientID:        manifest.Patient.ID,
+		StudyDate:        manifest.Study.StudyDate,
+		StudyDescription: manifest.Study.StudyDescription,
+		SeriesCount:      len(manifest.Series),
+		ImageCount:       manifest.InstanceCount(),
+		Modality:         strings.Join(manifest.Modalities(), "\\"),
+		AccessionNumber:  manifest.Study.AccessionNumber,
 	}, nil
 }
 
+// readStudyInfo reads study information from the DICOM files of a study
+func readStudyInfo(studyPath string) (StudyInfo, error) {
+	study, err := dicom.ReadStudy(studyPath)
+	if err != nil {
+		return StudyInfo{}, err
+	}
+
+	info := StudyInfo{
+		StudyUID:         study.StudyInstanceUID,
+		PatientName:      study.PatientName,
+		PatientID:        study.PatientID,
+		StudyDate:        study.StudyDate,
+		StudyDescription: study.StudyDescription,
+		SeriesCount:      len(study.Series),
+		AccessionNumber:  study.AccessionNumber,
+	}
+	var modalities []string
+	for _, series := range study.Series {
+		info.ImageCount += len(series.Images)
+		if series.Modality != "" && !slices.Contains(modalities, series.Modality) {
+			modalities = append(modalities, series.Modality)
+		}
+	}
+	info.Modality = strings.Join(modalities, "\\")
+	return info, nil
+}
+
 // displayStudiesTable displays studies in table format
 func displayStudiesTable(studies []StudyInfo, verbose bool) {
 	if verbose {
@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// mppsFlags returns the Modality Performed Procedure Step flags of send
+func mppsFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "mpps",
+			Usage: "Report the send as a Modality Performed Procedure Step (N-CREATE IN PROGRESS, then N-SET COMPLETED)",
+		},
+		&cli.StringFlag{
+			Name:  "mpps-station",
+			Usage: "Performed Station AE Title (default: --aec)",
+		},
+	}
+}
+
+// startPerformedProcedureStep creates an IN PROGRESS performed procedure step
+// from the patient, study and request attributes of the study's first file
+func startPerformedProcedureStep(c *cli.Context, cfg *config.Config, client *pacs.Client, dicomFiles []string) (*pacs.PerformedProcedureStep, error) {
+	if len(dicomFiles) == 0 {
+		return nil, fmt.Errorf("no DICOM files to report in the performed procedure step")
+	}
+
+	file, err := dicom.ReadFile(dicomFiles[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dicomFiles[0], err)
+	}
+
+	pacsConfig := client.GetConfig()
+	step := performedProcedureStep(file.Dataset)
+	step.SOPInstanceUID = dicom.NewUIDGenerator(cfg.DICOM.OrgRoot).GenerateInstanceUID()
+	step.ID = time.Now().Format("PPS060102150405")
+	step.StationAETitle = c.String("mpps-station")
+	if step.StationAETitle == "" {
+		step.StationAETitle = pacsConfig.AEC
+	}
+	step.RetrieveAETitle = pacsConfig.AET
+
+	if err := client.CreatePerformedProcedureStep(c.Context, step); err != nil {
+		return nil, err
+	}
+	return step, nil
+}
+
+// finishPerformedProcedureStep completes the step, or discontinues it when nothing was sent
+func finishPerformedProcedureStep(c *cli.Context, client *pacs.Client, step *pacs.PerformedProcedureStep) error {
+	status := pacs.MPPSCompleted
+	if len(step.Series) == 0 {
+		status = pacs.MPPSDiscontinued
+	}
+
+	if err := client.UpdatePerformedProcedureStep(c.Context, step, status); err != nil {
+		return err
+	}
+
+	instances := 0
+	for _, series := range step.Series {
+		instances += len(series.Instances)
+	}
+	logrus.Infof("Performed procedure step %s %s", step.SOPInstanceUID, status)
+	fmt.Printf("MPPS %s: %s with %d series, %d instances\n", step.ID, status, len(step.Series), instances)
+	return nil
+}
+
+// performedProcedureStep reads the step attributes from an instance of the study
+func performedProcedureStep(ds *dicom.Dataset) *pacs.PerformedProcedureStep {
+	step := &pacs.PerformedProcedureStep{
+		Description:                   ds.String(dicom.TagStudyDescription),
+		StationName:                   ds.String(dicom.TagStationName),
+		PatientName:                   ds.String(dicom.TagPatientName),
+		PatientID:                     ds.String(dicom.TagPatientID),
+		PatientBirthDate:              ds.String(dicom.TagPatientBirthDate),
+		PatientSex:                    ds.String(dicom.TagPatientSex),
+		StudyInstanceUID:              ds.String(dicom.TagStudyInstanceUID),
+		StudyID:                       ds.String(dicom.TagStudyID),
+		AccessionNumber:               ds.String(dicom.TagAccessionNumber),
+		RequestedProcedureDescription: ds.String(dicom.TagRequestedProcedureDescription),
+		Modality:                      ds.String(dicom.TagModality),
+	}
+
+	// Studies created from a worklist reference the scheduled step
+	if requests := ds.Sequence(dicom.TagRequestAttributesSequence); len(requests) > 0 {
+		step.RequestedProcedureID = requests[0].String(dicom.TagRequestedProcedureID)
+		step.ScheduledProcedureStepID = requests[0].String(dicom.TagScheduledProcedureStepID)
+	}
+	return step
+}
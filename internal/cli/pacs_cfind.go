@@ -1,15 +1,21 @@
 package cli
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/flatmapit/crgodicom/internal/config"
 	"github.com/flatmapit/crgodicom/internal/dcmtk"
+	"github.com/flatmapit/crgodicom/internal/dicom"
 	"github.com/flatmapit/crgodicom/internal/orm"
 	"github.com/flatmapit/crgodicom/internal/orm/generator"
 	"github.com/flatmapit/crgodicom/internal/orm/parser"
+	"github.com/flatmapit/crgodicom/internal/pacs"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
@@ -22,6 +28,12 @@ func CreatePACSCFindCommand() *cli.Command {
 		Aliases: []string{"pacs", "cfind"},
 		Description: `Generate DICOM study templates by querying a PACS server using DICOM CFIND operations.
 
+Queries are sent with the built-in C-FIND client (Study Root model), so DCMTK
+is not required. Use --use-dcmtk to query with DCMTK findscu instead, or
+--protocol dicomweb to search the destination's DICOMweb service with QIDO-RS.
+QIDO-RS searches can also run at the series or instance level (--level); the
+matches are grouped by study before templates are generated.
+
 This command allows you to:
 - Query PACS for studies by Study Instance UID
 - Query PACS for studies by patient ID, name, or date range
@@ -37,6 +49,10 @@ Examples:
   crgodicom pacs-cfind --patient-id "12345" \
     --host pacs.hospital.local --port 4242 --aec CLIENT --aet PACS
 
+  # Search a DICOMweb archive for CT series of a patient with QIDO-RS
+  crgodicom pacs-cfind --patient-id "12345" --modality CT --level series \
+    --protocol dicomweb --dicomweb-url https://archive.example.com/dicom-web
+
   # Generate template from existing CFIND response file
   crgodicom pacs-cfind --input response.json --output template.yaml
 
@@ -44,7 +60,7 @@ Examples:
   crgodicom pacs-cfind --study-uid "1.2.840.113619.2.5.1762583153.215519.978957063.78" \
     --save-response response.json --host pacs.hospital.local --port 4242 --aec CLIENT --aet PACS
 `,
-		Flags: []cli.Flag{
+		Flags: append(append([]cli.Flag{
 			// Input options
 			&cli.StringFlag{
 				Name:    "input",
@@ -81,34 +97,12 @@ Examples:
 				Usage:   "Modality to query from PACS (CT, MR, CR, etc.)",
 				Aliases: []string{"mod"},
 			},
-
-			// PACS connection options
 			&cli.StringFlag{
-				Name:  "host",
-				Usage: "PACS host address",
-				Value: "localhost",
-			},
-			&cli.IntFlag{
-				Name:  "port",
-				Usage: "PACS port",
-				Value: 4242,
-			},
-			&cli.StringFlag{
-				Name:  "aec",
-				Usage: "Application Entity Caller",
-				Value: "DICOM_CLIENT",
-			},
-			&cli.StringFlag{
-				Name:  "aet",
-				Usage: "Application Entity Title",
-				Value: "PACS1",
-			},
-			&cli.IntFlag{
-				Name:  "timeout",
-				Usage: "Connection timeout in seconds",
-				Value: 30,
+				Name:  "level",
+				Usage: "Query level: study, or series and instance with --protocol dicomweb",
+				Value: "study",
 			},
-
+		}, append(destinationFlags(), protocolFlags()...)...), append([]cli.Flag{
 			// Output options
 			&cli.StringFlag{
 				Name:    "output",
@@ -158,8 +152,12 @@ Examples:
 				Name:  "test-connection",
 				Usage: "Test PACS connection with C-ECHO before querying",
 			},
-		},
-		Action: pacsCFindAction,
+			&cli.BoolFlag{
+				Name:  "use-dcmtk",
+				Usage: "Use DCMTK findscu/echoscu instead of the built-in DICOM client",
+			},
+		}, traceFlags()...)...),
+		Action: traced(pacsCFindAction),
 	}
 }
 
@@ -169,20 +167,19 @@ func pacsCFindAction(c *cli.Context) error {
 		return err
 	}
 
-	// Check DCMTK availability
-	if err := CheckDCMTKAvailability(); err != nil {
-		return fmt.Errorf("DCMTK not available: %w", err)
-	}
-
-	// Get DCMTK path
-	dcmtkManager := dcmtk.NewManager()
-	dcmtkInfo := dcmtkManager.GetInstallationInfo()
+	pacsParser := parser.NewPACSParser("")
+	if c.Bool("use-dcmtk") {
+		// Check DCMTK availability
+		if err := CheckDCMTKAvailability(); err != nil {
+			return fmt.Errorf("DCMTK not available: %w", err)
+		}
 
-	var pacsParser *parser.PACSParser
-	if dcmtkInfo.Bundled {
-		pacsParser = parser.NewPACSParser(dcmtkInfo.Path)
-	} else {
-		pacsParser = parser.NewPACSParser("") // Will use system PATH
+		// Get DCMTK path
+		dcmtkManager := dcmtk.NewManager()
+		dcmtkInfo := dcmtkManager.GetInstallationInfo()
+		if dcmtkInfo.Bundled {
+			pacsParser = parser.NewPACSParser(dcmtkInfo.Path)
+		}
 	}
 
 	// Test PACS connection if requested
@@ -246,20 +243,29 @@ func validatePACSCFindInput(c *cli.Context) error {
 		return fmt.Errorf("must specify either --input, --study-uid, --patient-id, --patient-name, --study-date, or --accession-number")
 	}
 
+	if err := validateProtocol(c); err != nil {
+		return err
+	}
+	level, err := pacs.ParseQueryLevel(c.String("level"))
+	if err != nil || level == pacs.QueryLevelPatient {
+		return fmt.Errorf("invalid level '%s'. Valid levels: study, series, instance", c.String("level"))
+	}
+	if c.String("protocol") == "dicomweb" {
+		if c.Bool("use-dcmtk") || c.Bool("test-connection") {
+			return fmt.Errorf("--use-dcmtk and --test-connection require --protocol dimse")
+		}
+	} else if level != pacs.QueryLevelStudy {
+		return fmt.Errorf("--level %s requires --protocol dicomweb", c.String("level"))
+	}
+
 	// If querying PACS, need connection parameters
 	if input == "" {
-		host := c.String("host")
-		aec := c.String("aec")
-		aet := c.String("aet")
-
-		if host == "" {
-			return fmt.Errorf("--host is required for PACS queries")
-		}
-		if aec == "" {
-			return fmt.Errorf("--aec is required for PACS queries")
+		pacsConfig, err := resolveDestination(c, "")
+		if err != nil {
+			return err
 		}
-		if aet == "" {
-			return fmt.Errorf("--aet is required for PACS queries")
+		if c.Bool("use-dcmtk") && pacsConfig.TLS.Enabled {
+			return fmt.Errorf("--use-dcmtk does not support TLS destinations")
 		}
 	}
 
@@ -268,23 +274,172 @@ func validatePACSCFindInput(c *cli.Context) error {
 
 // testPACSConnection tests PACS connectivity using C-ECHO
 func testPACSConnection(c *cli.Context) error {
-	host := c.String("host")
-	port := c.Int("port")
-	aec := c.String("aec")
-	aet := c.String("aet")
+	pacsConfig, err := resolveDestination(c, "")
+	if err != nil {
+		return err
+	}
 
-	logrus.Infof("Testing PACS connection to %s:%d (AEC: %s, AET: %s)", host, port, aec, aet)
+	logrus.Infof("Testing PACS connection to %s:%d (AEC: %s, AET: %s)", pacsConfig.Host, pacsConfig.Port, pacsConfig.AEC, pacsConfig.AET)
 
-	// Use existing echoscu functionality
-	return runEchoSCU(host, port, aec, aet, c.Bool("verbose"))
+	if c.Bool("use-dcmtk") {
+		// Use existing echoscu functionality
+		return runEchoSCU(pacsConfig.Host, pacsConfig.Port, pacsConfig.AEC, pacsConfig.AET, c.Bool("verbose"))
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context, time.Duration(pacsConfig.Timeout)*time.Second)
+	defer cancel()
+
+	client := pacs.NewClient(pacsConfig)
+	if err := client.Connect(ctx); err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	return client.CEcho(ctx)
 }
 
 // queryPACS queries PACS using the specified parameters
 func queryPACS(pacsParser *parser.PACSParser, c *cli.Context) ([]orm.ModelDefinition, error) {
-	// This is a simplified implementation
-	// In a full implementation, we would use the DCMTK findscu command
-	// For now, we'll return an error indicating this needs to be implemented
-	return nil, fmt.Errorf("direct PACS querying not yet implemented - use --input with a Study Instance UID or saved CFIND response file")
+	pacsConfig, err := resolveDestination(c, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Bool("use-dcmtk") {
+		studyUID := c.String("study-uid")
+		if studyUID == "" {
+			return nil, fmt.Errorf("--use-dcmtk queries require --study-uid")
+		}
+		return pacsParser.QueryWithFindSCU(pacsConfig.Host, pacsConfig.Port, pacsConfig.AEC, pacsConfig.AET, studyUID, c.Bool("verbose"))
+	}
+
+	var studies []parser.PACSStudy
+	if c.String("protocol") == "dicomweb" {
+		studies, err = searchDICOMweb(c, pacsConfig)
+	} else {
+		studies, err = findStudies(c, pacsConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, study := range studies {
+		logrus.Infof("Found study %s (patient: %s, date: %s)", study.StudyInstanceUID, study.PatientName, study.StudyDate)
+	}
+
+	if path := c.String("save-response"); path != "" && len(studies) > 0 {
+		data, err := json.MarshalIndent(studies, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode CFIND response: %w", err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to save CFIND response: %w", err)
+		}
+		logrus.Infof("💾 Saved CFIND response to %s", path)
+	}
+
+	return pacsParser.ConvertStudies(studies)
+}
+
+// findStudies queries the PACS for studies with C-FIND
+func findStudies(c *cli.Context, pacsConfig *config.PACSConfig) ([]parser.PACSStudy, error) {
+	ctx, cancel := context.WithTimeout(c.Context, time.Duration(pacsConfig.Timeout)*time.Second)
+	defer cancel()
+
+	client := pacs.NewClient(pacsConfig)
+	if err := client.Connect(ctx); err != nil {
+		return nil, err
+	}
+	defer client.Disconnect()
+
+	var studies []parser.PACSStudy
+	for result := range client.CFind(ctx, pacs.QueryLevelStudy, buildStudyQuery(c)) {
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		studies = append(studies, parser.PACSStudyFromDataset(result.Dataset))
+	}
+	return studies, nil
+}
+
+// searchDICOMweb searches the DICOMweb service of the destination with
+// QIDO-RS at the --level query level
+func searchDICOMweb(c *cli.Context, pacsConfig *config.PACSConfig) ([]parser.PACSStudy, error) {
+	client, err := newDICOMwebClient(c, pacsConfig)
+	if err != nil {
+		return nil, err
+	}
+	level, err := pacs.ParseQueryLevel(c.String("level"))
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Searching %s with QIDO-RS at the %s level", client.URL(), strings.ToLower(string(level)))
+	matches, err := client.Search(c.Context, level, buildQuery(c, level))
+	if err != nil {
+		return nil, err
+	}
+	return studiesFromMatches(matches), nil
+}
+
+// studiesFromMatches converts query matches to PACS studies. Series and
+// instance level matches carry the attributes of their study, so the first
+// match of each study stands for it.
+func studiesFromMatches(matches []*dicom.Dataset) []parser.PACSStudy {
+	var studies []parser.PACSStudy
+	seen := make(map[string]bool)
+	for _, match := range matches {
+		study := parser.PACSStudyFromDataset(match)
+		if seen[study.StudyInstanceUID] {
+			continue
+		}
+		seen[study.StudyInstanceUID] = true
+		studies = append(studies, study)
+	}
+	return studies
+}
+
+// buildQuery builds the identifier of a query at the given level: the study
+// level identifier, with the modality matched per series below the study level
+func buildQuery(c *cli.Context, level pacs.QueryLevel) *dicom.Dataset {
+	query := buildStudyQuery(c)
+	if level == pacs.QueryLevelStudy {
+		return query
+	}
+
+	query.SetString(dicom.TagModalitiesInStudy, "")
+	query.SetString(dicom.TagModality, c.String("modality"))
+	query.SetString(dicom.TagSeriesInstanceUID, "")
+	query.SetString(dicom.TagSeriesNumber, "")
+	query.SetString(dicom.TagSeriesDescription, "")
+	query.SetString(dicom.TagNumberOfSeriesRelatedInstances, "")
+	if level == pacs.QueryLevelImage {
+		query.SetString(dicom.TagSOPClassUID, "")
+		query.SetString(dicom.TagSOPInstanceUID, "")
+		query.SetString(dicom.TagInstanceNumber, "")
+	}
+	return query
+}
+
+// buildStudyQuery builds a study level C-FIND identifier from the query flags.
+// Empty keys are return keys the PACS fills in for each match.
+func buildStudyQuery(c *cli.Context) *dicom.Dataset {
+	query := dicom.NewDataset()
+	query.SetString(dicom.TagStudyDate, c.String("study-date"))
+	query.SetString(dicom.TagStudyTime, "")
+	query.SetString(dicom.TagAccessionNumber, c.String("accession-number"))
+	query.SetString(dicom.TagModalitiesInStudy, c.String("modality"))
+	query.SetString(dicom.TagInstitutionName, "")
+	query.SetString(dicom.TagReferringPhysician, "")
+	query.SetString(dicom.TagStudyDescription, "")
+	query.SetString(dicom.TagPatientName, c.String("patient-name"))
+	query.SetString(dicom.TagPatientID, c.String("patient-id"))
+	query.SetString(dicom.TagPatientBirthDate, "")
+	query.SetString(dicom.TagPatientSex, "")
+	query.SetString(dicom.TagStudyInstanceUID, c.String("study-uid"))
+	query.SetString(dicom.TagStudyID, "")
+	query.SetString(dicom.TagNumberOfStudyRelatedSeries, "")
+	query.SetString(dicom.TagNumberOfStudyRelatedInstances, "")
+	return query
 }
 
 // generateTemplateFromModels generates a template from parsed models
@@ -0,0 +1,348 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// Exit codes of retrieve when not every sub-operation completed cleanly
+const (
+	exitRetrieveWarning = 2 // Every instance was retrieved, some with a warning status
+	exitRetrieveFailed  = 3 // Some sub-operations failed
+)
+
+// RetrieveCommand returns the retrieve command
+func RetrieveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "retrieve",
+		Usage: "Retrieve a study from PACS using C-GET, C-MOVE or WADO-RS",
+		Description: `Retrieve a study, or a single series, from a PACS into the local studies
+directory using the studies/<StudyUID>/series_NNN layout read by list and export.
+
+C-GET (default) receives the instances over the query association. C-MOVE
+starts an embedded storage SCP; the PACS must know the --move-ae title with
+this machine's address and --move-port. With --protocol dicomweb the study is
+retrieved from the destination's DICOMweb service with WADO-RS instead.
+
+With --verify, instances that already exist locally (for example, the study
+you sent) are compared with what the PACS returns instead of being replaced,
+and the command fails if any instance differs or is missing.
+
+Exits with 2 when every instance was retrieved but some sub-operations ended
+with a warning, and with 3 when some sub-operations failed.
+
+Examples:
+  crgodicom retrieve --study-uid 1.2.3 --host localhost --port 4242 --aet PACS1
+  crgodicom retrieve --study-uid 1.2.3 --dest orthanc_1
+  crgodicom retrieve --study-uid 1.2.3 --method move --move-ae CRGODICOM --move-port 11113
+  crgodicom retrieve --study-uid 1.2.3 --verify
+  crgodicom retrieve --study-uid 1.2.3 --dest cloud --protocol dicomweb`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:     "study-uid",
+				Usage:    "Study Instance UID to retrieve",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "series-uid",
+				Usage: "Series Instance UID to retrieve a single series",
+			},
+			&cli.StringFlag{
+				Name:  "method",
+				Usage: "Retrieve method: get, move",
+				Value: "get",
+			},
+			&cli.StringFlag{
+				Name:  "move-ae",
+				Usage: "C-MOVE destination AE title of the embedded storage SCP (default: --aec)",
+			},
+			&cli.IntFlag{
+				Name:  "move-port",
+				Usage: "Port of the embedded storage SCP for C-MOVE",
+				Value: 11113,
+			},
+			&cli.StringFlag{
+				Name:  "output-dir",
+				Usage: "Studies directory",
+				Value: "studies",
+			},
+			&cli.BoolFlag{
+				Name:  "verify",
+				Usage: "Compare retrieved instances with the local copies of the study",
+			},
+		}, append(append(destinationFlags(), protocolFlags()...), traceFlags()...)...),
+		Action: traced(retrieveAction),
+	}
+}
+
+func retrieveAction(c *cli.Context) error {
+	// Get configuration from context
+	if _, ok := c.Context.Value("config").(*config.Config); !ok {
+		return fmt.Errorf("configuration not found in context")
+	}
+
+	studyUID := c.String("study-uid")
+	seriesUID := c.String("series-uid")
+	method := strings.ToLower(c.String("method"))
+	if method != "get" && method != "move" {
+		return fmt.Errorf("invalid method '%s'. Valid methods: get, move", method)
+	}
+	if err := validateProtocol(c); err != nil {
+		return err
+	}
+
+	pacsConfig, err := resolveDestination(c, "")
+	if err != nil {
+		return err
+	}
+
+	// Build the retrieve identifier
+	level := pacs.QueryLevelStudy
+	identifier := dicom.NewDataset()
+	identifier.SetString(dicom.TagStudyInstanceUID, studyUID)
+	if seriesUID != "" {
+		level = pacs.QueryLevelSeries
+		identifier.SetString(dicom.TagSeriesInstanceUID, seriesUID)
+	}
+
+	store := dicom.NewStudyStore(c.String("output-dir"), pacsConfig.AEC)
+	receiver, err := newRetrieveReceiver(store, studyUID, seriesUID, c.Bool("verify"))
+	if err != nil {
+		return err
+	}
+
+	if c.String("protocol") == "dicomweb" {
+		if err := retrieveWithWADO(c, pacsConfig, studyUID, seriesUID, receiver); err != nil {
+			return err
+		}
+		return receiver.report()
+	}
+
+	logrus.Infof("Retrieving study %s from %s:%d using C-%s", studyUID, pacsConfig.Host, pacsConfig.Port, strings.ToUpper(method))
+
+	var result *pacs.RetrieveResult
+	if method == "get" {
+		result, err = retrieveWithCGet(c.Context, pacsConfig, level, identifier, receiver)
+	} else {
+		moveAE := c.String("move-ae")
+		if moveAE == "" {
+			moveAE = pacsConfig.AEC
+		}
+		result, err = retrieveWithCMove(c.Context, pacsConfig, level, identifier, moveAE, c.Int("move-port"), receiver)
+	}
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Retrieve finished: %d completed, %d failed, %d warning sub-operations",
+		result.Completed, result.Failed, result.Warning)
+	if err := receiver.report(); err != nil {
+		return err
+	}
+	return retrieveResultError(result)
+}
+
+// retrieveResultError returns the exit error of a retrieve whose
+// sub-operations failed or completed with warnings, as send does for files
+func retrieveResultError(result *pacs.RetrieveResult) error {
+	total := result.Completed + result.Failed + result.Warning
+	switch {
+	case result.Failed > 0:
+		return cli.Exit(fmt.Sprintf("%d of %d sub-operations failed", result.Failed, total), exitRetrieveFailed)
+	case result.Warning > 0:
+		return cli.Exit(fmt.Sprintf("%d of %d sub-operations completed with warnings", result.Warning, total), exitRetrieveWarning)
+	case pacs.ClassifyStatus(result.Status) == pacs.CategoryWarning:
+		return cli.Exit(fmt.Sprintf("retrieve completed with warning status 0x%04X", result.Status), exitRetrieveWarning)
+	}
+	return nil
+}
+
+// retrieveWithCGet retrieves instances over the query association
+func retrieveWithCGet(ctx context.Context, pacsConfig *config.PACSConfig, level pacs.QueryLevel, identifier *dicom.Dataset, receiver *retrieveReceiver) (*pacs.RetrieveResult, error) {
+	client := pacs.NewClient(pacsConfig)
+	client.EnableCGet()
+	if err := client.Connect(ctx); err != nil {
+		return nil, err
+	}
+	defer client.Disconnect()
+
+	return client.CGet(ctx, level, identifier, receiver.handle)
+}
+
+// retrieveWithCMove runs an embedded storage SCP and asks the PACS to move instances to it
+func retrieveWithCMove(ctx context.Context, pacsConfig *config.PACSConfig, level pacs.QueryLevel, identifier *dicom.Dataset, moveAE string, movePort int, receiver *retrieveReceiver) (*pacs.RetrieveResult, error) {
+	server := pacs.NewServer(&pacs.ServerConfig{AETitle: moveAE, Timeout: pacsConfig.Timeout}, receiver.handle)
+	if err := server.Listen(fmt.Sprintf(":%d", movePort)); err != nil {
+		return nil, err
+	}
+
+	serveCtx, stopServer := context.WithCancel(ctx)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(serveCtx)
+	}()
+	// Wait for the storage associations to finish before reporting
+	defer func() {
+		stopServer()
+		if err := <-served; err != nil {
+			logrus.Warnf("Storage SCP stopped with error: %v", err)
+		}
+	}()
+
+	client := pacs.NewClient(pacsConfig)
+	if err := client.Connect(ctx); err != nil {
+		return nil, err
+	}
+	defer client.Disconnect()
+
+	return client.CMove(ctx, level, identifier, moveAE)
+}
+
+// retrieveWithWADO retrieves instances from the DICOMweb service of the destination
+func retrieveWithWADO(c *cli.Context, pacsConfig *config.PACSConfig, studyUID, seriesUID string, receiver *retrieveReceiver) error {
+	client, err := newDICOMwebClient(c, pacsConfig)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Retrieving study %s from %s using WADO-RS", studyUID, client.URL())
+	received, err := client.Retrieve(c.Context, studyUID, seriesUID, func(file *dicom.File) error {
+		return receiver.handle(file.SOPClassUID(), file.SOPInstanceUID(), file.TransferSyntaxUID, file.RawDataset)
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Retrieve finished: %d instances received", received)
+	return nil
+}
+
+// retrieveReceiver saves retrieved instances and, when verifying, compares
+// instances that already exist locally with what the PACS returned
+type retrieveReceiver struct {
+	store    *dicom.StudyStore
+	verify   bool
+	expected map[string]string // Local SOP instances to verify, mapped to their paths
+
+	mu         sync.Mutex
+	received   map[string]bool
+	stored     int
+	mismatched map[string][]string
+}
+
+// newRetrieveReceiver creates a receiver, indexing the local copy of the study when verifying
+func newRetrieveReceiver(store *dicom.StudyStore, studyUID, seriesUID string, verify bool) (*retrieveReceiver, error) {
+	r := &retrieveReceiver{
+		store:      store,
+		verify:     verify,
+		expected:   make(map[string]string),
+		received:   make(map[string]bool),
+		mismatched: make(map[string][]string),
+	}
+	if !verify {
+		return r, nil
+	}
+
+	instances, err := store.Instances(studyUID)
+	if err != nil {
+		return nil, err
+	}
+	for uid, path := range instances {
+		if seriesUID != "" {
+			file, err := dicom.ReadFile(path)
+			if err != nil || file.Dataset.String(dicom.TagSeriesInstanceUID) != seriesUID {
+				continue
+			}
+		}
+		r.expected[uid] = path
+	}
+
+	if len(r.expected) == 0 {
+		logrus.Warnf("No local copy of study %s to verify against; retrieved instances will be stored", studyUID)
+	} else {
+		logrus.Infof("Verifying against %d local instances", len(r.expected))
+	}
+	return r, nil
+}
+
+// handle receives a single retrieved instance
+func (r *retrieveReceiver) handle(sopClassUID, sopInstanceUID, transferSyntaxUID string, dataset []byte) error {
+	r.mu.Lock()
+	r.received[sopInstanceUID] = true
+	localPath, verifying := r.expected[sopInstanceUID]
+	r.mu.Unlock()
+
+	if !verifying {
+		path, err := r.store.Save(sopClassUID, sopInstanceUID, transferSyntaxUID, dataset)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Stored %s", path)
+
+		r.mu.Lock()
+		r.stored++
+		r.mu.Unlock()
+		return nil
+	}
+
+	local, err := dicom.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	retrieved, err := dicom.ParseDataset(dataset, transferSyntaxUID)
+	if err != nil {
+		return err
+	}
+	differences, err := dicom.CompareDatasets(local.Dataset, retrieved)
+	if err != nil {
+		return err
+	}
+
+	if len(differences) > 0 {
+		logrus.Warnf("Instance %s differs from %s: %s", sopInstanceUID, localPath, strings.Join(differences, "; "))
+		r.mu.Lock()
+		r.mismatched[sopInstanceUID] = differences
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// report logs the retrieval summary and fails when verification found problems
+func (r *retrieveReceiver) report() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logrus.Infof("Received %d instances, stored %d new instances", len(r.received), r.stored)
+	if !r.verify || len(r.expected) == 0 {
+		return nil
+	}
+
+	var missing []string
+	for uid := range r.expected {
+		if !r.received[uid] {
+			missing = append(missing, uid)
+		}
+	}
+	sort.Strings(missing)
+	for _, uid := range missing {
+		logrus.Warnf("Instance %s was not returned by the PACS", uid)
+	}
+
+	matched := len(r.expected) - len(missing) - len(r.mismatched)
+	logrus.Infof("Verification: %d identical, %d differ, %d missing", matched, len(r.mismatched), len(missing))
+
+	if len(r.mismatched) > 0 || len(missing) > 0 {
+		return fmt.Errorf("round-trip verification failed: %d instances differ, %d missing", len(r.mismatched), len(missing))
+	}
+	logrus.Info("✅ Round-trip verification passed")
+	return nil
+}
@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestRetrieveResultError(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   pacs.RetrieveResult
+		exitCode int
+	}{
+		{"all completed", pacs.RetrieveResult{Completed: 4, Status: pacs.StatusSuccess}, 0},
+		{"failed sub-operations", pacs.RetrieveResult{Completed: 3, Failed: 1, Status: pacs.StatusSubOpsWarning}, exitRetrieveFailed},
+		{"warning sub-operations", pacs.RetrieveResult{Completed: 3, Warning: 1, Status: pacs.StatusSubOpsWarning}, exitRetrieveWarning},
+		{"warning status", pacs.RetrieveResult{Completed: 4, Status: pacs.StatusSubOpsWarning}, exitRetrieveWarning},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := retrieveResultError(&tt.result)
+			if tt.exitCode == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			exitErr, ok := err.(cli.ExitCoder)
+			if assert.True(t, ok) {
+				assert.Equal(t, tt.exitCode, exitErr.ExitCode())
+			}
+		})
+	}
+}
@@ -1,61 +1,66 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
 	"github.com/flatmapit/crgodicom/internal/pacs"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
 
+// Exit codes of send when not every file was stored cleanly
+const (
+	exitSendWarning  = 2 // Every file was stored, some with a warning status
+	exitSendRejected = 3 // Some files failed or were refused
+)
+
+// sendResult is the outcome of sending a single file
+type sendResult struct {
+	File              string
+	Instance          pacs.ReferencedInstance
+	SeriesInstanceUID string
+	SeriesDescription string
+	Size              int
+	Category          pacs.StatusCategory
+	Status            pacs.Status
+	Err               error
+	Latency           time.Duration // From sending the request to receiving its response
+	Skipped           bool          // Already acknowledged according to the transfer journal
+}
+
 // SendCommand returns the send command
 func SendCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "send",
 		Usage: "Send DICOM study to PACS",
-		Flags: []cli.Flag{
+		Description: fmt.Sprintf("Exits with %d when every file was stored but some with a warning status, "+
+			"and with %d when some files failed or were refused. A --dest naming a fan-out list "+
+			"sends the study to each of its members and exits with the worst outcome. With --protocol dicomweb "+
+			"the study is sent with STOW-RS to the DICOMweb service of each destination.", exitSendWarning, exitSendRejected),
+		Flags: append(append([]cli.Flag{
 			&cli.StringFlag{
 				Name:     "study-id",
 				Usage:    "Study Instance UID (required)",
 				Required: true,
 			},
-			&cli.StringFlag{
-				Name:  "host",
-				Usage: "PACS host address",
-			},
-			&cli.IntFlag{
-				Name:  "port",
-				Usage: "PACS port",
-				Value: 11112,
-			},
-			&cli.StringFlag{
-				Name:  "aec",
-				Usage: "Application Entity Caller",
-			},
-			&cli.StringFlag{
-				Name:  "aet",
-				Usage: "Application Entity Title",
-			},
 			&cli.StringFlag{
 				Name:  "output-dir",
 				Usage: "Studies directory",
 				Value: "studies",
 			},
-			&cli.IntFlag{
-				Name:  "timeout",
-				Usage: "Connection timeout in seconds",
-				Value: 30,
-			},
 			&cli.IntFlag{
 				Name:  "retries",
-				Usage: "Retry attempts",
+				Usage: "Retry attempts for transient connection and storage failures, with exponential backoff",
 				Value: 3,
 			},
-		},
-		Action: sendAction,
+		}, append(destinationFlags(), protocolFlags()...)...), append(append(append(transferFlags(), commitFlags()...), mppsFlags()...), traceFlags()...)...),
+		Action: traced(sendAction),
 	}
 }
 
@@ -66,85 +71,219 @@ func sendAction(c *cli.Context) error {
 		return fmt.Errorf("configuration not found in context")
 	}
 
-	// Build PACS connection parameters
-	pacsConfig := config.PACSConfig{
-		Host:    c.String("host"),
-		Port:    c.Int("port"),
-		AEC:     c.String("aec"),
-		AET:     c.String("aet"),
-		Timeout: c.Int("timeout"),
+	if err := validateProtocol(c); err != nil {
+		return err
 	}
-
-	// Use default PACS config if not specified via CLI
-	if pacsConfig.Host == "" {
-		pacsConfig = cfg.DefaultPACS
-		logrus.Info("Using default PACS configuration")
+	if c.String("protocol") == "dicomweb" && (c.Bool("mpps") || c.Bool("commit")) {
+		return fmt.Errorf("--mpps and --commit require --protocol dimse")
 	}
 
-	// Validate required PACS parameters
-	if pacsConfig.Host == "" || pacsConfig.AEC == "" || pacsConfig.AET == "" {
-		return fmt.Errorf("PACS connection requires host, aec, and aet parameters")
+	destinations, err := resolveDestinations(c, "")
+	if err != nil {
+		return err
 	}
 
 	studyID := c.String("study-id")
 	outputDir := c.String("output-dir")
+
+	// Find the DICOM files for the study
+	studyDir := filepath.Join(outputDir, studyID)
+	dicomFiles, err := studyFiles(studyDir)
+	if err != nil {
+		return fmt.Errorf("failed to find DICOM files: %w", err)
+	}
+
+	logrus.Infof("Found %d DICOM files to send", len(dicomFiles))
+
+	if len(destinations) == 1 {
+		return sendStudy(c, cfg, &destinations[0], studyID, studyDir, dicomFiles, "")
+	}
+
+	// Fan out to every destination of the list and report the worst outcome
+	var outcome cli.ExitCoder
+	failed := 0
+	for i := range destinations {
+		fmt.Printf("Destination %s (%s:%d):\n", destinations[i].AET, destinations[i].Host, destinations[i].Port)
+		err := sendStudy(c, cfg, &destinations[i], studyID, studyDir, dicomFiles, destinations[i].AET)
+		var exitErr cli.ExitCoder
+		switch {
+		case err == nil:
+		case errors.As(err, &exitErr):
+			if outcome == nil || exitErr.ExitCode() > outcome.ExitCode() {
+				outcome = exitErr
+			}
+		default:
+			logrus.Errorf("Failed to send to %s: %v", destinations[i].AET, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("send failed for %d of %d destinations", failed, len(destinations))
+	}
+	if outcome != nil {
+		return outcome
+	}
+	return nil
+}
+
+// sendStudy sends the files of a study to one PACS, wrapped in the MPPS and
+// storage commitment exchanges requested on the command line, or with
+// STOW-RS when --protocol is dicomweb. The storage
+// commitment report name gets reportSuffix to keep fan-out reports apart.
+func sendStudy(c *cli.Context, cfg *config.Config, pacsConfig *config.PACSConfig, studyID, studyDir string, dicomFiles []string, reportSuffix string) error {
+	if c.String("protocol") == "dicomweb" {
+		return sendStudyDICOMweb(c, pacsConfig, studyID, studyDir, dicomFiles)
+	}
 	retries := c.Int("retries")
 
 	logrus.Infof("Sending study %s to PACS %s:%d (AEC: %s, AET: %s)",
 		studyID, pacsConfig.Host, pacsConfig.Port, pacsConfig.AEC, pacsConfig.AET)
 	logrus.Infof("Studies directory: %s, Retries: %d, Timeout: %ds",
-		outputDir, retries, pacsConfig.Timeout)
+		filepath.Dir(studyDir), retries, pacsConfig.Timeout)
 
-	// Create PACS client
-	client := pacs.NewClient(&pacsConfig)
-
-	// Connect to PACS
-	if err := client.Connect(c.Context); err != nil {
-		return fmt.Errorf("failed to connect to PACS: %w", err)
+	// Instances this destination acknowledged in an earlier run are skipped
+	journal, err := openTransferJournal(studyDir, pacsConfig)
+	if err != nil {
+		return err
 	}
-	defer client.Disconnect()
+	defer journal.Close()
 
-	// Test connectivity with C-ECHO
-	if err := client.CEcho(c.Context); err != nil {
-		return fmt.Errorf("C-ECHO failed: %w", err)
-	}
+	opts := transferOptionsFromFlags(c)
+	opts.journal = journal
 
-	// Find and send DICOM files for the study
-	studyDir := filepath.Join(outputDir, studyID)
-	dicomFiles, err := findDICOMFiles(studyDir)
+	// Connect to PACS and test connectivity with C-ECHO
+	client, err := connectWithRetries(c.Context, pacsConfig, opts.asyncOps, retries)
 	if err != nil {
-		return fmt.Errorf("failed to find DICOM files: %w", err)
+		return err
 	}
+	defer func() { client.Disconnect() }()
 
-	logrus.Infof("Found %d DICOM files to send", len(dicomFiles))
+	// Announce the procedure step before any instance is stored
+	var step *pacs.PerformedProcedureStep
+	if c.Bool("mpps") {
+		if step, err = startPerformedProcedureStep(c, cfg, client, dicomFiles); err != nil {
+			return err
+		}
+	}
 
-	successCount := 0
-	for _, filePath := range dicomFiles {
-		logrus.Infof("Sending %s", filePath)
+	results := storeFilesWithRetries(c.Context, client, dicomFiles, opts, retries)
 
-		// Read DICOM file
-		dicomData, err := os.ReadFile(filePath)
-		if err != nil {
-			logrus.Errorf("Failed to read %s: %v", filePath, err)
+	var sent []pacs.ReferencedInstance
+	for _, result := range results {
+		if !result.stored() {
 			continue
 		}
+		sent = append(sent, result.Instance)
+		if step != nil {
+			step.AddInstance(result.SeriesInstanceUID, result.SeriesDescription, result.Instance)
+		}
+	}
 
-		// Extract SOP Instance UID from filename or data (simplified)
-		sopInstanceUID := extractSOPInstanceUID(filePath)
+	fmt.Printf("Successfully sent %d/%d DICOM files to PACS\n", len(sent), len(dicomFiles))
+	outcome := reportSendResults(results)
 
-		// Send to PACS
-		if err := client.CStore(c.Context, dicomData, sopInstanceUID); err != nil {
-			logrus.Errorf("Failed to send %s: %v", filePath, err)
-			continue
+	// The transfer may have lost the association; MPPS and storage
+	// commitment continue over a new one in that case
+	if step != nil || c.Bool("commit") {
+		if err := client.CEcho(c.Context); err != nil {
+			logrus.Warnf("Association lost during the transfer (%v), reconnecting", err)
+			client.Disconnect()
+			if client, err = connectWithRetries(c.Context, pacsConfig, opts.asyncOps, retries); err != nil {
+				return err
+			}
 		}
+	}
 
-		successCount++
+	if step != nil {
+		if err := finishPerformedProcedureStep(c, client, step); err != nil {
+			return err
+		}
 	}
 
-	fmt.Printf("Successfully sent %d/%d DICOM files to PACS\n", successCount, len(dicomFiles))
+	if c.Bool("commit") {
+		if err := requestStorageCommitment(c, client, studyID, commitmentReportPath(c, studyDir, reportSuffix), sent); err != nil {
+			return err
+		}
+	}
+	return outcome
+}
+
+// setOutcome classifies the outcome of sending the file. Errors that do not
+// carry a DIMSE status, such as unreadable files, count as failures.
+func (r *sendResult) setOutcome(status pacs.Status, err error) {
+	r.Status = status
+	r.Err = err
+
+	var statusErr *pacs.StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		r.Status = statusErr.Status
+		r.Category = statusErr.Status.Category()
+	case err != nil:
+		r.Category = pacs.CategoryFailure
+	default:
+		r.Category = status.Category()
+	}
+}
+
+// stored reports whether the peer accepted the file, possibly with a warning
+func (r *sendResult) stored() bool {
+	return r.Category == pacs.CategorySuccess || r.Category == pacs.CategoryWarning
+}
+
+// reportSendResults prints every file that was not a clean success with its
+// status and comment, and returns the exit error for the overall outcome
+func reportSendResults(results []sendResult) error {
+	counts := make(map[pacs.StatusCategory]int)
+	skipped := 0
+	for _, result := range results {
+		counts[result.Category]++
+		if result.Skipped {
+			skipped++
+		}
+
+		switch {
+		case result.Category == pacs.CategorySuccess:
+		case result.Err != nil && result.Status.Code == 0:
+			fmt.Printf("  %-8s %s: %v\n", result.Category, filepath.Base(result.File), result.Err)
+		default:
+			fmt.Printf("  %-8s %s: status %s\n", result.Category, filepath.Base(result.File), result.Status)
+		}
+	}
+
+	rejected := len(results) - counts[pacs.CategorySuccess] - counts[pacs.CategoryWarning]
+	fmt.Printf("Results: %d success, %d warning, %d failure, %d refused\n",
+		counts[pacs.CategorySuccess], counts[pacs.CategoryWarning], rejected-counts[pacs.CategoryRefused], counts[pacs.CategoryRefused])
+	if skipped > 0 {
+		fmt.Printf("Skipped %d files already acknowledged by the destination\n", skipped)
+	}
+
+	switch {
+	case rejected > 0:
+		return cli.Exit(fmt.Sprintf("%d of %d files were not stored", rejected, len(results)), exitSendRejected)
+	case counts[pacs.CategoryWarning] > 0:
+		return cli.Exit(fmt.Sprintf("%d of %d files were stored with warnings", counts[pacs.CategoryWarning], len(results)), exitSendWarning)
+	}
 	return nil
 }
 
+// studyFiles returns the DICOM files of a study directory, listed by its
+// manifest when it is up to date and found by walking the directory otherwise
+func studyFiles(studyDir string) ([]string, error) {
+	manifest, err := dicom.ReadManifest(studyDir)
+	if err == nil {
+		files, err := manifest.Files(studyDir)
+		if err == nil {
+			return files, nil
+		}
+		logrus.Warnf("Ignoring study manifest: %v", err)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logrus.Warnf("Ignoring study manifest: %v", err)
+	}
+
+	return findDICOMFiles(studyDir)
+}
+
 // findDICOMFiles recursively finds all DICOM files in a directory
 func findDICOMFiles(dir string) ([]string, error) {
 	var dicomFiles []string
@@ -165,15 +304,11 @@ func findDICOMFiles(dir string) ([]string, error) {
 	return dicomFiles, err
 }
 
-// extractSOPInstanceUID extracts SOP Instance UID from file path
-// This is a simplified implementation - in a real scenario, you'd parse the DICOM file
-func extractSOPInstanceUID(filePath string) string {
-	// For now, use a simple approach based on filename
-	// In a real implementation, you'd parse the DICOM file to extract the actual UID
-	base := filepath.Base(filePath)
-	if base == "image_001.dcm" {
-		// This is a placeholder - in reality you'd extract from DICOM metadata
-		return "1.2.840.10008.5.1.4.1.1.1.1"
-	}
-	return "1.2.840.10008.5.1.4.1.1.1.1" // Default placeholder
+// sopInstance returns the SOP Class and Instance UIDs of a parsed DICOM file
+func sopInstance(file *dicom.File) (pacs.ReferencedInstance, error) {
+	sopInstanceUID := file.SOPInstanceUID()
+	if sopInstanceUID == "" {
+		return pacs.ReferencedInstance{}, fmt.Errorf("SOP Instance UID not present")
+	}
+	return pacs.ReferencedInstance{SOPClassUID: file.SOPClassUID(), SOPInstanceUID: sopInstanceUID}, nil
 }
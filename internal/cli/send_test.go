@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/flatmapit/crgodicom/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestReportSendResults(t *testing.T) {
+	result := func(file string, status pacs.Status, err error) sendResult {
+		r := sendResult{File: file}
+		r.setOutcome(status, err)
+		return r
+	}
+	success := result("a.dcm", pacs.Status{Code: pacs.StatusSuccess}, nil)
+	coerced := result("b.dcm", pacs.Status{Code: 0xB000, ErrorComment: "Patient name coerced"}, nil)
+	rejected := result("c.dcm", pacs.Status{}, fmt.Errorf("C-STORE failed: %w",
+		&pacs.StatusError{Status: pacs.Status{Code: 0xA900}}))
+	unreadable := result("d.dcm", pacs.Status{}, fmt.Errorf("failed to parse"))
+
+	tests := []struct {
+		name     string
+		results  []sendResult
+		exitCode int
+	}{
+		{"all stored", []sendResult{success, success}, 0},
+		{"coercion warning", []sendResult{success, coerced}, exitSendWarning},
+		{"rejection", []sendResult{success, coerced, rejected}, exitSendRejected},
+		{"unreadable file", []sendResult{success, unreadable}, exitSendRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := reportSendResults(tt.results)
+			if tt.exitCode == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			exitErr, ok := err.(cli.ExitCoder)
+			if assert.True(t, ok) {
+				assert.Equal(t, tt.exitCode, exitErr.ExitCode())
+			}
+		})
+	}
+
+	assert.Equal(t, pacs.CategoryFailure, rejected.Category)
+	assert.Equal(t, uint16(0xA900), rejected.Status.Code)
+	assert.Equal(t, pacs.CategoryWarning, coerced.Category)
+	assert.True(t, coerced.stored())
+	assert.False(t, unreadable.stored())
+}
+
+func TestStudyManifest(t *testing.T) {
+	studyDir := filepath.Join(t.TempDir(), "1.2.3")
+	seriesDir := filepath.Join(studyDir, "series_001")
+	assert.NoError(t, os.MkdirAll(seriesDir, 0755))
+	files := writeTestInstances(t, seriesDir, 3)
+	assert.NoError(t, os.WriteFile(filepath.Join(studyDir, "unlisted.dcm"), nil, 0644))
+
+	manifest := dicom.NewManifest(&types.Study{StudyInstanceUID: "1.2.3", PatientName: "DOE^JANE"}, nil)
+	series := dicom.ManifestSeries{SeriesInstanceUID: "1.2.3.1", Modality: "CT"}
+	for i, file := range files {
+		info, err := os.Stat(file)
+		assert.NoError(t, err)
+		series.Instances = append(series.Instances, dicom.ManifestInstance{
+			SOPInstanceUID: fmt.Sprintf("1.2.3.1.%d", i+1),
+			Path:           "series_001/" + filepath.Base(file),
+			Size:           info.Size(),
+		})
+	}
+	manifest.Series = append(manifest.Series, series)
+	assert.NoError(t, manifest.Write(studyDir))
+
+	found, err := studyFiles(studyDir)
+	assert.NoError(t, err)
+	assert.Equal(t, files, found)
+
+	info, err := getStudyInfo(studyDir)
+	assert.NoError(t, err)
+	assert.Equal(t, "DOE^JANE", info.PatientName)
+	assert.Equal(t, 1, info.SeriesCount)
+	assert.Equal(t, 3, info.ImageCount)
+	assert.Equal(t, "CT", info.Modality)
+
+	// A stale manifest falls back to walking the study directory
+	assert.NoError(t, os.Remove(files[2]))
+	found, err = studyFiles(studyDir)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{files[0], files[1], filepath.Join(studyDir, "unlisted.dcm")}, found)
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// ServeCommand returns the serve command
+func ServeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Run a built-in storage SCP that stores received studies",
+		Description: `Run a DICOM storage SCP that accepts C-ECHO and C-STORE and writes received
+instances into the studies directory, using the same layout as create. Stored
+studies can be queried with C-FIND (Patient and Study Root) and used with list,
+export and send like any generated study.
+
+The server runs until interrupted, which makes it a stand-in PACS for local
+testing of echo, send, store and pacs-cfind without Docker. It listens on
+127.0.0.1 unless --host says otherwise; anyone who can reach it may store
+instances, so only widen it on trusted networks.
+
+With --tls-cert and --tls-key it serves DICOM over TLS; with --tls-ca as well,
+clients must present a certificate signed by that CA.
+
+Examples:
+  crgodicom serve --ae-title CRGODICOM --port 11112
+  crgodicom serve --port 4242 --output-dir received
+  crgodicom serve --host 0.0.0.0 --port 11112
+  crgodicom serve --port 2762 --tls-cert server.pem --tls-key server.key`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "ae-title",
+				Usage: "AE title of the server; associations to other called AE titles are rejected",
+				Value: "CRGODICOM",
+			},
+			&cli.StringFlag{
+				Name:  "host",
+				Usage: "Address to listen on (0.0.0.0 for all interfaces)",
+				Value: "127.0.0.1",
+			},
+			&cli.IntFlag{
+				Name:  "port",
+				Usage: "Port to listen on",
+				Value: 11112,
+			},
+			&cli.StringFlag{
+				Name:  "output-dir",
+				Usage: "Studies directory to store received instances in",
+				Value: "studies",
+			},
+			&cli.IntFlag{
+				Name:  "timeout",
+				Usage: "Seconds of inactivity before an association is dropped",
+				Value: 30,
+			},
+		}, append(tlsFlags(""), traceFlags()...)...),
+		Action: traced(serveAction),
+	}
+}
+
+func serveAction(c *cli.Context) error {
+	// Get configuration from context
+	if _, ok := c.Context.Value("config").(*config.Config); !ok {
+		return fmt.Errorf("configuration not found in context")
+	}
+
+	outputDir := c.String("output-dir")
+	store := dicom.NewStudyStore(outputDir, c.String("ae-title"))
+
+	serverConfig := &pacs.ServerConfig{
+		AETitle: c.String("ae-title"),
+		Timeout: c.Int("timeout"),
+	}
+	var tlsConfig config.TLSConfig
+	applyTLSFlags(c, "", &tlsConfig)
+	if tlsConfig.Enabled {
+		var err error
+		if serverConfig.TLS, err = pacs.ServerTLSConfig(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	server := pacs.NewServer(serverConfig, func(sopClassUID, sopInstanceUID, transferSyntaxUID string, dataset []byte) error {
+		path, err := store.Save(sopClassUID, sopInstanceUID, transferSyntaxUID, dataset)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Stored %s", path)
+		return nil
+	})
+	server.SetFindHandler(func(level pacs.QueryLevel, identifier *dicom.Dataset) ([]*dicom.Dataset, error) {
+		return store.Find(string(level), identifier)
+	})
+
+	if err := server.Listen(net.JoinHostPort(c.String("host"), strconv.Itoa(c.Int("port")))); err != nil {
+		return err
+	}
+	logrus.Infof("Storing received instances in %s (press Ctrl+C to stop)", outputDir)
+
+	if err := server.Serve(c.Context); err != nil {
+		return err
+	}
+
+	logrus.Info("DICOM server stopped")
+	return nil
+}
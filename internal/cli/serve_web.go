@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/dicomweb"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// ServeWebCommand returns the serve-web command
+func ServeWebCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve-web",
+		Usage: "Serve the studies directory over DICOMweb (QIDO-RS, WADO-RS, STOW-RS)",
+		Description: `Serve the studies directory as a DICOMweb service, so web viewers such as
+OHIF can browse generated studies without a full archive.
+
+The service supports:
+- QIDO-RS searches for studies, series and instances
+- WADO-RS retrieval of instances, metadata, frames and rendered PNG images
+  (rendered with the burnt-in text of export)
+- STOW-RS, storing received instances into the studies directory
+
+The service listens on 127.0.0.1 unless --host says otherwise, and anyone
+who can reach it may store instances, so only widen it on trusted networks.
+Browsers on other origins may call it only when --cors-origin allows them,
+and each stored instance is limited to --max-instance-size MiB. With --tls-cert and
+--tls-key it serves HTTPS; with --tls-ca as well, clients must present a
+certificate signed by that CA.
+
+Examples:
+  crgodicom serve-web --port 8042 --cors-origin http://localhost:3000
+  crgodicom serve-web --output-dir received
+  crgodicom serve-web --host 0.0.0.0 --max-instance-size 512
+
+  # OHIF data source settings for the first example, with OHIF served
+  # from http://localhost:3000
+  #   wadoRoot and qidoRoot: http://localhost:8042/dicom-web`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "host",
+				Usage: "Address to listen on (0.0.0.0 for all interfaces)",
+				Value: "127.0.0.1",
+			},
+			&cli.IntFlag{
+				Name:  "port",
+				Usage: "Port to listen on",
+				Value: 8042,
+			},
+			&cli.StringFlag{
+				Name:  "output-dir",
+				Usage: "Studies directory to serve and store received instances in",
+				Value: "studies",
+			},
+			&cli.StringFlag{
+				Name:  "prefix",
+				Usage: "Path of the DICOMweb service root",
+				Value: "/dicom-web",
+			},
+			&cli.StringFlag{
+				Name:  "cors-origin",
+				Usage: "Origin allowed to call the service from a browser (\"*\" for any; CORS is disabled when unset)",
+			},
+			&cli.IntFlag{
+				Name:  "max-instance-size",
+				Usage: "Maximum size in MiB of an instance received over STOW-RS",
+				Value: dicomweb.DefaultMaxInstanceSize >> 20,
+			},
+		}, tlsFlags("")...),
+		Action: serveWebAction,
+	}
+}
+
+func serveWebAction(c *cli.Context) error {
+	// Get configuration from context
+	cfg, ok := c.Context.Value("config").(*config.Config)
+	if !ok {
+		return fmt.Errorf("configuration not found in context")
+	}
+
+	outputDir := c.String("output-dir")
+	handler := dicomweb.NewServer(dicom.NewStudyStore(outputDir, cfg.DefaultPACS.AEC), c.String("prefix"))
+	handler.SetAllowOrigin(c.String("cors-origin"))
+	if c.Int("max-instance-size") <= 0 {
+		return fmt.Errorf("--max-instance-size must be greater than 0")
+	}
+	handler.SetMaxInstanceSize(int64(c.Int("max-instance-size")) << 20)
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	var tlsConfig config.TLSConfig
+	applyTLSFlags(c, "", &tlsConfig)
+	scheme := "http"
+	if tlsConfig.Enabled {
+		var err error
+		if server.TLSConfig, err = pacs.ServerTLSConfig(tlsConfig); err != nil {
+			return err
+		}
+		scheme = "https"
+	}
+
+	address := net.JoinHostPort(c.String("host"), strconv.Itoa(c.Int("port")))
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+	logrus.Infof("Serving %s over DICOMweb at %s://%s%s (press Ctrl+C to stop)",
+		outputDir, scheme, address, c.String("prefix"))
+
+	served := make(chan error, 1)
+	go func() {
+		if tlsConfig.Enabled {
+			served <- server.ServeTLS(listener, "", "")
+		} else {
+			served <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-c.Context.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-served; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	logrus.Info("DICOMweb server stopped")
+	return nil
+}
@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicomweb"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// errNotReferenced marks instances a STOW-RS response neither stored nor failed
+var errNotReferenced = errors.New("not referenced in the STOW-RS response")
+
+// sendStudyDICOMweb sends the files of a study to the DICOMweb service of a
+// destination with STOW-RS, retrying transient failures
+func sendStudyDICOMweb(c *cli.Context, pacsConfig *config.PACSConfig, studyID, studyDir string, dicomFiles []string) error {
+	client, err := newDICOMwebClient(c, pacsConfig)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Sending study %s to DICOMweb service %s", studyID, client.URL())
+
+	// Instances this destination acknowledged in an earlier run are skipped
+	journal, err := openJournal(studyDir, "", pacsConfig.AET, client.URL())
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	results := storeDICOMwebWithRetries(c.Context, client, dicomFiles, journal, c.Int("retries"))
+
+	sent := 0
+	for _, result := range results {
+		if result.stored() {
+			sent++
+		}
+	}
+	fmt.Printf("Successfully sent %d/%d DICOM files to %s\n", sent, len(dicomFiles), client.URL())
+	return reportSendResults(results)
+}
+
+// storeDICOMwebWithRetries stores the files with STOW-RS and sends the files
+// that failed transiently again in new requests, with exponential backoff
+func storeDICOMwebWithRetries(ctx context.Context, client *dicomweb.Client, files []string, journal *transferJournal, retries int) []sendResult {
+	results := storeDICOMweb(ctx, client, files, journal)
+
+	for attempt := 1; attempt <= retries; attempt++ {
+		var retry []int
+		var paths []string
+		for i := range results {
+			if !results[i].stored() && isTransient(results[i].Err) {
+				retry = append(retry, i)
+				paths = append(paths, results[i].File)
+			}
+		}
+		if len(retry) == 0 {
+			break
+		}
+
+		delay := retryDelay(attempt)
+		logrus.Warnf("Retrying %d file(s) in %s (attempt %d of %d)", len(retry), delay, attempt, retries)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return results
+		}
+
+		retried := storeDICOMweb(ctx, client, paths, journal)
+		for j, i := range retry {
+			results[i] = retried[j]
+		}
+	}
+	return results
+}
+
+// storeDICOMweb sends the files in a single STOW-RS request and matches the
+// Referenced and Failed SOP Sequences of the response to them. Instances the
+// journal shows as acknowledged are skipped.
+func storeDICOMweb(ctx context.Context, client *dicomweb.Client, files []string, journal *transferJournal) []sendResult {
+	results := make([]sendResult, len(files))
+	pending := make(map[string]int)
+	var paths []string
+	for i, path := range files {
+		results[i], _ = readSendFile(path)
+		switch uid := results[i].Instance.SOPInstanceUID; {
+		case results[i].Err != nil:
+			logrus.Errorf("Failed to read %s: %v", path, results[i].Err)
+		case journal.isAcknowledged(uid):
+			results[i].Skipped = true
+			results[i].setOutcome(pacs.Status{}, nil)
+		default:
+			pending[uid] = i
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return results
+	}
+
+	start := time.Now()
+	response, err := client.Store(ctx, paths)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		logrus.Errorf("STOW-RS request failed: %v", err)
+		for _, i := range pending {
+			results[i].setOutcome(pacs.Status{}, err)
+		}
+	} else {
+		for _, ref := range response.Referenced {
+			if i, ok := pending[ref.SOPInstanceUID]; ok {
+				results[i].setOutcome(pacs.Status{Code: ref.Reason}, nil)
+				results[i].Latency = elapsed
+			}
+		}
+		for _, failed := range response.Failed {
+			if i, ok := pending[failed.SOPInstanceUID]; ok {
+				// A failure without a reason is reported as a processing failure
+				status := pacs.Status{Code: failed.Reason, ErrorComment: "failed in STOW-RS response"}
+				if status.Code == pacs.StatusSuccess {
+					status.Code = pacs.StatusProcessingFailure
+				}
+				results[i].setOutcome(status, &pacs.StatusError{Status: status})
+			}
+		}
+		// A 200 response stores every instance, even when it lists none
+		for _, i := range pending {
+			if results[i].Category == "" {
+				if response.StatusCode == 200 {
+					results[i].setOutcome(pacs.Status{}, nil)
+					results[i].Latency = elapsed
+				} else {
+					results[i].setOutcome(pacs.Status{}, errNotReferenced)
+				}
+			}
+		}
+	}
+
+	var size int
+	for _, i := range pending {
+		journal.record(&results[i])
+		size += results[i].Size
+	}
+	fmt.Printf("Transfer: %d files (%.1f MB) in one STOW-RS request in %s: %.2f MB/s\n",
+		len(paths), float64(size)/1e6, elapsed.Round(time.Millisecond), float64(size)/1e6/max(elapsed.Seconds(), 1e-9))
+	return results
+}
@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/dicomweb"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/stretchr/testify/assert"
+)
+
+// startSTOWServer starts a STOW-RS stand-in that answers each instance of a
+// request with the status returned by handler
+func startSTOWServer(t *testing.T, handler func(sopInstance string) (uint16, bool)) (*dicomweb.Client, func() int) {
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			io.Copy(io.Discard, r.Body)
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+			return
+		}
+
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		var referenced, failed []map[string]any
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			data, _ := io.ReadAll(part)
+			file, err := dicom.ParseFile(data)
+			if !assert.NoError(t, err) {
+				continue
+			}
+			sop := file.SOPInstanceUID()
+			code, stored := handler(sop)
+			item := map[string]any{"00081155": map[string]any{"vr": "UI", "Value": []string{sop}}}
+			if stored {
+				if code != 0 {
+					item["00081196"] = map[string]any{"vr": "US", "Value": []uint16{code}}
+				}
+				referenced = append(referenced, item)
+			} else {
+				item["00081197"] = map[string]any{"vr": "US", "Value": []uint16{code}}
+				failed = append(failed, item)
+			}
+		}
+
+		response := map[string]any{}
+		if len(referenced) > 0 {
+			response["00081199"] = map[string]any{"vr": "SQ", "Value": referenced}
+		}
+		if len(failed) > 0 {
+			response["00081198"] = map[string]any{"vr": "SQ", "Value": failed}
+		}
+		w.Header().Set("Content-Type", "application/dicom+json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := dicomweb.NewClient(config.DICOMwebConfig{URL: server.URL}, 5*time.Second)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return client, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestStoreDICOMwebRetriesTransientFailures(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = time.Second }()
+
+	// The service is unavailable at first, then runs out of resources once for
+	// 1.2.3.1.4, refuses 1.2.3.1.3 and stores 1.2.3.1.2 with a warning
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	client, requests := startSTOWServer(t, func(sop string) (uint16, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[sop]++
+		switch {
+		case sop == "1.2.3.1.4" && attempts[sop] == 1:
+			return 0xA700, false
+		case sop == "1.2.3.1.3":
+			return 0xA900, false
+		case sop == "1.2.3.1.2":
+			return 0xB000, true
+		}
+		return 0, true
+	})
+
+	dir := t.TempDir()
+	files := writeTestInstances(t, dir, 4)
+	journal, err := openJournal(dir, "", "ARCHIVE", client.URL())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer journal.Close()
+
+	results := storeDICOMwebWithRetries(context.Background(), client, files, journal, 3)
+	if !assert.Len(t, results, len(files)) {
+		return
+	}
+	assert.Equal(t, pacs.CategorySuccess, results[0].Category, "%v", results[0].Err)
+	assert.Equal(t, pacs.CategoryWarning, results[1].Category)
+	assert.Equal(t, uint16(0xA900), results[2].Status.Code)
+	assert.False(t, isTransient(results[2].Err))
+	assert.Equal(t, pacs.CategorySuccess, results[3].Category, "%v", results[3].Err)
+
+	// Permanent failures are not retried
+	assert.Equal(t, 3, requests())
+	assert.Equal(t, map[string]int{"1.2.3.1.1": 1, "1.2.3.1.2": 1, "1.2.3.1.3": 1, "1.2.3.1.4": 2}, attempts)
+
+	// Acknowledged instances are skipped when the study is sent again
+	results = storeDICOMweb(context.Background(), client, files, journal)
+	assert.True(t, results[0].Skipped)
+	assert.True(t, results[3].Skipped)
+	assert.False(t, results[2].Skipped)
+	assert.Equal(t, 2, attempts["1.2.3.1.3"])
+}
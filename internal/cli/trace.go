@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// traceFlags returns the flags that record the DICOM protocol exchange of a
+// network command
+func traceFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "trace",
+			Usage: "Record every PDU sent and received, with decoded fields, as JSON Lines in the given file",
+		},
+		&cli.StringFlag{
+			Name:  "trace-pcap",
+			Usage: "Record the associations as a libpcap capture in the given file, for Wireshark's DICOM dissector",
+		},
+	}
+}
+
+// traced wraps the action of a command with trace flags. The trace files
+// requested with --trace and --trace-pcap are opened before the action runs
+// and closed when it returns, including when it returns a cli.Exit error:
+// urfave/cli exits the process for those before any After hook runs.
+func traced(action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) (err error) {
+		if err := startTrace(c); err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := stopTrace(c); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}()
+		return action(c)
+	}
+}
+
+// startTrace opens the trace files requested with --trace and --trace-pcap
+// and attaches the tracer to the command context
+func startTrace(c *cli.Context) error {
+	tracePath, pcapPath := c.String("trace"), c.String("trace-pcap")
+	if tracePath == "" && pcapPath == "" {
+		return nil
+	}
+
+	tracer, err := pacs.NewTracer(tracePath, pcapPath)
+	if err != nil {
+		return err
+	}
+	c.Context = pacs.WithTracer(c.Context, tracer)
+	logrus.Infof("Tracing DICOM associations (JSON Lines: %q, pcap: %q)", tracePath, pcapPath)
+	return nil
+}
+
+// stopTrace closes the tracer started for the command
+func stopTrace(c *cli.Context) error {
+	return pacs.TracerFromContext(c.Context).Close()
+}
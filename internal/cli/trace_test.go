@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestEchoTrace(t *testing.T) {
+	pacsConfig := startStoreServer(t, nil)
+	dir := t.TempDir()
+	tracePath, pcapPath := filepath.Join(dir, "echo.jsonl"), filepath.Join(dir, "echo.pcap")
+
+	app := &cli.App{
+		Before: func(c *cli.Context) error {
+			c.Context = context.WithValue(c.Context, "config", config.DefaultConfig())
+			return nil
+		},
+		Commands: []*cli.Command{EchoCommand()},
+	}
+	err := app.Run([]string{"crgodicom", "echo",
+		"--host", pacsConfig.Host, "--port", strconv.Itoa(pacsConfig.Port),
+		"--aec", pacsConfig.AEC, "--aet", pacsConfig.AET,
+		"--trace", tracePath, "--trace-pcap", pcapPath})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	trace, err := os.ReadFile(tracePath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(trace), `"pdu":"A-ASSOCIATE-RQ"`)
+	assert.Contains(t, string(trace), `"command":"C-ECHO-RSP"`)
+	assert.Contains(t, string(trace), `"event":"close"`)
+
+	capture, err := os.Stat(pcapPath)
+	if assert.NoError(t, err) {
+		assert.Greater(t, capture.Size(), int64(24))
+	}
+}
+
+func TestTraceClosedBeforeExit(t *testing.T) {
+	pacsConfig := startStoreServer(t, nil)
+	tracePath := filepath.Join(t.TempDir(), "send.jsonl")
+
+	// urfave/cli exits the process for a cli.Exit error before the After
+	// hooks run, so the trace must be closed by the time the exit is handled
+	var actionCtx context.Context
+	exitCode := 0
+	app := &cli.App{
+		Commands: []*cli.Command{{
+			Name:  "partial",
+			Flags: traceFlags(),
+			Action: traced(func(c *cli.Context) error {
+				actionCtx = c.Context
+				if err := echoPACS(c.Context, pacsConfig); err != nil {
+					return err
+				}
+				return cli.Exit("1 of 2 files were not stored", exitSendRejected)
+			}),
+		}},
+		ExitErrHandler: func(c *cli.Context, err error) {
+			if exitErr, ok := err.(cli.ExitCoder); ok {
+				exitCode = exitErr.ExitCode()
+			}
+			// An association after the exit is not recorded
+			assert.NoError(t, echoPACS(actionCtx, pacsConfig))
+		},
+	}
+	assert.Error(t, app.Run([]string{"crgodicom", "partial", "--trace", tracePath}))
+	assert.Equal(t, exitSendRejected, exitCode)
+
+	trace, err := os.ReadFile(tracePath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(trace), `"pdu":"A-ASSOCIATE-RQ"`))
+	assert.Contains(t, string(trace), `"event":"close"`)
+}
@@ -0,0 +1,430 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/dicomweb"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// transferOptions control how files are spread across associations
+type transferOptions struct {
+	associations int
+	maxRate      float64 // Files per second across all associations, 0 for no limit
+	asyncOps     int     // Outstanding C-STORE requests proposed per association
+	journal      *transferJournal
+}
+
+// retryBaseDelay is the backoff before the first retry, doubled for each further attempt
+var retryBaseDelay = time.Second
+
+// maxRetryDelay caps the backoff between retries
+const maxRetryDelay = 30 * time.Second
+
+// errNoAssociation marks files left unsent after every association failed
+var errNoAssociation = errors.New("not sent: no association left")
+
+// transferFlags returns the throughput flags shared by send and store
+func transferFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:  "associations",
+			Usage: "Number of concurrent associations to spread the files across",
+			Value: 1,
+		},
+		&cli.Float64Flag{
+			Name:  "max-rate",
+			Usage: "Maximum files per second across all associations (0: unlimited)",
+		},
+		&cli.IntFlag{
+			Name:  "async-ops",
+			Usage: "Outstanding C-STORE requests per association, proposed in the Asynchronous Operations Window",
+			Value: 1,
+		},
+	}
+}
+
+// transferOptionsFromFlags reads the throughput flags
+func transferOptionsFromFlags(c *cli.Context) transferOptions {
+	return transferOptions{
+		associations: max(c.Int("associations"), 1),
+		maxRate:      c.Float64("max-rate"),
+		asyncOps:     max(c.Int("async-ops"), 1),
+	}
+}
+
+// storeFiles sends the files over the client's association and up to
+// opts.associations-1 additional ones, keeping as many C-STORE requests
+// outstanding on each as its operations window allows. It prints a
+// throughput and latency summary and returns the result of each file in order.
+func storeFiles(ctx context.Context, client *pacs.Client, files []string, opts transferOptions) []sendResult {
+	clients := []*pacs.Client{client}
+	for i := 1; i < opts.associations; i++ {
+		extra := pacs.NewClient(client.GetConfig())
+		extra.SetAsyncOperations(opts.asyncOps)
+		if err := extra.Connect(ctx); err != nil {
+			logrus.Warnf("Failed to open association %d of %d, continuing with %d: %v", i+1, opts.associations, len(clients), err)
+			break
+		}
+		defer extra.Disconnect()
+		clients = append(clients, extra)
+	}
+
+	jobs := make(chan int, len(files))
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+
+	results := make([]sendResult, len(files))
+	limiter := newRateLimiter(opts.maxRate)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for _, worker := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			storeWorker(ctx, worker, files, jobs, results, limiter, opts.journal)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// Files left in the queue after every association failed were never sent
+	for i := range results {
+		if results[i].File == "" {
+			results[i].File = files[i]
+			results[i].setOutcome(pacs.Status{}, errNoAssociation)
+		}
+	}
+
+	printTransferSummary(results, elapsed, len(clients), client.MaxOperations())
+	return results
+}
+
+// storeWorker sends queued files over one association until the queue is
+// empty or the association fails, recording each outcome in results and the
+// journal. Instances the journal shows as acknowledged are skipped.
+func storeWorker(ctx context.Context, client *pacs.Client, files []string, jobs <-chan int, results []sendResult, limiter *rateLimiter, journal *transferJournal) {
+	type inflight struct {
+		index int
+		start time.Time
+	}
+	pending := make(map[uint16]inflight)
+	window := client.MaxOperations()
+	queued := true
+
+	for {
+		for queued && len(pending) < window {
+			index, ok := <-jobs
+			if !ok {
+				queued = false
+				break
+			}
+
+			result, data := readSendFile(files[index])
+			results[index] = result
+			if result.Err != nil {
+				logrus.Errorf("Failed to read %s: %v", files[index], result.Err)
+				continue
+			}
+			if journal.isAcknowledged(result.Instance.SOPInstanceUID) {
+				logrus.Debugf("Skipping %s, already acknowledged", files[index])
+				results[index].Skipped = true
+				results[index].setOutcome(pacs.Status{Code: pacs.StatusSuccess}, nil)
+				continue
+			}
+			if err := limiter.wait(ctx); err != nil {
+				results[index].setOutcome(pacs.Status{}, err)
+				continue
+			}
+
+			logrus.Infof("Sending %s", files[index])
+			sentAt := time.Now()
+			messageID, err := client.CStoreAsync(ctx, data, result.Instance.SOPInstanceUID)
+			if err != nil {
+				logrus.Errorf("Failed to send %s: %v", files[index], err)
+				results[index].setOutcome(pacs.Status{}, err)
+				journal.record(&results[index])
+				if !associationLost(err) {
+					continue
+				}
+				// The association is unusable: fail what is outstanding and leave the rest to the others
+				logrus.Errorf("Association failed with %d requests outstanding", len(pending))
+				for _, op := range pending {
+					results[op.index].setOutcome(pacs.Status{}, err)
+					journal.record(&results[op.index])
+				}
+				return
+			}
+			pending[messageID] = inflight{index: index, start: sentAt}
+		}
+		if len(pending) == 0 {
+			return
+		}
+
+		messageID, status, err := client.CStoreResponse(ctx)
+		var statusErr *pacs.StatusError
+		if err != nil && !errors.As(err, &statusErr) {
+			// The association is unusable: fail what is outstanding and leave the rest to the others
+			logrus.Errorf("Association failed with %d requests outstanding: %v", len(pending), err)
+			for _, op := range pending {
+				results[op.index].setOutcome(pacs.Status{}, err)
+				journal.record(&results[op.index])
+			}
+			return
+		}
+
+		op, ok := pending[messageID]
+		if !ok {
+			logrus.Warnf("Ignoring C-STORE response to unknown message %d", messageID)
+			continue
+		}
+		delete(pending, messageID)
+
+		results[op.index].Latency = time.Since(op.start)
+		results[op.index].setOutcome(status, err)
+		journal.record(&results[op.index])
+		if err != nil {
+			logrus.Errorf("Failed to send %s: %v", files[op.index], err)
+		}
+	}
+}
+
+// storeFilesWithRetries stores the files like storeFiles, then retries the
+// transient failures up to retries times with exponential backoff, each time
+// over new associations since the earlier ones may have been lost
+func storeFilesWithRetries(ctx context.Context, client *pacs.Client, files []string, opts transferOptions, retries int) []sendResult {
+	results := storeFiles(ctx, client, files, opts)
+
+	for attempt := 1; attempt <= retries; attempt++ {
+		var retry []int
+		var paths []string
+		for i := range results {
+			if !results[i].stored() && isTransient(results[i].Err) {
+				retry = append(retry, i)
+				paths = append(paths, results[i].File)
+			}
+		}
+		if len(retry) == 0 {
+			break
+		}
+
+		logrus.Warnf("Retrying %d file(s) in %s (attempt %d of %d)", len(retry), retryDelay(attempt), attempt, retries)
+		if waitForRetry(ctx, attempt) != nil {
+			return results
+		}
+
+		retryClient := pacs.NewClient(client.GetConfig())
+		retryClient.SetAsyncOperations(opts.asyncOps)
+		if err := retryClient.Connect(ctx); err != nil {
+			logrus.Errorf("Failed to reconnect for retry: %v", err)
+			for _, i := range retry {
+				results[i].setOutcome(pacs.Status{}, err)
+			}
+			continue
+		}
+		retried := storeFiles(ctx, retryClient, paths, opts)
+		retryClient.Disconnect()
+
+		for j, i := range retry {
+			results[i] = retried[j]
+		}
+	}
+	return results
+}
+
+// connectWithRetries opens an association and checks it with a C-ECHO,
+// retrying transient failures up to retries times with the backoff used for
+// failed files
+func connectWithRetries(ctx context.Context, pacsConfig *config.PACSConfig, asyncOps, retries int) (*pacs.Client, error) {
+	for attempt := 1; ; attempt++ {
+		client := pacs.NewClient(pacsConfig)
+		client.SetAsyncOperations(asyncOps)
+
+		err := client.Connect(ctx)
+		if err != nil {
+			err = fmt.Errorf("failed to connect to PACS: %w", err)
+		} else if err = client.CEcho(ctx); err != nil {
+			client.Disconnect()
+			err = fmt.Errorf("C-ECHO failed: %w", err)
+		} else {
+			return client, nil
+		}
+
+		if attempt > retries || !isTransient(err) {
+			return nil, err
+		}
+		logrus.Warnf("%v; retrying in %s (attempt %d of %d)", err, retryDelay(attempt), attempt, retries)
+		if waitErr := waitForRetry(ctx, attempt); waitErr != nil {
+			return nil, err
+		}
+	}
+}
+
+// waitForRetry waits for the backoff before the given retry attempt,
+// returning the error of ctx when it is cancelled first
+func waitForRetry(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(retryDelay(attempt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isTransient reports whether a failed send may succeed when retried: the
+// peer was out of resources or busy, or the connection was lost
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *pacs.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status.Retryable()
+	}
+	var httpErr *dicomweb.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Temporary()
+	}
+	return associationLost(err) || errors.Is(err, errNoAssociation)
+}
+
+// associationLost reports whether err means the connection of an association was lost
+func associationLost(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryDelay returns the backoff before the given retry attempt, starting at 1
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << (attempt - 1)
+	if delay <= 0 || delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// readSendFile reads a file to send, returning its result with the instance
+// and series filled in, or with the error when it cannot be sent
+func readSendFile(path string) (sendResult, []byte) {
+	result := sendResult{File: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		result.setOutcome(pacs.Status{}, err)
+		return result, nil
+	}
+	file, err := dicom.ParseFile(data)
+	if err != nil {
+		result.setOutcome(pacs.Status{}, err)
+		return result, nil
+	}
+	instance, err := sopInstance(file)
+	if err != nil {
+		result.setOutcome(pacs.Status{}, err)
+		return result, nil
+	}
+
+	result.Instance = instance
+	result.SeriesInstanceUID = file.Dataset.String(dicom.TagSeriesInstanceUID)
+	result.SeriesDescription = file.Dataset.String(dicom.TagSeriesDescription)
+	result.Size = len(data)
+	return result, data
+}
+
+// rateLimiter spaces operations evenly to stay under a rate shared by all associations
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter returns a limiter for perSecond operations, or nil for no limit
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next operation may start; a nil limiter never blocks
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// printTransferSummary prints the throughput of the transfer and the
+// percentiles of the C-STORE latency of the files that got a response
+func printTransferSummary(results []sendResult, elapsed time.Duration, associations, window int) {
+	var bytes int
+	var latencies []time.Duration
+	for _, result := range results {
+		if result.Latency > 0 {
+			bytes += result.Size
+			latencies = append(latencies, result.Latency)
+		}
+	}
+	slices.Sort(latencies)
+
+	seconds := max(elapsed.Seconds(), 1e-9)
+	fmt.Printf("Transfer: %d files (%.1f MB) in %s over %d association(s), window %d: %.1f files/s, %.2f MB/s\n",
+		len(latencies), float64(bytes)/1e6, elapsed.Round(time.Millisecond), associations, window,
+		float64(len(latencies))/seconds, float64(bytes)/1e6/seconds)
+	if len(latencies) == 0 {
+		return
+	}
+	fmt.Printf("Latency: min %s, p50 %s, p90 %s, p95 %s, p99 %s, max %s\n",
+		latencies[0].Round(time.Microsecond),
+		percentile(latencies, 50).Round(time.Microsecond),
+		percentile(latencies, 90).Round(time.Microsecond),
+		percentile(latencies, 95).Round(time.Microsecond),
+		percentile(latencies, 99).Round(time.Microsecond),
+		latencies[len(latencies)-1].Round(time.Microsecond))
+}
+
+// percentile returns the p-th percentile of sorted durations using the nearest-rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
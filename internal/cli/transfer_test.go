@@ -0,0 +1,315 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/dicomweb"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{0, 1 * time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("p%.0f", tt.p), func(t *testing.T) {
+			assert.Equal(t, tt.expected, percentile(latencies, tt.p))
+		})
+	}
+	assert.Equal(t, time.Duration(0), percentile(nil, 50))
+}
+
+func TestRateLimiterSpacesOperations(t *testing.T) {
+	limiter := newRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, limiter.wait(context.Background()))
+	}
+	// The first operation starts immediately and the next four are 10ms apart
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	assert.NoError(t, (*rateLimiter)(nil).wait(context.Background()))
+}
+
+// startStoreServer starts a storage SCP on a random local port
+func startStoreServer(t *testing.T, handler pacs.StoreHandler) *config.PACSConfig {
+	server := pacs.NewServer(&pacs.ServerConfig{AETitle: "TEST_SCP", Timeout: 5}, handler)
+	if !assert.NoError(t, server.Listen("127.0.0.1:0")) {
+		t.FailNow()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go server.Serve(ctx)
+
+	addr := server.Addr().(*net.TCPAddr)
+	return &config.PACSConfig{Host: "127.0.0.1", Port: addr.Port, AEC: "TEST_SCU", AET: "TEST_SCP", Timeout: 5}
+}
+
+// writeTestInstances writes count CT instances of one series to dir
+func writeTestInstances(t *testing.T, dir string, count int) []string {
+	var files []string
+	for i := 1; i <= count; i++ {
+		sop := fmt.Sprintf("1.2.3.1.%d", i)
+		ds := dicom.NewDataset()
+		ds.SetString(dicom.TagSOPClassUID, pacs.SOPClassCTImageStorage)
+		ds.SetString(dicom.TagSOPInstanceUID, sop)
+		ds.SetString(dicom.TagStudyInstanceUID, "1.2.3")
+		ds.SetString(dicom.TagSeriesInstanceUID, "1.2.3.1")
+		encoded, err := dicom.EncodeDataset(ds, dicom.ExplicitVRLittleEndian)
+		assert.NoError(t, err)
+		data, err := dicom.EncodeFile("CRGODICOM", pacs.SOPClassCTImageStorage, sop, dicom.ExplicitVRLittleEndian, encoded)
+		assert.NoError(t, err)
+
+		path := filepath.Join(dir, fmt.Sprintf("image_%03d.dcm", i))
+		assert.NoError(t, os.WriteFile(path, data, 0644))
+		files = append(files, path)
+	}
+	return files
+}
+
+func TestStoreFilesAcrossAssociations(t *testing.T) {
+	var mu sync.Mutex
+	stored := make(map[string]bool)
+	cfg := startStoreServer(t, func(sopClass, sopInstance, ts string, data []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		stored[sopInstance] = true
+		return nil
+	})
+	files := writeTestInstances(t, t.TempDir(), 12)
+
+	ctx := context.Background()
+	client := pacs.NewClient(cfg)
+	opts := transferOptions{associations: 3, asyncOps: 4}
+	client.SetAsyncOperations(opts.asyncOps)
+	if !assert.NoError(t, client.Connect(ctx)) {
+		return
+	}
+	defer client.Disconnect()
+
+	results := storeFiles(ctx, client, files, opts)
+	if !assert.Len(t, results, len(files)) {
+		return
+	}
+	for i, result := range results {
+		assert.Equal(t, files[i], result.File)
+		assert.Equal(t, pacs.CategorySuccess, result.Category, "file %s: %v", result.File, result.Err)
+		assert.Equal(t, "1.2.3.1", result.SeriesInstanceUID)
+		assert.Greater(t, result.Latency, time.Duration(0))
+	}
+	assert.Len(t, stored, len(files))
+}
+
+// startDroppingProxy relays associations to cfg. The first association is
+// reset after the proxy has relayed its first pdus PDUs from the server.
+func startDroppingProxy(t *testing.T, cfg *config.PACSConfig, pdus int) *config.PACSConfig {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for first := true; ; first = false {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)))
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go io.Copy(upstream, conn)
+			if !first {
+				go io.Copy(conn, upstream)
+				continue
+			}
+			go func() {
+				defer upstream.Close()
+				defer conn.Close()
+				for range pdus {
+					header := make([]byte, 6)
+					if _, err := io.ReadFull(upstream, header); err != nil {
+						return
+					}
+					length := int(header[2])<<24 | int(header[3])<<16 | int(header[4])<<8 | int(header[5])
+					pdu := make([]byte, length)
+					if _, err := io.ReadFull(upstream, pdu); err != nil {
+						return
+					}
+					if _, err := conn.Write(append(header, pdu...)); err != nil {
+						return
+					}
+				}
+				conn.(*net.TCPConn).SetLinger(0)
+			}()
+		}
+	}()
+
+	proxy := *cfg
+	proxy.Port = listener.Addr().(*net.TCPAddr).Port
+	return &proxy
+}
+
+func TestStoreFilesSurvivesDroppedAssociation(t *testing.T) {
+	var mu sync.Mutex
+	stored := make(map[string]bool)
+	cfg := startStoreServer(t, func(sopClass, sopInstance, ts string, data []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		stored[sopInstance] = true
+		return nil
+	})
+	// The first association is reset after its A-ASSOCIATE-AC and first C-STORE-RSP
+	cfg = startDroppingProxy(t, cfg, 2)
+	files := writeTestInstances(t, t.TempDir(), 20)
+
+	ctx := context.Background()
+	client := pacs.NewClient(cfg)
+	opts := transferOptions{associations: 2, asyncOps: 2}
+	client.SetAsyncOperations(opts.asyncOps)
+	if !assert.NoError(t, client.Connect(ctx)) {
+		return
+	}
+	defer client.Disconnect()
+
+	results := storeFiles(ctx, client, files, opts)
+	failed := 0
+	for _, result := range results {
+		if !result.stored() {
+			failed++
+			assert.True(t, isTransient(result.Err), "file %s: %v", result.File, result.Err)
+			continue
+		}
+		assert.True(t, stored[result.Instance.SOPInstanceUID], "file %s", result.File)
+	}
+	// Only the requests in flight on the dropped association fail; the other
+	// association sends the rest of the study
+	assert.Greater(t, failed, 0)
+	assert.LessOrEqual(t, failed, opts.asyncOps+1)
+}
+
+func TestStoreFilesRetriesTransientFailures(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = time.Second }()
+
+	// The first attempt at each instance runs out of resources, the second is
+	// refused for 1.2.3.1.3 and stored for the others
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	cfg := startStoreServer(t, func(sopClass, sopInstance, ts string, data []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[sopInstance]++
+		switch {
+		case attempts[sopInstance] == 1:
+			return &pacs.StatusError{Status: pacs.Status{Code: 0xA700, ErrorComment: "Out of resources"}}
+		case sopInstance == "1.2.3.1.3":
+			return &pacs.StatusError{Status: pacs.Status{Code: 0xA900}}
+		}
+		return nil
+	})
+	files := writeTestInstances(t, t.TempDir(), 4)
+
+	ctx := context.Background()
+	client := pacs.NewClient(cfg)
+	if !assert.NoError(t, client.Connect(ctx)) {
+		return
+	}
+	defer client.Disconnect()
+
+	results := storeFilesWithRetries(ctx, client, files, transferOptions{associations: 1, asyncOps: 1}, 3)
+	for i, result := range results {
+		if i == 2 {
+			assert.Equal(t, uint16(0xA900), result.Status.Code)
+			continue
+		}
+		assert.Equal(t, pacs.CategorySuccess, result.Category, "file %s: %v", result.File, result.Err)
+	}
+	// Permanent failures are not retried
+	assert.Equal(t, map[string]int{"1.2.3.1.1": 2, "1.2.3.1.2": 2, "1.2.3.1.3": 2, "1.2.3.1.4": 2}, attempts)
+}
+
+func TestConnectWithRetries(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = time.Second }()
+
+	cfg := startStoreServer(t, nil)
+	ctx := context.Background()
+
+	// The first connection through each proxy is reset before the association is accepted
+	_, err := connectWithRetries(ctx, startDroppingProxy(t, cfg, 0), 1, 0)
+	assert.True(t, isTransient(err), "error %v", err)
+
+	client, err := connectWithRetries(ctx, startDroppingProxy(t, cfg, 0), 1, 2)
+	if assert.NoError(t, err) {
+		assert.NoError(t, client.CEcho(ctx))
+		client.Disconnect()
+	}
+
+	// Permanent failures are not retried
+	rejected := *cfg
+	rejected.AET = "SOMEONE_ELSE"
+	_, err = connectWithRetries(ctx, &rejected, 1, 5)
+	assert.Error(t, err)
+	assert.False(t, isTransient(err))
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"success", nil, false},
+		{"out of resources", &pacs.StatusError{Status: pacs.Status{Code: 0xA702}}, true},
+		{"data set mismatch", &pacs.StatusError{Status: pacs.Status{Code: 0xA900}}, false},
+		{"cannot understand", fmt.Errorf("C-STORE failed: %w", &pacs.StatusError{Status: pacs.Status{Code: 0xC000}}), false},
+		{"refused", &pacs.StatusError{Status: pacs.Status{Code: pacs.StatusNotAuthorized}}, false},
+		{"connection reset", fmt.Errorf("failed to read PDU: %w", &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}), true},
+		{"connection closed", fmt.Errorf("failed to read PDU: %w", io.EOF), true},
+		{"no association", errNoAssociation, true},
+		{"unreadable file", os.ErrNotExist, false},
+		{"service unavailable", &dicomweb.HTTPError{StatusCode: 503, Status: "503 Service Unavailable"}, true},
+		{"unauthorized", &dicomweb.HTTPError{StatusCode: 401, Status: "401 Unauthorized"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.transient, isTransient(tt.err))
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Second, retryDelay(1))
+	assert.Equal(t, 4*time.Second, retryDelay(3))
+	assert.Equal(t, maxRetryDelay, retryDelay(10))
+	assert.Equal(t, maxRetryDelay, retryDelay(100))
+}
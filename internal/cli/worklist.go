@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/flatmapit/crgodicom/pkg/types"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// worklistFlags returns the Modality Worklist flags of the create command
+func worklistFlags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "from-worklist",
+			Usage: "Query a Modality Worklist and create one study per scheduled procedure step",
+		},
+		&cli.StringFlag{
+			Name:  "worklist-station",
+			Usage: "Scheduled Station AE Title to match (default: all stations)",
+		},
+		&cli.StringFlag{
+			Name:  "worklist-date",
+			Usage: "Scheduled date to match: YYYYMMDD, a range YYYYMMDD-YYYYMMDD or 'today'",
+		},
+	}, append(connectionFlags("worklist-", "Worklist SCP"), traceFlags()...)...)
+}
+
+// createFromWorklist creates one study per scheduled procedure step returned
+// by the worklist SCP, carrying the patient and request attributes over
+func createFromWorklist(c *cli.Context, cfg *config.Config, template *config.TemplateConfig, transferSyntax string) error {
+	query := pacs.WorklistQuery{
+		ScheduledStationAETitle: c.String("worklist-station"),
+		ScheduledDate:           c.String("worklist-date"),
+		PatientID:               c.String("patient-id"),
+		AccessionNumber:         c.String("accession-number"),
+	}
+	if query.ScheduledDate == "today" {
+		query.ScheduledDate = time.Now().Format("20060102")
+	}
+	// The modality flag has a default, so only filter on it when given explicitly
+	if c.IsSet("modality") {
+		query.Modality = c.String("modality")
+	}
+
+	pacsConfig, err := resolveDestination(c, "worklist-")
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Querying Modality Worklist at %s:%d (AEC: %s, AET: %s)",
+		pacsConfig.Host, pacsConfig.Port, pacsConfig.AEC, pacsConfig.AET)
+
+	client := pacs.NewClient(pacsConfig)
+	ctx, cancel := context.WithTimeout(c.Context, time.Duration(pacsConfig.Timeout)*time.Second)
+	defer cancel()
+
+	if err := client.Connect(ctx); err != nil {
+		return fmt.Errorf("failed to establish DICOM association: %w", err)
+	}
+	items, err := client.FindWorklist(ctx, query)
+	client.Disconnect()
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("no scheduled procedure steps matched the worklist query")
+	}
+
+	logrus.Infof("Worklist returned %d scheduled procedure step(s)", len(items))
+
+	generator := dicom.NewGenerator(cfg)
+	writer := dicom.NewWriter(cfg)
+
+	for i, item := range items {
+		params := worklistStudyParams(c, item, template)
+		params.TransferSyntaxUID = transferSyntax
+		if err := validateCreateParams(StudyCreateParams{
+			StudyCount:  1,
+			SeriesCount: params.SeriesCount,
+			ImageCount:  params.ImageCount,
+			Modality:    params.Modality,
+		}); err != nil {
+			return fmt.Errorf("invalid parameters for scheduled procedure step %s: %w", item.ScheduledProcedureStepID, err)
+		}
+
+		study, err := generator.GenerateStudy(params)
+		if err != nil {
+			return fmt.Errorf("failed to generate study for scheduled procedure step %s: %w", item.ScheduledProcedureStepID, err)
+		}
+		if err := writer.WriteStudy(study, &params, params.OutputDir); err != nil {
+			return fmt.Errorf("failed to write study for scheduled procedure step %s: %w", item.ScheduledProcedureStepID, err)
+		}
+
+		logrus.Infof("Created study %d/%d for accession %s (step %s): %s",
+			i+1, len(items), study.AccessionNumber, item.ScheduledProcedureStepID, study.StudyInstanceUID)
+	}
+
+	fmt.Printf("Successfully created %d study(ies) from worklist in directory: %s\n", len(items), c.String("output-dir"))
+	return nil
+}
+
+// worklistStudyParams builds the generation parameters of a scheduled procedure step
+func worklistStudyParams(c *cli.Context, item pacs.WorklistItem, template *config.TemplateConfig) types.StudyParams {
+	// Prefer the scheduled modality, falling back to the modality flag
+	modality := item.Modality
+	if modality == "" {
+		modality = c.String("modality")
+	}
+
+	description := c.String("study-description")
+	if description == "" {
+		description = item.RequestedProcedureDescription
+	}
+	if description == "" {
+		description = item.ScheduledProcedureStepDescription
+	}
+
+	return types.StudyParams{
+		StudyCount:                    1,
+		SeriesCount:                   c.Int("series-count"),
+		ImageCount:                    c.Int("image-count"),
+		Modality:                      modality,
+		AnatomicalRegion:              c.String("anatomical-region"),
+		PatientName:                   item.PatientName,
+		PatientID:                     item.PatientID,
+		AccessionNumber:               item.AccessionNumber,
+		StudyDescription:              description,
+		StudyInstanceUID:              item.StudyInstanceUID,
+		RequestedProcedureID:          item.RequestedProcedureID,
+		RequestedProcedureDescription: item.RequestedProcedureDescription,
+		ScheduledProcedureStepID:      item.ScheduledProcedureStepID,
+		ScheduledStationAETitle:       item.ScheduledStationAETitle,
+		OutputDir:                     c.String("output-dir"),
+		Template:                      template,
+		TemplateName:                  c.String("template"),
+	}
+}
@@ -4,18 +4,21 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the application configuration
 type Config struct {
-	DICOM          DICOMConfig               `yaml:"dicom"`
-	DefaultPACS    PACSConfig                `yaml:"default_pacs"`
-	StudyTemplates map[string]TemplateConfig `yaml:"study_templates"`
-	Logging        LoggingConfig             `yaml:"logging"`
-	Storage        StorageConfig             `yaml:"storage"`
-	TestPACS       map[string]PACSConfig     `yaml:"test_pacs"`
+	DICOM          DICOMConfig                  `yaml:"dicom"`
+	DefaultPACS    PACSConfig                   `yaml:"default_pacs"`
+	StudyTemplates map[string]TemplateConfig    `yaml:"study_templates"`
+	Logging        LoggingConfig                `yaml:"logging"`
+	Storage        StorageConfig                `yaml:"storage"`
+	Destinations   map[string]DestinationConfig `yaml:"destinations"`
+	TestPACS       map[string]PACSConfig        `yaml:"test_pacs,omitempty"` // Deprecated: merged into Destinations
 }
 
 // DICOMConfig contains DICOM-specific configuration
@@ -25,11 +28,64 @@ type DICOMConfig struct {
 
 // PACSConfig represents PACS connection configuration
 type PACSConfig struct {
-	Host    string `yaml:"host"`
-	Port    int    `yaml:"port"`
-	AEC     string `yaml:"aec"`
-	AET     string `yaml:"aet"`
-	Timeout int    `yaml:"timeout"`
+	Host     string         `yaml:"host"`
+	Port     int            `yaml:"port"`
+	AEC      string         `yaml:"aec"`
+	AET      string         `yaml:"aet"`
+	Timeout  int            `yaml:"timeout"`
+	TLS      TLSConfig      `yaml:"tls,omitempty"`
+	Identity IdentityConfig `yaml:"identity,omitempty"`
+	DICOMweb DICOMwebConfig `yaml:"dicomweb,omitempty"`
+}
+
+// DICOMwebConfig represents the DICOMweb (PS3.18) service of a destination
+type DICOMwebConfig struct {
+	URL      string `yaml:"url,omitempty"`      // Base URL of the service, e.g. https://pacs.example.com/dicom-web
+	Username string `yaml:"username,omitempty"` // HTTP basic authentication
+	Password string `yaml:"password,omitempty"`
+	Token    string `yaml:"token,omitempty"` // Bearer token; takes precedence over basic authentication
+}
+
+// IdentityConfig represents the User Identity presented when associating (PS3.7 D.3.3.7)
+type IdentityConfig struct {
+	Type             string `yaml:"type,omitempty"` // username, username-password or jwt; inferred from the fields when empty
+	Username         string `yaml:"username,omitempty"`
+	Password         string `yaml:"password,omitempty"`
+	Token            string `yaml:"token,omitempty"`             // JSON Web Token
+	PositiveResponse bool   `yaml:"positive_response,omitempty"` // Require the server to acknowledge the identity
+}
+
+// TLSConfig represents DICOM over TLS settings (PS3.15 Annex B)
+type TLSConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	CAFile     string `yaml:"ca_file,omitempty"`     // PEM bundle to verify the peer; system roots when empty
+	CertFile   string `yaml:"cert_file,omitempty"`   // PEM certificate presented to the peer
+	KeyFile    string `yaml:"key_file,omitempty"`    // PEM private key of the certificate
+	ServerName string `yaml:"server_name,omitempty"` // Name to verify the server certificate against instead of the host
+	MinVersion string `yaml:"min_version,omitempty"` // Minimum TLS version: 1.2 (default) or 1.3
+}
+
+// DestinationConfig is a named PACS, or a fan-out list of other destination
+// names when written as a YAML sequence
+type DestinationConfig struct {
+	PACSConfig
+	Members []string
+}
+
+// UnmarshalYAML decodes a sequence of names as a fan-out list and a mapping as a PACS
+func (d *DestinationConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&d.Members)
+	}
+	return node.Decode(&d.PACSConfig)
+}
+
+// MarshalYAML encodes a fan-out list as a sequence of names and a PACS as a mapping
+func (d DestinationConfig) MarshalYAML() (interface{}, error) {
+	if len(d.Members) > 0 {
+		return d.Members, nil
+	}
+	return d.PACSConfig, nil
 }
 
 // TemplateConfig represents a study template configuration
@@ -42,6 +98,13 @@ type TemplateConfig struct {
 	PatientName      string `yaml:"patient_name,omitempty"`
 	PatientID        string `yaml:"patient_id,omitempty"`
 	AccessionNumber  string `yaml:"accession_number,omitempty"`
+	TransferSyntax   string `yaml:"transfer_syntax,omitempty"` // implicit-le, explicit-le, explicit-be, deflated or a compression
+
+	// Volume geometry of CT and MR series; unset fields take the modality defaults
+	Orientation    string   `yaml:"orientation,omitempty"`     // axial (default), sagittal or coronal
+	FieldOfView    float64  `yaml:"field_of_view,omitempty"`   // Width of the slices in mm
+	SliceThickness float64  `yaml:"slice_thickness,omitempty"` // mm
+	SliceGap       *float64 `yaml:"slice_gap,omitempty"`       // mm between slices, negative for overlapping slices
 }
 
 // LoggingConfig represents logging configuration
@@ -128,21 +191,22 @@ func DefaultConfig() *Config {
 			Compression: false,
 			IndexCache:  true,
 		},
-		TestPACS: map[string]PACSConfig{
-			"orthanc_1": {
+		Destinations: map[string]DestinationConfig{
+			"orthanc_1": {PACSConfig: PACSConfig{
 				Host:    "localhost",
 				Port:    4900,
 				AEC:     "CRGODICOM",
 				AET:     "ORTHANC1",
 				Timeout: 30,
-			},
-			"orthanc_2": {
+			}},
+			"orthanc_2": {PACSConfig: PACSConfig{
 				Host:    "localhost",
 				Port:    4901,
 				AEC:     "CRGODICOM",
 				AET:     "ORTHANC2",
 				Timeout: 30,
-			},
+			}},
+			"all-test": {Members: []string{"orthanc_1", "orthanc_2"}},
 		},
 	}
 }
@@ -165,6 +229,16 @@ func (c *Config) validateAndSetDefaults() {
 		}
 	}
 
+	// Test PACS entries from older configuration files are destinations too
+	for name, pacs := range c.TestPACS {
+		if _, exists := c.Destinations[name]; !exists {
+			if c.Destinations == nil {
+				c.Destinations = make(map[string]DestinationConfig)
+			}
+			c.Destinations[name] = DestinationConfig{PACSConfig: pacs}
+		}
+	}
+
 	// Set default study templates if not specified
 	if c.StudyTemplates == nil {
 		c.StudyTemplates = getBuiltInTemplates()
@@ -249,3 +323,76 @@ func (c *Config) ListTemplates() []string {
 	}
 	return templates
 }
+
+// ResolveDestination returns the PACS configurations a destination name
+// stands for, expanding fan-out lists in order and dropping duplicates.
+// Fields a destination leaves empty are taken from the default PACS, which
+// is also what an empty name resolves to.
+func (c *Config) ResolveDestination(name string) ([]PACSConfig, error) {
+	if name == "" || name == "default" {
+		return []PACSConfig{c.DefaultPACS}, nil
+	}
+
+	var resolved []PACSConfig
+	if err := c.resolveDestination(name, nil, &resolved); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// resolveDestination appends the PACS configurations of a destination,
+// following fan-out lists through path to detect cycles
+func (c *Config) resolveDestination(name string, path []string, resolved *[]PACSConfig) error {
+	if slices.Contains(path, name) {
+		return fmt.Errorf("destination %q refers to itself: %s -> %s", name, strings.Join(path, " -> "), name)
+	}
+	dest, exists := c.Destinations[name]
+	if !exists {
+		return fmt.Errorf("unknown destination %q (configured: %s)", name, strings.Join(c.ListDestinations(), ", "))
+	}
+
+	if len(dest.Members) == 0 {
+		pacs := c.withPACSDefaults(dest.PACSConfig)
+		if !slices.Contains(*resolved, pacs) {
+			*resolved = append(*resolved, pacs)
+		}
+		return nil
+	}
+	for _, member := range dest.Members {
+		if err := c.resolveDestination(member, append(path, name), resolved); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withPACSDefaults fills the empty connection fields of a PACS configuration
+// from the default PACS. TLS, identity and DICOMweb settings are not inherited.
+func (c *Config) withPACSDefaults(pacs PACSConfig) PACSConfig {
+	if pacs.Host == "" {
+		pacs.Host = c.DefaultPACS.Host
+	}
+	if pacs.Port == 0 {
+		pacs.Port = c.DefaultPACS.Port
+	}
+	if pacs.AEC == "" {
+		pacs.AEC = c.DefaultPACS.AEC
+	}
+	if pacs.AET == "" {
+		pacs.AET = c.DefaultPACS.AET
+	}
+	if pacs.Timeout == 0 {
+		pacs.Timeout = c.DefaultPACS.Timeout
+	}
+	return pacs
+}
+
+// ListDestinations returns the configured destination names in order
+func (c *Config) ListDestinations() []string {
+	var names []string
+	for name := range c.Destinations {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveDestination(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Destinations["partial"] = DestinationConfig{PACSConfig: PACSConfig{Host: "pacs.local", AET: "ARCHIVE"}}
+	cfg.Destinations["nested"] = DestinationConfig{Members: []string{"all-test", "orthanc_1", "partial"}}
+	cfg.Destinations["loop"] = DestinationConfig{Members: []string{"orthanc_1", "loop-back"}}
+	cfg.Destinations["loop-back"] = DestinationConfig{Members: []string{"loop"}}
+
+	tests := []struct {
+		name    string
+		dest    string
+		aets    []string
+		wantErr bool
+	}{
+		{"default", "", []string{"PACS_SERVER"}, false},
+		{"single", "orthanc_2", []string{"ORTHANC2"}, false},
+		{"fan-out", "all-test", []string{"ORTHANC1", "ORTHANC2"}, false},
+		{"nested fan-out without duplicates", "nested", []string{"ORTHANC1", "ORTHANC2", "ARCHIVE"}, false},
+		{"unknown", "nowhere", nil, true},
+		{"cycle", "loop", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := cfg.ResolveDestination(tt.dest)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			var aets []string
+			for _, pacs := range resolved {
+				aets = append(aets, pacs.AET)
+			}
+			assert.Equal(t, tt.aets, aets)
+		})
+	}
+
+	// Fields a destination leaves out come from the default PACS
+	resolved, err := cfg.ResolveDestination("partial")
+	if assert.NoError(t, err) {
+		assert.Equal(t, PACSConfig{Host: "pacs.local", Port: 11112, AEC: "CRGODICOM", AET: "ARCHIVE", Timeout: 30}, resolved[0])
+	}
+}
+
+func TestLoadConfigDestinations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crgodicom.yaml")
+	data := `
+destinations:
+  archive:
+    host: archive.local
+    port: 104
+    aet: ARCHIVE
+  both: [archive, legacy]
+test_pacs:
+  legacy:
+    host: legacy.local
+    port: 4242
+    aec: CRGODICOM
+    aet: LEGACY
+`
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+	cfg, err := LoadConfig(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"archive", "both", "legacy"}, cfg.ListDestinations())
+	assert.Equal(t, []string{"archive", "legacy"}, cfg.Destinations["both"].Members)
+
+	resolved, err := cfg.ResolveDestination("both")
+	if assert.NoError(t, err) && assert.Len(t, resolved, 2) {
+		assert.Equal(t, "archive.local", resolved[0].Host)
+		assert.Equal(t, 104, resolved[0].Port)
+		assert.Equal(t, "LEGACY", resolved[1].AET)
+	}
+
+	// Fan-out lists are written back as sequences
+	saved := filepath.Join(t.TempDir(), "saved.yaml")
+	assert.NoError(t, SaveConfig(cfg, saved))
+	reloaded, err := LoadConfig(saved)
+	if assert.NoError(t, err) {
+		assert.Equal(t, cfg.Destinations, reloaded.Destinations)
+	}
+}
@@ -0,0 +1,139 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Transfer Syntax UIDs with encapsulated pixel data crgodicom encodes and decodes
+const (
+	JPEGBaseline8Bit = "1.2.840.10008.1.2.4.50"
+	JPEGLosslessSV1  = "1.2.840.10008.1.2.4.70"
+	JPEGLSLossless   = "1.2.840.10008.1.2.4.80"
+	RLELossless      = "1.2.840.10008.1.2.5"
+)
+
+// frameCodec compresses and decompresses single frames of monochrome pixel
+// data. Uncompressed frames are little endian.
+type frameCodec struct {
+	encode func(pixels []byte, width, height, bitsAllocated int) ([]byte, error)
+	decode func(frame []byte, width, height, bitsAllocated int) ([]byte, error)
+
+	maxBits     int    // Largest Bits Allocated the codec encodes
+	lossyMethod string // Lossy Image Compression Method, empty for lossless codecs
+}
+
+// frameCodecs holds the codecs of the encapsulated transfer syntaxes
+var frameCodecs = map[string]frameCodec{
+	RLELossless:      {encode: encodeRLE, decode: decodeRLE, maxBits: 16},
+	JPEGBaseline8Bit: {encode: encodeJPEGBaseline, decode: decodeJPEGBaseline, maxBits: 8, lossyMethod: "ISO_10918_1"},
+	JPEGLosslessSV1:  {encode: encodeJPEGLossless, decode: decodeJPEGLossless, maxBits: 16},
+	JPEGLSLossless:   {encode: encodeJPEGLS, decode: decodeJPEGLS, maxBits: 16},
+}
+
+// IsEncapsulatedTransferSyntax reports whether the transfer syntax is one of
+// the compressed syntaxes crgodicom encodes and decodes
+func IsEncapsulatedTransferSyntax(transferSyntaxUID string) bool {
+	_, ok := frameCodecs[transferSyntaxUID]
+	return ok
+}
+
+// EncapsulatedTransferSyntaxes returns the compressed transfer syntaxes
+// crgodicom encodes and decodes, sorted by UID
+func EncapsulatedTransferSyntaxes() []string {
+	return slices.Sorted(maps.Keys(frameCodecs))
+}
+
+// encodeFrame compresses a frame with the codec of a transfer syntax
+func encodeFrame(transferSyntaxUID string, pixels []byte, width, height, bitsAllocated int) ([]byte, error) {
+	codec, ok := frameCodecs[transferSyntaxUID]
+	if !ok {
+		return nil, fmt.Errorf("no encoder for transfer syntax %s", transferSyntaxUID)
+	}
+	if bitsAllocated > codec.maxBits {
+		return nil, fmt.Errorf("transfer syntax %s supports at most %d bits allocated, got %d", transferSyntaxUID, codec.maxBits, bitsAllocated)
+	}
+	if len(pixels) < width*height*((bitsAllocated+7)/8) {
+		return nil, fmt.Errorf("pixel data is shorter than a %dx%d frame", width, height)
+	}
+	return codec.encode(pixels, width, height, bitsAllocated)
+}
+
+// decodeFrame decompresses a frame with the codec of a transfer syntax
+func decodeFrame(transferSyntaxUID string, frame []byte, width, height, bitsAllocated int) ([]byte, error) {
+	codec, ok := frameCodecs[transferSyntaxUID]
+	if !ok {
+		return nil, fmt.Errorf("no decoder for transfer syntax %s", transferSyntaxUID)
+	}
+	return codec.decode(frame, width, height, bitsAllocated)
+}
+
+// encapsulate returns the pixel data fragments of compressed frames: a Basic
+// Offset Table followed by one fragment per frame, padded to an even length
+func encapsulate(frames [][]byte) [][]byte {
+	offsets := make([]byte, 0, 4*len(frames))
+	fragments := make([][]byte, 1, len(frames)+1)
+
+	offset := uint32(0)
+	for _, frame := range frames {
+		offsets = binary.LittleEndian.AppendUint32(offsets, offset)
+		if len(frame)%2 == 1 {
+			frame = append(frame[:len(frame):len(frame)], 0x00)
+		}
+		fragments = append(fragments, frame)
+		offset += 8 + uint32(len(frame)) // Item tag and length precede each fragment
+	}
+
+	fragments[0] = offsets
+	return fragments
+}
+
+// firstFrame returns the first frame of the encapsulated pixel data of a
+// dataset. The fragments of a single frame image all belong to that frame;
+// multi-frame images are expected to hold one fragment per frame.
+func firstFrame(ds *Dataset, elem *Element) ([]byte, error) {
+	if len(elem.Fragments) < 2 {
+		return nil, fmt.Errorf("encapsulated pixel data has no fragments")
+	}
+	if ds.Int(Tag{0x0028, 0x0008}) > 1 || len(elem.Fragments) == 2 {
+		return elem.Fragments[1], nil
+	}
+
+	var frame []byte
+	for _, fragment := range elem.Fragments[1:] {
+		frame = append(frame, fragment...)
+	}
+	return frame, nil
+}
+
+// frameSamples returns the samples of a little endian frame
+func frameSamples(pixels []byte, count, bitsAllocated int) []int {
+	samples := make([]int, count)
+	for i := range samples {
+		if bitsAllocated <= 8 {
+			samples[i] = int(pixels[i])
+		} else {
+			samples[i] = int(binary.LittleEndian.Uint16(pixels[2*i:]))
+		}
+	}
+	return samples
+}
+
+// packSamples returns samples as a little endian frame
+func packSamples(samples []int, bitsAllocated int) []byte {
+	if bitsAllocated <= 8 {
+		pixels := make([]byte, len(samples))
+		for i, sample := range samples {
+			pixels[i] = byte(sample)
+		}
+		return pixels
+	}
+
+	pixels := make([]byte, 0, 2*len(samples))
+	for _, sample := range samples {
+		pixels = binary.LittleEndian.AppendUint16(pixels, uint16(sample))
+	}
+	return pixels
+}
@@ -0,0 +1,192 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackBits(t *testing.T) {
+	tests := []struct {
+		name string
+		src  []byte
+		want []byte
+	}{
+		{"single byte", []byte{7}, []byte{0, 7}},
+		{"replicate run", []byte{5, 5, 5}, []byte{0xFE, 5}},
+		{"literal then run", []byte{1, 2, 3, 3}, []byte{1, 1, 2, 0xFF, 3}},
+		{"long run", bytes.Repeat([]byte{9}, 130), []byte{0x81, 9, 0xFF, 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := packBits(nil, tt.src)
+			assert.Equal(t, tt.want, got)
+
+			unpacked, err := unpackBits(got, len(tt.src))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.src, unpacked)
+		})
+	}
+
+	_, err := unpackBits([]byte{0x05, 1, 2}, 6)
+	assert.Error(t, err)
+}
+
+func TestRLERoundTrip(t *testing.T) {
+	const width, height = 200, 3
+
+	// Rows mix literal runs longer than 128 bytes with replicate runs
+	pixels8 := make([]byte, width*height)
+	for i := range pixels8 {
+		if i%width < 150 {
+			pixels8[i] = byte(i * 7)
+		} else {
+			pixels8[i] = 42
+		}
+	}
+	pixels16 := make([]byte, 0, 2*width*height)
+	for i := range width * height {
+		pixels16 = binary.LittleEndian.AppendUint16(pixels16, uint16(i*37%4096))
+	}
+
+	for _, tc := range []struct {
+		bits   int
+		pixels []byte
+	}{{8, pixels8}, {16, pixels16}} {
+		frame, err := encodeFrame(RLELossless, tc.pixels, width, height, tc.bits)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, uint32(tc.bits/8), binary.LittleEndian.Uint32(frame))
+		assert.Zero(t, len(frame)%2)
+
+		decoded, err := decodeFrame(RLELossless, frame, width, height, tc.bits)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.pixels, decoded)
+	}
+
+	_, err := encodeFrame(JPEGBaseline8Bit, pixels16, width, height, 16)
+	assert.ErrorContains(t, err, "at most 8 bits")
+}
+
+func TestJPEGBaselineRoundTrip(t *testing.T) {
+	const width, height = 16, 16
+	pixels := make([]byte, width*height)
+	for y := range height {
+		for x := range width {
+			pixels[y*width+x] = byte(8 * (x + y))
+		}
+	}
+
+	frame, err := encodeFrame(JPEGBaseline8Bit, pixels, width, height, 8)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []byte{0xFF, 0xD8}, frame[:2])
+
+	decoded, err := decodeFrame(JPEGBaseline8Bit, frame, width, height, 8)
+	if assert.NoError(t, err) && assert.Len(t, decoded, len(pixels)) {
+		for i := range pixels {
+			assert.InDelta(t, int(pixels[i]), int(decoded[i]), 8)
+		}
+	}
+}
+
+func TestEncapsulate(t *testing.T) {
+	fragments := encapsulate([][]byte{{1, 2, 3}, {4, 5}})
+	if !assert.Len(t, fragments, 3) {
+		return
+	}
+	assert.Equal(t, []byte{0, 0, 0, 0, 12, 0, 0, 0}, fragments[0])
+	assert.Equal(t, []byte{1, 2, 3, 0}, fragments[1])
+	assert.Equal(t, []byte{4, 5}, fragments[2])
+}
+
+func TestLosslessCodecsRoundTrip(t *testing.T) {
+	const width, height = 64, 48
+	rng := rand.New(rand.NewSource(1))
+
+	// Frames with runs, smooth gradients, noise and the extremes of the range
+	images := map[string]func(x, y, maxVal int) int{
+		"flat":     func(x, y, maxVal int) int { return maxVal / 3 },
+		"gradient": func(x, y, maxVal int) int { return (x*37 + y*11) % (maxVal + 1) },
+		"noise":    func(x, y, maxVal int) int { return rng.Intn(maxVal + 1) },
+		"extremes": func(x, y, maxVal int) int { return maxVal * ((x/3 + y) % 2) },
+		"body": func(x, y, maxVal int) int {
+			dx, dy := x-width/2, y-height/2
+			if dx*dx+dy*dy > 400 {
+				return 0
+			}
+			return min(maxVal, 1000+dx*dx+rng.Intn(16))
+		},
+	}
+
+	for _, transferSyntax := range []string{RLELossless, JPEGLosslessSV1, JPEGLSLossless} {
+		for _, bits := range []int{8, 16} {
+			for name, sample := range images {
+				t.Run(fmt.Sprintf("%s/%d/%s", transferSyntax, bits, name), func(t *testing.T) {
+					samples := make([]int, width*height)
+					for i := range samples {
+						samples[i] = sample(i%width, i/width, 1<<bits-1)
+					}
+					pixels := packSamples(samples, bits)
+
+					frame, err := encodeFrame(transferSyntax, pixels, width, height, bits)
+					if !assert.NoError(t, err) {
+						return
+					}
+					decoded, err := decodeFrame(transferSyntax, frame, width, height, bits)
+					assert.NoError(t, err)
+					assert.Equal(t, pixels, decoded)
+				})
+			}
+		}
+	}
+}
+
+func TestJPEGLSExample(t *testing.T) {
+	// The 4x4 8-bit example of ITU-T T.87 Annex H.3
+	pixels := []byte{
+		0, 0, 90, 74,
+		68, 50, 43, 205,
+		64, 145, 145, 145,
+		100, 145, 145, 145,
+	}
+	encoded := []byte{
+		0xFF, 0xD8, 0xFF, 0xF7, 0x00, 0x0B, 0x08, 0x00, 0x04, 0x00, 0x04, 0x01, 0x01, 0x11, 0x00,
+		0xFF, 0xDA, 0x00, 0x08, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0xC0, 0x00, 0x00, 0x6C, 0x80, 0x20, 0x8E, 0x01, 0xC0, 0x00, 0x00, 0x57, 0x40, 0x00, 0x00,
+		0x6E, 0xE6, 0x00, 0x00, 0x01, 0xBC, 0x18, 0x00, 0x00, 0x05, 0xD8, 0x00, 0x00, 0x91, 0x60,
+		0xFF, 0xD9,
+	}
+
+	frame, err := encodeJPEGLS(pixels, 4, 4, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, encoded, frame)
+
+	decoded, err := decodeJPEGLS(encoded, 4, 4, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, pixels, decoded)
+}
+
+func TestJPEGLosslessHeader(t *testing.T) {
+	pixels := packSamples([]int{0, 4095, 2048, 65535}, 16)
+	frame, err := encodeFrame(JPEGLosslessSV1, pixels, 2, 2, 16)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// SOI, then a lossless (SOF3) frame header of 16-bit precision
+	assert.Equal(t, []byte{0xFF, 0xD8, 0xFF, 0xC3, 0x00, 0x0B, 0x10, 0x00, 0x02, 0x00, 0x02}, frame[:11])
+	assert.Equal(t, []byte{0xFF, 0xD9}, frame[len(frame)-2:])
+
+	_, err = decodeFrame(JPEGLosslessSV1, frame[:len(frame)-6], 2, 2, 16)
+	assert.Error(t, err)
+	_, err = decodeFrame(JPEGLosslessSV1, frame, 4, 1, 16)
+	assert.ErrorContains(t, err, "expected 4x1")
+}
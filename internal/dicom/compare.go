@@ -0,0 +1,87 @@
+package dicom
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// CompareDatasets reports the elements of original that are missing from, or
+// differ in, other. Elements only present in other are ignored, since archives
+// commonly add attributes of their own. Both datasets are normalised to
+// Explicit VR Little Endian before comparison.
+func CompareDatasets(original, other *Dataset) ([]string, error) {
+	a, err := normaliseDataset(original)
+	if err != nil {
+		return nil, err
+	}
+	b, err := normaliseDataset(other)
+	if err != nil {
+		return nil, err
+	}
+
+	var differences []string
+	compareElements(a, b, "", &differences)
+	return differences, nil
+}
+
+// normaliseDataset re-encodes a dataset as Explicit VR Little Endian
+func normaliseDataset(ds *Dataset) (*Dataset, error) {
+	data, err := EncodeDataset(ds, ExplicitVRLittleEndian)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDataset(data, ExplicitVRLittleEndian)
+}
+
+// compareElements compares the elements of a against b, recursing into sequences
+func compareElements(a, b *Dataset, path string, differences *[]string) {
+	for _, elem := range a.Elements {
+		// Group lengths are recomputed by every writer
+		if elem.Tag.Element == 0x0000 {
+			continue
+		}
+
+		name := path + elem.Tag.String()
+		other := b.Get(elem.Tag)
+		if other == nil {
+			*differences = append(*differences, fmt.Sprintf("%s missing", name))
+			continue
+		}
+
+		switch {
+		case elem.Items != nil || other.Items != nil:
+			if len(elem.Items) != len(other.Items) {
+				*differences = append(*differences, fmt.Sprintf("%s has %d items, expected %d", name, len(other.Items), len(elem.Items)))
+				continue
+			}
+			for i := range elem.Items {
+				compareElements(elem.Items[i], other.Items[i], fmt.Sprintf("%s[%d]", name, i), differences)
+			}
+		case elem.Fragments != nil || other.Fragments != nil:
+			if !equalFragments(elem.Fragments, other.Fragments) {
+				*differences = append(*differences, fmt.Sprintf("%s encapsulated pixel data differs", name))
+			}
+		case vrWordSize(elem.VR) == 1 && elem.VR != "OB" && elem.VR != "UN":
+			if trimValue(elem.Value) != trimValue(other.Value) {
+				*differences = append(*differences, fmt.Sprintf("%s is %q, expected %q", name, trimValue(other.Value), trimValue(elem.Value)))
+			}
+		default:
+			if !bytes.Equal(elem.Value, other.Value) {
+				*differences = append(*differences, fmt.Sprintf("%s value differs (%d bytes, expected %d)", name, len(other.Value), len(elem.Value)))
+			}
+		}
+	}
+}
+
+// equalFragments compares encapsulated pixel data, ignoring the Basic Offset Table
+func equalFragments(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 1; i < len(a); i++ {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
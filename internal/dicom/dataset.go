@@ -0,0 +1,329 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tag identifies a DICOM data element by its group and element numbers
+type Tag struct {
+	Group   uint16
+	Element uint16
+}
+
+// String returns the tag in standard (GGGG,EEEE) format
+func (t Tag) String() string {
+	return fmt.Sprintf("(%04X,%04X)", t.Group, t.Element)
+}
+
+// Commonly used DICOM tags
+var (
+	// File Meta Information (group 0002)
+	TagFileMetaInformationGroupLength = Tag{0x0002, 0x0000}
+	TagFileMetaInformationVersion     = Tag{0x0002, 0x0001}
+	TagMediaStorageSOPClassUID        = Tag{0x0002, 0x0002}
+	TagMediaStorageSOPInstanceUID     = Tag{0x0002, 0x0003}
+	TagTransferSyntaxUID              = Tag{0x0002, 0x0010}
+	TagImplementationClassUID         = Tag{0x0002, 0x0012}
+	TagImplementationVersionName      = Tag{0x0002, 0x0013}
+	TagSourceApplicationEntityTitle   = Tag{0x0002, 0x0016}
+
+	// Patient, study, series and instance identification
+	TagSpecificCharacterSet = Tag{0x0008, 0x0005}
+	TagSOPClassUID          = Tag{0x0008, 0x0016}
+	TagSOPInstanceUID       = Tag{0x0008, 0x0018}
+	TagStudyDate            = Tag{0x0008, 0x0020}
+	TagStudyTime            = Tag{0x0008, 0x0030}
+	TagAccessionNumber      = Tag{0x0008, 0x0050}
+	TagQueryRetrieveLevel   = Tag{0x0008, 0x0052}
+	TagModality             = Tag{0x0008, 0x0060}
+	TagModalitiesInStudy    = Tag{0x0008, 0x0061}
+	TagInstitutionName      = Tag{0x0008, 0x0080}
+	TagReferringPhysician   = Tag{0x0008, 0x0090}
+	TagStudyDescription     = Tag{0x0008, 0x1030}
+	TagSeriesDescription    = Tag{0x0008, 0x103E}
+	TagPatientName          = Tag{0x0010, 0x0010}
+	TagPatientID            = Tag{0x0010, 0x0020}
+	TagPatientBirthDate     = Tag{0x0010, 0x0030}
+	TagPatientSex           = Tag{0x0010, 0x0040}
+	TagStudyInstanceUID     = Tag{0x0020, 0x000D}
+	TagSeriesInstanceUID    = Tag{0x0020, 0x000E}
+	TagStudyID              = Tag{0x0020, 0x0010}
+	TagSeriesNumber         = Tag{0x0020, 0x0011}
+	TagInstanceNumber       = Tag{0x0020, 0x0013}
+
+	// Query/Retrieve counts
+	TagNumberOfStudyRelatedSeries     = Tag{0x0020, 0x1206}
+	TagNumberOfStudyRelatedInstances  = Tag{0x0020, 0x1208}
+	TagNumberOfSeriesRelatedInstances = Tag{0x0020, 0x1209}
+
+	// Referenced instances and storage commitment
+	TagReferencedSOPClassUID    = Tag{0x0008, 0x1150}
+	TagReferencedSOPInstanceUID = Tag{0x0008, 0x1155}
+	TagTransactionUID           = Tag{0x0008, 0x1195}
+	TagFailureReason            = Tag{0x0008, 0x1197}
+	TagFailedSOPSequence        = Tag{0x0008, 0x1198}
+	TagReferencedSOPSequence    = Tag{0x0008, 0x1199}
+
+	// Modality worklist
+	TagRequestedProcedureDescription     = Tag{0x0032, 0x1060}
+	TagScheduledStationAETitle           = Tag{0x0040, 0x0001}
+	TagScheduledProcedureStepStartDate   = Tag{0x0040, 0x0002}
+	TagScheduledProcedureStepStartTime   = Tag{0x0040, 0x0003}
+	TagScheduledProcedureStepDescription = Tag{0x0040, 0x0007}
+	TagScheduledProcedureStepID          = Tag{0x0040, 0x0009}
+	TagScheduledProcedureStepSequence    = Tag{0x0040, 0x0100}
+	TagRequestedProcedureID              = Tag{0x0040, 0x1001}
+	TagRequestAttributesSequence         = Tag{0x0040, 0x0275}
+
+	// Modality performed procedure step
+	TagRetrieveAETitle                        = Tag{0x0008, 0x0054}
+	TagStationName                            = Tag{0x0008, 0x1010}
+	TagProcedureCodeSequence                  = Tag{0x0008, 0x1032}
+	TagPerformingPhysicianName                = Tag{0x0008, 0x1050}
+	TagOperatorsName                          = Tag{0x0008, 0x1070}
+	TagReferencedStudySequence                = Tag{0x0008, 0x1110}
+	TagReferencedPatientSequence              = Tag{0x0008, 0x1120}
+	TagReferencedImageSequence                = Tag{0x0008, 0x1140}
+	TagProtocolName                           = Tag{0x0018, 0x1030}
+	TagScheduledProtocolCodeSequence          = Tag{0x0040, 0x0008}
+	TagReferencedNonImageCompositeSOPSequence = Tag{0x0040, 0x0220}
+	TagPerformedStationAETitle                = Tag{0x0040, 0x0241}
+	TagPerformedStationName                   = Tag{0x0040, 0x0242}
+	TagPerformedLocation                      = Tag{0x0040, 0x0243}
+	TagPerformedProcedureStepStartDate        = Tag{0x0040, 0x0244}
+	TagPerformedProcedureStepStartTime        = Tag{0x0040, 0x0245}
+	TagPerformedProcedureStepEndDate          = Tag{0x0040, 0x0250}
+	TagPerformedProcedureStepEndTime          = Tag{0x0040, 0x0251}
+	TagPerformedProcedureStepStatus           = Tag{0x0040, 0x0252}
+	TagPerformedProcedureStepID               = Tag{0x0040, 0x0253}
+	TagPerformedProcedureStepDescription      = Tag{0x0040, 0x0254}
+	TagPerformedProcedureTypeDescription      = Tag{0x0040, 0x0255}
+	TagPerformedProtocolCodeSequence          = Tag{0x0040, 0x0260}
+	TagScheduledStepAttributesSequence        = Tag{0x0040, 0x0270}
+	TagPerformedSeriesSequence                = Tag{0x0040, 0x0340}
+
+	// Image pixel module
+	TagSamplesPerPixel           = Tag{0x0028, 0x0002}
+	TagPhotometricInterpretation = Tag{0x0028, 0x0004}
+	TagPlanarConfiguration       = Tag{0x0028, 0x0006}
+	TagRows                      = Tag{0x0028, 0x0010}
+	TagColumns                   = Tag{0x0028, 0x0011}
+	TagBitsAllocated             = Tag{0x0028, 0x0100}
+	TagBitsStored                = Tag{0x0028, 0x0101}
+	TagHighBit                   = Tag{0x0028, 0x0102}
+	TagPixelRepresentation       = Tag{0x0028, 0x0103}
+	TagPixelData                 = Tag{0x7FE0, 0x0010}
+
+	// Modality and VOI LUT
+	TagWindowCenter     = Tag{0x0028, 0x1050}
+	TagWindowWidth      = Tag{0x0028, 0x1051}
+	TagRescaleIntercept = Tag{0x0028, 0x1052}
+	TagRescaleSlope     = Tag{0x0028, 0x1053}
+	TagRescaleType      = Tag{0x0028, 0x1054}
+
+	// Frame of reference and image plane
+	TagSliceThickness             = Tag{0x0018, 0x0050}
+	TagImagePositionPatient       = Tag{0x0020, 0x0032}
+	TagImageOrientationPatient    = Tag{0x0020, 0x0037}
+	TagFrameOfReferenceUID        = Tag{0x0020, 0x0052}
+	TagPositionReferenceIndicator = Tag{0x0020, 0x1040}
+	TagSliceLocation              = Tag{0x0020, 0x1041}
+	TagPixelSpacing               = Tag{0x0028, 0x0030}
+
+	// Lossy compression
+	TagLossyImageCompression       = Tag{0x0028, 0x2110}
+	TagLossyImageCompressionRatio  = Tag{0x0028, 0x2112}
+	TagLossyImageCompressionMethod = Tag{0x0028, 0x2114}
+
+	// Item and sequence delimiters
+	TagItem                     = Tag{0xFFFE, 0xE000}
+	TagItemDelimitationItem     = Tag{0xFFFE, 0xE00D}
+	TagSequenceDelimitationItem = Tag{0xFFFE, 0xE0DD}
+)
+
+// Element represents a single DICOM data element
+type Element struct {
+	Tag   Tag
+	VR    string
+	Value []byte // Raw value bytes in the byte order of the owning dataset
+
+	// Items holds the nested datasets of a sequence (SQ) element
+	Items []*Dataset
+
+	// Fragments holds encapsulated pixel data items, the first being the Basic Offset Table
+	Fragments [][]byte
+}
+
+// Dataset is an ordered collection of DICOM data elements
+type Dataset struct {
+	Elements  []*Element
+	BigEndian bool
+}
+
+// NewDataset creates an empty dataset
+func NewDataset() *Dataset {
+	return &Dataset{
+		Elements: make([]*Element, 0),
+	}
+}
+
+// Get returns the element with the given tag, or nil if it is not present
+func (d *Dataset) Get(t Tag) *Element {
+	if d == nil {
+		return nil
+	}
+	for _, elem := range d.Elements {
+		if elem.Tag == t {
+			return elem
+		}
+	}
+	return nil
+}
+
+// Has reports whether the dataset contains the given tag
+func (d *Dataset) Has(t Tag) bool {
+	return d.Get(t) != nil
+}
+
+// String returns the value of a string element with padding removed
+func (d *Dataset) String(t Tag) string {
+	elem := d.Get(t)
+	if elem == nil {
+		return ""
+	}
+	return trimValue(elem.Value)
+}
+
+// Strings returns the individual values of a multi-valued string element
+func (d *Dataset) Strings(t Tag) []string {
+	value := d.String(t)
+	if value == "" {
+		return nil
+	}
+	parts := strings.Split(value, "\\")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+// Int returns the first value of a numeric element as an int.
+// Binary (US, SS, UL, SL) and string (IS, DS) encodings are both supported.
+func (d *Dataset) Int(t Tag) int {
+	elem := d.Get(t)
+	if elem == nil {
+		return 0
+	}
+
+	order := d.byteOrder()
+	switch elem.VR {
+	case "US":
+		if len(elem.Value) >= 2 {
+			return int(order.Uint16(elem.Value))
+		}
+	case "SS":
+		if len(elem.Value) >= 2 {
+			return int(int16(order.Uint16(elem.Value)))
+		}
+	case "UL":
+		if len(elem.Value) >= 4 {
+			return int(order.Uint32(elem.Value))
+		}
+	case "SL":
+		if len(elem.Value) >= 4 {
+			return int(int32(order.Uint32(elem.Value)))
+		}
+	default:
+		values := d.Strings(t)
+		if len(values) == 0 {
+			return 0
+		}
+		if n, err := strconv.Atoi(values[0]); err == nil {
+			return n
+		}
+		if f, err := strconv.ParseFloat(values[0], 64); err == nil {
+			return int(f)
+		}
+	}
+	return 0
+}
+
+// Floats returns the values of a decimal string (DS) element
+func (d *Dataset) Floats(t Tag) []float64 {
+	var values []float64
+	for _, s := range d.Strings(t) {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil
+		}
+		values = append(values, f)
+	}
+	return values
+}
+
+// Set adds or replaces an element, keeping elements in ascending tag order
+func (d *Dataset) Set(t Tag, vr string, value []byte) {
+	elem := &Element{Tag: t, VR: vr, Value: value}
+
+	for i, existing := range d.Elements {
+		if existing.Tag == t {
+			d.Elements[i] = elem
+			return
+		}
+		if t.Group < existing.Tag.Group || (t.Group == existing.Tag.Group && t.Element < existing.Tag.Element) {
+			d.Elements = append(d.Elements[:i], append([]*Element{elem}, d.Elements[i:]...)...)
+			return
+		}
+	}
+	d.Elements = append(d.Elements, elem)
+}
+
+// SetString sets a string element, taking the VR from the dictionary and
+// padding the value to an even length
+func (d *Dataset) SetString(t Tag, value string) {
+	vr := lookupVR(t)
+	data := []byte(value)
+	if len(data)%2 == 1 {
+		data = append(data, paddingByte(vr))
+	}
+	d.Set(t, vr, data)
+}
+
+// SetSequence sets a sequence (SQ) element with the given items
+func (d *Dataset) SetSequence(t Tag, items []*Dataset) {
+	d.Set(t, "SQ", nil)
+	d.Get(t).Items = items
+}
+
+// Clone returns a shallow copy of the dataset that can be modified independently
+func (d *Dataset) Clone() *Dataset {
+	return &Dataset{
+		Elements:  append([]*Element{}, d.Elements...),
+		BigEndian: d.BigEndian,
+	}
+}
+
+// Sequence returns the items of a sequence element
+func (d *Dataset) Sequence(t Tag) []*Dataset {
+	elem := d.Get(t)
+	if elem == nil {
+		return nil
+	}
+	return elem.Items
+}
+
+// byteOrder returns the byte order used for binary values in the dataset
+func (d *Dataset) byteOrder() binary.ByteOrder {
+	if d.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// trimValue strips the trailing space and NUL padding of a string value
+func trimValue(value []byte) string {
+	return strings.TrimRight(string(value), " \x00")
+}
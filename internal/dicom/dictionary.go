@@ -0,0 +1,157 @@
+package dicom
+
+// vrDictionary maps the tags crgodicom reads and writes to their Value Representation.
+// It is consulted when decoding Implicit VR datasets, where the VR is not encoded.
+var vrDictionary = map[Tag]string{
+	// File Meta Information
+	TagFileMetaInformationGroupLength: "UL",
+	TagFileMetaInformationVersion:     "OB",
+	TagMediaStorageSOPClassUID:        "UI",
+	TagMediaStorageSOPInstanceUID:     "UI",
+	TagTransferSyntaxUID:              "UI",
+	TagImplementationClassUID:         "UI",
+	TagImplementationVersionName:      "SH",
+	TagSourceApplicationEntityTitle:   "AE",
+
+	// Identification
+	TagSpecificCharacterSet: "CS",
+	{0x0008, 0x0008}:        "CS", // Image Type
+	{0x0008, 0x0012}:        "DA", // Instance Creation Date
+	{0x0008, 0x0013}:        "TM", // Instance Creation Time
+	TagSOPClassUID:          "UI",
+	TagSOPInstanceUID:       "UI",
+	TagStudyDate:            "DA",
+	{0x0008, 0x0021}:        "DA", // Series Date
+	{0x0008, 0x0022}:        "DA", // Acquisition Date
+	{0x0008, 0x0023}:        "DA", // Content Date
+	TagStudyTime:            "TM",
+	{0x0008, 0x0031}:        "TM", // Series Time
+	{0x0008, 0x0032}:        "TM", // Acquisition Time
+	{0x0008, 0x0033}:        "TM", // Content Time
+	TagAccessionNumber:      "SH",
+	{0x0008, 0x0052}:        "CS", // Query/Retrieve Level
+	{0x0008, 0x0054}:        "AE", // Retrieve AE Title
+	{0x0008, 0x0056}:        "CS", // Instance Availability
+	TagModality:             "CS",
+	{0x0008, 0x0061}:        "CS", // Modalities in Study
+	{0x0008, 0x0070}:        "LO", // Manufacturer
+	{0x0008, 0x0080}:        "LO", // Institution Name
+	{0x0008, 0x0090}:        "PN", // Referring Physician's Name
+	{0x0008, 0x1010}:        "SH", // Station Name
+	TagStudyDescription:     "LO",
+	TagSeriesDescription:    "LO",
+	{0x0008, 0x1090}:        "LO", // Manufacturer's Model Name
+	{0x0008, 0x1150}:        "UI", // Referenced SOP Class UID
+	{0x0008, 0x1155}:        "UI", // Referenced SOP Instance UID
+	{0x0008, 0x1195}:        "UI", // Transaction UID
+	{0x0008, 0x1199}:        "SQ", // Referenced SOP Sequence
+	{0x0008, 0x1198}:        "SQ", // Failed SOP Sequence
+	{0x0008, 0x1197}:        "US", // Failure Reason
+
+	// Patient
+	TagPatientName:      "PN",
+	TagPatientID:        "LO",
+	TagPatientBirthDate: "DA",
+	TagPatientSex:       "CS",
+
+	// Acquisition
+	{0x0018, 0x0015}: "CS", // Body Part Examined
+	{0x0018, 0x0050}: "DS", // Slice Thickness
+	{0x0018, 0x0088}: "DS", // Spacing Between Slices
+
+	// Relationship
+	TagStudyInstanceUID:  "UI",
+	TagSeriesInstanceUID: "UI",
+	{0x0020, 0x0010}:     "SH", // Study ID
+	TagSeriesNumber:      "IS",
+	TagInstanceNumber:    "IS",
+	{0x0020, 0x0032}:     "DS", // Image Position (Patient)
+	{0x0020, 0x0037}:     "DS", // Image Orientation (Patient)
+	{0x0020, 0x0052}:     "UI", // Frame of Reference UID
+	{0x0020, 0x1040}:     "LO", // Position Reference Indicator
+	{0x0020, 0x1041}:     "DS", // Slice Location
+	{0x0020, 0x1206}:     "IS", // Number of Study Related Series
+	{0x0020, 0x1208}:     "IS", // Number of Study Related Instances
+	{0x0020, 0x1209}:     "IS", // Number of Series Related Instances
+
+	// Requested and scheduled procedures
+	TagRequestedProcedureDescription:     "LO",
+	TagScheduledStationAETitle:           "AE",
+	TagScheduledProcedureStepStartDate:   "DA",
+	TagScheduledProcedureStepStartTime:   "TM",
+	TagScheduledProcedureStepDescription: "LO",
+	TagScheduledProcedureStepID:          "SH",
+	TagScheduledProcedureStepSequence:    "SQ",
+	TagRequestedProcedureID:              "SH",
+	TagRequestAttributesSequence:         "SQ",
+
+	// Modality performed procedure step
+	TagProcedureCodeSequence:                  "SQ",
+	TagPerformingPhysicianName:                "PN",
+	TagOperatorsName:                          "PN",
+	TagReferencedStudySequence:                "SQ",
+	TagReferencedPatientSequence:              "SQ",
+	TagReferencedImageSequence:                "SQ",
+	TagProtocolName:                           "LO",
+	TagScheduledProtocolCodeSequence:          "SQ",
+	TagReferencedNonImageCompositeSOPSequence: "SQ",
+	TagPerformedStationAETitle:                "AE",
+	TagPerformedStationName:                   "SH",
+	TagPerformedLocation:                      "SH",
+	TagPerformedProcedureStepStartDate:        "DA",
+	TagPerformedProcedureStepStartTime:        "TM",
+	TagPerformedProcedureStepEndDate:          "DA",
+	TagPerformedProcedureStepEndTime:          "TM",
+	TagPerformedProcedureStepStatus:           "CS",
+	TagPerformedProcedureStepID:               "SH",
+	TagPerformedProcedureStepDescription:      "LO",
+	TagPerformedProcedureTypeDescription:      "LO",
+	TagPerformedProtocolCodeSequence:          "SQ",
+	TagScheduledStepAttributesSequence:        "SQ",
+	TagPerformedSeriesSequence:                "SQ",
+
+	// Image pixel
+	TagSamplesPerPixel:           "US",
+	TagPhotometricInterpretation: "CS",
+	TagPlanarConfiguration:       "US",
+	{0x0028, 0x0008}:             "IS", // Number of Frames
+	TagRows:                      "US",
+	TagColumns:                   "US",
+	{0x0028, 0x0030}:             "DS", // Pixel Spacing
+	TagBitsAllocated:             "US",
+	TagBitsStored:                "US",
+	TagHighBit:                   "US",
+	TagPixelRepresentation:       "US",
+	TagWindowCenter:              "DS",
+	TagWindowWidth:               "DS",
+	TagRescaleIntercept:          "DS",
+	TagRescaleSlope:              "DS",
+	TagRescaleType:               "LO",
+	TagPixelData:                 "OW",
+
+	// Lossy compression
+	TagLossyImageCompression:       "CS",
+	TagLossyImageCompressionRatio:  "DS",
+	TagLossyImageCompressionMethod: "CS",
+}
+
+// lookupVR returns the Value Representation for a tag, falling back to UN
+func lookupVR(t Tag) string {
+	if vr, ok := vrDictionary[t]; ok {
+		return vr
+	}
+	// Group length elements are always UL
+	if t.Element == 0x0000 {
+		return "UL"
+	}
+	return "UN"
+}
+
+// hasLongLength reports whether an Explicit VR element uses the 4-byte length form
+func hasLongLength(vr string) bool {
+	switch vr {
+	case "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UC", "UN", "UR", "UT", "UV":
+		return true
+	}
+	return false
+}
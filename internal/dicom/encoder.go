@@ -0,0 +1,311 @@
+package dicom
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UIDRoot is the root of the UIDs defined by crgodicom itself. It is derived
+// from a UUID (PS3.5 B.2), so needs no registration.
+const UIDRoot = "2.25.48593189039879146885316793522699858187"
+
+// Implementation identification written to File Meta Information and
+// association requests. SetImplementationVersion derives them from the build
+// version.
+var (
+	ImplementationClassUID    = UIDRoot + ".1"
+	ImplementationVersionName = "CRGODICOM"
+)
+
+// SetImplementationVersion sets ImplementationClassUID and
+// ImplementationVersionName for a version such as "0.3.0"
+func SetImplementationVersion(version string) {
+	// UID components are numbers without leading zeros; "1-rc.1" becomes 1
+	uid := UIDRoot + ".1"
+	for _, part := range strings.Split(strings.TrimPrefix(version, "v"), ".") {
+		end := strings.IndexFunc(part, func(r rune) bool { return r < '0' || r > '9' })
+		if end < 0 {
+			end = len(part)
+		}
+		n, err := strconv.Atoi(part[:end])
+		if err != nil || len(uid)+1+len(strconv.Itoa(n)) > 64 {
+			break
+		}
+		uid += "." + strconv.Itoa(n)
+		if end < len(part) {
+			// The rest is a prerelease or build suffix such as "-rc.1"
+			break
+		}
+	}
+	ImplementationClassUID = uid
+
+	// Implementation Version Name is a short string of at most 16 characters
+	name := "CRGODICOM_" + strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7E || r == '\\' {
+			return -1
+		}
+		return r
+	}, version)
+	// Truncation must not leave a dangling separator such as "CRGODICOM_v1.10."
+	ImplementationVersionName = strings.TrimRight(name[:min(len(name), 16)], "._-")
+}
+
+// IsNativeTransferSyntax reports whether the transfer syntax is one of the
+// syntaxes with uncompressed pixel data the native reader and encoder support
+func IsNativeTransferSyntax(transferSyntaxUID string) bool {
+	switch transferSyntaxUID {
+	case ImplicitVRLittleEndian, ExplicitVRLittleEndian, ExplicitVRBigEndian, DeflatedExplicitVRLittleEndian:
+		return true
+	}
+	return false
+}
+
+// EncodeDataset encodes a dataset with the given transfer syntax. Binary
+// values are byte swapped when the dataset was read with a different byte
+// order, and deflated syntaxes compress the encoded dataset. Pixel data of
+// encapsulated syntaxes must already be compressed into fragments.
+func EncodeDataset(ds *Dataset, transferSyntaxUID string) ([]byte, error) {
+	if !IsNativeTransferSyntax(transferSyntaxUID) && !IsEncapsulatedTransferSyntax(transferSyntaxUID) {
+		return nil, fmt.Errorf("unsupported transfer syntax for encoding: %s", transferSyntaxUID)
+	}
+
+	e := &encoder{
+		explicit: transferSyntaxUID != ImplicitVRLittleEndian,
+		order:    binary.LittleEndian,
+	}
+	if transferSyntaxUID == ExplicitVRBigEndian {
+		e.order = binary.BigEndian
+		e.bigEndian = true
+	}
+
+	if err := e.writeDataset(ds, ds.Int(TagBitsAllocated)); err != nil {
+		return nil, err
+	}
+	if transferSyntaxUID == DeflatedExplicitVRLittleEndian {
+		return deflate(e.buf.Bytes())
+	}
+	return e.buf.Bytes(), nil
+}
+
+// deflate compresses an encoded dataset with raw deflate, without a zlib header
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to deflate dataset: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to deflate dataset: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodeFile builds a DICOM Part 10 file from a dataset already encoded with
+// the given transfer syntax. The File Meta Information names sourceAETitle as
+// the AE that wrote the file, unless it is empty.
+func EncodeFile(sourceAETitle, sopClassUID, sopInstanceUID, transferSyntaxUID string, dataset []byte) ([]byte, error) {
+	meta := NewDataset()
+	meta.Set(TagFileMetaInformationVersion, "OB", []byte{0x00, 0x01})
+	meta.SetString(TagMediaStorageSOPClassUID, sopClassUID)
+	meta.SetString(TagMediaStorageSOPInstanceUID, sopInstanceUID)
+	meta.SetString(TagTransferSyntaxUID, transferSyntaxUID)
+	meta.SetString(TagImplementationClassUID, ImplementationClassUID)
+	meta.SetString(TagImplementationVersionName, ImplementationVersionName)
+	if sourceAETitle != "" {
+		meta.SetString(TagSourceApplicationEntityTitle, sourceAETitle)
+	}
+
+	// File Meta Information is always Explicit VR Little Endian
+	elements, err := EncodeDataset(meta, ExplicitVRLittleEndian)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode file meta information: %w", err)
+	}
+
+	groupLength := NewDataset()
+	groupLength.Set(TagFileMetaInformationGroupLength, "UL", binary.LittleEndian.AppendUint32(nil, uint32(len(elements))))
+	header, err := EncodeDataset(groupLength, ExplicitVRLittleEndian)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 128, 132+len(header)+len(elements)+len(dataset))
+	data = append(data, "DICM"...)
+	data = append(data, header...)
+	data = append(data, elements...)
+	return append(data, dataset...), nil
+}
+
+// Transcode re-encodes a raw dataset from one transfer syntax to another
+func Transcode(data []byte, fromTransferSyntax, toTransferSyntax string) ([]byte, error) {
+	if fromTransferSyntax == toTransferSyntax {
+		return data, nil
+	}
+	if !IsNativeTransferSyntax(fromTransferSyntax) {
+		return nil, fmt.Errorf("cannot transcode from transfer syntax %s", fromTransferSyntax)
+	}
+	if !IsNativeTransferSyntax(toTransferSyntax) {
+		return nil, fmt.Errorf("cannot transcode to transfer syntax %s", toTransferSyntax)
+	}
+
+	ds, err := ParseDataset(data, fromTransferSyntax)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeDataset(ds, toTransferSyntax)
+}
+
+// encoder writes DICOM data elements to a buffer
+type encoder struct {
+	buf       bytes.Buffer
+	explicit  bool
+	order     binary.ByteOrder
+	bigEndian bool
+}
+
+// writeDataset writes every element of a dataset
+func (e *encoder) writeDataset(ds *Dataset, bitsAllocated int) error {
+	for _, elem := range ds.Elements {
+		if err := e.writeElement(ds, elem, bitsAllocated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeElement writes a single data element, including sequence items and pixel data fragments
+func (e *encoder) writeElement(ds *Dataset, elem *Element, bitsAllocated int) error {
+	vr := elem.VR
+	if len(vr) != 2 {
+		vr = lookupVR(elem.Tag)
+	}
+	if elem.Items != nil {
+		vr = "SQ"
+	}
+	// Implicit VR pixel data is always OW; 8-bit pixels are OB in Explicit VR
+	if elem.Tag == TagPixelData && vr == "OW" && bitsAllocated > 0 && bitsAllocated <= 8 {
+		vr = "OB"
+	}
+
+	switch {
+	case vr == "SQ":
+		e.writeHeader(elem.Tag, vr, undefinedLength)
+		for _, item := range elem.Items {
+			e.writeTag(TagItem)
+			e.writeUint32(undefinedLength)
+			if err := e.writeDataset(item, bitsAllocated); err != nil {
+				return err
+			}
+			e.writeTag(TagItemDelimitationItem)
+			e.writeUint32(0)
+		}
+		e.writeTag(TagSequenceDelimitationItem)
+		e.writeUint32(0)
+		return nil
+
+	case elem.Fragments != nil:
+		e.writeHeader(elem.Tag, "OB", undefinedLength)
+		for _, fragment := range elem.Fragments {
+			e.writeTag(TagItem)
+			e.writeUint32(uint32(len(fragment)))
+			e.buf.Write(fragment)
+		}
+		e.writeTag(TagSequenceDelimitationItem)
+		e.writeUint32(0)
+		return nil
+	}
+
+	value := elem.Value
+	if len(value)%2 == 1 {
+		value = append(append([]byte{}, value...), paddingByte(vr))
+	}
+	if e.explicit && !hasLongLength(vr) && len(value) > 0xFFFF {
+		return fmt.Errorf("element %s value of %d bytes is too long for VR %s", elem.Tag, len(value), vr)
+	}
+	if ds.BigEndian != e.bigEndian {
+		value = swapBytes(value, vrWordSize(vr))
+	}
+
+	e.writeHeader(elem.Tag, vr, uint32(len(value)))
+	e.buf.Write(value)
+	return nil
+}
+
+// writeHeader writes an element tag, VR (for explicit syntaxes) and length
+func (e *encoder) writeHeader(t Tag, vr string, length uint32) {
+	e.writeTag(t)
+
+	if !e.explicit {
+		e.writeUint32(length)
+		return
+	}
+
+	e.buf.WriteString(vr)
+	if hasLongLength(vr) {
+		e.buf.Write([]byte{0x00, 0x00})
+		e.writeUint32(length)
+		return
+	}
+	b := make([]byte, 2)
+	e.order.PutUint16(b, uint16(length))
+	e.buf.Write(b)
+}
+
+// writeTag writes a tag in the encoder's byte order
+func (e *encoder) writeTag(t Tag) {
+	b := make([]byte, 4)
+	e.order.PutUint16(b, t.Group)
+	e.order.PutUint16(b[2:], t.Element)
+	e.buf.Write(b)
+}
+
+// writeUint32 writes a 32-bit value in the encoder's byte order
+func (e *encoder) writeUint32(v uint32) {
+	b := make([]byte, 4)
+	e.order.PutUint32(b, v)
+	e.buf.Write(b)
+}
+
+// vrWordSize returns the size of the binary words of a VR, or 1 for byte and string VRs
+func vrWordSize(vr string) int {
+	switch vr {
+	case "US", "SS", "OW", "AT":
+		return 2
+	case "UL", "SL", "FL", "OL", "OF":
+		return 4
+	case "FD", "OD", "SV", "UV", "OV":
+		return 8
+	}
+	return 1
+}
+
+// swapBytes returns a copy of value with each word of the given size reversed
+func swapBytes(value []byte, size int) []byte {
+	if size <= 1 {
+		return value
+	}
+
+	swapped := make([]byte, len(value))
+	copy(swapped, value)
+	for i := 0; i+size <= len(swapped); i += size {
+		for j := 0; j < size/2; j++ {
+			swapped[i+j], swapped[i+size-1-j] = swapped[i+size-1-j], swapped[i+j]
+		}
+	}
+	return swapped
+}
+
+// paddingByte returns the byte used to pad odd length values of a VR
+func paddingByte(vr string) byte {
+	switch vr {
+	case "UI", "OB", "UN":
+		return 0x00
+	}
+	return ' '
+}
@@ -0,0 +1,149 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranscode(t *testing.T) {
+	le := binary.LittleEndian
+	referencedSequence := Tag{0x0008, 0x1199}
+
+	source := concat(
+		implicitElement(TagSOPInstanceUID, padUID("1.2.3.4.5")),
+		[]byte{0x08, 0x00, 0x99, 0x11, 0xFF, 0xFF, 0xFF, 0xFF},
+		[]byte{0xFE, 0xFF, 0x00, 0xE0, 0xFF, 0xFF, 0xFF, 0xFF},
+		implicitElement(Tag{0x0008, 0x1155}, padUID("1.2.3")),
+		[]byte{0xFE, 0xFF, 0x0D, 0xE0, 0x00, 0x00, 0x00, 0x00},
+		[]byte{0xFE, 0xFF, 0xDD, 0xE0, 0x00, 0x00, 0x00, 0x00},
+		implicitElement(TagPatientName, []byte("DOE^JOHN")),
+		implicitElement(TagRows, us(le, 2)),
+		implicitElement(TagBitsAllocated, us(le, 16)),
+		implicitElement(TagPixelData, []byte{1, 0, 2, 0, 3, 0, 4, 0}),
+	)
+
+	transferSyntaxes := []string{ExplicitVRBigEndian, DeflatedExplicitVRLittleEndian, ExplicitVRLittleEndian, ImplicitVRLittleEndian}
+
+	data, from := source, ImplicitVRLittleEndian
+	for _, to := range transferSyntaxes {
+		t.Run(to, func(t *testing.T) {
+			encoded, err := Transcode(data, from, to)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			ds, err := ParseDataset(encoded, to)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, "1.2.3.4.5", ds.String(TagSOPInstanceUID))
+			assert.Equal(t, "DOE^JOHN", ds.String(TagPatientName))
+			assert.Equal(t, 2, ds.Int(TagRows))
+			if items := ds.Sequence(referencedSequence); assert.Len(t, items, 1) {
+				assert.Equal(t, "1.2.3", items[0].String(Tag{0x0008, 0x1155}))
+			}
+			assert.Equal(t, []byte{1, 0, 2, 0, 3, 0, 4, 0}, ImageFromDataset(ds).PixelData)
+
+			data, from = encoded, to
+		})
+	}
+
+	// The final implicit encoding uses undefined lengths but identical values
+	ds, err := ParseDataset(data, ImplicitVRLittleEndian)
+	assert.NoError(t, err)
+	assert.Len(t, ds.Elements, 6)
+}
+
+func TestTranscodeUnsupported(t *testing.T) {
+	_, err := Transcode([]byte{}, "1.2.840.10008.1.2.4.50", ExplicitVRLittleEndian)
+	assert.Error(t, err)
+}
+
+func TestCompareDatasets(t *testing.T) {
+	original := NewDataset()
+	original.SetString(TagSOPInstanceUID, "1.2.3.4.5")
+	original.SetString(TagPatientName, "DOE^JOHN")
+	original.Set(TagRows, "US", us(binary.LittleEndian, 2))
+
+	// Archives may change the transfer syntax and add attributes of their own
+	encoded, err := EncodeDataset(original, ExplicitVRBigEndian)
+	assert.NoError(t, err)
+	retrieved, err := ParseDataset(encoded, ExplicitVRBigEndian)
+	assert.NoError(t, err)
+	retrieved.SetString(TagInstitutionName, "ARCHIVE")
+
+	differences, err := CompareDatasets(original, retrieved)
+	assert.NoError(t, err)
+	assert.Empty(t, differences)
+
+	retrieved.SetString(TagPatientName, "DOE^JANE")
+	retrieved.Set(TagRows, "US", us(binary.BigEndian, 4))
+	differences, err = CompareDatasets(original, retrieved)
+	assert.NoError(t, err)
+	assert.Len(t, differences, 2)
+}
+
+func TestEncodeFile(t *testing.T) {
+	ds := NewDataset()
+	ds.SetString(TagPatientName, "DOE^JANE")
+	encoded, err := EncodeDataset(ds, ExplicitVRLittleEndian)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	data, err := EncodeFile("CRGODICOM", "1.2.840.10008.5.1.4.1.1.2", "1.2.3.4", ExplicitVRLittleEndian, encoded)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, make([]byte, 128), data[:128])
+	assert.Equal(t, "DICM", string(data[128:132]))
+
+	file, err := ParseFile(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	groupLength := file.Meta.Get(TagFileMetaInformationGroupLength)
+	if assert.NotNil(t, groupLength) {
+		// The group length counts the bytes of the elements following it
+		metaEnd := len(data) - len(file.RawDataset)
+		assert.Equal(t, uint32(metaEnd-132-12), binary.LittleEndian.Uint32(groupLength.Value))
+	}
+	assert.Equal(t, []byte{0x00, 0x01}, file.Meta.Get(TagFileMetaInformationVersion).Value)
+	assert.Equal(t, "CRGODICOM", file.Meta.String(TagSourceApplicationEntityTitle))
+	assert.Equal(t, ImplementationClassUID, file.Meta.String(TagImplementationClassUID))
+	assert.Equal(t, ImplementationVersionName, file.Meta.String(TagImplementationVersionName))
+	assert.Nil(t, file.Dataset.Get(TagTransferSyntaxUID), "file meta elements stay out of the dataset")
+	assert.Equal(t, "DOE^JANE", file.Dataset.String(TagPatientName))
+}
+
+func TestSetImplementationVersion(t *testing.T) {
+	defer func(uid, name string) {
+		ImplementationClassUID, ImplementationVersionName = uid, name
+	}(ImplementationClassUID, ImplementationVersionName)
+
+	tests := []struct {
+		version string
+		uid     string
+		name    string
+	}{
+		{"0.3.0", UIDRoot + ".1.0.3.0", "CRGODICOM_0.3.0"},
+		{"v1.10.2-rc1", UIDRoot + ".1.1.10.2", "CRGODICOM_v1.10"},
+		{"1.2.3-rc.1", UIDRoot + ".1.1.2.3", "CRGODICOM_1.2.3"},
+		{"v1.2.3-rc.1", UIDRoot + ".1.1.2.3", "CRGODICOM_v1.2.3"},
+		{"2.0_beta", UIDRoot + ".1.2.0", "CRGODICOM_2.0_be"},
+		{"12.345.6789", UIDRoot + ".1.12.345.6789", "CRGODICOM_12.345"},
+		{"dev", UIDRoot + ".1", "CRGODICOM_dev"},
+		{"", UIDRoot + ".1", "CRGODICOM"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			SetImplementationVersion(tt.version)
+			assert.Equal(t, tt.uid, ImplementationClassUID)
+			assert.Equal(t, tt.name, ImplementationVersionName)
+			assert.LessOrEqual(t, len(ImplementationClassUID), 64)
+		})
+	}
+}
@@ -59,8 +59,11 @@ func (g *Generator) GenerateStudy(params types.StudyParams) (*types.Study, error
 	// Generate study information
 	studyInfo := g.generateStudyInfo(params.StudyDescription, params.AccessionNumber)
 	
-	// Generate study UID
-	studyUID := g.uidGen.GenerateStudyUID()
+	// Generate study UID unless the worklist scheduled one
+	studyUID := params.StudyInstanceUID
+	if studyUID == "" {
+		studyUID = g.uidGen.GenerateStudyUID()
+	}
 	
 	// Create study
 	study := &types.Study{
@@ -73,11 +76,26 @@ func (g *Generator) GenerateStudy(params types.StudyParams) (*types.Study, error
 		PatientID:        patientInfo.ID,
 		PatientBirthDate: patientInfo.BirthDate.Format("20060102"),
 		Series:           make([]types.Series, 0, params.SeriesCount),
+
+		RequestedProcedureID:          params.RequestedProcedureID,
+		RequestedProcedureDescription: params.RequestedProcedureDescription,
+		ScheduledProcedureStepID:      params.ScheduledProcedureStepID,
+		ScheduledStationAETitle:       params.ScheduledStationAETitle,
+	}
+	
+	// CT and MR series are volumes sharing one frame of reference
+	vol, err := volumeFor(params.Modality, params.Template)
+	if err != nil {
+		return nil, err
+	}
+	frameOfReferenceUID := ""
+	if vol != nil {
+		frameOfReferenceUID = g.uidGen.GenerateInstanceUID()
 	}
 	
 	// Generate series
 	for i := 0; i < params.SeriesCount; i++ {
-		series, err := g.generateSeries(studyUID, params.Modality, i+1, params.ImageCount)
+		series, err := g.generateSeries(studyUID, params.Modality, i+1, params.ImageCount, vol, frameOfReferenceUID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate series %d: %w", i+1, err)
 		}
@@ -88,7 +106,7 @@ func (g *Generator) GenerateStudy(params types.StudyParams) (*types.Study, error
 }
 
 // generateSeries generates a DICOM series
-func (g *Generator) generateSeries(studyUID, modality string, seriesNumber, imageCount int) (*types.Series, error) {
+func (g *Generator) generateSeries(studyUID, modality string, seriesNumber, imageCount int, vol *volume, frameOfReferenceUID string) (*types.Series, error) {
 	seriesUID := g.uidGen.GenerateSeriesUID()
 	
 	series := &types.Series{
@@ -97,11 +115,13 @@ func (g *Generator) generateSeries(studyUID, modality string, seriesNumber, imag
 		Modality:          modality,
 		SeriesDescription: fmt.Sprintf("%s Series %d", modality, seriesNumber),
 		Images:            make([]types.Image, 0, imageCount),
+
+		FrameOfReferenceUID: frameOfReferenceUID,
 	}
 	
 	// Generate images
 	for i := 0; i < imageCount; i++ {
-		image, err := g.generateImage(studyUID, seriesUID, modality, i+1)
+		image, err := g.generateImage(studyUID, seriesUID, modality, i+1, vol, imageCount)
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate image %d: %w", i+1, err)
 		}
@@ -111,8 +131,8 @@ func (g *Generator) generateSeries(studyUID, modality string, seriesNumber, imag
 	return series, nil
 }
 
-// generateImage generates a DICOM image
-func (g *Generator) generateImage(studyUID, seriesUID, modality string, instanceNumber int) (*types.Image, error) {
+// generateImage generates a DICOM image, a slice of vol when it is not nil
+func (g *Generator) generateImage(studyUID, seriesUID, modality string, instanceNumber int, vol *volume, sliceCount int) (*types.Image, error) {
 	instanceUID := g.uidGen.GenerateInstanceUID()
 	
 	// Get SOP class UID for modality
@@ -127,10 +147,18 @@ func (g *Generator) generateImage(studyUID, seriesUID, modality string, instance
 		return nil, fmt.Errorf("unsupported modality: %s", modality)
 	}
 	
-	// Generate pixel data
-	pixelData, err := g.imageGen.GenerateImage(modality, imageSize.Width, imageSize.Height, imageSize.BitsPerPixel)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate pixel data: %w", err)
+	// Generate pixel data, slicing through the synthetic body for volumes
+	var geometry *types.ImageGeometry
+	var pixelData []byte
+	if vol != nil {
+		geometry = vol.slice(instanceNumber-1, sliceCount, imageSize.Width, imageSize.Height)
+		pixelData = g.imageGen.GenerateSlice(modality, geometry, imageSize.Width, imageSize.Height, imageSize.BitsPerPixel)
+	} else {
+		var err error
+		pixelData, err = g.imageGen.GenerateImage(modality, imageSize.Width, imageSize.Height, imageSize.BitsPerPixel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate pixel data: %w", err)
+		}
 	}
 	
 	image := &types.Image{
@@ -141,7 +169,14 @@ func (g *Generator) generateImage(studyUID, seriesUID, modality string, instance
 		Width:          imageSize.Width,
 		Height:         imageSize.Height,
 		BitsPerPixel:   imageSize.BitsPerPixel,
+		BitsStored:     imageSize.BitsStored,
 		Modality:       modality,
+		Geometry:       geometry,
+	}
+	if modality == "CT" {
+		// Stored values are Hounsfield units offset by 1024, shown in a soft tissue window
+		image.RescaleIntercept, image.RescaleSlope = -1024, 1
+		image.WindowCenter, image.WindowWidth = 40, 400
 	}
 	
 	return image, nil
@@ -332,7 +367,7 @@ func (i *ImageGenerator) generateCTPattern(pixelData []byte, width, height, byte
 			
 			// Store pixel value
 			if bytesPerPixel == 2 {
-				value := uint16(noise) * 256
+				value := uint16(noise) * 16 // Scale to the 12 bits stored of CT
 				pixelData[idx] = byte(value & 0xFF)
 				pixelData[idx+1] = byte((value >> 8) & 0xFF)
 			} else {
@@ -0,0 +1,240 @@
+package dicom
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/pkg/types"
+)
+
+// volume describes how the slices of a CT or MR series are laid out in
+// patient space. The volume is centred on the origin of the patient
+// coordinate system.
+type volume struct {
+	orientation    string
+	fieldOfView    float64 // Width of the slices in mm
+	sliceThickness float64 // mm
+	sliceGap       float64 // mm between adjacent slices
+}
+
+// defaultVolumes holds the volume geometry of the cross-sectional modalities
+var defaultVolumes = map[string]volume{
+	"CT": {orientation: "axial", fieldOfView: 350, sliceThickness: 5},
+	"MR": {orientation: "axial", fieldOfView: 240, sliceThickness: 5, sliceGap: 1},
+}
+
+// orientations holds the direction cosines of the rows and columns of the
+// slices of each orientation, in the LPS patient coordinate system
+var orientations = map[string][6]float64{
+	"axial":    {1, 0, 0, 0, 1, 0},
+	"sagittal": {0, 1, 0, 0, 0, -1},
+	"coronal":  {1, 0, 0, 0, 0, -1},
+}
+
+// volumeFor returns the volume of a modality with the overrides of a
+// template, or nil for projection modalities
+func volumeFor(modality string, template interface{}) (*volume, error) {
+	v, ok := defaultVolumes[modality]
+	if !ok {
+		return nil, nil
+	}
+
+	if t, ok := template.(*config.TemplateConfig); ok && t != nil {
+		if t.Orientation != "" {
+			v.orientation = strings.ToLower(t.Orientation)
+		}
+		if t.FieldOfView != 0 {
+			v.fieldOfView = t.FieldOfView
+		}
+		if t.SliceThickness != 0 {
+			v.sliceThickness = t.SliceThickness
+		}
+		if t.SliceGap != nil {
+			v.sliceGap = *t.SliceGap
+		}
+	}
+
+	if _, ok := orientations[v.orientation]; !ok {
+		names := make([]string, 0, len(orientations))
+		for name := range orientations {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unsupported orientation '%s'. Valid orientations: %v", v.orientation, names)
+	}
+	if v.fieldOfView <= 0 || v.sliceThickness <= 0 {
+		return nil, fmt.Errorf("field of view and slice thickness must be greater than 0")
+	}
+	if v.sliceThickness+v.sliceGap <= 0 {
+		return nil, fmt.Errorf("slice gap of %g mm leaves no distance between slices", v.sliceGap)
+	}
+	return &v, nil
+}
+
+// slice returns the geometry of slice index (counting from 0) of count
+// slices of width by height pixels. Slices step along the normal of the
+// image plane, the first slice being the lowest along it.
+func (v *volume) slice(index, count, width, height int) *types.ImageGeometry {
+	orientation := orientations[v.orientation]
+	row := vec3{orientation[0], orientation[1], orientation[2]}
+	col := vec3{orientation[3], orientation[4], orientation[5]}
+	normal := row.cross(col)
+
+	spacing := v.fieldOfView / float64(max(width, height))
+	location := (float64(index) - float64(count-1)/2) * (v.sliceThickness + v.sliceGap)
+	position := normal.scale(location).
+		add(row.scale(-spacing * float64(width-1) / 2)).
+		add(col.scale(-spacing * float64(height-1) / 2))
+
+	return &types.ImageGeometry{
+		ImagePosition:    position,
+		ImageOrientation: orientation,
+		PixelSpacing:     [2]float64{spacing, spacing},
+		SliceThickness:   v.sliceThickness,
+		SliceLocation:    location,
+	}
+}
+
+// vec3 is a point or direction in patient space
+type vec3 [3]float64
+
+func (a vec3) add(b vec3) vec3 {
+	return vec3{a[0] + b[0], a[1] + b[1], a[2] + b[2]}
+}
+
+func (a vec3) scale(s float64) vec3 {
+	return vec3{a[0] * s, a[1] * s, a[2] * s}
+}
+
+func (a vec3) cross(b vec3) vec3 {
+	return vec3{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+// phantomShape is an ellipsoid of the synthetic body, in fractions of the
+// field of view
+type phantomShape struct {
+	center, radii vec3
+	tissue        int // Index into the tissue values of a modality
+}
+
+// phantomShapes make up the synthetic body: a torso with lungs, heart and
+// spine and a small lesion. Later shapes cover earlier ones.
+var phantomShapes = []phantomShape{
+	{center: vec3{0, 0, 0}, radii: vec3{0.40, 0.28, 0.90}, tissue: 1},
+	{center: vec3{0.17, -0.02, 0.05}, radii: vec3{0.12, 0.17, 0.30}, tissue: 2},
+	{center: vec3{-0.17, -0.02, 0.05}, radii: vec3{0.12, 0.17, 0.30}, tissue: 2},
+	{center: vec3{0.05, -0.08, -0.05}, radii: vec3{0.09, 0.08, 0.10}, tissue: 3},
+	{center: vec3{0, 0.20, 0}, radii: vec3{0.04, 0.04, 2}, tissue: 4},
+	{center: vec3{-0.12, -0.05, 0.08}, radii: vec3{0.04, 0.04, 0.04}, tissue: 5},
+}
+
+// phantomTissues holds the stored values of the background, body, lung,
+// heart, bone and lesion tissues and the noise amplitude of each modality.
+// CT values are Hounsfield units offset by 1024.
+var phantomTissues = map[string]struct {
+	values [6]int
+	noise  int
+}{
+	"CT": {values: [6]int{24, 1064, 174, 1074, 1724, 1144}, noise: 10},
+	"MR": {values: [6]int{0, 900, 250, 1200, 150, 1800}, noise: 25},
+}
+
+// GenerateSlice generates the pixel data of a slice through the synthetic
+// body, so that the slices of a volume show the same anatomy
+func (i *ImageGenerator) GenerateSlice(modality string, geometry *types.ImageGeometry, width, height, bitsPerPixel int) []byte {
+	tissues := phantomTissues[modality]
+	maxValue := 1<<bitsPerPixel - 1
+
+	o := geometry.ImageOrientation
+	row := vec3{o[0], o[1], o[2]}.scale(geometry.PixelSpacing[1])
+	col := vec3{o[3], o[4], o[5]}.scale(geometry.PixelSpacing[0])
+
+	// Shapes are scaled by the field of view of the slice
+	size := geometry.PixelSpacing[1] * float64(max(width, height))
+
+	samples := make([]int, width*height)
+	for y := range height {
+		for x := range width {
+			p := vec3(geometry.ImagePosition).add(row.scale(float64(x))).add(col.scale(float64(y)))
+
+			tissue := 0
+			for _, shape := range phantomShapes {
+				d := 0.0
+				for k := range 3 {
+					t := (p[k] - shape.center[k]*size) / (shape.radii[k] * size)
+					d += t * t
+				}
+				if d <= 1 {
+					tissue = shape.tissue
+				}
+			}
+
+			value := tissues.values[tissue] + i.rand.Intn(2*tissues.noise+1) - tissues.noise
+			samples[y*width+x] = min(max(value, 0), maxValue)
+		}
+	}
+	return packSamples(samples, bitsPerPixel)
+}
+
+// addImagePlaneElements adds the Image Plane module of a slice
+func addImagePlaneElements(dataset *Dataset, geometry *types.ImageGeometry) {
+	dataset.SetString(TagImagePositionPatient, formatDS(geometry.ImagePosition[:]...))
+	dataset.SetString(TagImageOrientationPatient, formatDS(geometry.ImageOrientation[:]...))
+	dataset.SetString(TagPixelSpacing, formatDS(geometry.PixelSpacing[:]...))
+	dataset.SetString(TagSliceThickness, formatDS(geometry.SliceThickness))
+	dataset.SetString(TagSliceLocation, formatDS(geometry.SliceLocation))
+}
+
+// geometryFromDataset reads the Image Plane module of a dataset, returning
+// nil when it has no image position and orientation
+func geometryFromDataset(ds *Dataset) *types.ImageGeometry {
+	position := parseDS(ds.Strings(TagImagePositionPatient))
+	orientation := parseDS(ds.Strings(TagImageOrientationPatient))
+	if len(position) != 3 || len(orientation) != 6 {
+		return nil
+	}
+
+	geometry := &types.ImageGeometry{}
+	copy(geometry.ImagePosition[:], position)
+	copy(geometry.ImageOrientation[:], orientation)
+	copy(geometry.PixelSpacing[:], parseDS(ds.Strings(TagPixelSpacing)))
+	if thickness := parseDS(ds.Strings(TagSliceThickness)); len(thickness) > 0 {
+		geometry.SliceThickness = thickness[0]
+	}
+	if location := parseDS(ds.Strings(TagSliceLocation)); len(location) > 0 {
+		geometry.SliceLocation = location[0]
+	}
+	return geometry
+}
+
+// formatDS formats values as a multi-valued Decimal String, rounded to keep
+// each value within the 16 characters DS allows
+func formatDS(values ...float64) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		v = math.Round(v*1e4) / 1e4
+		if v == 0 {
+			v = 0 // No negative zero
+		}
+		parts[i] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strings.Join(parts, "\\")
+}
+
+// parseDS parses the values of a Decimal String, stopping at the first
+// invalid value
+func parseDS(values []string) []float64 {
+	var parsed []float64
+	for _, value := range values {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			break
+		}
+		parsed = append(parsed, f)
+	}
+	return parsed
+}
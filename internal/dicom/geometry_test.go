@@ -0,0 +1,152 @@
+package dicom
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumeFor(t *testing.T) {
+	gap := 0.0
+	tests := []struct {
+		name     string
+		modality string
+		template interface{}
+		want     *volume
+		wantErr  string
+	}{
+		{"projection modality", "CR", nil, nil, ""},
+		{"CT defaults", "CT", nil, &volume{"axial", 350, 5, 0}, ""},
+		{"MR defaults", "MR", &config.TemplateConfig{}, &volume{"axial", 240, 5, 1}, ""},
+		{"template overrides", "MR", &config.TemplateConfig{Orientation: "Sagittal", FieldOfView: 200, SliceThickness: 3, SliceGap: &gap}, &volume{"sagittal", 200, 3, 0}, ""},
+		{"invalid orientation", "CT", &config.TemplateConfig{Orientation: "oblique"}, nil, "unsupported orientation 'oblique'"},
+		{"negative thickness", "CT", &config.TemplateConfig{SliceThickness: -1}, nil, "greater than 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := volumeFor(tt.modality, tt.template)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestVolumeSlice(t *testing.T) {
+	tests := []struct {
+		orientation string
+		normal      [3]float64
+	}{
+		{"axial", [3]float64{0, 0, 1}},
+		{"sagittal", [3]float64{-1, 0, 0}},
+		{"coronal", [3]float64{0, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.orientation, func(t *testing.T) {
+			v := &volume{orientation: tt.orientation, fieldOfView: 200, sliceThickness: 2, sliceGap: 0.5}
+			first, second := v.slice(0, 4, 100, 50), v.slice(1, 4, 100, 50)
+
+			assert.Equal(t, [2]float64{2, 2}, first.PixelSpacing)
+			assert.Equal(t, orientations[tt.orientation], first.ImageOrientation)
+			assert.Equal(t, -3.75, first.SliceLocation)
+			for k := range 3 {
+				assert.InDelta(t, 2.5*tt.normal[k], second.ImagePosition[k]-first.ImagePosition[k], 1e-9)
+			}
+
+			// The slice location is the distance of the plane along the normal
+			location := 0.0
+			for k := range 3 {
+				location += second.ImagePosition[k] * tt.normal[k]
+			}
+			assert.InDelta(t, second.SliceLocation, location, 1e-9)
+		})
+	}
+}
+
+func TestGenerateStudyGeometry(t *testing.T) {
+	cfg := config.DefaultConfig()
+	template := &config.TemplateConfig{Orientation: "coronal", FieldOfView: 300, SliceThickness: 2}
+	params := types.StudyParams{StudyCount: 1, SeriesCount: 2, ImageCount: 3, Modality: "CT", Template: template}
+
+	study, err := NewGenerator(cfg).GenerateStudy(params)
+	if !assert.NoError(t, err) {
+		return
+	}
+	frameOfReference := study.Series[0].FrameOfReferenceUID
+	assert.NotEmpty(t, frameOfReference)
+	assert.Equal(t, frameOfReference, study.Series[1].FrameOfReferenceUID)
+
+	outputDir := t.TempDir()
+	if !assert.NoError(t, NewWriter(cfg).WriteStudy(study, &params, outputDir)) {
+		return
+	}
+	read, err := ReadStudy(filepath.Join(outputDir, study.StudyInstanceUID))
+	if !assert.NoError(t, err) || !assert.Len(t, read.Series, 2) {
+		return
+	}
+	series := read.Series[1]
+	assert.Equal(t, frameOfReference, series.FrameOfReferenceUID)
+	if !assert.Len(t, series.Images, 3) {
+		return
+	}
+	for i, image := range series.Images {
+		want := study.Series[1].Images[i].Geometry
+		if assert.NotNil(t, image.Geometry) {
+			assert.Equal(t, want.ImageOrientation, image.Geometry.ImageOrientation)
+			assert.InDelta(t, want.SliceLocation, image.Geometry.SliceLocation, 1e-4)
+			assert.InDelta(t, want.ImagePosition[1], image.Geometry.ImagePosition[1], 1e-4)
+		}
+	}
+	assert.Equal(t, -2.0, series.Images[0].Geometry.SliceLocation)
+	assert.Equal(t, 2.0, series.Images[2].Geometry.SliceThickness)
+
+	// Projection modalities have no volume geometry
+	params.Modality, params.Template = "CR", nil
+	study, err = NewGenerator(cfg).GenerateStudy(params)
+	if assert.NoError(t, err) {
+		assert.Empty(t, study.Series[0].FrameOfReferenceUID)
+		assert.Nil(t, study.Series[0].Images[0].Geometry)
+	}
+
+	params.Modality, params.Template = "MR", &config.TemplateConfig{Orientation: "oblique"}
+	_, err = NewGenerator(cfg).GenerateStudy(params)
+	assert.ErrorContains(t, err, "unsupported orientation")
+}
+
+func TestGenerateSliceCoherence(t *testing.T) {
+	const width, height = 64, 64
+	v := &volume{orientation: "axial", fieldOfView: 350, sliceThickness: 5}
+	gen := NewImageGenerator()
+
+	slice := func(index int) []int {
+		pixels := gen.GenerateSlice("CT", v.slice(index, 60, width, height), width, height, 16)
+		return frameSamples(pixels, width*height, 16)
+	}
+	meanDifference := func(a, b []int) float64 {
+		total := 0
+		for i := range a {
+			total += max(a[i]-b[i], b[i]-a[i])
+		}
+		return float64(total) / float64(len(a))
+	}
+
+	// Neighbouring slices share their anatomy, distant ones do not
+	middle := slice(30)
+	near := meanDifference(middle, slice(31))
+	far := meanDifference(middle, slice(2))
+	assert.Less(t, near*5, far)
+
+	// Air outside the body, soft tissue at its front and bone in the spine
+	assert.InDelta(t, 24, middle[0], 10)
+	assert.InDelta(t, 1064, middle[20*width+width/2], 10)
+	spine := (width/5 + height/2) * width
+	assert.InDelta(t, 1724, middle[spine+width/2], 10)
+}
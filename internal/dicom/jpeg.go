@@ -0,0 +1,132 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+)
+
+// JPEG markers of the lossless codecs (ITU-T T.81 and T.87)
+const (
+	markerSOF3  = 0xC3 // Lossless, Huffman coding
+	markerDHT   = 0xC4
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerDRI   = 0xDD
+	markerSOF55 = 0xF7 // JPEG-LS
+	markerLSE   = 0xF8 // JPEG-LS preset parameters
+)
+
+// jpegBaselineQuality is the quality generated JPEG Baseline frames are encoded with
+const jpegBaselineQuality = 90
+
+// encodeJPEGBaseline compresses an 8-bit frame with JPEG Baseline (Process 1)
+func encodeJPEGBaseline(pixels []byte, width, height, bitsAllocated int) ([]byte, error) {
+	img := &image.Gray{
+		Pix:    pixels[:width*height],
+		Stride: width,
+		Rect:   image.Rect(0, 0, width, height),
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegBaselineQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode JPEG frame: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeJPEGBaseline decompresses a JPEG Baseline frame into 8-bit pixels
+func decodeJPEGBaseline(frame []byte, width, height, bitsAllocated int) ([]byte, error) {
+	img, err := jpeg.Decode(bytes.NewReader(frame))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JPEG frame: %w", err)
+	}
+	if img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+		return nil, fmt.Errorf("JPEG frame is %dx%d, expected %dx%d", img.Bounds().Dx(), img.Bounds().Dy(), width, height)
+	}
+
+	gray, ok := img.(*image.Gray)
+	if !ok || gray.Stride != width {
+		gray = image.NewGray(image.Rect(0, 0, width, height))
+		draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	return gray.Pix, nil
+}
+
+// appendMarkerSegment appends a marker segment, prefixing the payload with its length
+func appendMarkerSegment(dst []byte, marker byte, payload ...byte) []byte {
+	dst = append(dst, 0xFF, marker)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(payload)+2))
+	return append(dst, payload...)
+}
+
+// appendFrameHeader appends the start of frame segment of a single component
+// frame
+func appendFrameHeader(dst []byte, marker byte, precision, width, height int) []byte {
+	return appendMarkerSegment(dst, marker,
+		byte(precision), byte(height>>8), byte(height), byte(width>>8), byte(width),
+		1,       // Number of components
+		1, 0x11, // Component identifier and sampling factors
+		0, // Quantization table, unused by lossless processes
+	)
+}
+
+// nextMarkerSegment reads the marker at pos and returns it with its payload
+// and the position following the segment
+func nextMarkerSegment(data []byte, pos int) (byte, []byte, int, error) {
+	if pos >= len(data) || data[pos] != 0xFF {
+		return 0, nil, pos, fmt.Errorf("expected a JPEG marker at offset %d", pos)
+	}
+	for pos < len(data) && data[pos] == 0xFF {
+		pos++ // Fill bytes may precede a marker
+	}
+	if pos >= len(data) {
+		return 0, nil, pos, fmt.Errorf("JPEG data ends in a marker")
+	}
+
+	marker := data[pos]
+	pos++
+	if marker == markerSOI || marker == markerEOI {
+		return marker, nil, pos, nil
+	}
+	if pos+2 > len(data) {
+		return 0, nil, pos, fmt.Errorf("JPEG marker segment %02X is truncated", marker)
+	}
+	length := int(binary.BigEndian.Uint16(data[pos:]))
+	if length < 2 || pos+length > len(data) {
+		return 0, nil, pos, fmt.Errorf("JPEG marker segment %02X is truncated", marker)
+	}
+	return marker, data[pos+2 : pos+length], pos + length, nil
+}
+
+// frameHeader holds the start of frame parameters of a single component frame
+type frameHeader struct {
+	precision, width, height int
+}
+
+// parseFrameHeader parses a start of frame payload and checks it against the
+// expected frame size
+func parseFrameHeader(payload []byte, width, height int) (frameHeader, error) {
+	if len(payload) < 6 {
+		return frameHeader{}, fmt.Errorf("JPEG frame header is truncated")
+	}
+	header := frameHeader{
+		precision: int(payload[0]),
+		height:    int(binary.BigEndian.Uint16(payload[1:])),
+		width:     int(binary.BigEndian.Uint16(payload[3:])),
+	}
+	if components := int(payload[5]); components != 1 {
+		return header, fmt.Errorf("JPEG frame has %d components, expected 1", components)
+	}
+	if header.width != width || header.height != height {
+		return header, fmt.Errorf("JPEG frame is %dx%d, expected %dx%d", header.width, header.height, width, height)
+	}
+	if header.precision < 2 || header.precision > 16 {
+		return header, fmt.Errorf("JPEG frame has an invalid precision of %d bits", header.precision)
+	}
+	return header, nil
+}
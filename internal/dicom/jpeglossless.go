@@ -0,0 +1,416 @@
+package dicom
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// jpegLosslessPredictor is the selection value generated JPEG Lossless frames
+// are encoded with: the sample to the left
+const jpegLosslessPredictor = 1
+
+// encodeJPEGLossless compresses a frame with JPEG Lossless, Non-Hierarchical,
+// First-Order Prediction (Process 14, Selection Value 1) and a Huffman table
+// optimised for the frame
+func encodeJPEGLossless(pixels []byte, width, height, bitsAllocated int) ([]byte, error) {
+	precision := max(bitsAllocated, 2)
+	samples := frameSamples(pixels, width*height, bitsAllocated)
+
+	// Differences are computed modulo 2^16 (T.81 H.1.2.1)
+	diffs := make([]int, len(samples))
+	var freq [17]int
+	for i, sample := range samples {
+		diff := (sample - losslessPrediction(samples, i, width, 1<<(precision-1), jpegLosslessPredictor)) & 0xFFFF
+		if diff >= 0x8000 {
+			diff -= 0x10000
+		}
+		diffs[i] = diff
+		freq[differenceCategory(diff)]++
+	}
+
+	counts, values := buildHuffmanTable(freq[:])
+	codes := huffmanCodes(counts, values)
+
+	frame := []byte{0xFF, markerSOI}
+	frame = appendFrameHeader(frame, markerSOF3, precision, width, height)
+	frame = appendMarkerSegment(frame, markerDHT, append(append([]byte{0x00}, counts[:]...), values...)...)
+	frame = appendMarkerSegment(frame, markerSOS,
+		1,    // Number of components
+		1, 0, // Component identifier and Huffman table
+		jpegLosslessPredictor, 0, // Predictor and end of spectral selection, unused
+		0, // Point transform
+	)
+
+	w := &jpegBitWriter{out: frame}
+	for _, diff := range diffs {
+		category := differenceCategory(diff)
+		w.write(uint32(codes[category].code), uint(codes[category].size))
+		if category > 0 && category < 16 {
+			if diff < 0 {
+				diff--
+			}
+			w.write(uint32(diff), uint(category))
+		}
+	}
+	w.flush()
+
+	return append(w.out, 0xFF, markerEOI), nil
+}
+
+// decodeJPEGLossless decompresses a JPEG Lossless (Process 14) frame of any
+// predictor into little endian pixels
+func decodeJPEGLossless(frame []byte, width, height, bitsAllocated int) ([]byte, error) {
+	if len(frame) < 2 || frame[0] != 0xFF || frame[1] != markerSOI {
+		return nil, fmt.Errorf("JPEG Lossless frame does not start with SOI")
+	}
+
+	var header *frameHeader
+	var tables [4]*huffmanDecoder
+	for pos := 2; ; {
+		marker, payload, next, err := nextMarkerSegment(frame, pos)
+		if err != nil {
+			return nil, err
+		}
+		pos = next
+
+		switch {
+		case marker == markerSOF3:
+			h, err := parseFrameHeader(payload, width, height)
+			if err != nil {
+				return nil, err
+			}
+			if h.precision > bitsAllocated {
+				return nil, fmt.Errorf("JPEG precision of %d bits exceeds %d bits allocated", h.precision, bitsAllocated)
+			}
+			header = &h
+		case marker == markerDHT:
+			for len(payload) > 0 {
+				if len(payload) < 17 {
+					return nil, fmt.Errorf("JPEG Huffman table is truncated")
+				}
+				n := 0
+				for _, count := range payload[1:17] {
+					n += int(count)
+				}
+				if len(payload) < 17+n {
+					return nil, fmt.Errorf("JPEG Huffman table is truncated")
+				}
+				tables[payload[0]&0x03] = newHuffmanDecoder(payload[1:17], payload[17:17+n])
+				payload = payload[17+n:]
+			}
+		case marker == markerDRI:
+			if len(payload) >= 2 && (payload[0] != 0 || payload[1] != 0) {
+				return nil, fmt.Errorf("JPEG restart intervals are not supported")
+			}
+		case marker == markerSOS:
+			if header == nil {
+				return nil, fmt.Errorf("JPEG scan precedes the frame header")
+			}
+			if len(payload) < 6 || payload[0] != 1 {
+				return nil, fmt.Errorf("JPEG Lossless scan must have a single component")
+			}
+			table := tables[(payload[2]>>4)&0x03]
+			if table == nil {
+				return nil, fmt.Errorf("JPEG scan uses an undefined Huffman table")
+			}
+			predictor, pointTransform := int(payload[3]), int(payload[5]&0x0F)
+			if predictor < 1 || predictor > 7 {
+				return nil, fmt.Errorf("JPEG Lossless predictor %d is not supported", predictor)
+			}
+			return decodeLosslessScan(frame[pos:], header, table, predictor, pointTransform, bitsAllocated)
+		case marker == markerEOI:
+			return nil, fmt.Errorf("JPEG frame has no scan")
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			return nil, fmt.Errorf("JPEG process of marker %02X is not lossless", marker)
+		}
+	}
+}
+
+// decodeLosslessScan decodes the entropy-coded data of a lossless scan
+func decodeLosslessScan(data []byte, header *frameHeader, table *huffmanDecoder, predictor, pointTransform, bitsAllocated int) ([]byte, error) {
+	r := &jpegBitReader{data: data}
+	samples := make([]int, header.width*header.height)
+	initial := 1 << max(header.precision-pointTransform-1, 0)
+
+	for i := range samples {
+		category := table.decode(r)
+		diff := 0
+		switch {
+		case category == 16:
+			diff = 32768
+		case category > 16:
+			return nil, fmt.Errorf("invalid JPEG difference category %d", category)
+		case category > 0:
+			diff = r.read(category)
+			if diff < 1<<(category-1) {
+				diff += 1 - 1<<category
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		samples[i] = (losslessPrediction(samples, i, header.width, initial, predictor) + diff) & 0xFFFF
+	}
+
+	for i := range samples {
+		samples[i] <<= pointTransform
+	}
+	return packSamples(samples, bitsAllocated), nil
+}
+
+// losslessPrediction predicts sample i from its decoded neighbours with one
+// of the seven JPEG Lossless predictors (T.81 H.1.2.1). The first row is
+// predicted from the left and the first column from above.
+func losslessPrediction(samples []int, i, width, initial, predictor int) int {
+	row, col := i/width, i%width
+	switch {
+	case i == 0:
+		return initial
+	case row == 0:
+		return samples[i-1]
+	case col == 0:
+		return samples[i-width]
+	}
+
+	ra, rb, rc := samples[i-1], samples[i-width], samples[i-width-1]
+	switch predictor {
+	case 2:
+		return rb
+	case 3:
+		return rc
+	case 4:
+		return ra + rb - rc
+	case 5:
+		return ra + (rb-rc)>>1
+	case 6:
+		return rb + (ra-rc)>>1
+	case 7:
+		return (ra + rb) / 2
+	}
+	return ra
+}
+
+// differenceCategory returns the SSSS category of a difference: the number
+// of bits of its magnitude
+func differenceCategory(diff int) int {
+	if diff < 0 {
+		diff = -diff
+	}
+	return bits.Len(uint(diff))
+}
+
+// buildHuffmanTable returns the code length counts and symbols of an optimal
+// Huffman table with codes of at most 16 bits (T.81 K.2)
+func buildHuffmanTable(freq []int) ([16]byte, []byte) {
+	// A reserved symbol keeps any code from being all ones
+	f := append(append([]int(nil), freq...), 1)
+	codeSize := make([]int, len(f))
+	others := make([]int, len(f))
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		// The two least frequent symbols, preferring the highest value on ties
+		v1, v2 := -1, -1
+		for i, n := range f {
+			if n == 0 {
+				continue
+			}
+			switch {
+			case v1 < 0 || n <= f[v1]:
+				v1, v2 = i, v1
+			case v2 < 0 || n <= f[v2]:
+				v2 = i
+			}
+		}
+		if v2 < 0 {
+			break
+		}
+
+		f[v1] += f[v2]
+		f[v2] = 0
+		for codeSize[v1]++; others[v1] >= 0; codeSize[v1]++ {
+			v1 = others[v1]
+		}
+		others[v1] = v2
+		for codeSize[v2]++; others[v2] >= 0; codeSize[v2]++ {
+			v2 = others[v2]
+		}
+	}
+
+	var lengths [33]int
+	for _, size := range codeSize {
+		if size > 0 {
+			lengths[size]++
+		}
+	}
+
+	// Limit code lengths to 16 bits
+	for i := 32; i > 16; i-- {
+		for lengths[i] > 0 {
+			j := i - 2
+			for lengths[j] == 0 {
+				j--
+			}
+			lengths[i] -= 2
+			lengths[i-1]++
+			lengths[j+1] += 2
+			lengths[j]--
+		}
+	}
+
+	// Drop the reserved symbol, which has the longest code
+	i := 16
+	for lengths[i] == 0 {
+		i--
+	}
+	lengths[i]--
+
+	var counts [16]byte
+	for i := range counts {
+		counts[i] = byte(lengths[i+1])
+	}
+
+	// Symbols in order of code size, then value
+	var values []byte
+	for size := 1; size <= 32; size++ {
+		for symbol, s := range codeSize[:len(freq)] {
+			if s == size {
+				values = append(values, byte(symbol))
+			}
+		}
+	}
+	return counts, values
+}
+
+// huffmanCode is the code of a symbol
+type huffmanCode struct {
+	code uint16
+	size uint8
+}
+
+// huffmanCodes assigns the canonical codes of a Huffman table to its symbols
+func huffmanCodes(counts [16]byte, values []byte) [256]huffmanCode {
+	var codes [256]huffmanCode
+	code, k := uint16(0), 0
+	for size := 1; size <= 16; size++ {
+		for range counts[size-1] {
+			codes[values[k]] = huffmanCode{code: code, size: uint8(size)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// huffmanDecoder decodes the symbols of a canonical Huffman table (T.81 F.2.2.3)
+type huffmanDecoder struct {
+	minCode, maxCode, valPtr [17]int
+	values                   []byte
+}
+
+// newHuffmanDecoder creates a decoder for a table of code length counts and symbols
+func newHuffmanDecoder(counts, values []byte) *huffmanDecoder {
+	d := &huffmanDecoder{values: values}
+	code, k := 0, 0
+	for size := 1; size <= 16; size++ {
+		n := int(counts[size-1])
+		d.maxCode[size] = -1
+		if n > 0 {
+			d.valPtr[size] = k
+			d.minCode[size] = code
+			d.maxCode[size] = code + n - 1
+		}
+		code = (code + n) << 1
+		k += n
+	}
+	return d
+}
+
+// decode reads a symbol, returning -1 for an invalid code
+func (d *huffmanDecoder) decode(r *jpegBitReader) int {
+	code := r.read(1)
+	for size := 1; size <= 16; size++ {
+		if code <= d.maxCode[size] {
+			index := d.valPtr[size] + code - d.minCode[size]
+			if index < len(d.values) {
+				return int(d.values[index])
+			}
+			break
+		}
+		code = code<<1 | r.read(1)
+	}
+	if r.err == nil {
+		r.err = fmt.Errorf("invalid JPEG Huffman code")
+	}
+	return -1
+}
+
+// jpegBitWriter writes entropy-coded JPEG data, stuffing a zero byte after
+// each 0xFF byte
+type jpegBitWriter struct {
+	out []byte
+	acc uint64
+	n   uint
+}
+
+// write appends the low size bits of bits
+func (w *jpegBitWriter) write(bits uint32, size uint) {
+	w.acc = w.acc<<size | uint64(bits)&(1<<size-1)
+	w.n += size
+	for w.n >= 8 {
+		b := byte(w.acc >> (w.n - 8))
+		w.out = append(w.out, b)
+		if b == 0xFF {
+			w.out = append(w.out, 0x00)
+		}
+		w.n -= 8
+	}
+}
+
+// flush pads the last byte with one bits
+func (w *jpegBitWriter) flush() {
+	if w.n > 0 {
+		w.write(1<<(8-w.n)-1, 8-w.n)
+	}
+}
+
+// jpegBitReader reads entropy-coded JPEG data, removing stuffed zero bytes.
+// Running into a marker or the end of the data sets err.
+type jpegBitReader struct {
+	data []byte
+	pos  int
+	acc  byte
+	n    uint
+	err  error
+}
+
+// read returns the next size bits
+func (r *jpegBitReader) read(size int) int {
+	value := 0
+	for range size {
+		if r.n == 0 {
+			if r.pos >= len(r.data) || r.err != nil {
+				if r.err == nil {
+					r.err = fmt.Errorf("JPEG scan ends early")
+				}
+				return 0
+			}
+			b := r.data[r.pos]
+			r.pos++
+			if b == 0xFF {
+				if r.pos >= len(r.data) || r.data[r.pos] != 0x00 {
+					r.err = fmt.Errorf("JPEG scan ends early at a marker")
+					return 0
+				}
+				r.pos++
+			}
+			r.acc, r.n = b, 8
+		}
+		r.n--
+		value = value<<1 | int(r.acc>>r.n)&1
+	}
+	return value
+}
@@ -0,0 +1,597 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// jpegLSJ is the order of the run length codes (T.87 A.7.1.2)
+var jpegLSJ = [32]int{0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+const (
+	jpegLSReset = 64 // Default RESET interval of the context counters
+
+	// Contexts 0 to 364 are regular contexts, 365 and 366 the run
+	// interruption contexts of RItype 0 and 1
+	jpegLSRunContext = 365
+	jpegLSContexts   = 367
+)
+
+// jpegLSCoder holds the context modelling state shared by the JPEG-LS
+// lossless (NEAR = 0) encoder and decoder of a single component
+type jpegLSCoder struct {
+	maxVal, rangeVal int
+	qbpp, limit      int
+	t1, t2, t3       int
+	reset            int
+
+	a, b, c, n [jpegLSContexts]int
+	nn         [2]int // Negative errors of the run interruption contexts
+	runIndex   int
+}
+
+// newJPEGLSCoder creates a coder for samples up to maxVal with the default
+// thresholds (T.87 C.2.4.1.1)
+func newJPEGLSCoder(maxVal int) *jpegLSCoder {
+	s := &jpegLSCoder{maxVal: maxVal, rangeVal: maxVal + 1, reset: jpegLSReset}
+	s.qbpp = bits.Len(uint(maxVal))
+	bpp := max(2, s.qbpp)
+	s.limit = 2 * (bpp + max(8, bpp))
+
+	if maxVal >= 128 {
+		factor := (min(maxVal, 4095) + 128) / 256
+		s.t1 = jpegLSClamp(factor*(3-2)+2, 1, maxVal)
+		s.t2 = jpegLSClamp(factor*(7-3)+3, s.t1, maxVal)
+		s.t3 = jpegLSClamp(factor*(21-4)+4, s.t2, maxVal)
+	} else {
+		factor := 256 / (maxVal + 1)
+		s.t1 = jpegLSClamp(max(2, 3/factor), 1, maxVal)
+		s.t2 = jpegLSClamp(max(3, 7/factor), s.t1, maxVal)
+		s.t3 = jpegLSClamp(max(4, 21/factor), s.t2, maxVal)
+	}
+	return s
+}
+
+// init resets the context counters once the parameters are final
+func (s *jpegLSCoder) init() {
+	initialA := max(2, (s.rangeVal+32)/64)
+	for q := range s.a {
+		s.a[q], s.b[q], s.c[q], s.n[q] = initialA, 0, 0, 1
+	}
+	s.nn = [2]int{}
+	s.runIndex = 0
+}
+
+// jpegLSClamp is the CLAMP function of T.87 C.2.4.1.1, which falls back to
+// low rather than high for values above high
+func jpegLSClamp(value, low, high int) int {
+	if value > high || value < low {
+		return low
+	}
+	return value
+}
+
+// quantize maps a local gradient to one of the regions -4 to 4
+func (s *jpegLSCoder) quantize(d int) int {
+	switch {
+	case d <= -s.t3:
+		return -4
+	case d <= -s.t2:
+		return -3
+	case d <= -s.t1:
+		return -2
+	case d < 0:
+		return -1
+	case d == 0:
+		return 0
+	case d < s.t1:
+		return 1
+	case d < s.t2:
+		return 2
+	case d < s.t3:
+		return 3
+	}
+	return 4
+}
+
+// regularContext returns the context, sign and corrected prediction of a
+// sample in regular mode
+func (s *jpegLSCoder) regularContext(ra, rb, rc, rd int) (q, sign, px int) {
+	q = 81*s.quantize(rd-rb) + 9*s.quantize(rb-rc) + s.quantize(rc-ra)
+	sign = 1
+	if q < 0 {
+		q, sign = -q, -1
+	}
+
+	// Median edge detector
+	switch {
+	case rc >= max(ra, rb):
+		px = min(ra, rb)
+	case rc <= min(ra, rb):
+		px = max(ra, rb)
+	default:
+		px = ra + rb - rc
+	}
+	px = min(max(px+sign*s.c[q], 0), s.maxVal)
+	return q, sign, px
+}
+
+// golombK returns the Golomb coding parameter of a regular context
+func (s *jpegLSCoder) golombK(q int) int {
+	k := 0
+	for s.n[q]<<k < s.a[q] {
+		k++
+	}
+	return k
+}
+
+// errorCorrection returns -1 when the mapping of an error is inverted for a
+// context with a negative bias and k of 0, else 0
+func (s *jpegLSCoder) errorCorrection(q, k int) int {
+	if k == 0 && 2*s.b[q] <= -s.n[q] {
+		return -1
+	}
+	return 0
+}
+
+// reduce reduces a prediction error modulo the range of the samples
+func (s *jpegLSCoder) reduce(errval int) int {
+	if errval < 0 {
+		errval += s.rangeVal
+	}
+	if errval >= (s.rangeVal+1)/2 {
+		errval -= s.rangeVal
+	}
+	return errval
+}
+
+// reconstruct returns the sample of a prediction plus an error
+func (s *jpegLSCoder) reconstruct(value int) int {
+	if value < 0 {
+		return value + s.rangeVal
+	}
+	if value > s.maxVal {
+		return value - s.rangeVal
+	}
+	return value
+}
+
+// updateRegular updates a regular context with a coded error (T.87 A.6)
+func (s *jpegLSCoder) updateRegular(q, errval int) {
+	s.b[q] += errval
+	s.a[q] += abs(errval)
+	if s.n[q] == s.reset {
+		s.a[q] >>= 1
+		s.b[q] >>= 1
+		s.n[q] >>= 1
+	}
+	s.n[q]++
+
+	if s.b[q] <= -s.n[q] {
+		s.b[q] += s.n[q]
+		if s.c[q] > -128 {
+			s.c[q]--
+		}
+		if s.b[q] <= -s.n[q] {
+			s.b[q] = -s.n[q] + 1
+		}
+	} else if s.b[q] > 0 {
+		s.b[q] -= s.n[q]
+		if s.c[q] < 127 {
+			s.c[q]++
+		}
+		if s.b[q] > 0 {
+			s.b[q] = 0
+		}
+	}
+}
+
+// interruptionContext returns the run interruption type, prediction and sign
+// of the sample ending a run (T.87 A.7.2)
+func interruptionContext(ra, rb int) (riType, px, sign int) {
+	if ra == rb {
+		return 1, ra, 1
+	}
+	if ra > rb {
+		return 0, rb, -1
+	}
+	return 0, rb, 1
+}
+
+// interruptionK returns the Golomb coding parameter of a run interruption context
+func (s *jpegLSCoder) interruptionK(riType int) int {
+	q := jpegLSRunContext + riType
+	temp := s.a[q] + (s.n[q]>>1)*riType
+	k := 0
+	for s.n[q]<<k < temp {
+		k++
+	}
+	return k
+}
+
+// updateInterruption updates a run interruption context with a coded error
+func (s *jpegLSCoder) updateInterruption(riType, errval, mapped int) {
+	q := jpegLSRunContext + riType
+	if errval < 0 {
+		s.nn[riType]++
+	}
+	s.a[q] += (mapped + 1 - riType) >> 1
+	if s.n[q] == s.reset {
+		s.a[q] >>= 1
+		s.n[q] >>= 1
+		s.nn[riType] >>= 1
+	}
+	s.n[q]++
+}
+
+// encodeJPEGLS compresses a frame with lossless JPEG-LS (T.87) and the
+// default coding parameters
+func encodeJPEGLS(pixels []byte, width, height, bitsAllocated int) ([]byte, error) {
+	precision := max(bitsAllocated, 2)
+	samples := frameSamples(pixels, width*height, bitsAllocated)
+
+	frame := []byte{0xFF, markerSOI}
+	frame = appendFrameHeader(frame, markerSOF55, precision, width, height)
+	frame = appendMarkerSegment(frame, markerSOS,
+		1,    // Number of components
+		1, 0, // Component identifier and mapping table
+		0, // NEAR, lossless
+		0, // Interleave mode
+		0, // Point transform
+	)
+
+	s := newJPEGLSCoder(1<<precision - 1)
+	s.init()
+	w := &jpegLSBitWriter{out: frame, capacity: 8}
+
+	// Lines hold a sample on either side for the neighbours at the edges
+	prev, cur := make([]int, width+2), make([]int, width+2)
+	for y := range height {
+		line := samples[y*width : (y+1)*width]
+		prev[width+1] = prev[width]
+		cur[0] = prev[1]
+
+		for x := 0; x < width; {
+			ra, rb, rc, rd := cur[x], prev[x+1], prev[x], prev[x+2]
+			if ra != rb || rb != rc || rc != rd {
+				q, sign, px := s.regularContext(ra, rb, rc, rd)
+				errval := s.reduce(sign * (line[x] - px))
+				k := s.golombK(q)
+				w.golomb(mapError(errval^s.errorCorrection(q, k)), k, s.limit, s.qbpp)
+				s.updateRegular(q, errval)
+				cur[x+1] = line[x]
+				x++
+				continue
+			}
+
+			// Run mode: code the number of samples equal to Ra
+			run := 0
+			for x+run < width && line[x+run] == ra {
+				cur[x+run+1] = ra
+				run++
+			}
+			x += run
+			for run >= 1<<jpegLSJ[s.runIndex] {
+				w.write(1, 1)
+				run -= 1 << jpegLSJ[s.runIndex]
+				s.runIndex = min(s.runIndex+1, 31)
+			}
+			if x == width {
+				if run > 0 {
+					w.write(1, 1)
+				}
+				break
+			}
+			w.write(0, 1)
+			w.write(run, jpegLSJ[s.runIndex])
+
+			// The sample interrupting the run
+			riType, px, sign := interruptionContext(ra, prev[x+1])
+			errval := s.reduce(sign * (line[x] - px))
+			k := s.interruptionK(riType)
+			mapped := 2*abs(errval) - riType - interruptionMap(errval, k, 2*s.nn[riType] >= s.n[jpegLSRunContext+riType])
+			w.golomb(mapped, k, s.limit-jpegLSJ[s.runIndex]-1, s.qbpp)
+			s.updateInterruption(riType, errval, mapped)
+			s.runIndex = max(s.runIndex-1, 0)
+			cur[x+1] = line[x]
+			x++
+		}
+		prev, cur = cur, prev
+	}
+	w.flush()
+
+	return append(w.out, 0xFF, markerEOI), nil
+}
+
+// decodeJPEGLS decompresses a lossless JPEG-LS frame into little endian pixels
+func decodeJPEGLS(frame []byte, width, height, bitsAllocated int) ([]byte, error) {
+	if len(frame) < 2 || frame[0] != 0xFF || frame[1] != markerSOI {
+		return nil, fmt.Errorf("JPEG-LS frame does not start with SOI")
+	}
+
+	var header *frameHeader
+	var preset [5]int // MAXVAL, T1, T2, T3 and RESET, zero for the defaults
+	for pos := 2; ; {
+		marker, payload, next, err := nextMarkerSegment(frame, pos)
+		if err != nil {
+			return nil, err
+		}
+		pos = next
+
+		switch {
+		case marker == markerSOF55:
+			h, err := parseFrameHeader(payload, width, height)
+			if err != nil {
+				return nil, err
+			}
+			if h.precision > bitsAllocated {
+				return nil, fmt.Errorf("JPEG-LS precision of %d bits exceeds %d bits allocated", h.precision, bitsAllocated)
+			}
+			header = &h
+		case marker == markerLSE:
+			if len(payload) < 1 || payload[0] != 1 {
+				return nil, fmt.Errorf("JPEG-LS mapping tables are not supported")
+			}
+			if len(payload) < 11 {
+				return nil, fmt.Errorf("JPEG-LS preset parameters are truncated")
+			}
+			for i := range preset {
+				preset[i] = int(binary.BigEndian.Uint16(payload[1+2*i:]))
+			}
+		case marker == markerSOS:
+			if header == nil {
+				return nil, fmt.Errorf("JPEG-LS scan precedes the frame header")
+			}
+			if len(payload) < 6 || payload[0] != 1 {
+				return nil, fmt.Errorf("JPEG-LS scan must have a single component")
+			}
+			if payload[2] != 0 {
+				return nil, fmt.Errorf("JPEG-LS mapping tables are not supported")
+			}
+			if near := payload[3]; near != 0 {
+				return nil, fmt.Errorf("near-lossless JPEG-LS (NEAR=%d) is not supported", near)
+			}
+
+			maxVal := 1<<header.precision - 1
+			if preset[0] != 0 {
+				maxVal = preset[0]
+			}
+			s := newJPEGLSCoder(maxVal)
+			for i, t := range []*int{&s.t1, &s.t2, &s.t3, &s.reset} {
+				if preset[i+1] != 0 {
+					*t = preset[i+1]
+				}
+			}
+			s.init()
+			return s.decodeScan(frame[pos:], header.width, header.height, bitsAllocated)
+		case marker == markerEOI:
+			return nil, fmt.Errorf("JPEG-LS frame has no scan")
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			return nil, fmt.Errorf("JPEG frame of marker %02X is not JPEG-LS", marker)
+		}
+	}
+}
+
+// decodeScan decodes the coded samples of a single component scan
+func (s *jpegLSCoder) decodeScan(data []byte, width, height, bitsAllocated int) ([]byte, error) {
+	r := &jpegLSBitReader{data: data}
+	samples := make([]int, 0, width*height)
+
+	prev, cur := make([]int, width+2), make([]int, width+2)
+	for range height {
+		prev[width+1] = prev[width]
+		cur[0] = prev[1]
+
+		for x := 0; x < width; {
+			ra, rb, rc, rd := cur[x], prev[x+1], prev[x], prev[x+2]
+			if ra != rb || rb != rc || rc != rd {
+				q, sign, px := s.regularContext(ra, rb, rc, rd)
+				k := s.golombK(q)
+				errval := unmapError(r.golomb(k, s.limit, s.qbpp)) ^ s.errorCorrection(q, k)
+				s.updateRegular(q, errval)
+				cur[x+1] = s.reconstruct(px + sign*errval)
+				x++
+				continue
+			}
+
+			// Run mode
+			run := 0
+			for r.read(1) == 1 && r.err == nil {
+				count := min(1<<jpegLSJ[s.runIndex], width-x-run)
+				run += count
+				if count == 1<<jpegLSJ[s.runIndex] {
+					s.runIndex = min(s.runIndex+1, 31)
+				}
+				if x+run == width {
+					break
+				}
+			}
+			if x+run < width {
+				run += r.read(jpegLSJ[s.runIndex])
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+			if x+run > width {
+				return nil, fmt.Errorf("JPEG-LS run exceeds the line")
+			}
+			for range run {
+				cur[x+1] = ra
+				x++
+			}
+			if x == width {
+				break
+			}
+
+			riType, px, sign := interruptionContext(ra, prev[x+1])
+			k := s.interruptionK(riType)
+			mapped := r.golomb(k, s.limit-jpegLSJ[s.runIndex]-1, s.qbpp)
+			temp := mapped + riType
+			errval := (temp + temp&1) / 2
+			if (k != 0 || 2*s.nn[riType] >= s.n[jpegLSRunContext+riType]) == (temp&1 == 1) {
+				errval = -errval
+			}
+			s.updateInterruption(riType, errval, mapped)
+			s.runIndex = max(s.runIndex-1, 0)
+			cur[x+1] = s.reconstruct(px + sign*errval)
+			x++
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		samples = append(samples, cur[1:width+1]...)
+		prev, cur = cur, prev
+	}
+
+	return packSamples(samples, bitsAllocated), nil
+}
+
+// mapError maps a prediction error to a non-negative value
+func mapError(errval int) int {
+	if errval >= 0 {
+		return 2 * errval
+	}
+	return -2*errval - 1
+}
+
+// unmapError reverses mapError
+func unmapError(mapped int) int {
+	if mapped&1 == 0 {
+		return mapped >> 1
+	}
+	return -(mapped + 1) >> 1
+}
+
+// interruptionMap returns the map bit of a run interruption error (T.87 A.7.2)
+func interruptionMap(errval, k int, negativeMajority bool) int {
+	switch {
+	case k == 0 && errval > 0 && !negativeMajority:
+		return 1
+	case errval < 0 && negativeMajority:
+		return 1
+	case errval < 0 && k != 0:
+		return 1
+	}
+	return 0
+}
+
+// abs returns the absolute value of an integer
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// jpegLSBitWriter writes JPEG-LS coded data, in which the byte after a 0xFF
+// byte only holds seven bits
+type jpegLSBitWriter struct {
+	out      []byte
+	cur      byte
+	n        int
+	capacity int
+}
+
+// write appends the low size bits of value
+func (w *jpegLSBitWriter) write(value, size int) {
+	for i := size - 1; i >= 0; i-- {
+		w.cur = w.cur<<1 | byte(value>>i)&1
+		w.n++
+		if w.n == w.capacity {
+			w.out = append(w.out, w.cur)
+			w.capacity = 8
+			if w.cur == 0xFF {
+				w.capacity = 7
+			}
+			w.cur, w.n = 0, 0
+		}
+	}
+}
+
+// zeros appends count zero bits
+func (w *jpegLSBitWriter) zeros(count int) {
+	for range count {
+		w.write(0, 1)
+	}
+}
+
+// golomb appends a value with the limited length Golomb code of T.87 A.5.3
+func (w *jpegLSBitWriter) golomb(value, k, limit, qbpp int) {
+	if high := value >> k; high < limit-qbpp-1 {
+		w.zeros(high)
+		w.write(1, 1)
+		w.write(value, k)
+		return
+	}
+	w.zeros(limit - qbpp - 1)
+	w.write(1, 1)
+	w.write(value-1, qbpp)
+}
+
+// flush pads the last byte with zero bits, keeping a 0xFF byte from running
+// into the following marker
+func (w *jpegLSBitWriter) flush() {
+	if w.n > 0 {
+		w.zeros(w.capacity - w.n)
+	}
+	if w.capacity == 7 {
+		w.out = append(w.out, 0x00)
+	}
+}
+
+// jpegLSBitReader reads JPEG-LS coded data. Running into a marker or the end
+// of the data sets err.
+type jpegLSBitReader struct {
+	data []byte
+	pos  int
+	cur  byte
+	n    int
+	err  error
+}
+
+// read returns the next size bits
+func (r *jpegLSBitReader) read(size int) int {
+	value := 0
+	for range size {
+		if r.n == 0 {
+			if r.pos >= len(r.data) || r.err != nil {
+				if r.err == nil {
+					r.err = fmt.Errorf("JPEG-LS scan ends early")
+				}
+				return 0
+			}
+			b := r.data[r.pos]
+			r.n = 8
+			if r.pos > 0 && r.data[r.pos-1] == 0xFF {
+				if b&0x80 != 0 {
+					r.err = fmt.Errorf("JPEG-LS scan ends early at a marker")
+					return 0
+				}
+				r.n = 7
+			}
+			r.cur = b
+			r.pos++
+		}
+		r.n--
+		value = value<<1 | int(r.cur>>r.n)&1
+	}
+	return value
+}
+
+// golomb reads a value coded with the limited length Golomb code
+func (r *jpegLSBitReader) golomb(k, limit, qbpp int) int {
+	high := 0
+	for r.read(1) == 0 && r.err == nil {
+		high++
+		if high > limit {
+			r.err = fmt.Errorf("invalid JPEG-LS Golomb code")
+			return 0
+		}
+	}
+	if high >= limit-qbpp-1 {
+		return r.read(qbpp) + 1
+	}
+	return high<<k | r.read(k)
+}
@@ -0,0 +1,218 @@
+package dicom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/flatmapit/crgodicom/pkg/types"
+)
+
+// ManifestFileName is the name of the manifest Writer places in each study directory
+const ManifestFileName = "study.json"
+
+// manifestVersion is the format version of the manifests written by Writer
+const manifestVersion = 1
+
+// Manifest describes a study directory written by Writer, so that listing and
+// sending a study does not need to walk and parse every file in it
+type Manifest struct {
+	Version    int                 `json:"version"`
+	CreatedAt  time.Time           `json:"created_at"`
+	Patient    ManifestPatient     `json:"patient"`
+	Study      ManifestStudy       `json:"study"`
+	Parameters *ManifestParameters `json:"parameters,omitempty"`
+	Series     []ManifestSeries    `json:"series"`
+}
+
+// ManifestPatient holds the patient attributes of a study
+type ManifestPatient struct {
+	Name      string `json:"name"`
+	ID        string `json:"id"`
+	BirthDate string `json:"birth_date,omitempty"`
+}
+
+// ManifestStudy holds the study level attributes of a study
+type ManifestStudy struct {
+	StudyInstanceUID string `json:"study_instance_uid"`
+	StudyDate        string `json:"study_date"`
+	StudyTime        string `json:"study_time"`
+	AccessionNumber  string `json:"accession_number"`
+	StudyDescription string `json:"study_description"`
+}
+
+// ManifestParameters records the generation parameters a study was created with
+type ManifestParameters struct {
+	SeriesCount      int    `json:"series_count"`
+	ImageCount       int    `json:"image_count"`
+	Modality         string `json:"modality"`
+	AnatomicalRegion string `json:"anatomical_region,omitempty"`
+	Template         string `json:"template,omitempty"`
+	TransferSyntax   string `json:"transfer_syntax,omitempty"`
+}
+
+// ManifestSeries holds a series of a study and its instances
+type ManifestSeries struct {
+	SeriesInstanceUID string             `json:"series_instance_uid"`
+	SeriesNumber      int                `json:"series_number"`
+	Modality          string             `json:"modality"`
+	SeriesDescription string             `json:"series_description"`
+	Instances         []ManifestInstance `json:"instances"`
+}
+
+// ManifestInstance holds an instance of a series and the file it is stored in.
+// Path is relative to the study directory and uses forward slashes.
+type ManifestInstance struct {
+	SOPInstanceUID string `json:"sop_instance_uid"`
+	SOPClassUID    string `json:"sop_class_uid"`
+	InstanceNumber int    `json:"instance_number"`
+	Path           string `json:"path"`
+	Size           int64  `json:"size"`
+	SHA256         string `json:"sha256"`
+}
+
+// NewManifest creates a manifest of a study without any instances
+func NewManifest(study *types.Study, params *types.StudyParams) *Manifest {
+	manifest := &Manifest{
+		Version:   manifestVersion,
+		CreatedAt: time.Now().UTC(),
+		Patient: ManifestPatient{
+			Name:      study.PatientName,
+			ID:        study.PatientID,
+			BirthDate: study.PatientBirthDate,
+		},
+		Study: ManifestStudy{
+			StudyInstanceUID: study.StudyInstanceUID,
+			StudyDate:        study.StudyDate,
+			StudyTime:        study.StudyTime,
+			AccessionNumber:  study.AccessionNumber,
+			StudyDescription: study.StudyDescription,
+		},
+		Series: []ManifestSeries{},
+	}
+
+	if params != nil {
+		manifest.Parameters = &ManifestParameters{
+			SeriesCount:      params.SeriesCount,
+			ImageCount:       params.ImageCount,
+			Modality:         params.Modality,
+			AnatomicalRegion: params.AnatomicalRegion,
+			Template:         params.TemplateName,
+			TransferSyntax:   params.TransferSyntaxUID,
+		}
+	}
+
+	return manifest
+}
+
+// ReadManifest reads the manifest of a study directory. The error wraps
+// os.ErrNotExist when the directory has no manifest.
+func ReadManifest(studyDir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(studyDir, ManifestFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read study manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse study manifest: %w", err)
+	}
+	if manifest.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported study manifest version %d", manifest.Version)
+	}
+
+	return &manifest, nil
+}
+
+// Write writes the manifest into a study directory
+func (m *Manifest) Write(studyDir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode study manifest: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(studyDir, ManifestFileName), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write study manifest: %w", err)
+	}
+	return nil
+}
+
+// StudyInfo returns the patient and study attributes of the manifest as a
+// study without series
+func (m *Manifest) StudyInfo() *types.Study {
+	return &types.Study{
+		StudyInstanceUID: m.Study.StudyInstanceUID,
+		StudyDate:        m.Study.StudyDate,
+		StudyTime:        m.Study.StudyTime,
+		AccessionNumber:  m.Study.AccessionNumber,
+		StudyDescription: m.Study.StudyDescription,
+		PatientName:      m.Patient.Name,
+		PatientID:        m.Patient.ID,
+		PatientBirthDate: m.Patient.BirthDate,
+		Series:           []types.Series{},
+	}
+}
+
+// InstanceCount returns the number of instances across all series
+func (m *Manifest) InstanceCount() int {
+	count := 0
+	for _, series := range m.Series {
+		count += len(series.Instances)
+	}
+	return count
+}
+
+// Modalities returns the distinct modalities of the series in series order
+func (m *Manifest) Modalities() []string {
+	var modalities []string
+	seen := make(map[string]bool)
+	for _, series := range m.Series {
+		if series.Modality != "" && !seen[series.Modality] {
+			seen[series.Modality] = true
+			modalities = append(modalities, series.Modality)
+		}
+	}
+	return modalities
+}
+
+// Files returns the paths of the instance files of a study directory in
+// series order. It fails when a file is missing or its size differs from
+// the manifest, as the manifest is then stale.
+func (m *Manifest) Files(studyDir string) ([]string, error) {
+	files := make([]string, 0, m.InstanceCount())
+	for _, series := range m.Series {
+		for _, instance := range series.Instances {
+			path := filepath.Join(studyDir, filepath.FromSlash(instance.Path))
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("study manifest is stale: %w", err)
+			}
+			if info.Size() != instance.Size {
+				return nil, fmt.Errorf("study manifest is stale: %s is %d bytes, expected %d", instance.Path, info.Size(), instance.Size)
+			}
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// hashFile returns the hex encoded SHA-256 hash and the size of a file
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
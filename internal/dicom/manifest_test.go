@@ -0,0 +1,57 @@
+package dicom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteStudyManifest(t *testing.T) {
+	study := testStudy()
+	params := &types.StudyParams{SeriesCount: 2, ImageCount: 2, Modality: "CT", TemplateName: "chest-ct"}
+
+	outputDir := t.TempDir()
+	if !assert.NoError(t, NewWriter(config.DefaultConfig()).WriteStudy(study, params, outputDir)) {
+		return
+	}
+	studyDir := filepath.Join(outputDir, "1.2.3")
+
+	manifest, err := ReadManifest(studyDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "DOE^JANE", manifest.Patient.Name)
+	assert.Equal(t, "ACC1", manifest.Study.AccessionNumber)
+	if assert.NotNil(t, manifest.Parameters) {
+		assert.Equal(t, "chest-ct", manifest.Parameters.Template)
+	}
+	assert.Equal(t, 4, manifest.InstanceCount())
+	assert.Equal(t, []string{"CT"}, manifest.Modalities())
+
+	instance := manifest.Series[1].Instances[0]
+	assert.Equal(t, "1.2.3.2.1", instance.SOPInstanceUID)
+	assert.Equal(t, "series_002/image_001.dcm", instance.Path)
+	data, err := os.ReadFile(filepath.Join(studyDir, "series_002", "image_001.dcm"))
+	assert.NoError(t, err)
+	sum := sha256.Sum256(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), instance.SHA256)
+	assert.Equal(t, int64(len(data)), instance.Size)
+
+	files, err := manifest.Files(studyDir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 4)
+
+	// A changed file makes the manifest stale
+	assert.NoError(t, os.WriteFile(files[0], append(data, 0), 0644))
+	_, err = manifest.Files(studyDir)
+	assert.ErrorContains(t, err, "stale")
+
+	_, err = ReadManifest(t.TempDir())
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,237 @@
+package dicom
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// queryKeys maps each Query/Retrieve level to the attribute identifying its entities
+var queryKeys = map[string]Tag{
+	"PATIENT": TagPatientID,
+	"STUDY":   TagStudyInstanceUID,
+	"SERIES":  TagSeriesInstanceUID,
+	"IMAGE":   TagSOPInstanceUID,
+}
+
+// Find answers a C-FIND identifier at a Query/Retrieve level (PATIENT, STUDY,
+// SERIES or IMAGE) from the stored instances. Each response holds the
+// attributes requested by the identifier, including the computed
+// ModalitiesInStudy and Number of ... Related attributes.
+func (s *StudyStore) Find(level string, identifier *Dataset) ([]*Dataset, error) {
+	key, ok := queryKeys[level]
+	if !ok {
+		return nil, fmt.Errorf("unsupported query level %q", level)
+	}
+
+	records, err := s.records()
+	if err != nil {
+		return nil, err
+	}
+
+	// Group the instances by the entity they belong to at the query level
+	var order []string
+	groups := make(map[string][]*Dataset)
+	for _, ds := range records {
+		id := ds.String(key)
+		if _, ok := groups[id]; !ok {
+			order = append(order, id)
+		}
+		groups[id] = append(groups[id], ds)
+	}
+
+	var responses []*Dataset
+	for _, id := range order {
+		response := buildQueryResponse(level, identifier, groups[id])
+		if matchIdentifier(identifier, response) {
+			responses = append(responses, response)
+		}
+	}
+	return responses, nil
+}
+
+// records reads every stored instance, normalised to little endian, in path order
+func (s *StudyStore) records() ([]*Dataset, error) {
+	entries, err := os.ReadDir(s.baseDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read studies directory: %w", err)
+	}
+
+	var paths []string
+	s.mu.Lock()
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		instances, err := s.index(entry.Name())
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		for _, path := range instances {
+			paths = append(paths, path)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(paths)
+
+	records := make([]*Dataset, 0, len(paths))
+	for _, path := range paths {
+		file, err := ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		ds := file.Dataset
+		if ds.BigEndian {
+			if ds, err = normaliseDataset(ds); err != nil {
+				return nil, err
+			}
+		}
+		records = append(records, ds)
+	}
+	return records, nil
+}
+
+// buildQueryResponse fills the keys of identifier from the instances of one entity
+func buildQueryResponse(level string, identifier *Dataset, instances []*Dataset) *Dataset {
+	first := instances[0]
+	response := NewDataset()
+
+	for _, elem := range identifier.Elements {
+		switch elem.Tag {
+		case TagQueryRetrieveLevel:
+			response.SetString(elem.Tag, level)
+		case TagSpecificCharacterSet:
+			// Responses use the default character repertoire unless the instances say otherwise
+			if value := first.Get(elem.Tag); value != nil {
+				response.Set(elem.Tag, value.VR, value.Value)
+			}
+		case TagModalitiesInStudy:
+			response.SetString(elem.Tag, strings.Join(distinctValues(instances, TagModality), "\\"))
+		case TagNumberOfStudyRelatedSeries:
+			response.SetString(elem.Tag, strconv.Itoa(len(distinctValues(instances, TagSeriesInstanceUID))))
+		case TagNumberOfStudyRelatedInstances, TagNumberOfSeriesRelatedInstances:
+			response.SetString(elem.Tag, strconv.Itoa(len(instances)))
+		default:
+			if value := first.Get(elem.Tag); value != nil && value.Items == nil && value.Fragments == nil {
+				response.Set(elem.Tag, value.VR, value.Value)
+			} else {
+				response.Set(elem.Tag, elem.VR, nil)
+			}
+		}
+	}
+
+	// The unique key of the level is always returned
+	if key := queryKeys[level]; !response.Has(key) {
+		response.SetString(key, first.String(key))
+	}
+	return response
+}
+
+// distinctValues returns the distinct values of an attribute across instances in first-seen order
+func distinctValues(instances []*Dataset, t Tag) []string {
+	seen := make(map[string]bool)
+	var values []string
+	for _, ds := range instances {
+		value := ds.String(t)
+		if value != "" && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// matchIdentifier reports whether a response satisfies every matching key of identifier
+func matchIdentifier(identifier, response *Dataset) bool {
+	for _, elem := range identifier.Elements {
+		switch elem.Tag {
+		case TagQueryRetrieveLevel, TagSpecificCharacterSet:
+			continue
+		}
+		if elem.Items != nil || isBinaryVR(elem.VR) {
+			continue
+		}
+
+		pattern := trimValue(elem.Value)
+		if pattern == "" {
+			continue // Universal matching
+		}
+		// Modalities in Study matches when any of the requested modalities is present
+		alternatives := []string{pattern}
+		if elem.Tag == TagModalitiesInStudy {
+			alternatives = strings.Split(pattern, "\\")
+		}
+
+		matched := false
+		for _, alternative := range alternatives {
+			if matchValue(elem.VR, strings.TrimSpace(alternative), response.Strings(elem.Tag)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchValue applies single value, UID list, range and wildcard matching to
+// the values of an attribute
+func matchValue(vr, pattern string, values []string) bool {
+	for _, value := range values {
+		switch {
+		case vr == "UI":
+			for _, uid := range strings.Split(pattern, "\\") {
+				if strings.TrimSpace(uid) == value {
+					return true
+				}
+			}
+		case (vr == "DA" || vr == "TM" || vr == "DT") && strings.Contains(pattern, "-"):
+			bounds := strings.SplitN(pattern, "-", 2)
+			if (bounds[0] == "" || value >= bounds[0]) && (bounds[1] == "" || value <= bounds[1]) {
+				return true
+			}
+		case strings.ContainsAny(pattern, "*?"):
+			if wildcardPattern(pattern, vr == "PN").MatchString(value) {
+				return true
+			}
+		case vr == "PN":
+			if strings.EqualFold(pattern, value) {
+				return true
+			}
+		default:
+			if pattern == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wildcardPattern converts a DICOM wildcard pattern to a regular expression
+func wildcardPattern(pattern string, ignoreCase bool) *regexp.Regexp {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	return regexp.MustCompile("^" + expr + "$")
+}
+
+// isBinaryVR reports whether values of a VR are binary rather than text
+func isBinaryVR(vr string) bool {
+	switch vr {
+	case "OB", "OW", "OF", "OD", "OL", "UN", "US", "SS", "UL", "SL", "FL", "FD", "AT":
+		return true
+	}
+	return false
+}
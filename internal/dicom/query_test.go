@@ -0,0 +1,34 @@
+package dicom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		vr      string
+		pattern string
+		values  []string
+		want    bool
+	}{
+		{"single value", "LO", "12345", []string{"12345"}, true},
+		{"single value mismatch", "LO", "12345", []string{"1234"}, false},
+		{"uid list", "UI", "1.2.3\\1.2.4", []string{"1.2.4"}, true},
+		{"date range", "DA", "20240101-20241231", []string{"20240615"}, true},
+		{"open date range", "DA", "20250101-", []string{"20240615"}, false},
+		{"wildcard", "LO", "CT*", []string{"CT CHEST"}, true},
+		{"single character wildcard", "CS", "M?", []string{"MR"}, true},
+		{"person name ignores case", "PN", "doe^*", []string{"DOE^JOHN"}, true},
+		{"multi-valued attribute", "CS", "MR", []string{"CT", "MR"}, true},
+		{"no value", "LO", "12345", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchValue(tt.vr, tt.pattern, tt.values))
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package dicom
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Transfer Syntax UIDs understood by the native reader
+const (
+	ImplicitVRLittleEndian         = "1.2.840.10008.1.2"
+	ExplicitVRLittleEndian         = "1.2.840.10008.1.2.1"
+	DeflatedExplicitVRLittleEndian = "1.2.840.10008.1.2.1.99"
+	ExplicitVRBigEndian            = "1.2.840.10008.1.2.2"
+)
+
+// undefinedLength marks a sequence, item or pixel data element of undefined length
+const undefinedLength = 0xFFFFFFFF
+
+// File represents a parsed DICOM Part 10 file
+type File struct {
+	Meta              *Dataset
+	Dataset           *Dataset
+	TransferSyntaxUID string
+
+	// RawDataset holds the encoded dataset that follows the File Meta Information
+	RawDataset []byte
+}
+
+// SOPClassUID returns the SOP Class UID of the file, preferring the File Meta Information
+func (f *File) SOPClassUID() string {
+	if uid := f.Meta.String(TagMediaStorageSOPClassUID); uid != "" {
+		return uid
+	}
+	return f.Dataset.String(TagSOPClassUID)
+}
+
+// SOPInstanceUID returns the SOP Instance UID of the file, preferring the File Meta Information
+func (f *File) SOPInstanceUID() string {
+	if uid := f.Meta.String(TagMediaStorageSOPInstanceUID); uid != "" {
+		return uid
+	}
+	return f.Dataset.String(TagSOPInstanceUID)
+}
+
+// ReadFile reads and parses a DICOM Part 10 file from disk
+func ReadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DICOM file: %w", err)
+	}
+
+	file, err := ParseFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// ParseFile parses the contents of a DICOM Part 10 file
+func ParseFile(data []byte) (*File, error) {
+	// 128-byte preamble followed by the "DICM" prefix
+	if len(data) < 132 || string(data[128:132]) != "DICM" {
+		return nil, fmt.Errorf("not a DICOM Part 10 file: missing DICM prefix")
+	}
+
+	// File Meta Information is always Explicit VR Little Endian
+	p := &parser{
+		data:     data,
+		pos:      132,
+		explicit: true,
+		order:    binary.LittleEndian,
+	}
+
+	meta := NewDataset()
+	for p.pos+2 <= len(p.data) && p.order.Uint16(p.data[p.pos:]) == 0x0002 {
+		elem, err := p.readElement()
+		if err != nil {
+			return nil, fmt.Errorf("invalid file meta information: %w", err)
+		}
+		meta.Elements = append(meta.Elements, elem)
+	}
+
+	transferSyntax := meta.String(TagTransferSyntaxUID)
+	if transferSyntax == "" {
+		transferSyntax = ImplicitVRLittleEndian
+	}
+
+	raw := data[p.pos:]
+	dataset, err := ParseDataset(raw, transferSyntax)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		Meta:              meta,
+		Dataset:           dataset,
+		TransferSyntaxUID: transferSyntax,
+		RawDataset:        raw,
+	}, nil
+}
+
+// ParseDataset parses a raw dataset encoded with the given transfer syntax.
+// Deflated datasets are inflated before parsing.
+func ParseDataset(data []byte, transferSyntaxUID string) (*Dataset, error) {
+	if transferSyntaxUID == DeflatedExplicitVRLittleEndian {
+		inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid deflated dataset: %w", err)
+		}
+		data = inflated
+	}
+
+	p := newParser(data, transferSyntaxUID)
+
+	dataset, err := p.readDataset(len(data), false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dataset: %w", err)
+	}
+	return dataset, nil
+}
+
+// parser decodes DICOM data elements from a byte buffer
+type parser struct {
+	data      []byte
+	pos       int
+	explicit  bool
+	order     binary.ByteOrder
+	bigEndian bool
+}
+
+// newParser creates a parser configured for the given transfer syntax
+func newParser(data []byte, transferSyntaxUID string) *parser {
+	p := &parser{
+		data:     data,
+		explicit: true,
+		order:    binary.LittleEndian,
+	}
+
+	switch transferSyntaxUID {
+	case ImplicitVRLittleEndian:
+		p.explicit = false
+	case ExplicitVRBigEndian:
+		p.order = binary.BigEndian
+		p.bigEndian = true
+	}
+
+	return p
+}
+
+// readDataset reads elements until end, or until an item delimiter when inItem is set
+func (p *parser) readDataset(end int, inItem bool) (*Dataset, error) {
+	dataset := NewDataset()
+	dataset.BigEndian = p.bigEndian
+
+	for p.pos < end {
+		if inItem {
+			tag, err := p.peekTag()
+			if err != nil {
+				return nil, err
+			}
+			if tag == TagItemDelimitationItem {
+				p.pos += 8
+				return dataset, nil
+			}
+		}
+
+		elem, err := p.readElement()
+		if err != nil {
+			return nil, err
+		}
+		dataset.Elements = append(dataset.Elements, elem)
+	}
+
+	if inItem && end == len(p.data) {
+		return nil, fmt.Errorf("unterminated sequence item")
+	}
+	return dataset, nil
+}
+
+// readElement reads a single data element at the current position
+func (p *parser) readElement() (*Element, error) {
+	tag, err := p.readTag()
+	if err != nil {
+		return nil, err
+	}
+
+	var vr string
+	var length uint32
+
+	if p.explicit {
+		if err := p.need(2); err != nil {
+			return nil, err
+		}
+		vr = string(p.data[p.pos : p.pos+2])
+		p.pos += 2
+
+		if hasLongLength(vr) {
+			if err := p.need(6); err != nil {
+				return nil, err
+			}
+			length = p.order.Uint32(p.data[p.pos+2:])
+			p.pos += 6
+		} else {
+			if err := p.need(2); err != nil {
+				return nil, err
+			}
+			length = uint32(p.order.Uint16(p.data[p.pos:]))
+			p.pos += 2
+		}
+	} else {
+		vr = lookupVR(tag)
+		if err := p.need(4); err != nil {
+			return nil, err
+		}
+		length = p.order.Uint32(p.data[p.pos:])
+		p.pos += 4
+
+		// An undefined length in Implicit VR can only be a sequence
+		if length == undefinedLength && tag != TagPixelData {
+			vr = "SQ"
+		}
+	}
+
+	elem := &Element{Tag: tag, VR: vr}
+
+	switch {
+	case vr == "SQ":
+		items, err := p.readSequence(length)
+		if err != nil {
+			return nil, fmt.Errorf("sequence %s: %w", tag, err)
+		}
+		elem.Items = items
+	case length == undefinedLength && tag == TagPixelData:
+		fragments, err := p.readFragments()
+		if err != nil {
+			return nil, fmt.Errorf("encapsulated pixel data: %w", err)
+		}
+		elem.Fragments = fragments
+	case length == undefinedLength:
+		return nil, fmt.Errorf("element %s has undefined length", tag)
+	default:
+		if err := p.need(int(length)); err != nil {
+			return nil, fmt.Errorf("element %s: %w", tag, err)
+		}
+		elem.Value = p.data[p.pos : p.pos+int(length)]
+		p.pos += int(length)
+	}
+
+	return elem, nil
+}
+
+// readSequence reads the items of a sequence element
+func (p *parser) readSequence(length uint32) ([]*Dataset, error) {
+	end := len(p.data)
+	if length != undefinedLength {
+		if err := p.need(int(length)); err != nil {
+			return nil, err
+		}
+		end = p.pos + int(length)
+	}
+
+	var items []*Dataset
+	for p.pos < end {
+		tag, err := p.readTag()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.need(4); err != nil {
+			return nil, err
+		}
+		itemLength := p.order.Uint32(p.data[p.pos:])
+		p.pos += 4
+
+		if tag == TagSequenceDelimitationItem {
+			return items, nil
+		}
+		if tag != TagItem {
+			return nil, fmt.Errorf("unexpected tag %s in sequence", tag)
+		}
+
+		var item *Dataset
+		if itemLength == undefinedLength {
+			item, err = p.readDataset(len(p.data), true)
+		} else {
+			if err := p.need(int(itemLength)); err != nil {
+				return nil, err
+			}
+			item, err = p.readDataset(p.pos+int(itemLength), false)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	if length == undefinedLength {
+		return nil, fmt.Errorf("missing sequence delimitation item")
+	}
+	return items, nil
+}
+
+// readFragments reads the items of encapsulated pixel data
+func (p *parser) readFragments() ([][]byte, error) {
+	var fragments [][]byte
+	for {
+		tag, err := p.readTag()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.need(4); err != nil {
+			return nil, err
+		}
+		length := p.order.Uint32(p.data[p.pos:])
+		p.pos += 4
+
+		if tag == TagSequenceDelimitationItem {
+			return fragments, nil
+		}
+		if tag != TagItem {
+			return nil, fmt.Errorf("unexpected tag %s in pixel data", tag)
+		}
+		if err := p.need(int(length)); err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, p.data[p.pos:p.pos+int(length)])
+		p.pos += int(length)
+	}
+}
+
+// readTag reads a tag and advances the position
+func (p *parser) readTag() (Tag, error) {
+	tag, err := p.peekTag()
+	if err != nil {
+		return Tag{}, err
+	}
+	p.pos += 4
+	return tag, nil
+}
+
+// peekTag reads a tag without advancing the position
+func (p *parser) peekTag() (Tag, error) {
+	if err := p.need(4); err != nil {
+		return Tag{}, err
+	}
+	return Tag{
+		Group:   p.order.Uint16(p.data[p.pos:]),
+		Element: p.order.Uint16(p.data[p.pos+2:]),
+	}, nil
+}
+
+// need verifies that n more bytes are available
+func (p *parser) need(n int) error {
+	if n < 0 || p.pos+n > len(p.data) {
+		return fmt.Errorf("unexpected end of data at offset %d", p.pos)
+	}
+	return nil
+}
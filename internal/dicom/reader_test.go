@@ -0,0 +1,200 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// implicitElement encodes an Implicit VR Little Endian element
+func implicitElement(t Tag, value []byte) []byte {
+	buf := make([]byte, 8, 8+len(value))
+	binary.LittleEndian.PutUint16(buf[0:], t.Group)
+	binary.LittleEndian.PutUint16(buf[2:], t.Element)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(value)))
+	return append(buf, value...)
+}
+
+// explicitElement encodes an Explicit VR element with a short length field
+func explicitElement(order binary.ByteOrder, t Tag, vr string, value []byte) []byte {
+	buf := make([]byte, 8, 8+len(value))
+	order.PutUint16(buf[0:], t.Group)
+	order.PutUint16(buf[2:], t.Element)
+	copy(buf[4:], vr)
+	order.PutUint16(buf[6:], uint16(len(value)))
+	return append(buf, value...)
+}
+
+// part10 wraps a dataset in a preamble and File Meta Information
+func part10(sopClass, sopInstance, transferSyntax string, dataset []byte) []byte {
+	data := make([]byte, 128)
+	data = append(data, "DICM"...)
+	data = append(data, explicitElement(binary.LittleEndian, TagMediaStorageSOPClassUID, "UI", padUID(sopClass))...)
+	data = append(data, explicitElement(binary.LittleEndian, TagMediaStorageSOPInstanceUID, "UI", padUID(sopInstance))...)
+	data = append(data, explicitElement(binary.LittleEndian, TagTransferSyntaxUID, "UI", padUID(transferSyntax))...)
+	return append(data, dataset...)
+}
+
+func padUID(uid string) []byte {
+	if len(uid)%2 == 1 {
+		return append([]byte(uid), 0x00)
+	}
+	return []byte(uid)
+}
+
+func us(order binary.ByteOrder, v uint16) []byte {
+	b := make([]byte, 2)
+	order.PutUint16(b, v)
+	return b
+}
+
+func TestParseFile(t *testing.T) {
+	le := binary.LittleEndian
+	be := binary.BigEndian
+
+	tests := []struct {
+		name           string
+		transferSyntax string
+		dataset        []byte
+		bigEndian      bool
+	}{
+		{
+			name:           "implicit VR little endian",
+			transferSyntax: ImplicitVRLittleEndian,
+			dataset: concat(
+				implicitElement(TagSOPClassUID, padUID("1.2.840.10008.5.1.4.1.1.2")),
+				implicitElement(TagSOPInstanceUID, padUID("1.2.3.4.5")),
+				implicitElement(TagPatientName, []byte("DOE^JOHN")),
+				implicitElement(TagInstanceNumber, []byte("7 ")),
+				implicitElement(TagRows, us(le, 2)),
+				implicitElement(TagColumns, us(le, 2)),
+				implicitElement(TagBitsAllocated, us(le, 16)),
+				implicitElement(TagPixelData, []byte{1, 0, 2, 0, 3, 0, 4, 0}),
+			),
+		},
+		{
+			name:           "explicit VR big endian",
+			transferSyntax: ExplicitVRBigEndian,
+			bigEndian:      true,
+			dataset: concat(
+				explicitElement(be, TagSOPClassUID, "UI", padUID("1.2.840.10008.5.1.4.1.1.2")),
+				explicitElement(be, TagSOPInstanceUID, "UI", padUID("1.2.3.4.5")),
+				explicitElement(be, TagPatientName, "PN", []byte("DOE^JOHN")),
+				explicitElement(be, TagInstanceNumber, "IS", []byte("7 ")),
+				explicitElement(be, TagRows, "US", us(be, 2)),
+				explicitElement(be, TagColumns, "US", us(be, 2)),
+				explicitElement(be, TagBitsAllocated, "US", us(be, 16)),
+				// OW uses the long length form
+				[]byte{0x7F, 0xE0, 0x00, 0x10, 'O', 'W', 0, 0, 0, 0, 0, 8, 0, 1, 0, 2, 0, 3, 0, 4},
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := part10("1.2.840.10008.5.1.4.1.1.2", "1.2.3.4.5", tt.transferSyntax, tt.dataset)
+
+			file, err := ParseFile(data)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.Equal(t, tt.transferSyntax, file.TransferSyntaxUID)
+			assert.Equal(t, "1.2.840.10008.5.1.4.1.1.2", file.SOPClassUID())
+			assert.Equal(t, "1.2.3.4.5", file.SOPInstanceUID())
+			assert.Equal(t, tt.bigEndian, file.Dataset.BigEndian)
+			assert.Equal(t, "DOE^JOHN", file.Dataset.String(TagPatientName))
+			assert.Equal(t, 7, file.Dataset.Int(TagInstanceNumber))
+			assert.Equal(t, 2, file.Dataset.Int(TagRows))
+
+			// Pixel data is normalised to little endian
+			image := ImageFromDataset(file.Dataset)
+			assert.Equal(t, []byte{1, 0, 2, 0, 3, 0, 4, 0}, image.PixelData)
+			assert.Equal(t, 16, image.BitsPerPixel)
+		})
+	}
+}
+
+func TestParseFileRejectsMissingPrefix(t *testing.T) {
+	_, err := ParseFile(make([]byte, 200))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "DICM")
+}
+
+func TestParseDatasetSequences(t *testing.T) {
+	referencedSequence := Tag{0x0008, 0x1199}
+
+	// Undefined length sequence with one undefined length item
+	item := concat(
+		implicitElement(Tag{0x0008, 0x1150}, padUID("1.2.840.10008.5.1.4.1.1.2")),
+		implicitElement(Tag{0x0008, 0x1155}, padUID("1.2.3")),
+	)
+	sequence := concat(
+		[]byte{0x08, 0x00, 0x99, 0x11, 0xFF, 0xFF, 0xFF, 0xFF},
+		[]byte{0xFE, 0xFF, 0x00, 0xE0, 0xFF, 0xFF, 0xFF, 0xFF},
+		item,
+		[]byte{0xFE, 0xFF, 0x0D, 0xE0, 0x00, 0x00, 0x00, 0x00},
+		[]byte{0xFE, 0xFF, 0xDD, 0xE0, 0x00, 0x00, 0x00, 0x00},
+	)
+	data := concat(sequence, implicitElement(TagPatientID, []byte("P1")))
+
+	ds, err := ParseDataset(data, ImplicitVRLittleEndian)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	items := ds.Sequence(referencedSequence)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, "1.2.3", items[0].String(Tag{0x0008, 0x1155}))
+	}
+	assert.Equal(t, "P1", ds.String(TagPatientID))
+}
+
+func TestReadStudy(t *testing.T) {
+	studyDir := filepath.Join(t.TempDir(), "1.2.3")
+	seriesDir := filepath.Join(studyDir, "series_001")
+	assert.NoError(t, os.MkdirAll(seriesDir, 0755))
+
+	// Write instances out of order to verify sorting by Instance Number
+	for i, instance := range []string{"2", "1"} {
+		dataset := concat(
+			implicitElement(TagSOPClassUID, padUID("1.2.840.10008.5.1.4.1.1.4")),
+			implicitElement(TagSOPInstanceUID, padUID("1.2.3.1.1."+instance)),
+			implicitElement(TagStudyInstanceUID, padUID("1.2.3")),
+			implicitElement(TagSeriesInstanceUID, padUID("1.2.3.1")),
+			implicitElement(TagPatientName, []byte("SMITH^JANE")),
+			implicitElement(TagModality, []byte("MR")),
+			implicitElement(TagInstanceNumber, []byte(instance+" ")),
+		)
+		data := part10("1.2.840.10008.5.1.4.1.1.4", "1.2.3.1.1."+instance, ImplicitVRLittleEndian, dataset)
+		path := filepath.Join(seriesDir, []string{"image_001.dcm", "image_002.dcm"}[i])
+		assert.NoError(t, os.WriteFile(path, data, 0644))
+	}
+
+	study, err := ReadStudy(studyDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "1.2.3", study.StudyInstanceUID)
+	assert.Equal(t, "SMITH^JANE", study.PatientName)
+	if assert.Len(t, study.Series, 1) {
+		series := study.Series[0]
+		assert.Equal(t, "MR", series.Modality)
+		if assert.Len(t, series.Images, 2) {
+			assert.Equal(t, "1.2.3.1.1.1", series.Images[0].SOPInstanceUID)
+			assert.Equal(t, "1.2.3.1.1.2", series.Images[1].SOPInstanceUID)
+		}
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
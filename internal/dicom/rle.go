@@ -0,0 +1,138 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// rleHeaderLength is the size of the RLE header: the number of segments and
+// the offsets of up to 15 segments (PS3.5 G.5)
+const rleHeaderLength = 64
+
+// encodeRLE compresses a frame with RLE Lossless (PS3.5 Annex G). Each byte
+// of a pixel goes to its own segment, most significant byte first, and each
+// row of a segment is PackBits encoded separately.
+func encodeRLE(pixels []byte, width, height, bitsAllocated int) ([]byte, error) {
+	bytesPerPixel := (bitsAllocated + 7) / 8
+	if bytesPerPixel > 2 {
+		return nil, fmt.Errorf("RLE encoding of %d bits allocated is not supported", bitsAllocated)
+	}
+
+	frame := make([]byte, rleHeaderLength)
+	binary.LittleEndian.PutUint32(frame, uint32(bytesPerPixel))
+
+	row := make([]byte, width)
+	for segment := 0; segment < bytesPerPixel; segment++ {
+		binary.LittleEndian.PutUint32(frame[4+4*segment:], uint32(len(frame)))
+
+		// Little endian pixels hold their most significant byte last
+		plane := bytesPerPixel - 1 - segment
+		for y := 0; y < height; y++ {
+			for x := range row {
+				row[x] = pixels[((y*width)+x)*bytesPerPixel+plane]
+			}
+			frame = packBits(frame, row)
+		}
+		if len(frame)%2 == 1 {
+			frame = append(frame, 0x00)
+		}
+	}
+
+	return frame, nil
+}
+
+// decodeRLE decompresses an RLE Lossless frame into little endian pixels
+func decodeRLE(frame []byte, width, height, bitsAllocated int) ([]byte, error) {
+	if len(frame) < rleHeaderLength {
+		return nil, fmt.Errorf("RLE frame is shorter than its header")
+	}
+
+	bytesPerPixel := (bitsAllocated + 7) / 8
+	segments := int(binary.LittleEndian.Uint32(frame))
+	if segments != bytesPerPixel {
+		return nil, fmt.Errorf("RLE frame has %d segments, expected %d", segments, bytesPerPixel)
+	}
+
+	size := width * height
+	pixels := make([]byte, size*bytesPerPixel)
+	for segment := 0; segment < segments; segment++ {
+		start := int(binary.LittleEndian.Uint32(frame[4+4*segment:]))
+		end := len(frame)
+		if segment+1 < segments {
+			end = int(binary.LittleEndian.Uint32(frame[8+4*segment:]))
+		}
+		if start < rleHeaderLength || start > end || end > len(frame) {
+			return nil, fmt.Errorf("RLE segment %d has invalid offsets", segment+1)
+		}
+
+		plane, err := unpackBits(frame[start:end], size)
+		if err != nil {
+			return nil, fmt.Errorf("RLE segment %d: %w", segment+1, err)
+		}
+		offset := bytesPerPixel - 1 - segment
+		for i, b := range plane {
+			pixels[i*bytesPerPixel+offset] = b
+		}
+	}
+
+	return pixels, nil
+}
+
+// packBits appends the PackBits encoding of src to dst
+func packBits(dst, src []byte) []byte {
+	for i := 0; i < len(src); {
+		// A replicate run of 2 to 128 bytes
+		run := 1
+		for i+run < len(src) && run < 128 && src[i+run] == src[i] {
+			run++
+		}
+		if run > 1 {
+			dst = append(dst, byte(257-run), src[i])
+			i += run
+			continue
+		}
+
+		// A literal run of up to 128 bytes, ending where a replicate run starts
+		start := i
+		for i < len(src) && i-start < 128 {
+			if i+1 < len(src) && src[i] == src[i+1] {
+				break
+			}
+			i++
+		}
+		dst = append(dst, byte(i-start-1))
+		dst = append(dst, src[start:i]...)
+	}
+	return dst
+}
+
+// unpackBits decodes size bytes of PackBits encoded data
+func unpackBits(src []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(src) && len(out) < size; {
+		n := int(int8(src[i]))
+		i++
+
+		switch {
+		case n >= 0:
+			if i+n+1 > len(src) {
+				return nil, fmt.Errorf("literal run exceeds the segment")
+			}
+			out = append(out, src[i:i+n+1]...)
+			i += n + 1
+		case n > -128:
+			if i >= len(src) {
+				return nil, fmt.Errorf("replicate run exceeds the segment")
+			}
+			for range 1 - n {
+				out = append(out, src[i])
+			}
+			i++
+		}
+	}
+
+	if len(out) < size {
+		return nil, fmt.Errorf("segment decodes to %d bytes, expected %d", len(out), size)
+	}
+	return out[:size], nil
+}
@@ -0,0 +1,282 @@
+package dicom
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StudyStore saves received instances into the studies/<StudyUID>/series_NNN
+// layout produced by Writer and read by ReadStudy. It is safe for concurrent use.
+type StudyStore struct {
+	baseDir string
+	aeTitle string
+
+	mu        sync.Mutex
+	instances map[string]map[string]string // Study Instance UID to SOP Instance UID to file path
+	series    map[string]string            // Series Instance UID to series directory
+}
+
+// NewStudyStore creates a study store rooted at baseDir. aeTitle is recorded
+// as the Source AE Title of the files it writes.
+func NewStudyStore(baseDir, aeTitle string) *StudyStore {
+	return &StudyStore{
+		baseDir:   baseDir,
+		aeTitle:   aeTitle,
+		instances: make(map[string]map[string]string),
+		series:    make(map[string]string),
+	}
+}
+
+// Save writes an instance, encoded with the given transfer syntax, as a DICOM
+// Part 10 file and returns its path. An existing file for the same SOP
+// Instance UID is replaced. The manifest of the study, if any, is removed
+// as it no longer matches the files.
+func (s *StudyStore) Save(sopClassUID, sopInstanceUID, transferSyntaxUID string, dataset []byte) (string, error) {
+	ds, err := ParseDataset(dataset, transferSyntaxUID)
+	if err != nil {
+		return "", err
+	}
+
+	studyUID := ds.String(TagStudyInstanceUID)
+	seriesUID := ds.String(TagSeriesInstanceUID)
+	if studyUID == "" || seriesUID == "" {
+		return "", fmt.Errorf("instance %s has no Study or Series Instance UID", sopInstanceUID)
+	}
+	if err := ValidateUID(studyUID); err != nil {
+		return "", fmt.Errorf("invalid Study Instance UID: %w", err)
+	}
+	if err := ValidateUID(seriesUID); err != nil {
+		return "", fmt.Errorf("invalid Series Instance UID: %w", err)
+	}
+
+	data, err := EncodeFile(s.aeTitle, sopClassUID, sopInstanceUID, transferSyntaxUID, dataset)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instances, err := s.index(studyUID)
+	if err != nil {
+		return "", err
+	}
+
+	path, exists := instances[sopInstanceUID]
+	if !exists {
+		seriesDir, err := s.seriesDir(studyUID, seriesUID)
+		if err != nil {
+			return "", err
+		}
+		path = nextImagePath(seriesDir, ds.Int(TagInstanceNumber))
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write DICOM file: %w", err)
+	}
+	instances[sopInstanceUID] = path
+
+	studyDir, err := s.studyDir(studyUID)
+	if err != nil {
+		return "", err
+	}
+	manifest := filepath.Join(studyDir, ManifestFileName)
+	if err := os.Remove(manifest); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Failed to remove stale study manifest: %v", err)
+	}
+
+	logrus.Debugf("Stored %s as %s", sopInstanceUID, path)
+	return path, nil
+}
+
+// Path returns the file holding a SOP instance of a study, if it is stored locally
+func (s *StudyStore) Path(studyUID, sopInstanceUID string) (string, bool) {
+	if ValidateUID(studyUID) != nil {
+		return "", false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instances, err := s.index(studyUID)
+	if err != nil {
+		return "", false
+	}
+	path, ok := instances[sopInstanceUID]
+	return path, ok
+}
+
+// Instances returns the SOP Instance UIDs stored for a study mapped to their file paths
+func (s *StudyStore) Instances(studyUID string) (map[string]string, error) {
+	if err := ValidateUID(studyUID); err != nil {
+		return nil, fmt.Errorf("invalid Study Instance UID: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instances, err := s.index(studyUID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string, len(instances))
+	for uid, path := range instances {
+		result[uid] = path
+	}
+	return result, nil
+}
+
+// SeriesInstances returns the SOP Instance UIDs stored for a series of a study
+// mapped to their file paths
+func (s *StudyStore) SeriesInstances(studyUID, seriesUID string) (map[string]string, error) {
+	if err := ValidateUID(studyUID); err != nil {
+		return nil, fmt.Errorf("invalid Study Instance UID: %w", err)
+	}
+	if err := ValidateUID(seriesUID); err != nil {
+		return nil, fmt.Errorf("invalid Series Instance UID: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instances, err := s.index(studyUID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	seriesDir, ok := s.series[seriesUID]
+	if !ok {
+		return result, nil
+	}
+	for uid, path := range instances {
+		if filepath.Dir(path) == seriesDir {
+			result[uid] = path
+		}
+	}
+	return result, nil
+}
+
+// index scans the directory of a study the first time it is used
+func (s *StudyStore) index(studyUID string) (map[string]string, error) {
+	if instances, ok := s.instances[studyUID]; ok {
+		return instances, nil
+	}
+
+	instances := make(map[string]string)
+	studyDir, err := s.studyDir(studyUID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(studyDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read study directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "series_") {
+			continue
+		}
+
+		seriesDir := filepath.Join(studyDir, entry.Name())
+		files, err := os.ReadDir(seriesDir)
+		if err != nil {
+			logrus.Warnf("Skipping series directory %s: %v", seriesDir, err)
+			continue
+		}
+
+		for _, f := range files {
+			if f.IsDir() || filepath.Ext(f.Name()) != ".dcm" {
+				continue
+			}
+			path := filepath.Join(seriesDir, f.Name())
+			file, err := ReadFile(path)
+			if err != nil {
+				logrus.Warnf("Skipping unreadable DICOM file: %v", err)
+				continue
+			}
+			instances[file.SOPInstanceUID()] = path
+			if seriesUID := file.Dataset.String(TagSeriesInstanceUID); seriesUID != "" {
+				s.series[seriesUID] = seriesDir
+			}
+		}
+	}
+
+	s.instances[studyUID] = instances
+	return instances, nil
+}
+
+// seriesDir returns the directory of a series, creating the next free
+// series_NNN directory for a series that is not yet stored
+func (s *StudyStore) seriesDir(studyUID, seriesUID string) (string, error) {
+	if dir, ok := s.series[seriesUID]; ok {
+		return dir, nil
+	}
+
+	studyDir, err := s.studyDir(studyUID)
+	if err != nil {
+		return "", err
+	}
+	for n := 1; ; n++ {
+		dir := filepath.Join(studyDir, fmt.Sprintf("series_%03d", n))
+		if _, err := os.Stat(dir); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create series directory: %w", err)
+		}
+		s.series[seriesUID] = dir
+		return dir, nil
+	}
+}
+
+// studyDir returns the directory of a study, which must lie inside the store
+func (s *StudyStore) studyDir(studyUID string) (string, error) {
+	dir := filepath.Join(s.baseDir, studyUID)
+	rel, err := filepath.Rel(s.baseDir, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("study directory %s is outside %s", dir, s.baseDir)
+	}
+	return dir, nil
+}
+
+// ValidateUID checks that uid is a DICOM UID: dot-separated numeric
+// components of at most 64 characters. UIDs received from peers are
+// validated before they name files or directories.
+func ValidateUID(uid string) error {
+	if uid == "" || len(uid) > 64 {
+		return fmt.Errorf("UID '%s' must have 1 to 64 characters", uid)
+	}
+	for _, component := range strings.Split(uid, ".") {
+		if component == "" {
+			return fmt.Errorf("UID '%s' has an empty component", uid)
+		}
+		for _, r := range component {
+			if r < '0' || r > '9' {
+				return fmt.Errorf("UID '%s' contains characters other than digits and dots", uid)
+			}
+		}
+	}
+	return nil
+}
+
+// nextImagePath returns a free image_NNN.dcm path, preferring the instance number
+func nextImagePath(seriesDir string, instanceNumber int) string {
+	n := instanceNumber
+	if n < 1 {
+		n = 1
+	}
+	for ; ; n++ {
+		path := filepath.Join(seriesDir, fmt.Sprintf("image_%03d.dcm", n))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+	}
+}
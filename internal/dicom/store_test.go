@@ -0,0 +1,80 @@
+package dicom
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUID(t *testing.T) {
+	tests := []struct {
+		uid   string
+		valid bool
+	}{
+		{"1.2.840.10008.1.2", true},
+		{"0", true},
+		{"1." + strings.Repeat("9", 62), true},
+		{"1." + strings.Repeat("9", 63), false},
+		{"", false},
+		{"1..2", false},
+		{"1.2.", false},
+		{"../escaped", false},
+		{"1.2/3", false},
+		{"1.2.3a", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uid, func(t *testing.T) {
+			err := ValidateUID(tt.uid)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestStudyStoreRejectsInvalidUIDs(t *testing.T) {
+	root := t.TempDir()
+	baseDir := filepath.Join(root, "studies")
+	store := NewStudyStore(baseDir, "CRGODICOM")
+
+	instance := func(studyUID, seriesUID string) []byte {
+		ds := NewDataset()
+		ds.SetString(TagSOPClassUID, "1.2.840.10008.5.1.4.1.1.7")
+		ds.SetString(TagSOPInstanceUID, "1.2.3.4")
+		ds.SetString(TagStudyInstanceUID, studyUID)
+		ds.SetString(TagSeriesInstanceUID, seriesUID)
+		data, err := EncodeDataset(ds, ImplicitVRLittleEndian)
+		assert.NoError(t, err)
+		return data
+	}
+
+	// A manifest next to the store must survive an escaping Study Instance UID
+	manifest := filepath.Join(root, "escaped", ManifestFileName)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(manifest), 0755))
+	assert.NoError(t, os.WriteFile(manifest, []byte("{}"), 0644))
+
+	for _, uids := range [][2]string{{"../escaped", "1.2.3"}, {"1.2.3", "../../escaped"}, {"..", "1.2.3"}} {
+		_, err := store.Save("1.2.840.10008.5.1.4.1.1.7", "1.2.3.4", ImplicitVRLittleEndian, instance(uids[0], uids[1]))
+		assert.ErrorContains(t, err, "invalid")
+	}
+	assert.FileExists(t, manifest)
+	assert.NoDirExists(t, filepath.Join(root, "escaped", "series_001"))
+
+	_, ok := store.Path("../escaped", "1.2.3.4")
+	assert.False(t, ok)
+	_, err := store.Instances("../escaped")
+	assert.Error(t, err)
+	_, err = store.SeriesInstances("1.2.3", "../escaped")
+	assert.Error(t, err)
+
+	path, err := store.Save("1.2.840.10008.5.1.4.1.1.7", "1.2.3.4", ImplicitVRLittleEndian, instance("1.2.3", "1.2.3.1"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.Join(baseDir, "1.2.3", "series_001", "image_001.dcm"), path)
+	}
+}
@@ -0,0 +1,228 @@
+package dicom
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/flatmapit/crgodicom/pkg/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ReadStudy reconstructs a study from the DICOM files in a study directory.
+// Series are read from the series_NNN subdirectories produced by Writer,
+// or from the files listed by the study manifest when it is up to date.
+func ReadStudy(studyDir string) (*types.Study, error) {
+	if manifest, err := ReadManifest(studyDir); err == nil {
+		study, err := readManifestStudy(studyDir, manifest)
+		if err == nil {
+			return study, nil
+		}
+		logrus.Warnf("Ignoring study manifest of %s: %v", studyDir, err)
+	}
+
+	entries, err := os.ReadDir(studyDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read study directory: %w", err)
+	}
+
+	study := &types.Study{
+		StudyInstanceUID: filepath.Base(studyDir),
+		Series:           []types.Series{},
+	}
+
+	studyPopulated := false
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "series_") {
+			continue
+		}
+
+		seriesDir := filepath.Join(studyDir, entry.Name())
+		files, err := readSeriesFiles(seriesDir)
+		if err != nil {
+			logrus.Warnf("Skipping series directory %s: %v", seriesDir, err)
+			continue
+		}
+		if len(files) == 0 {
+			continue
+		}
+
+		if !studyPopulated {
+			populateStudy(study, files[0].Dataset)
+			studyPopulated = true
+		}
+
+		series := seriesFromDataset(files[0].Dataset)
+		for _, file := range files {
+			series.Images = append(series.Images, ImageFromFile(file))
+		}
+		sort.SliceStable(series.Images, func(i, j int) bool {
+			return series.Images[i].InstanceNumber < series.Images[j].InstanceNumber
+		})
+
+		study.Series = append(study.Series, series)
+	}
+
+	if !studyPopulated {
+		return nil, fmt.Errorf("no readable DICOM files found in %s", studyDir)
+	}
+
+	return study, nil
+}
+
+// readManifestStudy reconstructs a study from the files listed by its manifest
+func readManifestStudy(studyDir string, manifest *Manifest) (*types.Study, error) {
+	if _, err := manifest.Files(studyDir); err != nil {
+		return nil, err
+	}
+
+	study := manifest.StudyInfo()
+	for _, manifestSeries := range manifest.Series {
+		series := types.Series{
+			SeriesInstanceUID: manifestSeries.SeriesInstanceUID,
+			SeriesNumber:      manifestSeries.SeriesNumber,
+			Modality:          manifestSeries.Modality,
+			SeriesDescription: manifestSeries.SeriesDescription,
+			Images:            make([]types.Image, 0, len(manifestSeries.Instances)),
+		}
+		for _, instance := range manifestSeries.Instances {
+			file, err := ReadFile(filepath.Join(studyDir, filepath.FromSlash(instance.Path)))
+			if err != nil {
+				return nil, err
+			}
+			if series.FrameOfReferenceUID == "" {
+				series.FrameOfReferenceUID = file.Dataset.String(TagFrameOfReferenceUID)
+			}
+			series.Images = append(series.Images, ImageFromFile(file))
+		}
+		sort.SliceStable(series.Images, func(i, j int) bool {
+			return series.Images[i].InstanceNumber < series.Images[j].InstanceNumber
+		})
+		study.Series = append(study.Series, series)
+	}
+
+	return study, nil
+}
+
+// ImageFromDataset builds an image from the contents of a parsed dataset.
+// Native pixel data is returned in little endian byte order; encapsulated
+// pixel data is not decoded and leaves PixelData empty; see ImageFromFile.
+func ImageFromDataset(ds *Dataset) types.Image {
+	image := types.Image{
+		SOPInstanceUID: ds.String(TagSOPInstanceUID),
+		SOPClassUID:    ds.String(TagSOPClassUID),
+		InstanceNumber: ds.Int(TagInstanceNumber),
+		Width:          ds.Int(TagColumns),
+		Height:         ds.Int(TagRows),
+		BitsPerPixel:   ds.Int(TagBitsAllocated),
+		BitsStored:     ds.Int(TagBitsStored),
+		Modality:       ds.String(TagModality),
+		Geometry:       geometryFromDataset(ds),
+	}
+	if rescale := parseDS(append(ds.Strings(TagRescaleIntercept), ds.Strings(TagRescaleSlope)...)); len(rescale) == 2 {
+		image.RescaleIntercept, image.RescaleSlope = rescale[0], rescale[1]
+	}
+	// Only the first of multiple windows is kept
+	center, width := parseDS(ds.Strings(TagWindowCenter)), parseDS(ds.Strings(TagWindowWidth))
+	if len(center) > 0 && len(width) > 0 {
+		image.WindowCenter, image.WindowWidth = center[0], width[0]
+	}
+
+	if elem := ds.Get(TagPixelData); elem != nil && elem.Value != nil {
+		pixelData := make([]byte, len(elem.Value))
+		copy(pixelData, elem.Value)
+		if ds.BigEndian && image.BitsPerPixel > 8 {
+			for i := 0; i+1 < len(pixelData); i += 2 {
+				pixelData[i], pixelData[i+1] = pixelData[i+1], pixelData[i]
+			}
+		}
+		image.PixelData = pixelData
+	}
+
+	return image
+}
+
+// ImageFromFile builds an image from a parsed file, decoding the first frame
+// of pixel data compressed with one of the encapsulated transfer syntaxes
+func ImageFromFile(file *File) types.Image {
+	image := ImageFromDataset(file.Dataset)
+
+	elem := file.Dataset.Get(TagPixelData)
+	if elem == nil || elem.Fragments == nil || !IsEncapsulatedTransferSyntax(file.TransferSyntaxUID) {
+		return image
+	}
+	frame, err := firstFrame(file.Dataset, elem)
+	if err == nil {
+		image.PixelData, err = decodeFrame(file.TransferSyntaxUID, frame, image.Width, image.Height, image.BitsPerPixel)
+	}
+	if err != nil {
+		logrus.Warnf("Failed to decode pixel data of %s: %v", image.SOPInstanceUID, err)
+	}
+	return image
+}
+
+// StudyFromFile builds a study holding the series and image of a single
+// instance, for rendering one instance without reading its whole study
+func StudyFromFile(file *File) *types.Study {
+	ds := file.Dataset
+	study := &types.Study{StudyInstanceUID: ds.String(TagStudyInstanceUID)}
+	populateStudy(study, ds)
+
+	series := seriesFromDataset(ds)
+	series.Images = append(series.Images, ImageFromFile(file))
+	study.Series = []types.Series{series}
+	return study
+}
+
+// readSeriesFiles parses every .dcm file in a series directory
+func readSeriesFiles(seriesDir string) ([]*File, error) {
+	entries, err := os.ReadDir(seriesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*File
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".dcm" {
+			continue
+		}
+
+		file, err := ReadFile(filepath.Join(seriesDir, entry.Name()))
+		if err != nil {
+			logrus.Warnf("Skipping unreadable DICOM file: %v", err)
+			continue
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// populateStudy copies patient and study level attributes from a dataset
+func populateStudy(study *types.Study, ds *Dataset) {
+	if uid := ds.String(TagStudyInstanceUID); uid != "" {
+		study.StudyInstanceUID = uid
+	}
+	study.StudyDate = ds.String(TagStudyDate)
+	study.StudyTime = ds.String(TagStudyTime)
+	study.AccessionNumber = ds.String(TagAccessionNumber)
+	study.StudyDescription = ds.String(TagStudyDescription)
+	study.PatientName = ds.String(TagPatientName)
+	study.PatientID = ds.String(TagPatientID)
+	study.PatientBirthDate = ds.String(TagPatientBirthDate)
+}
+
+// seriesFromDataset builds a series from the series level attributes of a dataset
+func seriesFromDataset(ds *Dataset) types.Series {
+	return types.Series{
+		SeriesInstanceUID: ds.String(TagSeriesInstanceUID),
+		SeriesNumber:      ds.Int(TagSeriesNumber),
+		Modality:          ds.String(TagModality),
+		SeriesDescription: ds.String(TagSeriesDescription),
+		Images:            []types.Image{},
+
+		FrameOfReferenceUID: ds.String(TagFrameOfReferenceUID),
+	}
+}
@@ -1,17 +1,54 @@
 package dicom
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/flatmapit/crgodicom/internal/config"
 	"github.com/flatmapit/crgodicom/pkg/types"
 	"github.com/sirupsen/logrus"
-	"github.com/suyashkumar/dicom"
-	"github.com/suyashkumar/dicom/pkg/tag"
 )
 
+// TransferSyntaxNames maps the names accepted by create --transfer-syntax and
+// the transfer_syntax of templates to the transfer syntaxes Writer produces
+var TransferSyntaxNames = map[string]string{
+	"implicit-le": ImplicitVRLittleEndian,
+	"explicit-le": ExplicitVRLittleEndian,
+	"explicit-be": ExplicitVRBigEndian,
+	"deflated":    DeflatedExplicitVRLittleEndian,
+
+	// Encapsulated pixel data, selected by create --compression
+	"rle":           RLELossless,
+	"jpeg-baseline": JPEGBaseline8Bit,
+	"jpeg-lossless": JPEGLosslessSV1,
+	"jpeg-ls":       JPEGLSLossless,
+}
+
+// ParseTransferSyntax resolves a transfer syntax name or UID to a transfer
+// syntax UID Writer can produce
+func ParseTransferSyntax(name string) (string, error) {
+	if uid, ok := TransferSyntaxNames[strings.ToLower(name)]; ok {
+		return uid, nil
+	}
+	for _, uid := range TransferSyntaxNames {
+		if name == uid {
+			return uid, nil
+		}
+	}
+
+	names := make([]string, 0, len(TransferSyntaxNames))
+	for n := range TransferSyntaxNames {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("unsupported transfer syntax '%s'. Valid transfer syntaxes: %v", name, names)
+}
+
 // Writer handles writing DICOM files to disk
 type Writer struct {
 	config *config.Config
@@ -24,53 +61,93 @@ func NewWriter(cfg *config.Config) *Writer {
 	}
 }
 
-// WriteStudy writes a complete study to disk
-func (w *Writer) WriteStudy(study *types.Study, outputDir string) error {
+// WriteStudy writes a complete study to disk, along with a manifest of its
+// files and the parameters it was generated with
+func (w *Writer) WriteStudy(study *types.Study, params *types.StudyParams, outputDir string) error {
 	// Create study directory
 	studyDir := filepath.Join(outputDir, study.StudyInstanceUID)
 	if err := os.MkdirAll(studyDir, 0755); err != nil {
 		return fmt.Errorf("failed to create study directory: %w", err)
 	}
 
+	transferSyntax := ImplicitVRLittleEndian
+	if params != nil && params.TransferSyntaxUID != "" {
+		transferSyntax = params.TransferSyntaxUID
+	}
+
 	logrus.Infof("Writing study %s to %s", study.StudyInstanceUID, studyDir)
 
-	// Write study metadata
-	if err := w.writeStudyMetadata(study, studyDir); err != nil {
-		return fmt.Errorf("failed to write study metadata: %w", err)
-	}
+	manifest := NewManifest(study, params)
 
 	// Write series
 	for i, series := range study.Series {
-		seriesDir := filepath.Join(studyDir, fmt.Sprintf("series_%03d", i+1))
+		seriesName := fmt.Sprintf("series_%03d", i+1)
+		seriesDir := filepath.Join(studyDir, seriesName)
 		if err := os.MkdirAll(seriesDir, 0755); err != nil {
 			return fmt.Errorf("failed to create series directory: %w", err)
 		}
 
-		if err := w.writeSeries(study, &series, seriesDir); err != nil {
+		if err := w.writeSeries(study, &series, seriesDir, transferSyntax); err != nil {
 			return fmt.Errorf("failed to write series %d: %w", i+1, err)
 		}
+
+		manifestSeries, err := w.manifestSeries(&series, studyDir, seriesName)
+		if err != nil {
+			return fmt.Errorf("failed to describe series %d: %w", i+1, err)
+		}
+		manifest.Series = append(manifest.Series, manifestSeries)
+	}
+
+	// Write study metadata
+	if err := manifest.Write(studyDir); err != nil {
+		return fmt.Errorf("failed to write study metadata: %w", err)
 	}
 
 	logrus.Infof("Successfully wrote study with %d series", len(study.Series))
 	return nil
 }
 
-// writeStudyMetadata writes study metadata to JSON file
-func (w *Writer) writeStudyMetadata(study *types.Study, studyDir string) error {
-	// TODO: Implement JSON metadata writing
-	// For now, just log the metadata
-	logrus.Infof("Study metadata: UID=%s, Patient=%s (%s), Description=%s",
-		study.StudyInstanceUID, study.PatientName, study.PatientID, study.StudyDescription)
-	return nil
+// manifestSeries describes a written series, hashing each of its image files
+func (w *Writer) manifestSeries(series *types.Series, studyDir, seriesName string) (ManifestSeries, error) {
+	result := ManifestSeries{
+		SeriesInstanceUID: series.SeriesInstanceUID,
+		SeriesNumber:      series.SeriesNumber,
+		Modality:          series.Modality,
+		SeriesDescription: series.SeriesDescription,
+		Instances:         make([]ManifestInstance, 0, len(series.Images)),
+	}
+
+	for i, image := range series.Images {
+		path := seriesName + "/" + imageFileName(i)
+		sum, size, err := hashFile(filepath.Join(studyDir, filepath.FromSlash(path)))
+		if err != nil {
+			return result, err
+		}
+		result.Instances = append(result.Instances, ManifestInstance{
+			SOPInstanceUID: image.SOPInstanceUID,
+			SOPClassUID:    image.SOPClassUID,
+			InstanceNumber: image.InstanceNumber,
+			Path:           path,
+			Size:           size,
+			SHA256:         sum,
+		})
+	}
+
+	return result, nil
+}
+
+// imageFileName returns the file name of the image at an index of a series
+func imageFileName(index int) string {
+	return fmt.Sprintf("image_%03d.dcm", index+1)
 }
 
 // writeSeries writes a series to disk
-func (w *Writer) writeSeries(study *types.Study, series *types.Series, seriesDir string) error {
+func (w *Writer) writeSeries(study *types.Study, series *types.Series, seriesDir, transferSyntax string) error {
 	logrus.Infof("Writing series %s with %d images", series.SeriesInstanceUID, len(series.Images))
 
 	for i, image := range series.Images {
-		imageFile := filepath.Join(seriesDir, fmt.Sprintf("image_%03d.dcm", i+1))
-		if err := w.writeImage(study, series, &image, imageFile); err != nil {
+		imageFile := filepath.Join(seriesDir, imageFileName(i))
+		if err := w.writeImage(study, series, &image, imageFile, transferSyntax); err != nil {
 			return fmt.Errorf("failed to write image %d: %w", i+1, err)
 		}
 	}
@@ -79,36 +156,45 @@ func (w *Writer) writeSeries(study *types.Study, series *types.Series, seriesDir
 }
 
 // writeImage writes a single DICOM image to disk
-func (w *Writer) writeImage(study *types.Study, series *types.Series, image *types.Image, filePath string) error {
-	// Create DICOM dataset
-	dataset := dicom.Dataset{
-		Elements: make([]*dicom.Element, 0),
+func (w *Writer) writeImage(study *types.Study, series *types.Series, image *types.Image, filePath, transferSyntax string) error {
+	// Reduce the bit depth to what the codec of a compressed syntax supports
+	if codec, ok := frameCodecs[transferSyntax]; ok && image.BitsPerPixel > codec.maxBits {
+		reduced := reduceBitDepth(image, codec.maxBits)
+		image = &reduced
 	}
 
-	// Add mandatory DICOM metadata elements first
-	w.addMandatoryElements(&dataset, image)
+	// Create DICOM dataset
+	dataset := NewDataset()
 
 	// Add patient information
-	w.addPatientElements(&dataset, study)
+	w.addPatientElements(dataset, study)
 
 	// Add study information
-	w.addStudyElements(&dataset, study)
+	w.addStudyElements(dataset, study)
 
 	// Add series information
-	w.addSeriesElements(&dataset, series)
+	w.addSeriesElements(dataset, series)
 
 	// Add image information
-	w.addImageElements(&dataset, image)
+	w.addImageElements(dataset, image)
 
-	// Create DICOM file
-	file, err := os.Create(filePath)
+	// Pixel data
+	if err := w.addPixelDataElements(dataset, image, transferSyntax); err != nil {
+		return err
+	}
+
+	// Encode the dataset and prefix it with the File Meta Information
+	encoded, err := EncodeDataset(dataset, transferSyntax)
 	if err != nil {
-		return fmt.Errorf("failed to create DICOM file: %w", err)
+		return fmt.Errorf("failed to encode DICOM dataset: %w", err)
+	}
+	data, err := EncodeFile(w.config.DefaultPACS.AEC, image.SOPClassUID, image.SOPInstanceUID, transferSyntax, encoded)
+	if err != nil {
+		return fmt.Errorf("failed to encode DICOM file: %w", err)
 	}
-	defer file.Close()
 
 	// Write DICOM file
-	if err := dicom.Write(file, dataset); err != nil {
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write DICOM file: %w", err)
 	}
 
@@ -116,178 +202,152 @@ func (w *Writer) writeImage(study *types.Study, series *types.Series, image *typ
 }
 
 // addPatientElements adds patient-related DICOM elements
-func (w *Writer) addPatientElements(dataset *dicom.Dataset, study *types.Study) {
-	// Patient Name (0010,0010)
-	if elem, err := dicom.NewElement(tag.PatientName, []string{study.PatientName}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
+func (w *Writer) addPatientElements(dataset *Dataset, study *types.Study) {
+	dataset.SetString(TagPatientName, study.PatientName)
+	dataset.SetString(TagPatientID, study.PatientID)
+	dataset.SetString(TagPatientBirthDate, study.PatientBirthDate)
 
-	// Patient ID (0010,0020)
-	if elem, err := dicom.NewElement(tag.PatientID, []string{study.PatientID}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// Patient Birth Date (0010,0030)
-	if elem, err := dicom.NewElement(tag.PatientBirthDate, []string{study.PatientBirthDate}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// Patient Sex (0010,0040) - Default to "O" (Other)
-	if elem, err := dicom.NewElement(tag.PatientSex, []string{"O"}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
+	// Default to "O" (Other)
+	dataset.SetString(TagPatientSex, "O")
 }
 
 // addStudyElements adds study-related DICOM elements
-func (w *Writer) addStudyElements(dataset *dicom.Dataset, study *types.Study) {
-	// Study Instance UID (0020,000D)
-	if elem, err := dicom.NewElement(tag.StudyInstanceUID, []string{study.StudyInstanceUID}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// Study Date (0008,0020)
-	if elem, err := dicom.NewElement(tag.StudyDate, []string{study.StudyDate}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
+func (w *Writer) addStudyElements(dataset *Dataset, study *types.Study) {
+	dataset.SetString(TagStudyInstanceUID, study.StudyInstanceUID)
+	dataset.SetString(TagStudyDate, study.StudyDate)
+	dataset.SetString(TagStudyTime, study.StudyTime)
+	dataset.SetString(TagStudyDescription, study.StudyDescription)
+	dataset.SetString(TagAccessionNumber, study.AccessionNumber)
 
-	// Study Time (0008,0030)
-	if elem, err := dicom.NewElement(tag.StudyTime, []string{study.StudyTime}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
+	if study.RequestedProcedureID != "" || study.ScheduledProcedureStepID != "" {
+		w.addRequestElements(dataset, study)
 	}
+}
 
-	// Study Description (0008,1030)
-	if elem, err := dicom.NewElement(tag.StudyDescription, []string{study.StudyDescription}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
+// addRequestElements adds the worklist request attributes of a study
+func (w *Writer) addRequestElements(dataset *Dataset, study *types.Study) {
+	// Station Name - the modality performing the step
+	dataset.SetString(TagStationName, study.ScheduledStationAETitle)
+	dataset.SetString(TagRequestedProcedureDescription, study.RequestedProcedureDescription)
 
-	// Accession Number (0008,0050)
-	if elem, err := dicom.NewElement(tag.AccessionNumber, []string{study.AccessionNumber}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
+	item := NewDataset()
+	item.SetString(TagScheduledProcedureStepID, study.ScheduledProcedureStepID)
+	item.SetString(TagRequestedProcedureID, study.RequestedProcedureID)
+	dataset.SetSequence(TagRequestAttributesSequence, []*Dataset{item})
 }
 
 // addSeriesElements adds series-related DICOM elements
-func (w *Writer) addSeriesElements(dataset *dicom.Dataset, series *types.Series) {
-	// Series Instance UID (0020,000E)
-	if elem, err := dicom.NewElement(tag.SeriesInstanceUID, []string{series.SeriesInstanceUID}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// Series Number (0020,0011)
-	if elem, err := dicom.NewElement(tag.SeriesNumber, []string{fmt.Sprintf("%d", series.SeriesNumber)}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// Modality (0008,0060)
-	if elem, err := dicom.NewElement(tag.Modality, []string{series.Modality}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
+func (w *Writer) addSeriesElements(dataset *Dataset, series *types.Series) {
+	dataset.SetString(TagSeriesInstanceUID, series.SeriesInstanceUID)
+	dataset.SetString(TagSeriesNumber, strconv.Itoa(series.SeriesNumber))
+	dataset.SetString(TagModality, series.Modality)
+	dataset.SetString(TagSeriesDescription, series.SeriesDescription)
 
-	// Series Description (0008,103E)
-	if elem, err := dicom.NewElement(tag.SeriesDescription, []string{series.SeriesDescription}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
+	if series.FrameOfReferenceUID != "" {
+		dataset.SetString(TagFrameOfReferenceUID, series.FrameOfReferenceUID)
+		dataset.SetString(TagPositionReferenceIndicator, "")
 	}
 }
 
 // addImageElements adds image-related DICOM elements
-func (w *Writer) addImageElements(dataset *dicom.Dataset, image *types.Image) {
-	// SOP Instance UID (0008,0018)
-	if elem, err := dicom.NewElement(tag.SOPInstanceUID, []string{image.SOPInstanceUID}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// SOP Class UID (0008,0016)
-	if elem, err := dicom.NewElement(tag.SOPClassUID, []string{image.SOPClassUID}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// Instance Number (0020,0013)
-	if elem, err := dicom.NewElement(tag.InstanceNumber, []string{fmt.Sprintf("%d", image.InstanceNumber)}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
+func (w *Writer) addImageElements(dataset *Dataset, image *types.Image) {
+	dataset.SetString(TagSOPInstanceUID, image.SOPInstanceUID)
+	dataset.SetString(TagSOPClassUID, image.SOPClassUID)
+	dataset.SetString(TagInstanceNumber, strconv.Itoa(image.InstanceNumber))
 
 	// Image dimensions
 	w.addImageDimensionElements(dataset, image)
 
-	// Pixel data
-	w.addPixelDataElements(dataset, image)
+	if image.Geometry != nil {
+		addImagePlaneElements(dataset, image.Geometry)
+	}
 }
 
 // addImageDimensionElements adds image dimension elements
-func (w *Writer) addImageDimensionElements(dataset *dicom.Dataset, image *types.Image) {
-	// Rows (0028,0010)
-	if elem, err := dicom.NewElement(tag.Rows, []int{int(image.Height)}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// Columns (0028,0011)
-	if elem, err := dicom.NewElement(tag.Columns, []int{int(image.Width)}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// Bits Allocated (0028,0100)
-	if elem, err := dicom.NewElement(tag.BitsAllocated, []int{int(image.BitsPerPixel)}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// Bits Stored (0028,0101)
-	if elem, err := dicom.NewElement(tag.BitsStored, []int{int(image.BitsPerPixel)}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// High Bit (0028,0102)
-	if elem, err := dicom.NewElement(tag.HighBit, []int{int(image.BitsPerPixel - 1)}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
+func (w *Writer) addImageDimensionElements(dataset *Dataset, image *types.Image) {
+	setUS(dataset, TagRows, image.Height)
+	setUS(dataset, TagColumns, image.Width)
+	setUS(dataset, TagBitsAllocated, image.BitsPerPixel)
+	setUS(dataset, TagBitsStored, image.SignificantBits())
+	setUS(dataset, TagHighBit, image.SignificantBits()-1)
+	setUS(dataset, TagPixelRepresentation, 0)
+	setUS(dataset, TagSamplesPerPixel, 1)
+	dataset.SetString(TagPhotometricInterpretation, "MONOCHROME2")
+	setUS(dataset, TagPlanarConfiguration, 0)
+
+	if image.RescaleSlope != 0 {
+		dataset.SetString(TagRescaleIntercept, formatDS(image.RescaleIntercept))
+		dataset.SetString(TagRescaleSlope, formatDS(image.RescaleSlope))
+		if image.Modality == "CT" {
+			dataset.SetString(TagRescaleType, "HU")
+		}
 	}
-
-	// Pixel Representation (0028,0103)
-	if elem, err := dicom.NewElement(tag.PixelRepresentation, []int{0}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
+	if image.WindowWidth > 0 {
+		dataset.SetString(TagWindowCenter, formatDS(image.WindowCenter))
+		dataset.SetString(TagWindowWidth, formatDS(image.WindowWidth))
 	}
+}
 
-	// Samples per Pixel (0028,0002)
-	if elem, err := dicom.NewElement(tag.SamplesPerPixel, []int{1}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
+// addPixelDataElements adds pixel data elements, compressing them into
+// encapsulated fragments for the compressed transfer syntaxes
+func (w *Writer) addPixelDataElements(dataset *Dataset, image *types.Image, transferSyntax string) error {
+	codec, ok := frameCodecs[transferSyntax]
+	if !ok {
+		// Generated pixel data is little endian; the encoder swaps it for big endian syntaxes
+		vr := "OW"
+		if image.BitsPerPixel <= 8 {
+			vr = "OB"
+		}
+		dataset.Set(TagPixelData, vr, image.PixelData)
+		return nil
 	}
 
-	// Photometric Interpretation (0028,0004)
-	if elem, err := dicom.NewElement(tag.PhotometricInterpretation, []string{"MONOCHROME2"}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
+	frame, err := encodeFrame(transferSyntax, image.PixelData, image.Width, image.Height, image.BitsPerPixel)
+	if err != nil {
+		return fmt.Errorf("failed to compress pixel data: %w", err)
 	}
+	dataset.Set(TagPixelData, "OB", nil)
+	dataset.Get(TagPixelData).Fragments = encapsulate([][]byte{frame})
 
-	// Planar Configuration (0028,0006)
-	if elem, err := dicom.NewElement(tag.PlanarConfiguration, []int{0}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
+	if codec.lossyMethod != "" {
+		ratio := float64(image.Width*image.Height*((image.BitsPerPixel+7)/8)) / float64(len(frame))
+		dataset.SetString(TagLossyImageCompression, "01")
+		dataset.SetString(TagLossyImageCompressionRatio, strconv.FormatFloat(ratio, 'f', 2, 64))
+		dataset.SetString(TagLossyImageCompressionMethod, codec.lossyMethod)
 	}
+	return nil
 }
 
-// addPixelDataElements adds pixel data elements
-func (w *Writer) addPixelDataElements(dataset *dicom.Dataset, image *types.Image) {
-	// Pixel Data (7FE0,0010) - pixel data should be []byte
-	if elem, err := dicom.NewElement(tag.PixelData, image.PixelData); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
+// reduceBitDepth returns a copy of a little endian image keeping the most
+// significant of its stored bits of each pixel. The rescale slope grows by
+// the dropped bits so output values are kept.
+func reduceBitDepth(image *types.Image, bits int) types.Image {
+	reduced := *image
+	reduced.BitsPerPixel = bits
+	reduced.BitsStored = min(image.SignificantBits(), bits)
+	shift := image.SignificantBits() - reduced.BitsStored
+	if reduced.RescaleSlope != 0 {
+		reduced.RescaleSlope *= float64(int(1) << shift)
+	}
+
+	from := (image.BitsPerPixel + 7) / 8
+	to := (bits + 7) / 8
+	count := min(image.Width*image.Height, len(image.PixelData)/from)
+	reduced.PixelData = make([]byte, image.Width*image.Height*to)
+	for i := 0; i < count; i++ {
+		value := uint64(0)
+		for b := from - 1; b >= 0; b-- {
+			value = value<<8 | uint64(image.PixelData[i*from+b])
+		}
+		value >>= shift
+		for b := 0; b < to; b++ {
+			reduced.PixelData[i*to+b] = byte(value >> (8 * b))
+		}
 	}
+	return reduced
 }
 
-// addMandatoryElements adds mandatory DICOM metadata elements
-func (w *Writer) addMandatoryElements(dataset *dicom.Dataset, image *types.Image) {
-	// File Meta Information Group Length (0002,0000)
-	if elem, err := dicom.NewElement(tag.FileMetaInformationGroupLength, []int{0}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// Media Storage SOP Class UID (0002,0002)
-	if elem, err := dicom.NewElement(tag.MediaStorageSOPClassUID, []string{image.SOPClassUID}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// Media Storage SOP Instance UID (0002,0003)
-	if elem, err := dicom.NewElement(tag.MediaStorageSOPInstanceUID, []string{image.SOPInstanceUID}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
-
-	// Transfer Syntax UID (0002,0010)
-	if elem, err := dicom.NewElement(tag.TransferSyntaxUID, []string{"1.2.840.10008.1.2"}); err == nil {
-		dataset.Elements = append(dataset.Elements, elem)
-	}
+// setUS sets a single unsigned short value in the little endian byte order of a dataset
+func setUS(dataset *Dataset, t Tag, value int) {
+	dataset.Set(t, "US", binary.LittleEndian.AppendUint16(nil, uint16(value)))
 }
@@ -0,0 +1,199 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// testStudy returns a CT study of two series with two 2x2 16-bit images each
+func testStudy() *types.Study {
+	study := &types.Study{
+		StudyInstanceUID: "1.2.3",
+		StudyDate:        "20250101",
+		AccessionNumber:  "ACC1",
+		PatientName:      "DOE^JANE",
+		PatientID:        "P1",
+	}
+	for s := 1; s <= 2; s++ {
+		series := types.Series{SeriesInstanceUID: fmt.Sprintf("1.2.3.%d", s), SeriesNumber: s, Modality: "CT"}
+		for i := 1; i <= 2; i++ {
+			pixels := make([]byte, 8)
+			for p := range 4 {
+				binary.LittleEndian.PutUint16(pixels[2*p:], uint16(1000*s+100*i+p))
+			}
+			series.Images = append(series.Images, types.Image{
+				SOPInstanceUID: fmt.Sprintf("%s.%d", series.SeriesInstanceUID, i),
+				SOPClassUID:    types.SOPClassUIDs["CT"],
+				InstanceNumber: i,
+				Width:          2,
+				Height:         2,
+				BitsPerPixel:   16,
+				PixelData:      pixels,
+				Modality:       "CT",
+			})
+		}
+		study.Series = append(study.Series, series)
+	}
+	return study
+}
+
+func TestWriteStudyTransferSyntaxes(t *testing.T) {
+	for name, transferSyntax := range TransferSyntaxNames {
+		t.Run(name, func(t *testing.T) {
+			study := testStudy()
+			outputDir := t.TempDir()
+			params := &types.StudyParams{TransferSyntaxUID: transferSyntax}
+			if !assert.NoError(t, NewWriter(config.DefaultConfig()).WriteStudy(study, params, outputDir)) {
+				return
+			}
+
+			file, err := ReadFile(filepath.Join(outputDir, "1.2.3", "series_002", "image_001.dcm"))
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, transferSyntax, file.TransferSyntaxUID)
+			assert.Equal(t, "CRGODICOM", file.Meta.String(TagSourceApplicationEntityTitle))
+			assert.Equal(t, "1.2.3.2.1", file.SOPInstanceUID())
+			assert.Equal(t, "DOE^JANE", file.Dataset.String(TagPatientName))
+			assert.Equal(t, 2, file.Dataset.Int(TagRows))
+			if frameCodecs[transferSyntax].lossyMethod == "" {
+				assert.Equal(t, study.Series[1].Images[0].PixelData, ImageFromFile(file).PixelData)
+			}
+
+			// The whole study reads back in the same order
+			read, err := ReadStudy(filepath.Join(outputDir, "1.2.3"))
+			if assert.NoError(t, err) && assert.Len(t, read.Series, 2) {
+				assert.Equal(t, study.Series[0].Images[1].SOPInstanceUID, read.Series[0].Images[1].SOPInstanceUID)
+			}
+		})
+	}
+}
+
+func TestWriteStudyBigEndian(t *testing.T) {
+	outputDir := t.TempDir()
+	params := &types.StudyParams{TransferSyntaxUID: ExplicitVRBigEndian}
+	if !assert.NoError(t, NewWriter(config.DefaultConfig()).WriteStudy(testStudy(), params, outputDir)) {
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "1.2.3", "series_001", "image_001.dcm"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	file, err := ParseFile(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, file.Dataset.BigEndian)
+	assert.Equal(t, []byte{0x00, 0x02}, file.Dataset.Get(TagRows).Value)
+	assert.Equal(t, []byte{0x04, 0x4C}, file.Dataset.Get(TagPixelData).Value[:2])
+}
+
+func TestWriteStudyJPEGBaseline(t *testing.T) {
+	outputDir := t.TempDir()
+	params := &types.StudyParams{TransferSyntaxUID: JPEGBaseline8Bit}
+	if !assert.NoError(t, NewWriter(config.DefaultConfig()).WriteStudy(testStudy(), params, outputDir)) {
+		return
+	}
+
+	file, err := ReadFile(filepath.Join(outputDir, "1.2.3", "series_002", "image_001.dcm"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	elem := file.Dataset.Get(TagPixelData)
+	if assert.NotNil(t, elem) {
+		assert.Len(t, elem.Fragments, 2)
+		assert.Equal(t, []byte{0, 0, 0, 0}, elem.Fragments[0])
+	}
+	assert.Equal(t, 8, file.Dataset.Int(TagBitsAllocated))
+	assert.Equal(t, "01", file.Dataset.String(TagLossyImageCompression))
+	assert.Equal(t, "ISO_10918_1", file.Dataset.String(TagLossyImageCompressionMethod))
+
+	// 16-bit pixels 2100 to 2103 keep their most significant byte
+	image := ImageFromFile(file)
+	if assert.Len(t, image.PixelData, 4) {
+		for _, p := range image.PixelData {
+			assert.InDelta(t, 8, int(p), 2)
+		}
+	}
+}
+
+func TestWriteStudyCTPixelRange(t *testing.T) {
+	cfg := config.DefaultConfig()
+	params := types.StudyParams{StudyCount: 1, SeriesCount: 1, ImageCount: 1, Modality: "CT"}
+	study, err := NewGenerator(cfg).GenerateStudy(params)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for name, transferSyntax := range TransferSyntaxNames {
+		t.Run(name, func(t *testing.T) {
+			params.TransferSyntaxUID = transferSyntax
+			outputDir := t.TempDir()
+			if !assert.NoError(t, NewWriter(cfg).WriteStudy(study, &params, outputDir)) {
+				return
+			}
+			file, err := ReadFile(filepath.Join(outputDir, study.StudyInstanceUID, "series_001", "image_001.dcm"))
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			image := ImageFromFile(file)
+			bitsStored := 12
+			if transferSyntax == JPEGBaseline8Bit {
+				bitsStored = 8
+			}
+			assert.Equal(t, bitsStored, file.Dataset.Int(TagBitsStored))
+			assert.Equal(t, bitsStored-1, file.Dataset.Int(TagHighBit))
+			assert.Equal(t, -1024.0, image.RescaleIntercept)
+			assert.Equal(t, float64(int(1)<<(12-bitsStored)), image.RescaleSlope)
+			assert.Equal(t, 40.0, image.WindowCenter)
+			assert.Equal(t, 400.0, image.WindowWidth)
+
+			// Stored values use their significant bits, and the spine reads
+			// back as bone at around 700 HU whatever the codec
+			samples := frameSamples(image.PixelData, image.Width*image.Height, image.BitsPerPixel)
+			maxValue := 0
+			for _, sample := range samples {
+				maxValue = max(maxValue, sample)
+			}
+			assert.Less(t, maxValue, 1<<bitsStored)
+			assert.Greater(t, maxValue, 1<<(bitsStored-2))
+			spine := samples[(image.Width/5+image.Height/2)*image.Width+image.Width/2]
+			assert.InDelta(t, 700, float64(spine)*image.RescaleSlope+image.RescaleIntercept, 50)
+		})
+	}
+}
+
+func TestParseTransferSyntax(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"explicit-le", ExplicitVRLittleEndian, false},
+		{"Deflated", DeflatedExplicitVRLittleEndian, false},
+		{ExplicitVRBigEndian, ExplicitVRBigEndian, false},
+		{"RLE", RLELossless, false},
+		{"jpeg", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTransferSyntax(tt.name)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package dicomweb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+)
+
+// maxResponseLength bounds the size of a response body we are willing to buffer
+const maxResponseLength = 64 * 1024 * 1024
+
+// Client is a client of a DICOMweb service (PS3.18)
+type Client struct {
+	baseURL *url.URL
+	config  config.DICOMwebConfig
+	http    *http.Client
+}
+
+// HTTPError is returned when the service answers a request with an HTTP
+// error status that does not carry a DICOM response
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       string // Start of the response body, for diagnostics
+}
+
+// Error returns the HTTP status and the start of the response body
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return "DICOMweb service returned " + e.Status
+	}
+	return fmt.Sprintf("DICOMweb service returned %s: %s", e.Status, e.Body)
+}
+
+// Temporary reports whether the request may succeed when retried later
+func (e *HTTPError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// NewClient creates a client of the DICOMweb service at cfg.URL. The timeout
+// bounds connecting and waiting for response headers, not the transfer itself.
+func NewClient(cfg config.DICOMwebConfig, timeout time.Duration) (*Client, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("DICOMweb URL is not configured")
+	}
+	baseURL, err := url.Parse(strings.TrimRight(cfg.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DICOMweb URL %q: %w", cfg.URL, err)
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("DICOMweb URL %q must use http or https", cfg.URL)
+	}
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}
+	return &Client{
+		baseURL: baseURL,
+		config:  cfg,
+		http:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// URL returns the base URL of the service
+func (c *Client) URL() string {
+	return c.baseURL.String()
+}
+
+// newRequest creates an authenticated request for a path below the base URL
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	target := c.baseURL.JoinPath(path)
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DICOMweb request: %w", err)
+	}
+
+	// A bearer token takes precedence over basic authentication
+	switch {
+	case c.config.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	case c.config.Username != "":
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+	return req, nil
+}
+
+// readBody reads a response body up to the response length limit
+func readBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseLength+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DICOMweb response: %w", err)
+	}
+	if len(body) > maxResponseLength {
+		return nil, fmt.Errorf("DICOMweb response exceeds %d bytes", maxResponseLength)
+	}
+	return body, nil
+}
+
+// newHTTPError describes an error response
+func newHTTPError(resp *http.Response, body []byte) *HTTPError {
+	text := strings.TrimSpace(string(body))
+	if len(text) > 200 {
+		text = text[:200] + "..."
+	}
+	return &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: text}
+}
@@ -0,0 +1,461 @@
+package dicomweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+)
+
+// jsonAttribute is an attribute of the DICOM JSON Model (PS3.18 Annex F)
+type jsonAttribute struct {
+	VR           string            `json:"vr"`
+	Value        []json.RawMessage `json:"Value,omitempty"`
+	InlineBinary string            `json:"InlineBinary,omitempty"`
+	BulkDataURI  string            `json:"BulkDataURI,omitempty"`
+}
+
+// xmlAttribute is a DicomAttribute of the Native DICOM Model (PS3.19 A.1)
+type xmlAttribute struct {
+	Tag          string          `xml:"tag,attr"`
+	VR           string          `xml:"vr,attr"`
+	Values       []string        `xml:"Value"`
+	PersonNames  []xmlPersonName `xml:"PersonName"`
+	Items        []xmlItem       `xml:"Item"`
+	InlineBinary string          `xml:"InlineBinary"`
+}
+
+// xmlItem is a sequence item of the Native DICOM Model
+type xmlItem struct {
+	Attributes []xmlAttribute `xml:"DicomAttribute"`
+}
+
+// xmlPersonName holds the component groups of a Native DICOM Model person name
+type xmlPersonName struct {
+	Alphabetic  *xmlNameComponents `xml:"Alphabetic"`
+	Ideographic *xmlNameComponents `xml:"Ideographic"`
+	Phonetic    *xmlNameComponents `xml:"Phonetic"`
+}
+
+// xmlNameComponents holds the components of a person name group
+type xmlNameComponents struct {
+	FamilyName string `xml:"FamilyName"`
+	GivenName  string `xml:"GivenName"`
+	MiddleName string `xml:"MiddleName"`
+	NamePrefix string `xml:"NamePrefix"`
+	NameSuffix string `xml:"NameSuffix"`
+}
+
+// DecodeJSON decodes a dataset in the DICOM JSON Model. Bulk data referenced
+// by URI is left out.
+func DecodeJSON(data []byte) (*dicom.Dataset, error) {
+	var attributes map[string]jsonAttribute
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil, fmt.Errorf("invalid DICOM JSON: %w", err)
+	}
+	return decodeJSONAttributes(attributes)
+}
+
+// DecodeJSONArray decodes an array of datasets in the DICOM JSON Model, such
+// as the matches of a search
+func DecodeJSONArray(data []byte) ([]*dicom.Dataset, error) {
+	var array []map[string]jsonAttribute
+	if err := json.Unmarshal(data, &array); err != nil {
+		return nil, fmt.Errorf("invalid DICOM JSON: %w", err)
+	}
+
+	datasets := make([]*dicom.Dataset, 0, len(array))
+	for _, attributes := range array {
+		ds, err := decodeJSONAttributes(attributes)
+		if err != nil {
+			return nil, err
+		}
+		datasets = append(datasets, ds)
+	}
+	return datasets, nil
+}
+
+// decodeJSONAttributes converts the attributes of a JSON dataset
+func decodeJSONAttributes(attributes map[string]jsonAttribute) (*dicom.Dataset, error) {
+	ds := dicom.NewDataset()
+	for key, attr := range attributes {
+		tag, err := parseTag(key)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case attr.VR == "SQ":
+			var items []*dicom.Dataset
+			for _, raw := range attr.Value {
+				var itemAttributes map[string]jsonAttribute
+				if err := json.Unmarshal(raw, &itemAttributes); err != nil {
+					return nil, fmt.Errorf("invalid item of %s: %w", tag, err)
+				}
+				item, err := decodeJSONAttributes(itemAttributes)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			ds.SetSequence(tag, items)
+		case attr.InlineBinary != "":
+			value, err := base64.StdEncoding.DecodeString(attr.InlineBinary)
+			if err != nil {
+				return nil, fmt.Errorf("invalid inline binary of %s: %w", tag, err)
+			}
+			ds.Set(tag, attr.VR, value)
+		case attr.BulkDataURI != "":
+			continue
+		default:
+			values := make([]string, len(attr.Value))
+			for i, raw := range attr.Value {
+				if values[i], err = jsonValueString(attr.VR, raw); err != nil {
+					return nil, fmt.Errorf("invalid value of %s: %w", tag, err)
+				}
+			}
+			value, err := encodeValues(attr.VR, values)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of %s: %w", tag, err)
+			}
+			ds.Set(tag, attr.VR, value)
+		}
+	}
+	return ds, nil
+}
+
+// EncodeJSON encodes a dataset in the DICOM JSON Model. Pixel data is
+// referenced by bulkDataURI when it is set and included inline otherwise.
+func EncodeJSON(ds *dicom.Dataset, bulkDataURI string) ([]byte, error) {
+	attributes, err := encodeJSONAttributes(ds, byteOrder(ds), bulkDataURI)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(attributes)
+}
+
+// encodeJSONAttributes converts the elements of a dataset to JSON attributes.
+// Group lengths are left out.
+func encodeJSONAttributes(ds *dicom.Dataset, order binary.ByteOrder, bulkDataURI string) (map[string]jsonAttribute, error) {
+	attributes := make(map[string]jsonAttribute, len(ds.Elements))
+	for _, elem := range ds.Elements {
+		if elem.Tag.Element == 0x0000 {
+			continue
+		}
+
+		attr := jsonAttribute{VR: elem.VR}
+		switch {
+		case elem.VR == "SQ" || elem.Items != nil:
+			attr.VR = "SQ"
+			for _, item := range elem.Items {
+				itemAttributes, err := encodeJSONAttributes(item, order, "")
+				if err != nil {
+					return nil, err
+				}
+				raw, err := json.Marshal(itemAttributes)
+				if err != nil {
+					return nil, err
+				}
+				attr.Value = append(attr.Value, raw)
+			}
+		case elem.Tag == dicom.TagPixelData && bulkDataURI != "":
+			attr.BulkDataURI = bulkDataURI
+		case elem.Fragments != nil:
+			// Encapsulated pixel data is only available as bulk data
+			continue
+		case isBulkVR(elem.VR):
+			if len(elem.Value) > 0 {
+				attr.InlineBinary = base64.StdEncoding.EncodeToString(elem.Value)
+			}
+		default:
+			values, err := jsonValues(elem.VR, elem.Value, order)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of %s: %w", elem.Tag, err)
+			}
+			attr.Value = values
+		}
+		attributes[fmt.Sprintf("%04X%04X", elem.Tag.Group, elem.Tag.Element)] = attr
+	}
+	return attributes, nil
+}
+
+// binaryValueSizes holds the size of a single value of the binary numeric VRs
+var binaryValueSizes = map[string]int{"US": 2, "SS": 2, "UL": 4, "SL": 4, "UV": 8, "SV": 8, "FL": 4, "FD": 8, "AT": 4}
+
+// jsonValues converts the value of an element to DICOM JSON values: numbers
+// for binary and numeric string VRs, person name objects for PN and strings
+// otherwise, with empty values as null
+func jsonValues(vr string, value []byte, order binary.ByteOrder) ([]json.RawMessage, error) {
+	var values []any
+	switch vr {
+	case "US", "SS", "UL", "SL", "UV", "SV", "FL", "FD", "AT":
+		size := binaryValueSizes[vr]
+		for i := 0; i+size <= len(value); i += size {
+			v := value[i : i+size]
+			switch vr {
+			case "US":
+				values = append(values, order.Uint16(v))
+			case "SS":
+				values = append(values, int16(order.Uint16(v)))
+			case "UL":
+				values = append(values, order.Uint32(v))
+			case "SL":
+				values = append(values, int32(order.Uint32(v)))
+			case "UV":
+				values = append(values, order.Uint64(v))
+			case "SV":
+				values = append(values, int64(order.Uint64(v)))
+			case "FL":
+				values = append(values, finiteOrNil(float64(math.Float32frombits(order.Uint32(v)))))
+			case "FD":
+				values = append(values, finiteOrNil(math.Float64frombits(order.Uint64(v))))
+			case "AT":
+				values = append(values, fmt.Sprintf("%04X%04X", order.Uint16(v), order.Uint16(v[2:])))
+			}
+		}
+	default:
+		text := strings.TrimRight(string(value), " \x00")
+		if text == "" {
+			return nil, nil
+		}
+		parts := []string{text}
+		switch vr {
+		case "LT", "ST", "UT", "UR":
+			// Single valued; backslashes are part of the text
+		default:
+			parts = strings.Split(text, "\\")
+		}
+		for _, part := range parts {
+			part = strings.TrimSpace(strings.TrimRight(part, "\x00"))
+			if part == "" {
+				values = append(values, nil)
+				continue
+			}
+			switch vr {
+			case "IS":
+				n, err := strconv.ParseInt(part, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("IS value %q: %w", part, err)
+				}
+				values = append(values, n)
+			case "DS":
+				f, err := strconv.ParseFloat(part, 64)
+				if err != nil {
+					return nil, fmt.Errorf("DS value %q: %w", part, err)
+				}
+				values = append(values, finiteOrNil(f))
+			case "PN":
+				values = append(values, personName(part))
+			default:
+				values = append(values, part)
+			}
+		}
+	}
+
+	raws := make([]json.RawMessage, len(values))
+	for i, v := range values {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		raws[i] = raw
+	}
+	return raws, nil
+}
+
+// personName splits a person name into its JSON component groups
+func personName(value string) map[string]string {
+	name := make(map[string]string)
+	for i, group := range strings.SplitN(value, "=", 3) {
+		if group != "" {
+			name[[]string{"Alphabetic", "Ideographic", "Phonetic"}[i]] = group
+		}
+	}
+	return name
+}
+
+// finiteOrNil returns f, or nil for the NaN and infinite values JSON cannot hold
+func finiteOrNil(f float64) any {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+	return f
+}
+
+// isBulkVR reports whether a VR holds binary data encoded as InlineBinary
+func isBulkVR(vr string) bool {
+	switch vr {
+	case "OB", "OD", "OF", "OL", "OV", "OW", "UN":
+		return true
+	}
+	return false
+}
+
+// byteOrder returns the byte order of the binary values of a dataset
+func byteOrder(ds *dicom.Dataset) binary.ByteOrder {
+	if ds.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// jsonValueString returns a JSON value as the text of a DICOM value: strings
+// as is, numbers in their JSON form and person names as component groups
+func jsonValueString(vr string, raw json.RawMessage) (string, error) {
+	trimmed := bytes.TrimSpace(raw)
+	switch {
+	case bytes.Equal(trimmed, []byte("null")):
+		return "", nil
+	case vr == "PN":
+		var name struct{ Alphabetic, Ideographic, Phonetic string }
+		if err := json.Unmarshal(trimmed, &name); err != nil {
+			return "", err
+		}
+		return strings.TrimRight(strings.Join([]string{name.Alphabetic, name.Ideographic, name.Phonetic}, "="), "="), nil
+	case len(trimmed) > 0 && trimmed[0] == '"':
+		var s string
+		err := json.Unmarshal(trimmed, &s)
+		return s, err
+	default:
+		return string(trimmed), nil
+	}
+}
+
+// DecodeXML decodes a dataset in the Native DICOM Model
+func DecodeXML(data []byte) (*dicom.Dataset, error) {
+	var model struct {
+		Attributes []xmlAttribute `xml:"DicomAttribute"`
+	}
+	if err := xml.Unmarshal(data, &model); err != nil {
+		return nil, fmt.Errorf("invalid Native DICOM Model XML: %w", err)
+	}
+	return decodeXMLAttributes(model.Attributes)
+}
+
+// decodeXMLAttributes converts the attributes of an XML dataset
+func decodeXMLAttributes(attributes []xmlAttribute) (*dicom.Dataset, error) {
+	ds := dicom.NewDataset()
+	for _, attr := range attributes {
+		tag, err := parseTag(attr.Tag)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case attr.VR == "SQ":
+			var items []*dicom.Dataset
+			for _, it := range attr.Items {
+				item, err := decodeXMLAttributes(it.Attributes)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			ds.SetSequence(tag, items)
+		case attr.InlineBinary != "":
+			value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(attr.InlineBinary))
+			if err != nil {
+				return nil, fmt.Errorf("invalid inline binary of %s: %w", tag, err)
+			}
+			ds.Set(tag, attr.VR, value)
+		default:
+			values := attr.Values
+			for _, name := range attr.PersonNames {
+				values = append(values, name.String())
+			}
+			value, err := encodeValues(attr.VR, values)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of %s: %w", tag, err)
+			}
+			ds.Set(tag, attr.VR, value)
+		}
+	}
+	return ds, nil
+}
+
+// String returns the person name in its DICOM form
+func (n xmlPersonName) String() string {
+	groups := make([]string, 3)
+	for i, group := range []*xmlNameComponents{n.Alphabetic, n.Ideographic, n.Phonetic} {
+		if group != nil {
+			groups[i] = strings.TrimRight(strings.Join([]string{
+				group.FamilyName, group.GivenName, group.MiddleName, group.NamePrefix, group.NameSuffix,
+			}, "^"), "^")
+		}
+	}
+	return strings.TrimRight(strings.Join(groups, "="), "=")
+}
+
+// parseTag parses a tag in the GGGGEEEE form of the DICOM JSON and XML models
+func parseTag(s string) (dicom.Tag, error) {
+	if len(s) != 8 {
+		return dicom.Tag{}, fmt.Errorf("invalid tag %q", s)
+	}
+	value, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return dicom.Tag{}, fmt.Errorf("invalid tag %q", s)
+	}
+	return dicom.Tag{Group: uint16(value >> 16), Element: uint16(value)}, nil
+}
+
+// encodeValues encodes the text of the values of an element in Little Endian
+// for its VR. String VRs are joined with backslashes and padded to an even length.
+func encodeValues(vr string, values []string) ([]byte, error) {
+	var out []byte
+	for _, v := range values {
+		var err error
+		switch vr {
+		case "US", "SS":
+			var n int64
+			n, err = strconv.ParseInt(v, 10, 32)
+			out = binary.LittleEndian.AppendUint16(out, uint16(n))
+		case "UL", "SL":
+			var n int64
+			n, err = strconv.ParseInt(v, 10, 64)
+			out = binary.LittleEndian.AppendUint32(out, uint32(n))
+		case "UV", "SV":
+			var n int64
+			n, err = strconv.ParseInt(v, 10, 64)
+			out = binary.LittleEndian.AppendUint64(out, uint64(n))
+		case "FL":
+			var f float64
+			f, err = strconv.ParseFloat(v, 32)
+			out = binary.LittleEndian.AppendUint32(out, math.Float32bits(float32(f)))
+		case "FD":
+			var f float64
+			f, err = strconv.ParseFloat(v, 64)
+			out = binary.LittleEndian.AppendUint64(out, math.Float64bits(f))
+		case "AT":
+			var tag dicom.Tag
+			tag, err = parseTag(v)
+			out = binary.LittleEndian.AppendUint16(out, tag.Group)
+			out = binary.LittleEndian.AppendUint16(out, tag.Element)
+		default:
+			return padValue(vr, strings.Join(values, "\\")), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s value %q: %w", vr, v, err)
+		}
+	}
+	return out, nil
+}
+
+// padValue pads a string value to an even length, with NUL for UIDs
+func padValue(vr, value string) []byte {
+	data := []byte(value)
+	if len(data)%2 == 1 {
+		if vr == "UI" {
+			data = append(data, 0x00)
+		} else {
+			data = append(data, ' ')
+		}
+	}
+	return data
+}
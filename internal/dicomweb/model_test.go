@@ -0,0 +1,109 @@
+package dicomweb
+
+import (
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	ds, err := DecodeJSON([]byte(`{
+		"00080005": {"vr": "CS", "Value": ["ISO_IR 192"]},
+		"00100010": {"vr": "PN", "Value": [{"Alphabetic": "DOE^JANE"}]},
+		"00201208": {"vr": "IS", "Value": [12]},
+		"00280010": {"vr": "US", "Value": [512]},
+		"00081190": {"vr": "UR", "Value": ["https://pacs/studies/1.2.3"]},
+		"00080061": {"vr": "CS", "Value": ["CT", "MR"]},
+		"00081198": {"vr": "SQ", "Value": [{
+			"00081150": {"vr": "UI", "Value": ["1.2.840.10008.5.1.4.1.1.2"]},
+			"00081155": {"vr": "UI", "Value": ["1.2.3.4"]},
+			"00081197": {"vr": "US", "Value": [42752]}
+		}]},
+		"7FE00010": {"vr": "OW", "BulkDataURI": "https://pacs/bulk/1"},
+		"00091001": {"vr": "OB", "InlineBinary": "AAEC"}
+	}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "ISO_IR 192", ds.String(dicom.TagSpecificCharacterSet))
+	assert.Equal(t, "DOE^JANE", ds.String(dicom.TagPatientName))
+	assert.Equal(t, 12, ds.Int(dicom.TagNumberOfStudyRelatedInstances))
+	assert.Equal(t, 512, ds.Int(dicom.TagRows))
+	assert.Equal(t, []string{"CT", "MR"}, ds.Strings(dicom.TagModalitiesInStudy))
+	assert.False(t, ds.Has(dicom.TagPixelData))
+	assert.Equal(t, []byte{0, 1, 2}, ds.Get(dicom.Tag{Group: 0x0009, Element: 0x1001}).Value)
+
+	failed := ds.Sequence(dicom.TagFailedSOPSequence)
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, "1.2.3.4", failed[0].String(dicom.TagReferencedSOPInstanceUID))
+		assert.Equal(t, 0xA700, failed[0].Int(dicom.TagFailureReason))
+	}
+
+	_, err = DecodeJSON([]byte(`{"0010": {"vr": "PN"}}`))
+	assert.Error(t, err)
+	_, err = DecodeJSON([]byte(`{"00280010": {"vr": "US", "Value": ["wide"]}}`))
+	assert.Error(t, err)
+}
+
+func TestDecodeXML(t *testing.T) {
+	ds, err := DecodeXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<NativeDicomModel>
+  <DicomAttribute tag="00100010" vr="PN" keyword="PatientName">
+    <PersonName number="1"><Alphabetic><FamilyName>DOE</FamilyName><GivenName>JANE</GivenName></Alphabetic></PersonName>
+  </DicomAttribute>
+  <DicomAttribute tag="00081199" vr="SQ" keyword="ReferencedSOPSequence">
+    <Item number="1">
+      <DicomAttribute tag="00081155" vr="UI" keyword="ReferencedSOPInstanceUID"><Value number="1">1.2.3.5</Value></DicomAttribute>
+      <DicomAttribute tag="00081196" vr="US" keyword="WarningReason"><Value number="1">45056</Value></DicomAttribute>
+    </Item>
+  </DicomAttribute>
+</NativeDicomModel>`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "DOE^JANE", ds.String(dicom.TagPatientName))
+	referenced := ds.Sequence(dicom.TagReferencedSOPSequence)
+	if assert.Len(t, referenced, 1) {
+		assert.Equal(t, "1.2.3.5", referenced[0].String(dicom.TagReferencedSOPInstanceUID))
+		assert.Equal(t, 0xB000, referenced[0].Int(tagWarningReason))
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	item := dicom.NewDataset()
+	item.SetString(dicom.TagReferencedSOPInstanceUID, "1.2.3.4")
+	ds := dicom.NewDataset()
+	ds.SetString(dicom.TagPatientName, "DOE^JANE=ドウ^ジェーン")
+	ds.SetString(dicom.TagModalitiesInStudy, "CT\\MR")
+	ds.SetString(dicom.TagNumberOfStudyRelatedInstances, "12")
+	ds.SetString(dicom.TagStudyDescription, "")
+	ds.Set(dicom.TagRows, "US", []byte{0x00, 0x02})
+	ds.Set(dicom.Tag{Group: 0x0028, Element: 0x1050}, "DS", []byte("40\\-1.5 "))
+	ds.Set(dicom.TagPixelData, "OW", []byte{1, 2, 3, 4})
+	ds.SetSequence(dicom.TagReferencedSOPSequence, []*dicom.Dataset{item})
+
+	data, err := EncodeJSON(ds, "http://pacs/bulk/1")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Contains(t, string(data), `"00100010":{"vr":"PN","Value":[{"Alphabetic":"DOE^JANE","Ideographic":"ドウ^ジェーン"}]}`)
+	assert.Contains(t, string(data), `"00201208":{"vr":"IS","Value":[12]}`)
+	assert.Contains(t, string(data), `"00281050":{"vr":"DS","Value":[40,-1.5]}`)
+	assert.Contains(t, string(data), `"00081030":{"vr":"LO"}`)
+	assert.Contains(t, string(data), `"7FE00010":{"vr":"OW","BulkDataURI":"http://pacs/bulk/1"}`)
+
+	decoded, err := DecodeJSON(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"CT", "MR"}, decoded.Strings(dicom.TagModalitiesInStudy))
+	assert.Equal(t, 512, decoded.Int(dicom.TagRows))
+	assert.Equal(t, "1.2.3.4", decoded.Sequence(dicom.TagReferencedSOPSequence)[0].String(dicom.TagReferencedSOPInstanceUID))
+
+	data, err = EncodeJSON(ds, "")
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"7FE00010":{"vr":"OW","InlineBinary":"AQIDBA=="}`)
+}
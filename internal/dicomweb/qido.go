@@ -0,0 +1,82 @@
+package dicomweb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+)
+
+// searchResources maps query levels to the QIDO-RS resources searched at them
+var searchResources = map[pacs.QueryLevel]string{
+	pacs.QueryLevelStudy:  "studies",
+	pacs.QueryLevelSeries: "series",
+	pacs.QueryLevelImage:  "instances",
+}
+
+// Search runs a QIDO-RS search at the given level. The identifier is
+// interpreted like a C-FIND identifier: attributes with a value are matching
+// keys and empty attributes are returned for each match.
+func (c *Client) Search(ctx context.Context, level pacs.QueryLevel, identifier *dicom.Dataset) ([]*dicom.Dataset, error) {
+	resource, ok := searchResources[level]
+	if !ok {
+		return nil, fmt.Errorf("QIDO-RS does not support the %s query level", level)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, resource, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = searchParameters(identifier).Encode()
+	req.Header.Set("Accept", "application/dicom+json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("QIDO-RS request to %s failed: %w", c.URL(), err)
+	}
+	defer resp.Body.Close()
+
+	data, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, newHTTPError(resp, data)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil, nil
+	}
+
+	matches, err := DecodeJSONArray(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QIDO-RS response: %w", err)
+	}
+	return matches, nil
+}
+
+// searchParameters converts an identifier to QIDO-RS query parameters.
+// Multiple values of a key, such as a UID list, are joined by commas.
+func searchParameters(identifier *dicom.Dataset) url.Values {
+	params := url.Values{}
+	for _, elem := range identifier.Elements {
+		if elem.VR == "SQ" {
+			continue
+		}
+		key := fmt.Sprintf("%04X%04X", elem.Tag.Group, elem.Tag.Element)
+		if value := strings.Join(identifier.Strings(elem.Tag), ","); value != "" {
+			params.Add(key, value)
+		} else {
+			params.Add("includefield", key)
+		}
+	}
+	return params
+}
@@ -0,0 +1,82 @@
+package dicomweb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	var path string
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		query = r.URL.Query()
+		assert.Equal(t, "application/dicom+json", r.Header.Get("Accept"))
+		if r.URL.Query().Get("00100020") == "none" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dicom+json")
+		fmt.Fprint(w, `[
+			{"0020000D": {"vr": "UI", "Value": ["1.2.3"]}, "00080060": {"vr": "CS", "Value": ["CT"]}},
+			{"0020000D": {"vr": "UI", "Value": ["1.2.3"]}, "00080060": {"vr": "CS", "Value": ["SR"]}}
+		]`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(config.DICOMwebConfig{URL: server.URL + "/qido"}, 5*time.Second)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		name  string
+		level pacs.QueryLevel
+		path  string
+	}{
+		{"study", pacs.QueryLevelStudy, "/qido/studies"},
+		{"series", pacs.QueryLevelSeries, "/qido/series"},
+		{"instance", pacs.QueryLevelImage, "/qido/instances"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identifier := dicom.NewDataset()
+			identifier.SetString(dicom.TagPatientID, "12345")
+			identifier.SetString(dicom.TagModality, "CT\\MR")
+			identifier.SetString(dicom.TagStudyDescription, "")
+			identifier.SetString(dicom.TagStudyInstanceUID, "")
+
+			matches, err := client.Search(context.Background(), tt.level, identifier)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.path, path)
+			assert.Equal(t, []string{"12345"}, query["00100020"])
+			assert.Equal(t, []string{"CT,MR"}, query["00080060"])
+			assert.ElementsMatch(t, []string{"00081030", "0020000D"}, query["includefield"])
+			if assert.Len(t, matches, 2) {
+				assert.Equal(t, "1.2.3", matches[0].String(dicom.TagStudyInstanceUID))
+				assert.Equal(t, "SR", matches[1].String(dicom.TagModality))
+			}
+		})
+	}
+
+	identifier := dicom.NewDataset()
+	identifier.SetString(dicom.TagPatientID, "none")
+	matches, err := client.Search(context.Background(), pacs.QueryLevelStudy, identifier)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+
+	_, err = client.Search(context.Background(), pacs.QueryLevelPatient, identifier)
+	assert.Error(t, err)
+}
@@ -0,0 +1,677 @@
+package dicomweb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/export"
+	"github.com/sirupsen/logrus"
+)
+
+// Failure reasons of the Store Instances Response (PS3.18 Table I.2-1)
+const (
+	failureProcessing       = 0x0110
+	failureCannotUnderstand = 0xC000
+)
+
+// searchKeywords maps the keywords accepted as QIDO-RS query keys to their tags
+var searchKeywords = map[string]dicom.Tag{
+	"SpecificCharacterSet":           dicom.TagSpecificCharacterSet,
+	"StudyDate":                      dicom.TagStudyDate,
+	"StudyTime":                      dicom.TagStudyTime,
+	"AccessionNumber":                dicom.TagAccessionNumber,
+	"Modality":                       dicom.TagModality,
+	"ModalitiesInStudy":              dicom.TagModalitiesInStudy,
+	"InstitutionName":                dicom.TagInstitutionName,
+	"ReferringPhysicianName":         dicom.TagReferringPhysician,
+	"StudyDescription":               dicom.TagStudyDescription,
+	"SeriesDescription":              dicom.TagSeriesDescription,
+	"PatientName":                    dicom.TagPatientName,
+	"PatientID":                      dicom.TagPatientID,
+	"PatientBirthDate":               dicom.TagPatientBirthDate,
+	"PatientSex":                     dicom.TagPatientSex,
+	"StudyInstanceUID":               dicom.TagStudyInstanceUID,
+	"SeriesInstanceUID":              dicom.TagSeriesInstanceUID,
+	"SOPClassUID":                    dicom.TagSOPClassUID,
+	"SOPInstanceUID":                 dicom.TagSOPInstanceUID,
+	"StudyID":                        dicom.TagStudyID,
+	"SeriesNumber":                   dicom.TagSeriesNumber,
+	"InstanceNumber":                 dicom.TagInstanceNumber,
+	"NumberOfStudyRelatedSeries":     dicom.TagNumberOfStudyRelatedSeries,
+	"NumberOfStudyRelatedInstances":  dicom.TagNumberOfStudyRelatedInstances,
+	"NumberOfSeriesRelatedInstances": dicom.TagNumberOfSeriesRelatedInstances,
+	"Rows":                           dicom.TagRows,
+	"Columns":                        dicom.TagColumns,
+	"BitsAllocated":                  dicom.TagBitsAllocated,
+	"NumberOfFrames":                 tagNumberOfFrames,
+}
+
+// Attributes returned by default for each search level (PS3.18 Table 10.6.3-3)
+var (
+	studyReturnKeys = []dicom.Tag{
+		dicom.TagSpecificCharacterSet, dicom.TagStudyDate, dicom.TagStudyTime, dicom.TagAccessionNumber,
+		dicom.TagReferringPhysician, dicom.TagPatientName, dicom.TagPatientID, dicom.TagPatientBirthDate,
+		dicom.TagPatientSex, dicom.TagStudyInstanceUID, dicom.TagStudyID, dicom.TagStudyDescription,
+	}
+	seriesReturnKeys = []dicom.Tag{
+		dicom.TagModality, dicom.TagSeriesDescription, dicom.TagSeriesInstanceUID, dicom.TagSeriesNumber,
+	}
+	instanceReturnKeys = []dicom.Tag{
+		dicom.TagSOPClassUID, dicom.TagSOPInstanceUID, dicom.TagInstanceNumber,
+		dicom.TagRows, dicom.TagColumns, dicom.TagBitsAllocated, tagNumberOfFrames,
+	}
+)
+
+// tagNumberOfFrames is the Number of Frames (0028,0008) of multi-frame images
+var tagNumberOfFrames = dicom.Tag{Group: 0x0028, Element: 0x0008}
+
+// DefaultMaxInstanceSize bounds the size of a single STOW-RS instance
+// unless SetMaxInstanceSize changes it
+const DefaultMaxInstanceSize = 64 * 1024 * 1024
+
+// Server serves the instances of a study store over QIDO-RS, WADO-RS and
+// STOW-RS (PS3.18). Rendered instances use the PNG rendering of export.
+type Server struct {
+	store       *dicom.StudyStore
+	prefix      string
+	allowOrigin string
+	exporter    *export.Exporter
+	mux         *http.ServeMux
+
+	maxInstanceSize int64
+}
+
+// NewServer creates a server for the store with its resources below prefix,
+// such as "/dicom-web"
+func NewServer(store *dicom.StudyStore, prefix string) *Server {
+	s := &Server{
+		store:    store,
+		prefix:   strings.TrimRight(prefix, "/"),
+		exporter: export.NewExporter(""),
+		mux:      http.NewServeMux(),
+
+		maxInstanceSize: DefaultMaxInstanceSize,
+	}
+
+	routes := map[string]http.HandlerFunc{
+		"GET /studies":                                                                 s.search("STUDY"),
+		"GET /series":                                                                  s.search("SERIES"),
+		"GET /instances":                                                               s.search("IMAGE"),
+		"GET /studies/{study}/series":                                                  s.search("SERIES"),
+		"GET /studies/{study}/instances":                                               s.search("IMAGE"),
+		"GET /studies/{study}/series/{series}/instances":                               s.search("IMAGE"),
+		"GET /studies/{study}":                                                         s.retrieve,
+		"GET /studies/{study}/series/{series}":                                         s.retrieve,
+		"GET /studies/{study}/series/{series}/instances/{instance}":                    s.retrieve,
+		"GET /studies/{study}/metadata":                                                s.metadata,
+		"GET /studies/{study}/series/{series}/metadata":                                s.metadata,
+		"GET /studies/{study}/series/{series}/instances/{instance}/metadata":           s.metadata,
+		"GET /studies/{study}/series/{series}/instances/{instance}/frames/{frames}":    s.frames,
+		"GET /studies/{study}/series/{series}/instances/{instance}/bulkdata/pixeldata": s.frames,
+		"GET /studies/{study}/series/{series}/instances/{instance}/rendered":           s.rendered,
+		"POST /studies":                                                                s.storeInstances,
+		"POST /studies/{study}":                                                        s.storeInstances,
+	}
+	for pattern, handler := range routes {
+		method, path, _ := strings.Cut(pattern, " ")
+		s.mux.HandleFunc(method+" "+s.prefix+path, validUIDs(handler))
+	}
+	return s
+}
+
+// SetAllowOrigin allows browser applications served from origin, or from any
+// origin with "*", to use the server
+func (s *Server) SetAllowOrigin(origin string) {
+	s.allowOrigin = origin
+}
+
+// SetMaxInstanceSize bounds the size in bytes of a single STOW-RS instance
+func (s *Server) SetMaxInstanceSize(size int64) {
+	s.maxInstanceSize = size
+}
+
+// validUIDs rejects requests whose study, series or instance path values
+// are not UIDs before they reach the store
+func validUIDs(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"study", "series", "instance"} {
+			if value := r.PathValue(name); value != "" {
+				if err := dicom.ValidateUID(value); err != nil {
+					http.Error(w, fmt.Sprintf("invalid %s UID: %v", name, err), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// ServeHTTP serves a request, answering CORS preflight requests itself
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logrus.Debugf("%s %s", r.Method, r.URL)
+	if s.allowOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", s.allowOrigin)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// baseURL returns the URL of the service root as seen by the client
+func (s *Server) baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + s.prefix
+}
+
+// retrieveURL returns the WADO-RS URL of a study, series or instance
+func (s *Server) retrieveURL(r *http.Request, studyUID, seriesUID, instanceUID string) string {
+	url := s.baseURL(r) + "/studies/" + studyUID
+	if seriesUID != "" {
+		url += "/series/" + seriesUID
+		if instanceUID != "" {
+			url += "/instances/" + instanceUID
+		}
+	}
+	return url
+}
+
+// search answers a QIDO-RS search at a query level from the store
+func (s *Server) search(level string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identifier, limit, offset, err := searchIdentifier(level, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		matches, err := s.store.Find(level, identifier)
+		if err != nil {
+			s.serverError(w, err)
+			return
+		}
+		if offset < len(matches) {
+			matches = matches[offset:]
+		} else {
+			matches = nil
+		}
+		if limit > 0 && limit < len(matches) {
+			matches = matches[:limit]
+		}
+		if len(matches) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		for _, match := range matches {
+			url := s.retrieveURL(r, match.String(dicom.TagStudyInstanceUID), match.String(dicom.TagSeriesInstanceUID), match.String(dicom.TagSOPInstanceUID))
+			match.Set(tagRetrieveURL, "UR", padValue("UR", url))
+		}
+		array, err := encodeDatasets(matches, nil)
+		if err != nil {
+			s.serverError(w, err)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, array)
+	}
+}
+
+// searchIdentifier builds the C-FIND style identifier of a QIDO-RS search
+// from its path and query parameters
+func searchIdentifier(level string, r *http.Request) (*dicom.Dataset, int, int, error) {
+	identifier := dicom.NewDataset()
+	keys := append([]dicom.Tag{dicom.TagStudyInstanceUID}, studyReturnKeys...)
+	switch level {
+	case "STUDY":
+		keys = append(keys, dicom.TagModalitiesInStudy, dicom.TagNumberOfStudyRelatedSeries, dicom.TagNumberOfStudyRelatedInstances)
+	case "SERIES":
+		keys = append(keys, seriesReturnKeys...)
+		keys = append(keys, dicom.TagNumberOfSeriesRelatedInstances)
+	case "IMAGE":
+		keys = append(append(keys, seriesReturnKeys...), instanceReturnKeys...)
+	}
+	for _, tag := range keys {
+		identifier.SetString(tag, "")
+	}
+
+	var limit, offset int
+	for name, values := range r.URL.Query() {
+		var err error
+		switch name {
+		case "limit":
+			limit, err = strconv.Atoi(values[0])
+		case "offset":
+			offset, err = strconv.Atoi(values[0])
+		case "fuzzymatching":
+		case "includefield":
+			for _, field := range strings.Split(strings.Join(values, ","), ",") {
+				if field == "all" {
+					continue
+				}
+				var tag dicom.Tag
+				if tag, err = parseAttribute(field); err == nil && !identifier.Has(tag) {
+					identifier.SetString(tag, "")
+				}
+			}
+		default:
+			var tag dicom.Tag
+			if tag, err = parseAttribute(name); err == nil {
+				// Lists of UIDs and modalities are comma separated in QIDO-RS
+				identifier.SetString(tag, strings.ReplaceAll(strings.Join(values, ","), ",", "\\"))
+			}
+		}
+		if err != nil || limit < 0 || offset < 0 {
+			return nil, 0, 0, fmt.Errorf("invalid query parameter %s", name)
+		}
+	}
+
+	if study := r.PathValue("study"); study != "" {
+		identifier.SetString(dicom.TagStudyInstanceUID, study)
+	}
+	if series := r.PathValue("series"); series != "" {
+		identifier.SetString(dicom.TagSeriesInstanceUID, series)
+	}
+	return identifier, limit, offset, nil
+}
+
+// parseAttribute parses a QIDO-RS attribute given as a keyword or GGGGEEEE tag
+func parseAttribute(name string) (dicom.Tag, error) {
+	if tag, ok := searchKeywords[name]; ok {
+		return tag, nil
+	}
+	return parseTag(name)
+}
+
+// instancePaths returns the files of the study, series or instance of a
+// request path, in path order
+func (s *Server) instancePaths(r *http.Request) ([]string, error) {
+	study, series, instance := r.PathValue("study"), r.PathValue("series"), r.PathValue("instance")
+
+	var instances map[string]string
+	var err error
+	if series != "" {
+		instances, err = s.store.SeriesInstances(study, series)
+	} else {
+		instances, err = s.store.Instances(study)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for uid, path := range instances {
+		if instance == "" || uid == instance {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// retrieve answers a WADO-RS retrieve of instances with their Part 10 files
+// in the transfer syntax they are stored in
+func (s *Server) retrieve(w http.ResponseWriter, r *http.Request) {
+	paths, err := s.instancePaths(r)
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	if len(paths) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	parts := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", fmt.Sprintf("multipart/related; type=%q; boundary=%s", "application/dicom", parts.Boundary()))
+	for _, path := range paths {
+		if err := writeFilePart(parts, path); err != nil {
+			// The response has started, so the client sees a truncated body
+			logrus.Errorf("WADO-RS retrieve of %s failed: %v", path, err)
+			return
+		}
+	}
+	parts.Close()
+}
+
+// writeFilePart writes a Part 10 file as an application/dicom part
+func writeFilePart(parts *multipart.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	part, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/dicom"}})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	return err
+}
+
+// metadata answers a WADO-RS metadata request with the DICOM JSON of each
+// instance, referencing pixel data as bulk data
+func (s *Server) metadata(w http.ResponseWriter, r *http.Request) {
+	paths, err := s.instancePaths(r)
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	if len(paths) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	datasets := make([]*dicom.Dataset, 0, len(paths))
+	for _, path := range paths {
+		file, err := dicom.ReadFile(path)
+		if err != nil {
+			s.serverError(w, err)
+			return
+		}
+		datasets = append(datasets, file.Dataset)
+	}
+	array, err := encodeDatasets(datasets, func(ds *dicom.Dataset) string {
+		url := s.retrieveURL(r, ds.String(dicom.TagStudyInstanceUID), ds.String(dicom.TagSeriesInstanceUID), ds.String(dicom.TagSOPInstanceUID))
+		return url + "/bulkdata/pixeldata"
+	})
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, array)
+}
+
+// frames answers a WADO-RS frames request, or the pixel data bulk data of an
+// instance, with one application/octet-stream part per frame
+func (s *Server) frames(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.readInstance(w, r)
+	if !ok {
+		return
+	}
+
+	frames, err := splitFrames(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+
+	var numbers []int
+	if list := r.PathValue("frames"); list != "" {
+		for _, field := range strings.Split(list, ",") {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 1 || n > len(frames) {
+				http.Error(w, fmt.Sprintf("invalid frame number %q", field), http.StatusNotFound)
+				return
+			}
+			numbers = append(numbers, n)
+		}
+	} else {
+		for n := 1; n <= len(frames); n++ {
+			numbers = append(numbers, n)
+		}
+	}
+
+	contentType := "application/octet-stream"
+	if !dicom.IsNativeTransferSyntax(file.TransferSyntaxUID) {
+		contentType += "; transfer-syntax=" + file.TransferSyntaxUID
+	}
+	parts := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", fmt.Sprintf("multipart/related; type=%q; boundary=%s", "application/octet-stream", parts.Boundary()))
+	for _, n := range numbers {
+		part, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+		if err != nil {
+			return
+		}
+		if _, err := part.Write(frames[n-1]); err != nil {
+			return
+		}
+	}
+	parts.Close()
+}
+
+// splitFrames returns the frames of the pixel data of an instance. Native
+// frames are returned in little endian byte order; encapsulated frames are
+// returned as stored, one fragment per frame.
+func splitFrames(file *dicom.File) ([][]byte, error) {
+	ds := file.Dataset
+	elem := ds.Get(dicom.TagPixelData)
+	if elem == nil {
+		return nil, fmt.Errorf("instance has no pixel data")
+	}
+
+	count := max(ds.Int(tagNumberOfFrames), 1)
+	if elem.Fragments != nil {
+		if len(elem.Fragments)-1 != count {
+			return nil, fmt.Errorf("encapsulated pixel data with %d fragments for %d frames is not supported", len(elem.Fragments)-1, count)
+		}
+		return elem.Fragments[1:], nil
+	}
+
+	samples := max(ds.Int(dicom.TagSamplesPerPixel), 1)
+	size := ds.Int(dicom.TagRows) * ds.Int(dicom.TagColumns) * samples * ds.Int(dicom.TagBitsAllocated) / 8
+	if size <= 0 || size*count > len(elem.Value) {
+		return nil, fmt.Errorf("pixel data is shorter than %d frames of %d bytes", count, size)
+	}
+
+	pixels := elem.Value
+	if ds.BigEndian && ds.Int(dicom.TagBitsAllocated) == 16 {
+		pixels = make([]byte, len(elem.Value))
+		for i := 0; i+1 < len(pixels); i += 2 {
+			binary.LittleEndian.PutUint16(pixels[i:], binary.BigEndian.Uint16(elem.Value[i:]))
+		}
+	}
+
+	frames := make([][]byte, count)
+	for i := range frames {
+		frames[i] = pixels[i*size : (i+1)*size]
+	}
+	return frames, nil
+}
+
+// rendered answers a WADO-RS rendered request with the PNG export renders
+func (s *Server) rendered(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.readInstance(w, r)
+	if !ok {
+		return
+	}
+
+	study := dicom.StudyFromFile(file)
+	series := &study.Series[0]
+	image := &series.Images[0]
+	if image.Width == 0 || image.Height == 0 || len(image.PixelData) == 0 {
+		http.Error(w, "instance has no native pixel data to render", http.StatusNotAcceptable)
+		return
+	}
+
+	total := image.InstanceNumber
+	if instances, err := s.store.SeriesInstances(r.PathValue("study"), r.PathValue("series")); err == nil {
+		total = max(total, len(instances))
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	if err := s.exporter.RenderPNG(w, study, series, image, image.InstanceNumber, total); err != nil {
+		logrus.Errorf("Rendering %s failed: %v", image.SOPInstanceUID, err)
+	}
+}
+
+// readInstance reads the instance of a request path, answering the request
+// itself when the instance cannot be read
+func (s *Server) readInstance(w http.ResponseWriter, r *http.Request) (*dicom.File, bool) {
+	paths, err := s.instancePaths(r)
+	if err != nil {
+		s.serverError(w, err)
+		return nil, false
+	}
+	if len(paths) == 0 {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	file, err := dicom.ReadFile(paths[0])
+	if err != nil {
+		s.serverError(w, err)
+		return nil, false
+	}
+	return file, true
+}
+
+// storeInstances answers a STOW-RS request, saving each application/dicom
+// part in the store
+func (s *Server) storeInstances(w http.ResponseWriter, r *http.Request) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" || params["boundary"] == "" {
+		http.Error(w, "expected a multipart/related request", http.StatusUnsupportedMediaType)
+		return
+	}
+	if params["type"] != "" && params["type"] != "application/dicom" {
+		http.Error(w, "only application/dicom instances are accepted", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	studyUID := r.PathValue("study")
+	var referenced, failed []*dicom.Dataset
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid multipart request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, s.maxInstanceSize+1))
+		if err != nil {
+			http.Error(w, "failed to read request", http.StatusBadRequest)
+			return
+		}
+		if int64(len(data)) > s.maxInstanceSize {
+			http.Error(w, fmt.Sprintf("instance exceeds %d bytes", s.maxInstanceSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		item, stored := s.storeInstance(r, data, studyUID)
+		if stored {
+			referenced = append(referenced, item)
+		} else {
+			failed = append(failed, item)
+		}
+	}
+
+	if len(referenced) == 0 && len(failed) == 0 {
+		http.Error(w, "request holds no instances", http.StatusBadRequest)
+		return
+	}
+
+	response := dicom.NewDataset()
+	if studyUID != "" {
+		response.Set(tagRetrieveURL, "UR", padValue("UR", s.retrieveURL(r, studyUID, "", "")))
+	}
+	if len(failed) > 0 {
+		response.SetSequence(dicom.TagFailedSOPSequence, failed)
+	}
+	if len(referenced) > 0 {
+		response.SetSequence(dicom.TagReferencedSOPSequence, referenced)
+	}
+
+	status := http.StatusOK
+	switch {
+	case len(referenced) == 0:
+		status = http.StatusConflict
+	case len(failed) > 0:
+		status = http.StatusAccepted
+	}
+	attributes, err := encodeJSONAttributes(response, binary.LittleEndian, "")
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	s.writeJSON(w, status, attributes)
+}
+
+// storeInstance saves one Part 10 file and returns its item of the
+// Referenced or Failed SOP Sequence
+func (s *Server) storeInstance(r *http.Request, data []byte, studyUID string) (*dicom.Dataset, bool) {
+	item := dicom.NewDataset()
+	fail := func(reason uint16, format string, args ...any) (*dicom.Dataset, bool) {
+		logrus.Warnf("STOW-RS instance rejected: "+format, args...)
+		item.Set(dicom.TagFailureReason, "US", binary.LittleEndian.AppendUint16(nil, reason))
+		return item, false
+	}
+
+	file, err := dicom.ParseFile(data)
+	if err != nil {
+		return fail(failureCannotUnderstand, "%v", err)
+	}
+	sopClassUID, sopInstanceUID := file.SOPClassUID(), file.SOPInstanceUID()
+	item.SetString(dicom.TagReferencedSOPClassUID, sopClassUID)
+	item.SetString(dicom.TagReferencedSOPInstanceUID, sopInstanceUID)
+
+	instanceStudyUID := file.Dataset.String(dicom.TagStudyInstanceUID)
+	if studyUID != "" && instanceStudyUID != studyUID {
+		return fail(failureProcessing, "%s belongs to study %s, not %s", sopInstanceUID, instanceStudyUID, studyUID)
+	}
+	path, err := s.store.Save(sopClassUID, sopInstanceUID, file.TransferSyntaxUID, file.RawDataset)
+	if err != nil {
+		return fail(failureProcessing, "%s: %v", sopInstanceUID, err)
+	}
+	logrus.Infof("Stored %s", path)
+
+	url := s.retrieveURL(r, instanceStudyUID, file.Dataset.String(dicom.TagSeriesInstanceUID), sopInstanceUID)
+	item.Set(tagRetrieveURL, "UR", padValue("UR", url))
+	return item, true
+}
+
+// encodeDatasets converts datasets to DICOM JSON. bulkDataURI, when set,
+// returns the URI referencing the pixel data of each dataset.
+func encodeDatasets(datasets []*dicom.Dataset, bulkDataURI func(*dicom.Dataset) string) ([]map[string]jsonAttribute, error) {
+	array := make([]map[string]jsonAttribute, 0, len(datasets))
+	for _, ds := range datasets {
+		uri := ""
+		if bulkDataURI != nil {
+			uri = bulkDataURI(ds)
+		}
+		attributes, err := encodeJSONAttributes(ds, byteOrder(ds), uri)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, attributes)
+	}
+	return array, nil
+}
+
+// writeJSON writes a DICOM JSON response body
+func (s *Server) writeJSON(w http.ResponseWriter, status int, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dicom+json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// serverError logs an internal error and answers the request with it
+func (s *Server) serverError(w http.ResponseWriter, err error) {
+	logrus.Errorf("DICOMweb request failed: %v", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,296 @@
+package dicomweb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/flatmapit/crgodicom/internal/pacs"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeImage writes a 4x2 16-bit CT image of a series and returns its path
+func writeImage(t *testing.T, dir, studyUID, seriesUID string, instanceNumber int) string {
+	sop := fmt.Sprintf("%s.%d", seriesUID, instanceNumber)
+	ds := dicom.NewDataset()
+	ds.SetString(dicom.TagSOPClassUID, pacs.SOPClassCTImageStorage)
+	ds.SetString(dicom.TagSOPInstanceUID, sop)
+	ds.SetString(dicom.TagModality, "CT")
+	ds.SetString(dicom.TagPatientName, "DOE^JANE")
+	ds.SetString(dicom.TagPatientID, "P1")
+	ds.SetString(dicom.TagStudyInstanceUID, studyUID)
+	ds.SetString(dicom.TagSeriesInstanceUID, seriesUID)
+	ds.SetString(dicom.TagInstanceNumber, fmt.Sprint(instanceNumber))
+	ds.Set(dicom.TagSamplesPerPixel, "US", []byte{1, 0})
+	ds.Set(dicom.TagRows, "US", []byte{2, 0})
+	ds.Set(dicom.TagColumns, "US", []byte{4, 0})
+	ds.Set(dicom.TagBitsAllocated, "US", []byte{16, 0})
+	pixels := make([]byte, 16)
+	for i := range 8 {
+		binary.LittleEndian.PutUint16(pixels[2*i:], uint16(i*1000))
+	}
+	ds.Set(dicom.TagPixelData, "OW", pixels)
+
+	encoded, err := dicom.EncodeDataset(ds, dicom.ExplicitVRLittleEndian)
+	assert.NoError(t, err)
+	data, err := dicom.EncodeFile("CRGODICOM", pacs.SOPClassCTImageStorage, sop, dicom.ExplicitVRLittleEndian, encoded)
+	assert.NoError(t, err)
+
+	path := filepath.Join(dir, sop+".dcm")
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+// startServer serves a study store below /dicom-web and returns a client of it
+func startServer(t *testing.T, dir string) (*Client, string) {
+	server := httptest.NewServer(NewServer(dicom.NewStudyStore(dir, "CRGODICOM"), "/dicom-web"))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(config.DICOMwebConfig{URL: server.URL + "/dicom-web"}, 5*time.Second)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return client, server.URL + "/dicom-web"
+}
+
+// readMultipart returns the parts of a multipart/related response
+func readMultipart(t *testing.T, resp *http.Response) [][]byte {
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+
+	var parts [][]byte
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return parts
+		}
+		data, _ := io.ReadAll(part)
+		parts = append(parts, data)
+	}
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	source := t.TempDir()
+	files := []string{
+		writeImage(t, source, "1.2.3", "1.2.3.1", 1),
+		writeImage(t, source, "1.2.3", "1.2.3.1", 2),
+		writeImage(t, source, "1.2.3", "1.2.3.2", 1),
+	}
+	client, base := startServer(t, t.TempDir())
+	ctx := context.Background()
+
+	// STOW-RS
+	stored, err := client.Store(ctx, files)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusOK, stored.StatusCode)
+	if assert.Len(t, stored.Referenced, 3) {
+		assert.Equal(t, base+"/studies/1.2.3/series/1.2.3.1/instances/1.2.3.1.1", stored.Referenced[0].RetrieveURL)
+	}
+
+	// QIDO-RS
+	identifier := dicom.NewDataset()
+	identifier.SetString(dicom.TagPatientName, "doe*")
+	identifier.SetString(dicom.TagModalitiesInStudy, "")
+	studies, err := client.Search(ctx, pacs.QueryLevelStudy, identifier)
+	if assert.NoError(t, err) && assert.Len(t, studies, 1) {
+		assert.Equal(t, "1.2.3", studies[0].String(dicom.TagStudyInstanceUID))
+		assert.Equal(t, "CT", studies[0].String(dicom.TagModalitiesInStudy))
+		assert.Equal(t, 3, studies[0].Int(dicom.TagNumberOfStudyRelatedInstances))
+	}
+
+	identifier = dicom.NewDataset()
+	identifier.SetString(dicom.TagSeriesInstanceUID, "1.2.3.1")
+	instances, err := client.Search(ctx, pacs.QueryLevelImage, identifier)
+	if assert.NoError(t, err) && assert.Len(t, instances, 2) {
+		assert.Equal(t, 4, instances[0].Int(dicom.TagColumns))
+	}
+
+	identifier = dicom.NewDataset()
+	identifier.SetString(dicom.TagPatientID, "nobody")
+	none, err := client.Search(ctx, pacs.QueryLevelStudy, identifier)
+	assert.NoError(t, err)
+	assert.Empty(t, none)
+
+	// WADO-RS
+	var received []string
+	count, err := client.Retrieve(ctx, "1.2.3", "1.2.3.1", func(file *dicom.File) error {
+		received = append(received, file.SOPInstanceUID())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{"1.2.3.1.1", "1.2.3.1.2"}, received)
+
+	resp, err := http.Get(base + "/studies/1.2.3/series/1.2.3.2/metadata")
+	if assert.NoError(t, err) {
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		var metadata []map[string]jsonAttribute
+		if assert.NoError(t, json.Unmarshal(data, &metadata)) && assert.Len(t, metadata, 1) {
+			assert.Equal(t, base+"/studies/1.2.3/series/1.2.3.2/instances/1.2.3.2.1/bulkdata/pixeldata", metadata[0]["7FE00010"].BulkDataURI)
+			assert.Equal(t, `{"Alphabetic":"DOE^JANE"}`, string(metadata[0]["00100010"].Value[0]))
+			assert.Equal(t, "2", string(metadata[0]["00280010"].Value[0]))
+		}
+	}
+
+	resp, err = http.Get(base + "/studies/1.2.3/series/1.2.3.1/instances/1.2.3.1.2/frames/1")
+	if assert.NoError(t, err) {
+		frames := readMultipart(t, resp)
+		resp.Body.Close()
+		if assert.Len(t, frames, 1) {
+			assert.Len(t, frames[0], 16)
+			assert.Equal(t, uint16(7000), binary.LittleEndian.Uint16(frames[0][14:]))
+		}
+	}
+
+	resp, err = http.Get(base + "/studies/1.2.3/series/1.2.3.1/instances/1.2.3.1.1/rendered")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+		img, err := png.Decode(resp.Body)
+		resp.Body.Close()
+		if assert.NoError(t, err) {
+			assert.Equal(t, 4, img.Bounds().Dx())
+		}
+	}
+
+	resp, err = http.Get(base + "/studies/9.9.9")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestServerStoreRejectsOtherStudies(t *testing.T) {
+	source := t.TempDir()
+	files := []string{writeImage(t, source, "1.2.3", "1.2.3.1", 1), writeImage(t, source, "1.2.4", "1.2.4.1", 1)}
+	_, base := startServer(t, t.TempDir())
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		part, _ := parts.CreatePart(map[string][]string{"Content-Type": {"application/dicom"}})
+		part.Write(data)
+	}
+	part, _ := parts.CreatePart(map[string][]string{"Content-Type": {"application/dicom"}})
+	part.Write([]byte("not DICOM"))
+	parts.Close()
+
+	resp, err := http.Post(base+"/studies/1.2.3", `multipart/related; type="application/dicom"; boundary=`+parts.Boundary(), &body)
+	if !assert.NoError(t, err) {
+		return
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	ds, err := DecodeJSON(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, base+"/studies/1.2.3", ds.String(tagRetrieveURL))
+	assert.Len(t, ds.Sequence(dicom.TagReferencedSOPSequence), 1)
+	failed := ds.Sequence(dicom.TagFailedSOPSequence)
+	if assert.Len(t, failed, 2) {
+		assert.Equal(t, "1.2.4.1.1", failed[0].String(dicom.TagReferencedSOPInstanceUID))
+		assert.Equal(t, failureProcessing, failed[0].Int(dicom.TagFailureReason))
+		assert.Equal(t, failureCannotUnderstand, failed[1].Int(dicom.TagFailureReason))
+	}
+
+	resp, err = http.Post(base+"/studies", "application/json", strings.NewReader("{}"))
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	}
+}
+
+func TestServerCORS(t *testing.T) {
+	handler := NewServer(dicom.NewStudyStore(t.TempDir(), "CRGODICOM"), "/dicom-web")
+	handler.SetAllowOrigin("http://localhost:3000")
+
+	req := httptest.NewRequest(http.MethodOptions, "/dicom-web/studies", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "http://localhost:3000", rec.Header().Get("Access-Control-Allow-Origin"))
+
+	req = httptest.NewRequest(http.MethodGet, "/dicom-web/studies?limit=x", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Without an allowed origin the server sends no CORS headers
+	handler.SetAllowOrigin("")
+	req = httptest.NewRequest(http.MethodGet, "/dicom-web/studies", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestServerRejectsInvalidUIDs(t *testing.T) {
+	handler := NewServer(dicom.NewStudyStore(t.TempDir(), "CRGODICOM"), "/dicom-web")
+
+	for _, target := range []string{
+		"/dicom-web/studies/..%2Fescaped",
+		"/dicom-web/studies/1.2.3/series/..%2F..%2Fescaped/metadata",
+		"/dicom-web/studies/1.2.3/series/1.2.3.1/instances/x/rendered",
+		"/dicom-web/studies/1.2.3a/instances",
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/dicom-web/studies/..%2Fescaped", strings.NewReader(""))
+	req.Header.Set("Content-Type", "multipart/related; boundary=x")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServerStoreLimitsInstanceSize(t *testing.T) {
+	source := t.TempDir()
+	file := writeImage(t, source, "1.2.3", "1.2.3.1", 1)
+	data, err := os.ReadFile(file)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	store := func(maxSize int64) int {
+		handler := NewServer(dicom.NewStudyStore(t.TempDir(), "CRGODICOM"), "/dicom-web")
+		handler.SetMaxInstanceSize(maxSize)
+
+		var body bytes.Buffer
+		parts := multipart.NewWriter(&body)
+		part, _ := parts.CreatePart(map[string][]string{"Content-Type": {"application/dicom"}})
+		part.Write(data)
+		parts.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/dicom-web/studies", &body)
+		req.Header.Set("Content-Type", `multipart/related; type="application/dicom"; boundary=`+parts.Boundary())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, store(int64(len(data))))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, store(int64(len(data)-1)))
+}
@@ -0,0 +1,137 @@
+package dicomweb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+)
+
+// Store Instances Response Module attributes (PS3.18 10.5.3)
+var (
+	tagRetrieveURL    = dicom.Tag{Group: 0x0008, Element: 0x1190}
+	tagWarningReason  = dicom.Tag{Group: 0x0008, Element: 0x1196}
+	tagOtherFailedSOP = dicom.Tag{Group: 0x0008, Element: 0x119A}
+)
+
+// StoreResponse is the Store Instances Response of a STOW-RS request
+type StoreResponse struct {
+	StatusCode  int // HTTP status: 200 all stored, 202 some failed or warned, 409 none stored
+	RetrieveURL string
+	Referenced  []StoreInstance // Instances stored, possibly with a warning reason
+	Failed      []StoreInstance // Instances not stored, with their failure reason
+}
+
+// StoreInstance is an item of the Referenced or Failed SOP Sequence
+type StoreInstance struct {
+	SOPClassUID    string
+	SOPInstanceUID string
+	Reason         uint16 // Warning Reason of a referenced instance or Failure Reason of a failed one
+	RetrieveURL    string
+}
+
+// Store sends Part 10 files in one STOW-RS request to the studies resource,
+// streaming them from disk as a multipart/related application/dicom body.
+// A response listing failed instances is returned without an error.
+func (c *Client) Store(ctx context.Context, files []string) (*StoreResponse, error) {
+	body, writer := io.Pipe()
+	parts := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeParts(parts, files))
+	}()
+
+	req, err := c.newRequest(ctx, http.MethodPost, "studies", body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", fmt.Sprintf("multipart/related; type=%q; boundary=%s", "application/dicom", parts.Boundary()))
+	req.Header.Set("Accept", "application/dicom+json, application/dicom+xml;q=0.9")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("STOW-RS request to %s failed: %w", c.URL(), err)
+	}
+	defer resp.Body.Close()
+
+	data, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusConflict:
+	default:
+		return nil, newHTTPError(resp, data)
+	}
+
+	response := &StoreResponse{StatusCode: resp.StatusCode}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		if resp.StatusCode == http.StatusConflict {
+			return nil, newHTTPError(resp, data)
+		}
+		return response, nil
+	}
+
+	ds, err := decodeResponse(resp.Header.Get("Content-Type"), data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STOW-RS response: %w", err)
+	}
+	response.RetrieveURL = ds.String(tagRetrieveURL)
+	for _, item := range ds.Sequence(dicom.TagReferencedSOPSequence) {
+		response.Referenced = append(response.Referenced, storeInstance(item, tagWarningReason))
+	}
+	for _, item := range ds.Sequence(dicom.TagFailedSOPSequence) {
+		response.Failed = append(response.Failed, storeInstance(item, dicom.TagFailureReason))
+	}
+	for _, item := range ds.Sequence(tagOtherFailedSOP) {
+		response.Failed = append(response.Failed, storeInstance(item, dicom.TagFailureReason))
+	}
+	return response, nil
+}
+
+// writeParts writes each file as an application/dicom part
+func writeParts(parts *multipart.Writer, files []string) error {
+	for _, path := range files {
+		part, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/dicom"}})
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to stream %s: %w", path, err)
+		}
+	}
+	return parts.Close()
+}
+
+// decodeResponse decodes a DICOM JSON or XML response body by its media type
+func decodeResponse(contentType string, data []byte) (*dicom.Dataset, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if strings.HasSuffix(mediaType, "xml") {
+		return DecodeXML(data)
+	}
+	return DecodeJSON(data)
+}
+
+// storeInstance reads an item of a Store Instances Response sequence
+func storeInstance(item *dicom.Dataset, reason dicom.Tag) StoreInstance {
+	return StoreInstance{
+		SOPClassUID:    item.String(dicom.TagReferencedSOPClassUID),
+		SOPInstanceUID: item.String(dicom.TagReferencedSOPInstanceUID),
+		Reason:         uint16(item.Int(reason)),
+		RetrieveURL:    item.String(tagRetrieveURL),
+	}
+}
@@ -0,0 +1,158 @@
+package dicomweb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeInstance writes a minimal CT instance and returns its path
+func writeInstance(t *testing.T, dir, sopInstanceUID string) string {
+	ds := dicom.NewDataset()
+	ds.SetString(dicom.TagSOPClassUID, "1.2.840.10008.5.1.4.1.1.2")
+	ds.SetString(dicom.TagSOPInstanceUID, sopInstanceUID)
+	encoded, err := dicom.EncodeDataset(ds, dicom.ExplicitVRLittleEndian)
+	assert.NoError(t, err)
+	data, err := dicom.EncodeFile("CRGODICOM", "1.2.840.10008.5.1.4.1.1.2", sopInstanceUID, dicom.ExplicitVRLittleEndian, encoded)
+	assert.NoError(t, err)
+
+	path := filepath.Join(dir, sopInstanceUID+".dcm")
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+// readParts returns the parts of a multipart/related application/dicom request
+func readParts(t *testing.T, r *http.Request) [][]byte {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/related", mediaType)
+	assert.Equal(t, "application/dicom", params["type"])
+
+	var parts [][]byte
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if !assert.NoError(t, err) {
+			return parts
+		}
+		assert.Equal(t, "application/dicom", part.Header.Get("Content-Type"))
+		data, _ := io.ReadAll(part)
+		parts = append(parts, data)
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{writeInstance(t, dir, "1.2.3.1"), writeInstance(t, dir, "1.2.3.2")}
+
+	var received [][]byte
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/dicom-web/studies", r.URL.Path)
+		authorization = r.Header.Get("Authorization")
+		received = readParts(t, r)
+
+		w.Header().Set("Content-Type", "application/dicom+json")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{
+			"00081199": {"vr": "SQ", "Value": [{
+				"00081155": {"vr": "UI", "Value": ["1.2.3.1"]},
+				"00081190": {"vr": "UR", "Value": ["http://pacs/studies/1/series/1/instances/1.2.3.1"]}
+			}]},
+			"00081198": {"vr": "SQ", "Value": [{
+				"00081155": {"vr": "UI", "Value": ["1.2.3.2"]},
+				"00081197": {"vr": "US", "Value": [272]}
+			}]}
+		}`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		cfg           config.DICOMwebConfig
+		authorization string
+	}{
+		{"bearer token", config.DICOMwebConfig{Token: "secret-token", Username: "ignored"}, "Bearer secret-token"},
+		{"basic auth", config.DICOMwebConfig{Username: "tech", Password: "pw"}, "Basic dGVjaDpwdw=="},
+		{"anonymous", config.DICOMwebConfig{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.URL = server.URL + "/dicom-web/"
+			client, err := NewClient(tt.cfg, 5*time.Second)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			response, err := client.Store(context.Background(), files)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.authorization, authorization)
+			if assert.Len(t, received, 2) {
+				original, _ := os.ReadFile(files[1])
+				assert.True(t, bytes.Equal(original, received[1]))
+			}
+
+			assert.Equal(t, http.StatusAccepted, response.StatusCode)
+			assert.Equal(t, []StoreInstance{{SOPInstanceUID: "1.2.3.1", RetrieveURL: "http://pacs/studies/1/series/1/instances/1.2.3.1"}}, response.Referenced)
+			assert.Equal(t, []StoreInstance{{SOPInstanceUID: "1.2.3.2", Reason: 0x0110}}, response.Failed)
+		})
+	}
+}
+
+func TestStoreErrors(t *testing.T) {
+	files := []string{writeInstance(t, t.TempDir(), "1.2.3.1")}
+
+	status := http.StatusUnauthorized
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, "token expired", status)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(config.DICOMwebConfig{URL: server.URL}, time.Second)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = client.Store(context.Background(), files)
+	var httpErr *HTTPError
+	if assert.ErrorAs(t, err, &httpErr) {
+		assert.Equal(t, http.StatusUnauthorized, httpErr.StatusCode)
+		assert.Equal(t, "token expired", httpErr.Body)
+		assert.False(t, httpErr.Temporary())
+	}
+
+	status = http.StatusServiceUnavailable
+	_, err = client.Store(context.Background(), files)
+	if assert.ErrorAs(t, err, &httpErr) {
+		assert.True(t, httpErr.Temporary())
+	}
+
+	_, err = client.Store(context.Background(), []string{"missing.dcm"})
+	assert.Error(t, err)
+
+	_, err = NewClient(config.DICOMwebConfig{URL: "ftp://pacs"}, time.Second)
+	assert.Error(t, err)
+	_, err = NewClient(config.DICOMwebConfig{}, time.Second)
+	assert.Error(t, err)
+}
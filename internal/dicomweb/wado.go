@@ -0,0 +1,77 @@
+package dicomweb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+)
+
+// maxInstanceLength bounds the size of a single retrieved instance
+const maxInstanceLength = 1024 * 1024 * 1024
+
+// Retrieve retrieves the instances of a study, or of one of its series when
+// seriesUID is set, with WADO-RS. Instances are requested in the transfer
+// syntax they are stored in and passed to handle as they arrive; the number
+// of instances received is returned.
+func (c *Client) Retrieve(ctx context.Context, studyUID, seriesUID string, handle func(*dicom.File) error) (int, error) {
+	path := "studies/" + studyUID
+	if seriesUID != "" {
+		path += "/series/" + seriesUID
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", `multipart/related; type="application/dicom"; transfer-syntax=*`)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("WADO-RS request to %s failed: %w", c.URL(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		data, _ := readBody(resp)
+		return 0, newHTTPError(resp, data)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" || params["boundary"] == "" {
+		return 0, fmt.Errorf("unexpected WADO-RS response type %q", resp.Header.Get("Content-Type"))
+	}
+
+	received := 0
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return received, nil
+		}
+		if err != nil {
+			return received, fmt.Errorf("failed to read WADO-RS response: %w", err)
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, maxInstanceLength+1))
+		if err != nil {
+			return received, fmt.Errorf("failed to read WADO-RS response: %w", err)
+		}
+		if len(data) > maxInstanceLength {
+			return received, fmt.Errorf("WADO-RS instance exceeds %d bytes", maxInstanceLength)
+		}
+
+		file, err := dicom.ParseFile(data)
+		if err != nil {
+			return received, fmt.Errorf("invalid instance in WADO-RS response: %w", err)
+		}
+		received++
+		if err := handle(file); err != nil {
+			return received, err
+		}
+	}
+}
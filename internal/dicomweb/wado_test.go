@@ -0,0 +1,62 @@
+package dicomweb
+
+import (
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetrieve(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{writeInstance(t, dir, "1.2.3.1"), writeInstance(t, dir, "1.2.3.2")}
+
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		assert.Contains(t, r.Header.Get("Accept"), `type="application/dicom"`)
+		if r.URL.Path == "/wado/studies/9.9" {
+			http.NotFound(w, r)
+			return
+		}
+
+		parts := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", `multipart/related; type="application/dicom"; boundary=`+parts.Boundary())
+		for _, file := range files {
+			data, _ := os.ReadFile(file)
+			part, _ := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/dicom"}})
+			part.Write(data)
+		}
+		parts.Close()
+	}))
+	defer server.Close()
+
+	client, err := NewClient(config.DICOMwebConfig{URL: server.URL + "/wado"}, 5*time.Second)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var received []string
+	count, err := client.Retrieve(context.Background(), "1.2.3", "1.2.3.4", func(file *dicom.File) error {
+		received = append(received, file.SOPInstanceUID())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "/wado/studies/1.2.3/series/1.2.3.4", path)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{"1.2.3.1", "1.2.3.2"}, received)
+
+	_, err = client.Retrieve(context.Background(), "9.9", "", func(*dicom.File) error { return nil })
+	var httpErr *HTTPError
+	if assert.ErrorAs(t, err, &httpErr) {
+		assert.Equal(t, http.StatusNotFound, httpErr.StatusCode)
+	}
+}
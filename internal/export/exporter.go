@@ -6,6 +6,8 @@ import (
 	"image/color"
 	"image/draw"
 	"image/png"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -90,6 +92,22 @@ func (e *Exporter) exportSeries(study *types.Study, series *types.Series, export
 
 // exportImageToPNG exports a DICOM image to PNG format with burnt-in metadata
 func (e *Exporter) exportImageToPNG(study *types.Study, series *types.Series, img *types.Image, instanceNum, totalInstances int, outputPath string) error {
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create PNG file: %w", err)
+	}
+	defer file.Close()
+	
+	if err := e.RenderPNG(file, study, series, img, instanceNum, totalInstances); err != nil {
+		return err
+	}
+	
+	logrus.Debugf("Exported image to %s", outputPath)
+	return nil
+}
+
+// RenderPNG renders a DICOM image with burnt-in metadata as a PNG
+func (e *Exporter) RenderPNG(w io.Writer, study *types.Study, series *types.Series, img *types.Image, instanceNum, totalInstances int) error {
 	// Create grayscale image from pixel data
 	grayImage := image.NewGray(image.Rect(0, 0, img.Width, img.Height))
 	
@@ -104,15 +122,12 @@ func (e *Exporter) exportImageToPNG(study *types.Study, series *types.Series, im
 			idx := (y*img.Width + x) * bytesPerPixel
 			
 			if idx+bytesPerPixel <= len(img.PixelData) {
-				var pixelValue uint8
+				value := int(img.PixelData[idx])
 				if bytesPerPixel == 2 {
-					// 16-bit to 8-bit conversion
-					pixelValue = uint8(img.PixelData[idx] >> 8)
-				} else {
-					pixelValue = img.PixelData[idx]
+					value |= int(img.PixelData[idx+1]) << 8
 				}
 				
-				grayImage.SetGray(x, y, color.Gray{Y: pixelValue})
+				grayImage.SetGray(x, y, color.Gray{Y: displayValue(img, value)})
 			}
 		}
 	}
@@ -122,19 +137,29 @@ func (e *Exporter) exportImageToPNG(study *types.Study, series *types.Series, im
 		return fmt.Errorf("failed to add burnt-in text: %w", err)
 	}
 	
-	// Save as PNG
-	file, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create PNG file: %w", err)
+	if err := png.Encode(w, grayImage); err != nil {
+		return fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return nil
+}
+
+// displayValue maps a stored pixel value to a gray level, through the
+// window of the image when it has one and otherwise keeping the most
+// significant of its stored bits
+func displayValue(img *types.Image, value int) uint8 {
+	if img.WindowWidth <= 0 {
+		return uint8(value >> max(img.SignificantBits()-8, 0))
 	}
-	defer file.Close()
 	
-	if err := png.Encode(file, grayImage); err != nil {
-		return fmt.Errorf("failed to encode PNG: %w", err)
+	slope := img.RescaleSlope
+	if slope == 0 {
+		slope = 1
 	}
+	output := float64(value)*slope + img.RescaleIntercept
 	
-	logrus.Debugf("Exported image to %s", outputPath)
-	return nil
+	// Linear VOI LUT function (PS3.3 C.11.2.1.2.1)
+	level := ((output-(img.WindowCenter-0.5))/max(img.WindowWidth-1, 1) + 0.5) * 255
+	return uint8(min(max(math.Round(level), 0), 255))
 }
 
 // addBurntInText adds metadata text to the top-left corner of the image
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/flatmapit/crgodicom/internal/dicom"
 	"github.com/flatmapit/crgodicom/internal/orm"
 	"github.com/sirupsen/logrus"
 )
@@ -33,6 +34,32 @@ type PACSStudy struct {
 	NumberOfSeriesRelatedInstances string `json:"number_of_series_related_instances"`
 }
 
+// PACSStudyFromDataset builds a PACS study from a study level C-FIND identifier
+func PACSStudyFromDataset(ds *dicom.Dataset) PACSStudy {
+	modality := ds.String(dicom.TagModality)
+	if modalities := ds.Strings(dicom.TagModalitiesInStudy); modality == "" && len(modalities) > 0 {
+		modality = modalities[0]
+	}
+
+	return PACSStudy{
+		StudyInstanceUID:               ds.String(dicom.TagStudyInstanceUID),
+		StudyDate:                      ds.String(dicom.TagStudyDate),
+		StudyTime:                      ds.String(dicom.TagStudyTime),
+		StudyDescription:               ds.String(dicom.TagStudyDescription),
+		AccessionNumber:                ds.String(dicom.TagAccessionNumber),
+		PatientName:                    ds.String(dicom.TagPatientName),
+		PatientID:                      ds.String(dicom.TagPatientID),
+		PatientBirthDate:               ds.String(dicom.TagPatientBirthDate),
+		PatientSex:                     ds.String(dicom.TagPatientSex),
+		Modality:                       modality,
+		InstitutionName:                ds.String(dicom.TagInstitutionName),
+		ReferringPhysician:             ds.String(dicom.TagReferringPhysician),
+		StudyID:                        ds.String(dicom.TagStudyID),
+		NumberOfStudyRelatedInstances:  ds.String(dicom.TagNumberOfStudyRelatedInstances),
+		NumberOfSeriesRelatedInstances: ds.String(dicom.TagNumberOfSeriesRelatedInstances),
+	}
+}
+
 // PACSParser implements the Parser interface for PACS CFIND responses
 type PACSParser struct {
 	dcmtkPath string
@@ -84,6 +111,25 @@ func (p *PACSParser) queryPACSByStudyUID(studyUID string) ([]orm.ModelDefinition
 	return nil, fmt.Errorf("PACS connection parameters required for Study UID queries - use the pacs-cfind command")
 }
 
+// ConvertStudies converts PACS studies, such as native C-FIND results, to ORM models
+func (p *PACSParser) ConvertStudies(studies []PACSStudy) ([]orm.ModelDefinition, error) {
+	return p.convertPACSStudiesToModels(studies)
+}
+
+// QueryWithFindSCU queries a PACS by Study Instance UID using DCMTK findscu
+func (p *PACSParser) QueryWithFindSCU(host string, port int, aec, aet, studyUID string, verbose bool) ([]orm.ModelDefinition, error) {
+	output, err := p.runFindSCU(host, port, aec, aet, studyUID, verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	studies, err := p.parseFindSCUOutput(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse findscu output: %w", err)
+	}
+	return p.convertPACSStudiesToModels(studies)
+}
+
 // parseCFINDResponse parses a CFIND response
 func (p *PACSParser) parseCFINDResponse(data []byte) ([]orm.ModelDefinition, error) {
 	logrus.Infof("Parsing CFIND response data")
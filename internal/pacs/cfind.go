@@ -0,0 +1,213 @@
+package pacs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/sirupsen/logrus"
+)
+
+// QueryLevel is the Query/Retrieve Level (0008,0052) of a query
+type QueryLevel string
+
+// Query/Retrieve levels
+const (
+	QueryLevelPatient QueryLevel = "PATIENT"
+	QueryLevelStudy   QueryLevel = "STUDY"
+	QueryLevelSeries  QueryLevel = "SERIES"
+	QueryLevelImage   QueryLevel = "IMAGE"
+)
+
+// QueryModel identifies a Query/Retrieve information model
+type QueryModel int
+
+// Query/Retrieve information models
+const (
+	StudyRoot QueryModel = iota
+	PatientRoot
+)
+
+// FindResult holds a single C-FIND match, or the error that ended the query
+type FindResult struct {
+	Dataset *dicom.Dataset
+	Err     error
+}
+
+// ParseQueryLevel parses a query level name such as "study" or "IMAGE"
+func ParseQueryLevel(level string) (QueryLevel, error) {
+	switch QueryLevel(strings.ToUpper(level)) {
+	case QueryLevelPatient:
+		return QueryLevelPatient, nil
+	case QueryLevelStudy:
+		return QueryLevelStudy, nil
+	case QueryLevelSeries:
+		return QueryLevelSeries, nil
+	case QueryLevelImage, "INSTANCE":
+		return QueryLevelImage, nil
+	}
+	return "", fmt.Errorf("invalid query level: %s (valid: PATIENT, STUDY, SERIES, IMAGE)", level)
+}
+
+// String returns the name of the information model
+func (m QueryModel) String() string {
+	if m == PatientRoot {
+		return "Patient Root"
+	}
+	return "Study Root"
+}
+
+// findSOPClass returns the C-FIND SOP class of the information model
+func (m QueryModel) findSOPClass() string {
+	if m == PatientRoot {
+		return SOPClassPatientRootQueryRetrieveFind
+	}
+	return SOPClassStudyRootQueryRetrieveFind
+}
+
+// CFind performs a C-FIND query at the given level, using the Study Root model
+// or the Patient Root model for PATIENT level queries
+func (c *Client) CFind(ctx context.Context, level QueryLevel, identifier *dicom.Dataset) <-chan FindResult {
+	model := StudyRoot
+	if level == QueryLevelPatient {
+		model = PatientRoot
+	}
+	return c.CFindWithModel(ctx, model, level, identifier)
+}
+
+// CFindWithModel performs a C-FIND query using the given information model.
+// Matches are streamed on the returned channel as pending responses arrive;
+// the channel is closed once the query completes. A failed query delivers a
+// final result with Err set, also when ctx is cancelled, so the channel must
+// be drained. Cancelling ctx sends a C-CANCEL to the peer.
+// The client must not be used for other requests until the channel is closed.
+func (c *Client) CFindWithModel(ctx context.Context, model QueryModel, level QueryLevel, identifier *dicom.Dataset) <-chan FindResult {
+	results := make(chan FindResult)
+
+	go func() {
+		defer close(results)
+
+		err := c.cfind(ctx, model, level, identifier, func(ds *dicom.Dataset) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			select {
+			case results <- FindResult{Dataset: ds}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil {
+			// Never dropped, or a cancelled query would look complete
+			results <- FindResult{Err: fmt.Errorf("C-FIND failed: %w", err)}
+		}
+	}()
+
+	return results
+}
+
+// cfind sends a C-FIND request and passes each matching identifier to handle
+func (c *Client) cfind(ctx context.Context, model QueryModel, level QueryLevel, identifier *dicom.Dataset, handle func(*dicom.Dataset) error) error {
+	if !c.associated {
+		return fmt.Errorf("not associated with PACS server")
+	}
+	if model == StudyRoot && level == QueryLevelPatient {
+		return fmt.Errorf("the Study Root model does not support PATIENT level queries")
+	}
+
+	query := identifier.Clone()
+	query.SetString(dicom.TagQueryRetrieveLevel, string(level))
+
+	logrus.Infof("Sending C-FIND request (%s, level %s)", model, level)
+	return c.find(ctx, model.findSOPClass(), query, handle)
+}
+
+// find sends a C-FIND request of a SOP class and passes each matching identifier to handle
+func (c *Client) find(ctx context.Context, sopClass string, query *dicom.Dataset, handle func(*dicom.Dataset) error) error {
+	if !c.associated {
+		return fmt.Errorf("not associated with PACS server")
+	}
+
+	pc, err := c.selectPresentationContext(sopClass, ExplicitVRLittleEndian)
+	if err != nil {
+		return err
+	}
+
+	data, err := dicom.EncodeDataset(query, pc.TransferSyntax)
+	if err != nil {
+		return fmt.Errorf("failed to encode identifier: %w", err)
+	}
+
+	cmd := &DIMSECommand{
+		CommandField:     CommandCFindRQ,
+		MessageID:        c.nextMessageID(),
+		AffectedSOPClass: sopClass,
+		DataSetType:      DataSetPresent,
+	}
+	if err := c.sendMessage(ctx, pc.ID, cmd, data); err != nil {
+		return err
+	}
+
+	matches := 0
+	for {
+		rsp, err := c.receiveMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read C-FIND response: %w", err)
+		}
+		if rsp.Command.CommandField != CommandCFindRSP {
+			return fmt.Errorf("unexpected DIMSE command 0x%04X in response", rsp.Command.CommandField)
+		}
+
+		switch status := rsp.Command.Status; status {
+		case StatusPending, StatusPendingWarning:
+			if !rsp.Command.HasDataSet() {
+				continue
+			}
+			ds, err := dicom.ParseDataset(rsp.Data, pc.TransferSyntax)
+			if err != nil {
+				return fmt.Errorf("invalid C-FIND identifier: %w", err)
+			}
+			matches++
+			if err := handle(ds); err != nil {
+				c.cancelFind(pc.ID, cmd.MessageID)
+				return err
+			}
+		case StatusSuccess:
+			logrus.Infof("C-FIND completed with %d matches", matches)
+			return nil
+		case StatusCancel:
+			return fmt.Errorf("query cancelled by peer")
+		default:
+			return fmt.Errorf("DIMSE status 0x%04X", status)
+		}
+	}
+}
+
+// cancelFind sends a C-CANCEL for an outstanding C-FIND and discards the
+// remaining responses so the association can be reused
+func (c *Client) cancelFind(contextID uint8, messageID uint16) {
+	logrus.Info("Cancelling C-FIND request")
+
+	cmd := &DIMSECommand{
+		CommandField:              CommandCCancelRQ,
+		MessageIDBeingRespondedTo: messageID,
+		DataSetType:               DataSetAbsent,
+	}
+	if err := c.sendMessage(context.Background(), contextID, cmd, nil); err != nil {
+		logrus.Warnf("Failed to send C-CANCEL: %v", err)
+		return
+	}
+
+	for {
+		rsp, err := c.receiveMessage(context.Background())
+		if err != nil {
+			logrus.Warnf("Failed to read C-FIND response after C-CANCEL: %v", err)
+			return
+		}
+		if status := rsp.Command.Status; status != StatusPending && status != StatusPendingWarning {
+			return
+		}
+	}
+}
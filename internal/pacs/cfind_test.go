@@ -0,0 +1,177 @@
+package pacs
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPipeClient returns a client associated over an in-memory connection
+// with a single accepted presentation context
+func newPipeClient(abstractSyntax, transferSyntax string) (*Client, net.Conn) {
+	clientConn, peerConn := net.Pipe()
+
+	client := NewClient(&config.PACSConfig{Timeout: 5})
+	client.conn = clientConn
+	client.associated = true
+	client.contexts = []*PresentationContext{{
+		ID:             1,
+		AbstractSyntax: abstractSyntax,
+		TransferSyntax: transferSyntax,
+	}}
+	return client, peerConn
+}
+
+func TestCFindStreamsPendingResponses(t *testing.T) {
+	client, peer := newPipeClient(SOPClassStudyRootQueryRetrieveFind, dicom.ImplicitVRLittleEndian)
+	defer peer.Close()
+
+	peerErr := make(chan error, 1)
+	go func() {
+		req, err := readMessage(peer)
+		if err != nil {
+			peerErr <- err
+			return
+		}
+
+		identifier, err := dicom.ParseDataset(req.Data, dicom.ImplicitVRLittleEndian)
+		if err != nil {
+			peerErr <- err
+			return
+		}
+		if identifier.String(dicom.TagQueryRetrieveLevel) != "STUDY" {
+			peerErr <- assert.AnError
+			return
+		}
+
+		for _, uid := range []string{"1.2.3", "1.2.4"} {
+			match := dicom.NewDataset()
+			match.SetString(dicom.TagQueryRetrieveLevel, "STUDY")
+			match.SetString(dicom.TagStudyInstanceUID, uid)
+			data, _ := dicom.EncodeDataset(match, dicom.ImplicitVRLittleEndian)
+
+			rsp := &DIMSECommand{
+				CommandField:              CommandCFindRSP,
+				MessageIDBeingRespondedTo: req.Command.MessageID,
+				AffectedSOPClass:          SOPClassStudyRootQueryRetrieveFind,
+				DataSetType:               DataSetPresent,
+				Status:                    StatusPending,
+			}
+			if err := writeMessage(peer, req.ContextID, rsp, data, 0); err != nil {
+				peerErr <- err
+				return
+			}
+		}
+
+		final := &DIMSECommand{
+			CommandField:              CommandCFindRSP,
+			MessageIDBeingRespondedTo: req.Command.MessageID,
+			AffectedSOPClass:          SOPClassStudyRootQueryRetrieveFind,
+			DataSetType:               DataSetAbsent,
+			Status:                    StatusSuccess,
+		}
+		peerErr <- writeMessage(peer, req.ContextID, final, nil, 0)
+	}()
+
+	identifier := dicom.NewDataset()
+	identifier.SetString(dicom.TagStudyInstanceUID, "")
+	identifier.SetString(dicom.TagPatientID, "12345")
+
+	var uids []string
+	for result := range client.CFind(context.Background(), QueryLevelStudy, identifier) {
+		if !assert.NoError(t, result.Err) {
+			continue
+		}
+		uids = append(uids, result.Dataset.String(dicom.TagStudyInstanceUID))
+	}
+
+	assert.NoError(t, <-peerErr)
+	assert.Equal(t, []string{"1.2.3", "1.2.4"}, uids)
+
+	// The caller's identifier is not modified
+	assert.False(t, identifier.Has(dicom.TagQueryRetrieveLevel))
+}
+
+func TestCFindRejectsPatientLevelInStudyRoot(t *testing.T) {
+	client, peer := newPipeClient(SOPClassStudyRootQueryRetrieveFind, dicom.ImplicitVRLittleEndian)
+	defer peer.Close()
+
+	results := client.CFindWithModel(context.Background(), StudyRoot, QueryLevelPatient, dicom.NewDataset())
+	result := <-results
+	assert.Error(t, result.Err)
+}
+
+func TestCFindCancelledMidQuery(t *testing.T) {
+	client, peer := newPipeClient(SOPClassStudyRootQueryRetrieveFind, dicom.ImplicitVRLittleEndian)
+	defer peer.Close()
+
+	cancelled := make(chan struct{})
+	peerErr := make(chan error, 1)
+	go func() {
+		req, err := readMessage(peer)
+		if err != nil {
+			peerErr <- err
+			return
+		}
+		rsp := func(status uint16, data []byte) error {
+			cmd := &DIMSECommand{
+				CommandField:              CommandCFindRSP,
+				MessageIDBeingRespondedTo: req.Command.MessageID,
+				AffectedSOPClass:          SOPClassStudyRootQueryRetrieveFind,
+				DataSetType:               DataSetAbsent,
+				Status:                    status,
+			}
+			if data != nil {
+				cmd.DataSetType = DataSetPresent
+			}
+			return writeMessage(peer, req.ContextID, cmd, data, 0)
+		}
+
+		match := dicom.NewDataset()
+		match.SetString(dicom.TagStudyInstanceUID, "1.2.3")
+		data, _ := dicom.EncodeDataset(match, dicom.ImplicitVRLittleEndian)
+		if err := rsp(StatusPending, data); err != nil {
+			peerErr <- err
+			return
+		}
+
+		// The second match arrives after the caller gave up
+		<-cancelled
+		if err := rsp(StatusPending, data); err != nil {
+			peerErr <- err
+			return
+		}
+		cancel, err := readMessage(peer)
+		if err != nil {
+			peerErr <- err
+			return
+		}
+		if cancel.Command.CommandField != CommandCCancelRQ {
+			peerErr <- assert.AnError
+			return
+		}
+		peerErr <- rsp(StatusCancel, nil)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var results []FindResult
+	for result := range client.CFind(ctx, QueryLevelStudy, dicom.NewDataset()) {
+		if len(results) == 0 {
+			cancel()
+			close(cancelled)
+		}
+		results = append(results, result)
+	}
+
+	assert.NoError(t, <-peerErr)
+	if assert.Len(t, results, 2) {
+		assert.NoError(t, results[0].Err)
+		assert.ErrorIs(t, results[1].Err, context.Canceled)
+	}
+}
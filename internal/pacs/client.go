@@ -3,12 +3,16 @@ package pacs
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"net"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
 	"github.com/sirupsen/logrus"
 )
 
@@ -32,9 +36,7 @@ const (
 	PDUTypeAbortRQ       = 0x07
 
 	// DICOM UIDs
-	ApplicationContextName    = "1.2.840.10008.3.1.1.1"
-	ImplementationClassUID    = "1.2.840.10008.5.1.4.1.1"
-	ImplementationVersionName = "CRGODICOM-1.0"
+	ApplicationContextName = "1.2.840.10008.3.1.1.1"
 
 	// Transfer Syntax UIDs
 	ImplicitVRLittleEndian = "1.2.840.10008.1.2"
@@ -51,6 +53,24 @@ const (
 	SOPClassUSImageStorage               = "1.2.840.10008.5.1.4.1.1.6.1"
 	SOPClassSecondaryCaptureImageStorage = "1.2.840.10008.5.1.4.1.1.7"
 
+	// Query/Retrieve SOP Classes
+	SOPClassPatientRootQueryRetrieveFind = "1.2.840.10008.5.1.4.1.2.1.1"
+	SOPClassStudyRootQueryRetrieveFind   = "1.2.840.10008.5.1.4.1.2.2.1"
+	SOPClassPatientRootQueryRetrieveMove = "1.2.840.10008.5.1.4.1.2.1.2"
+	SOPClassStudyRootQueryRetrieveMove   = "1.2.840.10008.5.1.4.1.2.2.2"
+	SOPClassPatientRootQueryRetrieveGet  = "1.2.840.10008.5.1.4.1.2.1.3"
+	SOPClassStudyRootQueryRetrieveGet    = "1.2.840.10008.5.1.4.1.2.2.3"
+
+	// Modality Worklist Information Model - FIND
+	SOPClassModalityWorklistFind = "1.2.840.10008.5.1.4.31"
+
+	// Modality Performed Procedure Step SOP Class
+	SOPClassModalityPerformedProcedureStep = "1.2.840.10008.3.1.2.3.3"
+
+	// Storage Commitment Push Model SOP Class and its well-known SOP Instance
+	SOPClassStorageCommitmentPushModel    = "1.2.840.10008.1.20.1"
+	SOPInstanceStorageCommitmentPushModel = "1.2.840.10008.1.20.1.1"
+
 	// Max PDU Length
 	MaxPDULength = 16384
 )
@@ -62,19 +82,66 @@ type PDUHeader struct {
 	Length   uint32
 }
 
+// Presentation context result/reason values from A-ASSOCIATE-AC
+const (
+	PresentationContextAccepted                   = 0
+	PresentationContextUserRejection              = 1
+	PresentationContextNoReason                   = 2
+	PresentationContextAbstractSyntaxNotSupported = 3
+	PresentationContextTransferSyntaxNotSupported = 4
+)
+
 // Presentation Context Item
 type PresentationContext struct {
 	ID               uint8
 	AbstractSyntax   string
-	TransferSyntaxes []string
+	TransferSyntaxes []string // Proposed transfer syntaxes in order of preference
+
+	// Negotiation outcome from the A-ASSOCIATE-AC
+	Result         uint8
+	TransferSyntax string
+}
+
+// Accepted reports whether the peer accepted the presentation context
+func (pc *PresentationContext) Accepted() bool {
+	return pc.Result == PresentationContextAccepted && pc.TransferSyntax != ""
+}
+
+// presentationContextResultString describes a presentation context result
+func presentationContextResultString(result uint8) string {
+	switch result {
+	case PresentationContextAccepted:
+		return "acceptance"
+	case PresentationContextUserRejection:
+		return "user-rejection"
+	case PresentationContextNoReason:
+		return "no-reason (provider rejection)"
+	case PresentationContextAbstractSyntaxNotSupported:
+		return "abstract-syntax-not-supported"
+	case PresentationContextTransferSyntaxNotSupported:
+		return "transfer-syntaxes-not-supported"
+	default:
+		return fmt.Sprintf("unknown result %d", result)
+	}
 }
 
 // Client represents a DICOM PACS client
 type Client struct {
-	config           *config.PACSConfig
-	conn             net.Conn
-	associated       bool
-	acceptedContexts []uint8
+	config       *config.PACSConfig
+	conn         net.Conn
+	associated   bool
+	contexts     []*PresentationContext
+	maxPDULength uint32 // Peer's maximum receive PDU length, 0 if unlimited
+	messageID    uint16
+	cgetEnabled  bool
+
+	// Asynchronous operations window: proposed and negotiated outstanding requests
+	asyncOps      int
+	maxOpsInvoked int
+
+	// User Identity proposed from the configuration and the server response to it
+	identity         *UserIdentity
+	identityResponse []byte
 }
 
 // NewClient creates a new PACS client
@@ -84,10 +151,43 @@ func NewClient(cfg *config.PACSConfig) *Client {
 	}
 }
 
-// Connect establishes a DICOM association with the PACS server
+// EnableCGet proposes the SCP role for the storage SOP classes when the
+// association is negotiated, so that the peer can return instances for
+// C-GET over the same association. It must be called before Connect.
+func (c *Client) EnableCGet() {
+	c.cgetEnabled = true
+}
+
+// SetAsyncOperations proposes an Asynchronous Operations Window allowing up
+// to ops outstanding requests when the association is negotiated. It must be
+// called before Connect; values below 2 keep the default of one operation.
+func (c *Client) SetAsyncOperations(ops int) {
+	c.asyncOps = min(ops, 0xFFFF)
+}
+
+// MaxOperations returns the number of requests that may be outstanding on
+// the association, as negotiated by the Asynchronous Operations Window
+func (c *Client) MaxOperations() int {
+	return max(c.maxOpsInvoked, 1)
+}
+
+// IdentityResponse returns the server response to the User Identity, such as
+// a Kerberos server ticket; it is nil when the server sent none
+func (c *Client) IdentityResponse() []byte {
+	return c.identityResponse
+}
+
+// Connect establishes a DICOM association with the PACS server, recorded by
+// the tracer of ctx, if any
 func (c *Client) Connect(ctx context.Context) error {
 	address := fmt.Sprintf("%s:%d", c.config.Host, c.config.Port)
 
+	identity, err := NewUserIdentity(c.config.Identity)
+	if err != nil {
+		return err
+	}
+	c.identity = identity
+
 	logrus.Infof("Connecting to PACS at %s", address)
 
 	// Create connection with timeout
@@ -100,9 +200,28 @@ func (c *Client) Connect(ctx context.Context) error {
 		return fmt.Errorf("failed to connect to PACS: %w", err)
 	}
 
-	c.conn = conn
 	logrus.Infof("TCP connection established to %s", address)
 
+	if c.config.TLS.Enabled {
+		tlsConfig, err := ClientTLSConfig(c.config.TLS, c.config.Host)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return fmt.Errorf("TLS handshake with %s failed: %w", address, err)
+		}
+		state := tlsConn.ConnectionState()
+		logrus.Infof("TLS established with %s (%s, %s)", address, tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+		conn = tlsConn
+	}
+	if tracer := TracerFromContext(ctx); tracer != nil {
+		conn = tracer.wrap(conn, true)
+	}
+	c.conn = conn
+
 	// Perform DICOM association
 	if err := c.performAssociation(ctx); err != nil {
 		c.conn.Close()
@@ -125,7 +244,8 @@ func (c *Client) Disconnect() error {
 		err := c.conn.Close()
 		c.conn = nil
 		c.associated = false
-		c.acceptedContexts = nil
+		c.contexts = nil
+		c.maxPDULength = 0
 		if err != nil {
 			return fmt.Errorf("failed to close connection: %w", err)
 		}
@@ -151,24 +271,76 @@ func (c *Client) CEcho(ctx context.Context) error {
 	return nil
 }
 
-// CStore performs a C-STORE request to send a DICOM file
-func (c *Client) CStore(ctx context.Context, dicomData []byte, sopInstanceUID string) error {
+// CStore performs a C-STORE request to send a DICOM file and returns the
+// response status. Failure and Refused statuses are returned as a *StatusError.
+func (c *Client) CStore(ctx context.Context, dicomData []byte, sopInstanceUID string) (Status, error) {
+	messageID, err := c.CStoreAsync(ctx, dicomData, sopInstanceUID)
+	if err != nil {
+		return Status{}, err
+	}
+
+	respondedTo, status, err := c.CStoreResponse(ctx)
+	if err == nil && respondedTo != messageID {
+		err = fmt.Errorf("C-STORE response to message %d while awaiting %d", respondedTo, messageID)
+	}
+	if err != nil {
+		return status, fmt.Errorf("C-STORE failed: %w", err)
+	}
+
+	logrus.Infof("C-STORE completed with status %s", status)
+	return status, nil
+}
+
+// CStoreAsync sends a C-STORE request without waiting for its response and
+// returns the request's message ID. Up to MaxOperations requests may be
+// outstanding; their responses are read with CStoreResponse.
+func (c *Client) CStoreAsync(ctx context.Context, dicomData []byte, sopInstanceUID string) (uint16, error) {
 	logrus.Infof("Performing C-STORE request for SOP Instance UID: %s", sopInstanceUID)
 
 	if !c.associated {
-		return fmt.Errorf("not associated with PACS server")
+		return 0, fmt.Errorf("not associated with PACS server")
 	}
 
-	// Determine SOP Class from DICOM data (simplified)
-	sopClass := c.extractSOPClassFromData(dicomData)
+	file, err := dicom.ParseFile(dicomData)
+	if err != nil {
+		return 0, fmt.Errorf("C-STORE failed: %w", err)
+	}
+	sopClass := file.SOPClassUID()
+	if sopClass == "" {
+		sopClass = SOPClassSecondaryCaptureImageStorage
+	}
 
-	// Use simplified C-STORE implementation
-	if err := c.sendCStore(ctx, dicomData, sopInstanceUID, sopClass); err != nil {
-		return fmt.Errorf("C-STORE failed: %w", err)
+	// Pick an accepted context, preferring one that matches the file's transfer syntax
+	pc, err := c.selectPresentationContext(sopClass, file.TransferSyntaxUID)
+	if err != nil {
+		return 0, fmt.Errorf("C-STORE failed: %w", err)
 	}
 
-	logrus.Info("C-STORE completed successfully")
-	return nil
+	dataset := file.RawDataset
+	if pc.TransferSyntax != file.TransferSyntaxUID {
+		logrus.Infof("Transcoding %s from %s to %s", sopInstanceUID, file.TransferSyntaxUID, pc.TransferSyntax)
+		dataset, err = dicom.Transcode(file.RawDataset, file.TransferSyntaxUID, pc.TransferSyntax)
+		if err != nil {
+			return 0, fmt.Errorf("C-STORE failed: %w", err)
+		}
+	}
+
+	messageID, err := c.sendCStore(ctx, pc.ID, dataset, sopInstanceUID, sopClass)
+	if err != nil {
+		return 0, fmt.Errorf("C-STORE failed: %w", err)
+	}
+	return messageID, nil
+}
+
+// CStoreResponse waits for the next C-STORE response and returns the message
+// ID it answers with its status. Failure and Refused statuses are returned as
+// a *StatusError together with the message ID.
+func (c *Client) CStoreResponse(ctx context.Context) (uint16, Status, error) {
+	rsp, err := c.receiveMessage(ctx)
+	if err != nil {
+		return 0, Status{}, fmt.Errorf("failed to read C-STORE response: %w", err)
+	}
+	return rsp.Command.MessageIDBeingRespondedTo, rsp.Command.status(), c.parseDIMSEResponse(rsp, CommandCStoreRSP)
 }
 
 // IsConnected returns true if the client is connected to a PACS server
@@ -185,7 +357,11 @@ func (c *Client) GetConfig() *config.PACSConfig {
 func (c *Client) performAssociation(ctx context.Context) error {
 	logrus.Info("Performing DICOM association negotiation")
 
+	c.setDeadline(ctx)
+	defer c.conn.SetDeadline(time.Time{})
+
 	// Send Association Request
+	c.contexts = proposedPresentationContexts()
 	assocReq := c.buildAssociationRequest()
 	logrus.Debugf("Sending association request: %d bytes, first 10 bytes: %X", len(assocReq), assocReq[:min(10, len(assocReq))])
 	logrus.Debugf("PDU Type: %02X (decimal: %d)", assocReq[0], assocReq[0])
@@ -194,14 +370,13 @@ func (c *Client) performAssociation(ctx context.Context) error {
 	}
 
 	// Read Association Response
-	response := make([]byte, 4096)
-	n, err := c.conn.Read(response)
+	response, err := readPDU(c.conn)
 	if err != nil {
 		return fmt.Errorf("failed to read association response: %w", err)
 	}
 
 	// Parse Association Response
-	if err := c.parseAssociationResponse(response[:n]); err != nil {
+	if err := c.parseAssociationResponse(response); err != nil {
 		return fmt.Errorf("association rejected: %w", err)
 	}
 
@@ -258,56 +433,47 @@ func (c *Client) buildAssociationRequest() []byte {
 	pdu.Write(appContextLength)
 	pdu.Write(appContext)
 
-	// Build presentation contexts for common SOP classes
-	contexts := []struct {
-		ID             uint8
-		AbstractSyntax string
-	}{
-		{1, SOPClassVerification},
-		{3, SOPClassCTImageStorage},
-		{5, SOPClassCRImageStorage},
-		{7, SOPClassDXImageStorage},
-		{9, SOPClassMGImageStorage},
-		{11, SOPClassMRImageStorage},
-		{13, SOPClassUSImageStorage},
-		{15, SOPClassSecondaryCaptureImageStorage},
-	}
-
-	for _, ctx := range contexts {
+	for _, pc := range c.contexts {
 		// Presentation Context Item
-		pdu.WriteByte(0x20) // Item Type: Presentation Context
-		pdu.WriteByte(0x00) // Reserved
+		pdu.WriteByte(ItemTypePresentationContextRQ) // Item Type: Presentation Context
+		pdu.WriteByte(0x00)                          // Reserved
 
 		// We'll calculate PC length after building it
 		pcLengthPos := pdu.Len()
 		pdu.Write(make([]byte, 2)) // Placeholder for PC length
 
-		pdu.WriteByte(ctx.ID)               // Presentation Context ID
+		pdu.WriteByte(pc.ID)                // Presentation Context ID
 		pdu.Write([]byte{0x00, 0x00, 0x00}) // Reserved
 
 		// Abstract Syntax Sub-item
-		pdu.WriteByte(0x30) // Item Type: Abstract Syntax
-		pdu.WriteByte(0x00) // Reserved
-		abstractSyntax := []byte(ctx.AbstractSyntax)
-		abstractLength := make([]byte, 2)
-		binary.BigEndian.PutUint16(abstractLength, uint16(len(abstractSyntax)))
-		pdu.Write(abstractLength)
-		pdu.Write(abstractSyntax)
-
-		// Transfer Syntax Sub-item (Implicit VR Little Endian)
-		pdu.WriteByte(0x40) // Item Type: Transfer Syntax
-		pdu.WriteByte(0x00) // Reserved
-		transferSyntax := []byte(ImplicitVRLittleEndian)
-		transferLength := make([]byte, 2)
-		binary.BigEndian.PutUint16(transferLength, uint16(len(transferSyntax)))
-		pdu.Write(transferLength)
-		pdu.Write(transferSyntax)
+		writeItem(&pdu, ItemTypeAbstractSyntax, []byte(pc.AbstractSyntax))
+
+		// Transfer Syntax Sub-items in order of preference
+		for _, ts := range pc.TransferSyntaxes {
+			writeItem(&pdu, ItemTypeTransferSyntax, []byte(ts))
+		}
 
 		// Update Presentation Context length
 		pcLength := pdu.Len() - pcLengthPos - 2
 		binary.BigEndian.PutUint16(pdu.Bytes()[pcLengthPos:], uint16(pcLength))
 	}
 
+	// User Information Item (maximum length, implementation identification and roles)
+	var scpRoles []string
+	if c.cgetEnabled {
+		for _, pc := range c.contexts {
+			if isStorageSOPClass(pc.AbstractSyntax) && !slices.Contains(scpRoles, pc.AbstractSyntax) {
+				scpRoles = append(scpRoles, pc.AbstractSyntax)
+			}
+		}
+	}
+	info := userInformation{scpRoles: scpRoles, identity: c.identity}
+	if c.asyncOps > 1 {
+		info.maxOpsInvoked = uint16(c.asyncOps)
+		info.maxOpsPerformed = 1
+	}
+	pdu.Write(buildUserInformation(info))
+
 	// Update PDU length
 	pduLength := pdu.Len() - 6
 	binary.BigEndian.PutUint32(pdu.Bytes()[lengthPos:], uint32(pduLength))
@@ -316,22 +482,82 @@ func (c *Client) buildAssociationRequest() []byte {
 }
 
 // parseAssociationResponse parses the DICOM Association Response
-func (c *Client) parseAssociationResponse(data []byte) error {
-	if len(data) < 6 {
+func (c *Client) parseAssociationResponse(pdu *PDU) error {
+	switch pdu.Type {
+	case PDUTypeAssociationAC:
+	case PDUTypeAssociationRJ:
+		if len(pdu.Data) < 4 {
+			return fmt.Errorf("association rejected by server")
+		}
+		return fmt.Errorf("association rejected by server (result %d, source %d, reason %d)",
+			pdu.Data[1], pdu.Data[2], pdu.Data[3])
+	case PDUTypeAbortRQ:
+		return errAborted
+	default:
+		return fmt.Errorf("unexpected PDU type in response: %d", pdu.Type)
+	}
+
+	// Protocol version, reserved, AE titles and reserved field precede the items
+	if len(pdu.Data) < 68 {
 		return fmt.Errorf("association response too short")
 	}
 
-	pduType := data[0]
-	if pduType == PDUTypeAssociationRJ {
-		return fmt.Errorf("association rejected by server")
+	items, err := parseItems(pdu.Data[68:])
+	if err != nil {
+		return fmt.Errorf("invalid association response: %w", err)
+	}
+
+	c.maxPDULength = 0
+	c.maxOpsInvoked = 1
+	c.identityResponse = nil
+	identityAcknowledged := false
+	for _, it := range items {
+		switch it.Type {
+		case ItemTypePresentationContextAC:
+			if err := c.parsePresentationContextResult(it.Data); err != nil {
+				return err
+			}
+		case ItemTypeUserInformation:
+			maxLength, err := parseMaxPDULength(it.Data)
+			if err != nil {
+				return err
+			}
+			c.maxPDULength = maxLength
+
+			// Without a window in the response only one operation may be outstanding
+			if invoked, _, ok := parseAsyncOperationsWindow(it.Data); ok && c.asyncOps > 1 {
+				if invoked == 0 || int(invoked) > c.asyncOps {
+					invoked = uint16(c.asyncOps) // 0 means unlimited
+				}
+				c.maxOpsInvoked = int(invoked)
+			}
+
+			if response, ok := parseUserIdentityResponse(it.Data); ok {
+				c.identityResponse = response
+				identityAcknowledged = true
+			}
+		}
+	}
+
+	if c.identity != nil {
+		if c.identity.PositiveResponseRequested && !identityAcknowledged {
+			return fmt.Errorf("server did not acknowledge user identity %s", c.identity)
+		}
+		logrus.Infof("Association negotiated with user identity %s (acknowledged: %t)", c.identity, identityAcknowledged)
 	}
 
-	if pduType != PDUTypeAssociationAC {
-		return fmt.Errorf("unexpected PDU type in response: %d", pduType)
+	accepted := 0
+	for _, pc := range c.contexts {
+		if pc.Accepted() {
+			accepted++
+		}
+	}
+	if accepted == 0 {
+		return fmt.Errorf("no presentation contexts were accepted")
 	}
 
-	// For now, just verify we got an accept
-	logrus.Info("Association accepted by server")
+	logrus.Infof("Association accepted by server (peer maximum PDU length: %d, operations window: %d)",
+		c.maxPDULength, c.maxOpsInvoked)
 	return nil
 }
 
@@ -339,149 +565,212 @@ func (c *Client) parseAssociationResponse(data []byte) error {
 func (c *Client) performRelease() {
 	logrus.Info("Releasing DICOM association")
 
-	var buf bytes.Buffer
-	buf.WriteByte(PDUTypeReleaseRQ)           // PDU Type
-	buf.WriteByte(0x00)                       // Reserved
-	buf.Write([]byte{0x00, 0x00, 0x00, 0x04}) // Length (4 bytes)
+	if c.conn == nil {
+		return
+	}
 
-	if c.conn != nil {
-		c.conn.Write(buf.Bytes())
-		// Read release response
-		response := make([]byte, 8)
-		c.conn.Read(response)
-	}
-}
-
-// sendDIMSECommand sends a DIMSE command over the DICOM association
-func (c *Client) sendDIMSECommand(ctx context.Context, presentationContextID uint8, sopClass string, commandField uint16, data []byte) error {
-	// Build DIMSE Command Set
-	var cmdSet bytes.Buffer
-
-	// Affected SOP Class UID
-	cmdSet.Write([]byte{0x00, 0x00, 0x02, 0x00, 0x55, 0x49}) // Tag (0000,0200)
-	cmdSet.Write([]byte{byte(len(sopClass))})
-	cmdSet.WriteString(sopClass)
-
-	// Command Field
-	cmdSet.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x55, 0x53}) // Tag (0000,0100)
-	cmdSet.Write([]byte{0x00, 0x02})
-	cmdFieldPos := cmdSet.Len()
-	cmdSet.Write(make([]byte, 2))
-	binary.BigEndian.PutUint16(cmdSet.Bytes()[cmdFieldPos:], commandField)
-
-	// Message ID
-	cmdSet.Write([]byte{0x00, 0x01, 0x10, 0x00, 0x55, 0x53}) // Tag (0000,0110)
-	cmdSet.Write([]byte{0x00, 0x02})
-	msgIdPos := cmdSet.Len()
-	cmdSet.Write(make([]byte, 2))
-	binary.BigEndian.PutUint16(cmdSet.Bytes()[msgIdPos:], 1)
-
-	// Data Set Type (for C-ECHO: 0101, for C-STORE: 0001)
-	dataSetType := byte(0x01)
-	if commandField == 0x0030 { // C-ECHO
-		dataSetType = 0x05
-	}
-	cmdSet.Write([]byte{0x00, 0x01, 0x08, 0x00, 0x55, 0x53}) // Tag (0000,0800)
-	cmdSet.Write([]byte{0x00, 0x02})
-	dataSetTypePos := cmdSet.Len()
-	cmdSet.Write(make([]byte, 2))
-	binary.BigEndian.PutUint16(cmdSet.Bytes()[dataSetTypePos:], uint16(dataSetType))
-
-	// Build P-DATA-TF PDU
-	var pdu bytes.Buffer
+	c.setDeadline(context.Background())
+	if err := writePDU(c.conn, PDUTypeReleaseRQ, make([]byte, 4)); err != nil {
+		logrus.Warnf("Failed to send release request: %v", err)
+		return
+	}
 
-	// Presentation Data Value Item
-	pdvHeader := []byte{presentationContextID, 0x00} // Context ID + Flags
-	pdvData := append(cmdSet.Bytes(), data...)
-	pdvLength := uint32(len(pdvData))
-
-	// Write PDV Item Type and Length
-	pdu.WriteByte(0x04) // Item Type: Presentation Data Value
-	pdu.Write(pdvHeader)
-	pdvLenPos := pdu.Len()
-	pdu.Write(make([]byte, 4)) // Placeholder for length
-	pdu.Write(pdvData)
-	// Update PDV length
-	binary.BigEndian.PutUint32(pdu.Bytes()[pdvLenPos:], pdvLength)
-
-	// Write P-DATA-TF PDU Header
-	var finalPDU bytes.Buffer
-	finalPDU.WriteByte(PDUTypeDataTF) // PDU Type
-	finalPDU.WriteByte(0x00)          // Reserved
-	finalPDULenPos := finalPDU.Len()
-	finalPDU.Write(make([]byte, 4)) // Placeholder for length
-	finalPDU.Write(pdu.Bytes())
-	// Update PDU length
-	finalPDULength := finalPDU.Len() - 6
-	binary.BigEndian.PutUint32(finalPDU.Bytes()[finalPDULenPos:], uint32(finalPDULength))
+	// Read release response
+	response, err := readPDU(c.conn)
+	if err != nil {
+		logrus.Warnf("Failed to read release response: %v", err)
+		return
+	}
+	if response.Type != PDUTypeReleaseRP {
+		logrus.Warnf("Unexpected PDU type in release response: %d", response.Type)
+	}
+}
 
-	// Send the PDU
-	if _, err := c.conn.Write(finalPDU.Bytes()); err != nil {
-		return fmt.Errorf("failed to send DIMSE command: %w", err)
+// sendMessage sends a DIMSE message within the peer's maximum PDU length
+func (c *Client) sendMessage(ctx context.Context, contextID uint8, cmd *DIMSECommand, data []byte) error {
+	c.setDeadline(ctx)
+	return writeMessage(c.conn, contextID, cmd, data, c.maxPDULength)
+}
+
+// receiveMessage reads the next complete DIMSE message from the peer
+func (c *Client) receiveMessage(ctx context.Context) (*message, error) {
+	c.setDeadline(ctx)
+	return readMessage(c.conn)
+}
+
+// setDeadline applies the configured timeout, or the context deadline when earlier
+func (c *Client) setDeadline(ctx context.Context) {
+	timeout := time.Duration(c.config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
 	}
 
-	// Read response
-	response := make([]byte, 4096)
-	n, err := c.conn.Read(response)
-	if err != nil {
-		return fmt.Errorf("failed to read DIMSE response: %w", err)
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
 	}
+	c.conn.SetDeadline(deadline)
+}
+
+// nextMessageID returns the next DIMSE message ID for this association
+func (c *Client) nextMessageID() uint16 {
+	c.messageID++
+	return c.messageID
+}
 
-	// Parse response status
-	if err := c.parseDIMSEResponse(response[:n]); err != nil {
-		return fmt.Errorf("DIMSE command failed: %w", err)
+// parseDIMSEResponse checks a DIMSE response against the expected command.
+// Warning statuses are logged and accepted; any other non-success status is
+// returned as a *StatusError.
+func (c *Client) parseDIMSEResponse(rsp *message, expected uint16) error {
+	if rsp.Command.CommandField != expected {
+		return fmt.Errorf("unexpected DIMSE command 0x%04X in response", rsp.Command.CommandField)
 	}
 
-	logrus.Info("DIMSE command completed successfully")
+	status := rsp.Command.status()
+	switch status.Category() {
+	case CategorySuccess:
+	case CategoryWarning:
+		logrus.Warnf("DIMSE response completed with status %s", status)
+	default:
+		return &StatusError{Status: status}
+	}
+
+	logrus.Info("DIMSE response received and parsed successfully")
 	return nil
 }
 
-// parseDIMSEResponse parses a DIMSE response
-func (c *Client) parseDIMSEResponse(data []byte) error {
-	if len(data) < 8 {
-		return fmt.Errorf("response too short")
+// parsePresentationContextResult records the result of a presentation context
+// item from the A-ASSOCIATE-AC against the matching proposed context
+func (c *Client) parsePresentationContextResult(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("presentation context result item too short")
 	}
 
-	// Check PDU type
-	if data[0] != PDUTypeDataTF {
-		return fmt.Errorf("unexpected PDU type in response: %d", data[0])
+	pc := c.presentationContext(data[0])
+	if pc == nil {
+		return fmt.Errorf("peer answered unknown presentation context %d", data[0])
 	}
+	pc.Result = data[2]
+	pc.TransferSyntax = ""
 
-	// For now, assume success if we get a valid P-DATA-TF response
-	// In a full implementation, we would parse the DIMSE command response
-	logrus.Info("DIMSE response received and parsed successfully")
+	subItems, err := parseItems(data[4:])
+	if err != nil {
+		return fmt.Errorf("invalid presentation context %d: %w", pc.ID, err)
+	}
+	for _, sub := range subItems {
+		if sub.Type == ItemTypeTransferSyntax && pc.Result == PresentationContextAccepted {
+			pc.TransferSyntax = strings.TrimRight(string(sub.Data), "\x00 ")
+		}
+	}
+
+	if pc.Accepted() {
+		logrus.Debugf("Presentation context %d (%s) accepted with transfer syntax %s", pc.ID, pc.AbstractSyntax, pc.TransferSyntax)
+	} else {
+		logrus.Debugf("Presentation context %d (%s) rejected: %s", pc.ID, pc.AbstractSyntax, presentationContextResultString(pc.Result))
+	}
+	return nil
+}
+
+// PresentationContexts returns the proposed presentation contexts with their negotiation results
+func (c *Client) PresentationContexts() []PresentationContext {
+	contexts := make([]PresentationContext, 0, len(c.contexts))
+	for _, pc := range c.contexts {
+		contexts = append(contexts, *pc)
+	}
+	return contexts
+}
+
+// presentationContext returns the proposed presentation context with the given ID
+func (c *Client) presentationContext(id uint8) *PresentationContext {
+	for _, pc := range c.contexts {
+		if pc.ID == id {
+			return pc
+		}
+	}
 	return nil
 }
 
-// extractSOPClassFromData extracts SOP Class UID from DICOM data
-func (c *Client) extractSOPClassFromData(data []byte) string {
-	// Look for SOP Class UID in DICOM elements (tag 0008,0016)
-	// This is a simplified implementation
-	if len(data) < 132 { // Minimum DICOM file size
-		return SOPClassSecondaryCaptureImageStorage
+// selectPresentationContext finds an accepted presentation context for a SOP class,
+// preferring one negotiated with the given transfer syntax. A context with a
+// different transfer syntax is only chosen when the data can be transcoded to it.
+func (c *Client) selectPresentationContext(sopClass, transferSyntax string) (*PresentationContext, error) {
+	var fallback *PresentationContext
+	var reasons []string
+
+	for _, pc := range c.contexts {
+		if pc.AbstractSyntax != sopClass {
+			continue
+		}
+		if !pc.Accepted() {
+			reasons = append(reasons, presentationContextResultString(pc.Result))
+			continue
+		}
+		if pc.TransferSyntax == transferSyntax {
+			return pc, nil
+		}
+		if fallback == nil && dicom.IsNativeTransferSyntax(transferSyntax) && dicom.IsNativeTransferSyntax(pc.TransferSyntax) {
+			fallback = pc
+		}
 	}
 
-	// Search for SOP Class UID tag (0008,0016) in the data
-	// In a real implementation, we would properly parse the DICOM elements
-	// For now, return a default based on file characteristics
-	return SOPClassSecondaryCaptureImageStorage
+	if fallback != nil {
+		return fallback, nil
+	}
+	if len(reasons) > 0 {
+		return nil, fmt.Errorf("presentation context for SOP class %s was rejected: %s", sopClass, strings.Join(reasons, ", "))
+	}
+	return nil, fmt.Errorf("no accepted presentation context for SOP class %s with transfer syntax %s", sopClass, transferSyntax)
 }
 
-// findPresentationContext finds the presentation context ID for a given SOP class
-func (c *Client) findPresentationContext(sopClass string) uint8 {
-	contextMap := map[string]uint8{
-		SOPClassVerification:                 1,
-		SOPClassCTImageStorage:               3,
-		SOPClassCRImageStorage:               5,
-		SOPClassDXImageStorage:               7,
-		SOPClassMGImageStorage:               9,
-		SOPClassMRImageStorage:               11,
-		SOPClassUSImageStorage:               13,
-		SOPClassSecondaryCaptureImageStorage: 15,
+// proposedPresentationContexts builds the presentation contexts proposed for common SOP classes
+func proposedPresentationContexts() []*PresentationContext {
+	abstractSyntaxes := []string{
+		SOPClassVerification,
+		SOPClassCTImageStorage,
+		SOPClassCRImageStorage,
+		SOPClassDXImageStorage,
+		SOPClassMGImageStorage,
+		SOPClassMRImageStorage,
+		SOPClassUSImageStorage,
+		SOPClassSecondaryCaptureImageStorage,
+		SOPClassPatientRootQueryRetrieveFind,
+		SOPClassStudyRootQueryRetrieveFind,
+		SOPClassPatientRootQueryRetrieveMove,
+		SOPClassStudyRootQueryRetrieveMove,
+		SOPClassPatientRootQueryRetrieveGet,
+		SOPClassStudyRootQueryRetrieveGet,
+		SOPClassModalityWorklistFind,
+		SOPClassModalityPerformedProcedureStep,
+		SOPClassStorageCommitmentPushModel,
 	}
 
-	if id, exists := contextMap[sopClass]; exists {
-		return id
+	contexts := make([]*PresentationContext, 0, len(abstractSyntaxes))
+	for _, abstractSyntax := range abstractSyntaxes {
+		contexts = append(contexts, &PresentationContext{
+			ID:               uint8(2*len(contexts) + 1), // Presentation context IDs are odd
+			AbstractSyntax:   abstractSyntax,
+			TransferSyntaxes: []string{ExplicitVRLittleEndian, ImplicitVRLittleEndian, ExplicitVRBigEndian},
+		})
 	}
-	return 15 // Default to Secondary Capture
+
+	// Compressed images cannot be transcoded, so storage SOP classes get a
+	// context of their own for each compressed transfer syntax
+	for _, transferSyntax := range dicom.EncapsulatedTransferSyntaxes() {
+		for _, abstractSyntax := range abstractSyntaxes {
+			if !isStorageSOPClass(abstractSyntax) {
+				continue
+			}
+			contexts = append(contexts, &PresentationContext{
+				ID:               uint8(2*len(contexts) + 1),
+				AbstractSyntax:   abstractSyntax,
+				TransferSyntaxes: []string{transferSyntax},
+			})
+		}
+	}
+	return contexts
+}
+
+// isStorageSOPClass reports whether a SOP class is a composite storage SOP class
+func isStorageSOPClass(sopClass string) bool {
+	return strings.HasPrefix(sopClass, "1.2.840.10008.5.1.4.1.1.")
 }
@@ -0,0 +1,190 @@
+package pacs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/sirupsen/logrus"
+)
+
+// Storage Commitment action and event types
+const (
+	commitmentActionRequest    = 1
+	commitmentEventSuccess     = 1
+	commitmentEventWithFailure = 2
+)
+
+// ReferencedInstance identifies a SOP instance in a storage commitment transaction
+type ReferencedInstance struct {
+	SOPClassUID    string `json:"sop_class_uid"`
+	SOPInstanceUID string `json:"sop_instance_uid"`
+	FailureReason  uint16 `json:"failure_reason,omitempty"` // Set for instances the peer failed to commit
+}
+
+// CommitmentResult is the outcome of a storage commitment transaction as
+// reported by the peer's N-EVENT-REPORT
+type CommitmentResult struct {
+	TransactionUID string
+	Committed      []ReferencedInstance
+	Failed         []ReferencedInstance
+}
+
+// CommitmentHandler receives storage commitment results reported to a Server
+type CommitmentHandler func(callingAE string, result *CommitmentResult)
+
+// RequestCommitment asks the peer to commit the instances with an N-ACTION
+// request of the Storage Commitment Push Model. The result arrives later in
+// an N-EVENT-REPORT, either on this association (see WaitCommitment) or, once
+// it is released, on a new association opened by the peer.
+func (c *Client) RequestCommitment(ctx context.Context, transactionUID string, instances []ReferencedInstance) error {
+	if !c.associated {
+		return fmt.Errorf("not associated with PACS server")
+	}
+
+	pc, err := c.selectPresentationContext(SOPClassStorageCommitmentPushModel, ExplicitVRLittleEndian)
+	if err != nil {
+		return fmt.Errorf("storage commitment request failed: %w", err)
+	}
+
+	ds := dicom.NewDataset()
+	ds.SetString(dicom.TagTransactionUID, transactionUID)
+	ds.SetSequence(dicom.TagReferencedSOPSequence, referencedSOPItems(instances, false))
+	data, err := dicom.EncodeDataset(ds, pc.TransferSyntax)
+	if err != nil {
+		return fmt.Errorf("failed to encode storage commitment request: %w", err)
+	}
+
+	logrus.Infof("Requesting storage commitment for %d instances (transaction %s)", len(instances), transactionUID)
+
+	cmd := &DIMSECommand{
+		CommandField:         CommandNActionRQ,
+		MessageID:            c.nextMessageID(),
+		RequestedSOPClass:    SOPClassStorageCommitmentPushModel,
+		RequestedSOPInstance: SOPInstanceStorageCommitmentPushModel,
+		ActionTypeID:         commitmentActionRequest,
+		DataSetType:          DataSetPresent,
+	}
+	if err := c.sendMessage(ctx, pc.ID, cmd, data); err != nil {
+		return fmt.Errorf("storage commitment request failed: %w", err)
+	}
+
+	rsp, err := c.receiveMessage(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read N-ACTION response: %w", err)
+	}
+	if err := c.parseDIMSEResponse(rsp, CommandNActionRSP); err != nil {
+		return fmt.Errorf("storage commitment request failed: %w", err)
+	}
+	return nil
+}
+
+// WaitCommitment waits on this association until ctx is done for the
+// N-EVENT-REPORT of a storage commitment transaction, and acknowledges it
+func (c *Client) WaitCommitment(ctx context.Context, transactionUID string) (*CommitmentResult, error) {
+	if !c.associated {
+		return nil, fmt.Errorf("not associated with PACS server")
+	}
+
+	// The report may take longer than a DIMSE response, so only ctx bounds the wait
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Duration(c.config.Timeout) * time.Second)
+	}
+
+	for {
+		c.conn.SetDeadline(deadline)
+		msg, err := readMessage(c.conn)
+		if err != nil {
+			if errors.Is(err, errReleaseRequested) || errors.Is(err, errAborted) {
+				c.associated = false
+			}
+			return nil, fmt.Errorf("no storage commitment report received: %w", err)
+		}
+		if msg.Command.CommandField != CommandNEventReportRQ {
+			logrus.Warnf("Ignoring DIMSE command 0x%04X while waiting for storage commitment", msg.Command.CommandField)
+			continue
+		}
+
+		pc := c.presentationContext(msg.ContextID)
+		if pc == nil || !pc.Accepted() {
+			return nil, fmt.Errorf("N-EVENT-REPORT received on unaccepted presentation context %d", msg.ContextID)
+		}
+
+		result, status := parseCommitmentReport(msg, pc.TransferSyntax)
+		rsp := &DIMSECommand{
+			CommandField:              CommandNEventReportRSP,
+			MessageIDBeingRespondedTo: msg.Command.MessageID,
+			AffectedSOPClass:          msg.Command.AffectedSOPClass,
+			AffectedSOPInstance:       msg.Command.AffectedSOPInstance,
+			EventTypeID:               msg.Command.EventTypeID,
+			DataSetType:               DataSetAbsent,
+			Status:                    status,
+		}
+		if err := c.sendMessage(ctx, msg.ContextID, rsp, nil); err != nil {
+			return nil, err
+		}
+
+		if result == nil {
+			return nil, fmt.Errorf("invalid storage commitment report")
+		}
+		if result.TransactionUID != transactionUID {
+			logrus.Warnf("Ignoring storage commitment report for transaction %s", result.TransactionUID)
+			continue
+		}
+		return result, nil
+	}
+}
+
+// parseCommitmentReport decodes the dataset of an N-EVENT-REPORT request and
+// returns the result with the status to respond with
+func parseCommitmentReport(msg *message, transferSyntax string) (*CommitmentResult, uint16) {
+	if msg.Command.EventTypeID != commitmentEventSuccess && msg.Command.EventTypeID != commitmentEventWithFailure {
+		logrus.Warnf("Unknown storage commitment event type %d", msg.Command.EventTypeID)
+		return nil, StatusNoSuchEventType
+	}
+
+	ds, err := dicom.ParseDataset(msg.Data, transferSyntax)
+	if err != nil {
+		logrus.Errorf("Invalid storage commitment report: %v", err)
+		return nil, StatusProcessingFailure
+	}
+
+	result := &CommitmentResult{TransactionUID: ds.String(dicom.TagTransactionUID)}
+	for _, item := range ds.Sequence(dicom.TagReferencedSOPSequence) {
+		result.Committed = append(result.Committed, referencedInstance(item))
+	}
+	for _, item := range ds.Sequence(dicom.TagFailedSOPSequence) {
+		result.Failed = append(result.Failed, referencedInstance(item))
+	}
+
+	logrus.Infof("Storage commitment report for transaction %s: %d committed, %d failed",
+		result.TransactionUID, len(result.Committed), len(result.Failed))
+	return result, StatusSuccess
+}
+
+// referencedSOPItems builds the items of a Referenced or Failed SOP Sequence
+func referencedSOPItems(instances []ReferencedInstance, withFailureReason bool) []*dicom.Dataset {
+	items := make([]*dicom.Dataset, 0, len(instances))
+	for _, instance := range instances {
+		item := dicom.NewDataset()
+		item.SetString(dicom.TagReferencedSOPClassUID, instance.SOPClassUID)
+		item.SetString(dicom.TagReferencedSOPInstanceUID, instance.SOPInstanceUID)
+		if withFailureReason {
+			item.Set(dicom.TagFailureReason, "US", []byte{byte(instance.FailureReason), byte(instance.FailureReason >> 8)})
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// referencedInstance reads an item of a Referenced or Failed SOP Sequence
+func referencedInstance(item *dicom.Dataset) ReferencedInstance {
+	return ReferencedInstance{
+		SOPClassUID:    item.String(dicom.TagReferencedSOPClassUID),
+		SOPInstanceUID: item.String(dicom.TagReferencedSOPInstanceUID),
+		FailureReason:  uint16(item.Int(dicom.TagFailureReason)),
+	}
+}
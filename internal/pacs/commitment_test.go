@@ -0,0 +1,142 @@
+package pacs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/stretchr/testify/assert"
+)
+
+// commitmentReport encodes the N-EVENT-REPORT dataset of a storage commitment result
+func commitmentReport(transactionUID string, committed, failed []ReferencedInstance) []byte {
+	ds := dicom.NewDataset()
+	ds.SetString(dicom.TagTransactionUID, transactionUID)
+	ds.SetSequence(dicom.TagReferencedSOPSequence, referencedSOPItems(committed, false))
+	if len(failed) > 0 {
+		ds.SetSequence(dicom.TagFailedSOPSequence, referencedSOPItems(failed, true))
+	}
+	data, _ := dicom.EncodeDataset(ds, dicom.ExplicitVRLittleEndian)
+	return data
+}
+
+func TestStorageCommitmentOnSameAssociation(t *testing.T) {
+	client, peer := newPipeClient(SOPClassStorageCommitmentPushModel, dicom.ExplicitVRLittleEndian)
+	defer peer.Close()
+
+	instances := []ReferencedInstance{
+		{SOPClassUID: SOPClassMRImageStorage, SOPInstanceUID: "1.2.3.1.1"},
+		{SOPClassUID: SOPClassMRImageStorage, SOPInstanceUID: "1.2.3.1.2"},
+	}
+
+	peerErr := make(chan error, 1)
+	go func() {
+		req, err := readMessage(peer)
+		if err != nil {
+			peerErr <- err
+			return
+		}
+
+		// The request references every sent instance
+		ds, err := dicom.ParseDataset(req.Data, dicom.ExplicitVRLittleEndian)
+		if err != nil || len(ds.Sequence(dicom.TagReferencedSOPSequence)) != 2 || req.Command.ActionTypeID != 1 {
+			peerErr <- assert.AnError
+			return
+		}
+
+		rsp := &DIMSECommand{
+			CommandField:              CommandNActionRSP,
+			MessageIDBeingRespondedTo: req.Command.MessageID,
+			AffectedSOPClass:          SOPClassStorageCommitmentPushModel,
+			AffectedSOPInstance:       SOPInstanceStorageCommitmentPushModel,
+			DataSetType:               DataSetAbsent,
+			Status:                    StatusSuccess,
+		}
+		if err := writeMessage(peer, req.ContextID, rsp, nil, 0); err != nil {
+			peerErr <- err
+			return
+		}
+
+		failed := instances[1]
+		failed.FailureReason = 0x0110
+		report := &DIMSECommand{
+			CommandField:        CommandNEventReportRQ,
+			MessageID:           1,
+			AffectedSOPClass:    SOPClassStorageCommitmentPushModel,
+			AffectedSOPInstance: SOPInstanceStorageCommitmentPushModel,
+			EventTypeID:         2,
+			DataSetType:         DataSetPresent,
+		}
+		data := commitmentReport(ds.String(dicom.TagTransactionUID), instances[:1], []ReferencedInstance{failed})
+		if err := writeMessage(peer, req.ContextID, report, data, 0); err != nil {
+			peerErr <- err
+			return
+		}
+
+		ack, err := readMessage(peer)
+		if err == nil && (ack.Command.CommandField != CommandNEventReportRSP || ack.Command.EventTypeID != 2) {
+			err = assert.AnError
+		}
+		peerErr <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.NoError(t, client.RequestCommitment(ctx, "1.2.3.99", instances))
+	result, err := client.WaitCommitment(ctx, "1.2.3.99")
+	assert.NoError(t, err)
+	assert.NoError(t, <-peerErr)
+
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "1.2.3.99", result.TransactionUID)
+		assert.Equal(t, []ReferencedInstance{instances[0]}, result.Committed)
+		if assert.Len(t, result.Failed, 1) {
+			assert.Equal(t, "1.2.3.1.2", result.Failed[0].SOPInstanceUID)
+			assert.Equal(t, uint16(0x0110), result.Failed[0].FailureReason)
+		}
+	}
+}
+
+func TestServerReceivesCommitmentReport(t *testing.T) {
+	results := make(chan *CommitmentResult, 1)
+	_, cfg := startServer(t, "TEST_SCU", t.TempDir(), func(s *Server) {
+		s.SetCommitmentHandler(func(callingAE string, result *CommitmentResult) {
+			results <- result
+		})
+	})
+
+	// The archive opens a new association to deliver the report
+	ctx := context.Background()
+	archive := NewClient(cfg)
+	if !assert.NoError(t, archive.Connect(ctx)) {
+		return
+	}
+	defer archive.Disconnect()
+
+	pc, err := archive.selectPresentationContext(SOPClassStorageCommitmentPushModel, ExplicitVRLittleEndian)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	report := &DIMSECommand{
+		CommandField:        CommandNEventReportRQ,
+		MessageID:           archive.nextMessageID(),
+		AffectedSOPClass:    SOPClassStorageCommitmentPushModel,
+		AffectedSOPInstance: SOPInstanceStorageCommitmentPushModel,
+		EventTypeID:         1,
+		DataSetType:         DataSetPresent,
+	}
+	committed := []ReferencedInstance{{SOPClassUID: SOPClassMRImageStorage, SOPInstanceUID: "1.2.3.1.1"}}
+	assert.NoError(t, archive.sendMessage(ctx, pc.ID, report, commitmentReport("1.2.3.99", committed, nil)))
+
+	rsp, err := archive.receiveMessage(ctx)
+	if assert.NoError(t, err) {
+		assert.NoError(t, archive.parseDIMSEResponse(rsp, CommandNEventReportRSP))
+	}
+
+	result := <-results
+	assert.Equal(t, "1.2.3.99", result.TransactionUID)
+	assert.Equal(t, committed, result.Committed)
+}
@@ -4,193 +4,473 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"time"
+	"io"
 
+	"github.com/flatmapit/crgodicom/internal/dicom"
 	"github.com/sirupsen/logrus"
 )
 
+// DIMSE command field values
+const (
+	CommandCStoreRQ  uint16 = 0x0001
+	CommandCStoreRSP uint16 = 0x8001
+	CommandCGetRQ    uint16 = 0x0010
+	CommandCGetRSP   uint16 = 0x8010
+	CommandCFindRQ   uint16 = 0x0020
+	CommandCFindRSP  uint16 = 0x8020
+	CommandCMoveRQ   uint16 = 0x0021
+	CommandCMoveRSP  uint16 = 0x8021
+	CommandCEchoRQ   uint16 = 0x0030
+	CommandCEchoRSP  uint16 = 0x8030
+	CommandCCancelRQ uint16 = 0x0FFF
+
+	CommandNEventReportRQ  uint16 = 0x0100
+	CommandNEventReportRSP uint16 = 0x8100
+	CommandNSetRQ          uint16 = 0x0120
+	CommandNSetRSP         uint16 = 0x8120
+	CommandNActionRQ       uint16 = 0x0130
+	CommandNActionRSP      uint16 = 0x8130
+	CommandNCreateRQ       uint16 = 0x0140
+	CommandNCreateRSP      uint16 = 0x8140
+)
+
+// DIMSE status values
+const (
+	StatusSuccess              uint16 = 0x0000
+	StatusProcessingFailure    uint16 = 0x0110
+	StatusNoSuchEventType      uint16 = 0x0113
+	StatusSOPClassNotSupported uint16 = 0x0122
+	StatusNotAuthorized        uint16 = 0x0124
+	StatusOutOfResources       uint16 = 0xA700
+	StatusIdentifierMismatch   uint16 = 0xA900
+	StatusCannotProcess        uint16 = 0xC000
+	StatusSubOpsWarning        uint16 = 0xB000
+	StatusCancel               uint16 = 0xFE00
+	StatusPending              uint16 = 0xFF00
+	StatusPendingWarning       uint16 = 0xFF01
+)
+
+// Command Data Set Type values
+const (
+	DataSetPresent uint16 = 0x0001
+	DataSetAbsent  uint16 = 0x0101
+)
+
+// Command set element numbers (group 0000)
+const (
+	elemCommandGroupLength        = 0x0000
+	elemAffectedSOPClassUID       = 0x0002
+	elemRequestedSOPClassUID      = 0x0003
+	elemCommandField              = 0x0100
+	elemMessageID                 = 0x0110
+	elemMessageIDBeingRespondedTo = 0x0120
+	elemMoveDestination           = 0x0600
+	elemPriority                  = 0x0700
+	elemCommandDataSetType        = 0x0800
+	elemStatus                    = 0x0900
+	elemOffendingElement          = 0x0901
+	elemErrorComment              = 0x0902
+	elemAffectedSOPInstanceUID    = 0x1000
+	elemRequestedSOPInstanceUID   = 0x1001
+	elemEventTypeID               = 0x1002
+	elemActionTypeID              = 0x1008
+	elemRemainingSubOperations    = 0x1020
+	elemCompletedSubOperations    = 0x1021
+	elemFailedSubOperations       = 0x1022
+	elemWarningSubOperations      = 0x1023
+)
+
+var (
+	// errReleaseRequested is returned when the peer sends an A-RELEASE-RQ
+	errReleaseRequested = errors.New("peer requested association release")
+	// errAborted is returned when the peer sends an A-ABORT
+	errAborted = errors.New("received A-ABORT from peer")
+)
+
 // DIMSECommand represents a DIMSE command
 type DIMSECommand struct {
-	CommandField     uint16
-	MessageID        uint16
-	AffectedSOPClass string
-	DataSetType      uint16
-	Data             []byte
+	CommandField              uint16
+	MessageID                 uint16
+	MessageIDBeingRespondedTo uint16
+	AffectedSOPClass          string
+	AffectedSOPInstance       string
+	Priority                  uint16
+	DataSetType               uint16
+	Status                    uint16
+
+	// Error details that may accompany a non-success status
+	ErrorComment      string
+	OffendingElements []dicom.Tag
+
+	// C-MOVE destination and C-GET/C-MOVE sub-operation counts
+	MoveDestination string
+	RemainingSubOps uint16
+	CompletedSubOps uint16
+	FailedSubOps    uint16
+	WarningSubOps   uint16
+
+	// Normalized (N-) service fields
+	RequestedSOPClass    string
+	RequestedSOPInstance string
+	EventTypeID          uint16
+	ActionTypeID         uint16
 }
 
-// sendCEcho sends a C-ECHO request using minimal DIMSE format
-func (c *Client) sendCEcho(ctx context.Context) error {
-	logrus.Info("Sending simplified C-ECHO request")
+// message is a DIMSE message: a command set and its optional dataset
+type message struct {
+	ContextID uint8
+	Command   *DIMSECommand
+	Data      []byte
+}
 
-	// Build minimal C-ECHO command (Implicit VR Little Endian)
-	var cmd bytes.Buffer
+// IsResponse reports whether the command is a response
+func (cmd *DIMSECommand) IsResponse() bool {
+	return cmd.CommandField&0x8000 != 0
+}
 
-	// Command Group Length (0000,0000) - will calculate at end
-	cmd.Write([]byte{0x00, 0x00, 0x00, 0x00}) // Tag
-	groupLengthPos := cmd.Len()
-	cmd.Write([]byte{0x00, 0x00, 0x00, 0x00}) // Length placeholder
+// HasDataSet reports whether a dataset follows the command
+func (cmd *DIMSECommand) HasDataSet() bool {
+	return cmd.DataSetType != DataSetAbsent
+}
 
-	// Affected SOP Class UID (0000,0002)
-	cmd.Write([]byte{0x00, 0x00, 0x00, 0x02}) // Tag
-	sopClass := SOPClassVerification
-	sopLen := len(sopClass)
-	if sopLen%2 == 1 {
-		sopClass += "\x00" // Pad to even length
-		sopLen++
+// hasPriority reports whether the command carries a Priority (0000,0700) element
+func (cmd *DIMSECommand) hasPriority() bool {
+	switch cmd.CommandField {
+	case CommandCStoreRQ, CommandCFindRQ, CommandCGetRQ, CommandCMoveRQ:
+		return true
 	}
-	cmd.Write([]byte{byte(sopLen >> 8), byte(sopLen)}) // Length
-	cmd.WriteString(sopClass)
-
-	// Command Field (0000,0100)
-	cmd.Write([]byte{0x00, 0x00, 0x01, 0x00}) // Tag
-	cmd.Write([]byte{0x00, 0x02})             // Length
-	cmd.Write([]byte{0x00, 0x30})             // C-ECHO-RQ
+	return false
+}
 
-	// Message ID (0000,0110)
-	cmd.Write([]byte{0x00, 0x00, 0x01, 0x10}) // Tag
-	cmd.Write([]byte{0x00, 0x02})             // Length
-	cmd.Write([]byte{0x00, 0x01})             // Message ID = 1
+// encode encodes the command set in Implicit VR Little Endian
+func (cmd *DIMSECommand) encode() []byte {
+	var elements bytes.Buffer
 
-	// Data Set Type (0000,0800)
-	cmd.Write([]byte{0x00, 0x00, 0x08, 0x00}) // Tag
-	cmd.Write([]byte{0x00, 0x02})             // Length
-	cmd.Write([]byte{0x01, 0x01})             // No Data Set Present
+	if cmd.AffectedSOPClass != "" {
+		writeCommandUID(&elements, elemAffectedSOPClassUID, cmd.AffectedSOPClass)
+	}
+	if cmd.RequestedSOPClass != "" {
+		writeCommandUID(&elements, elemRequestedSOPClassUID, cmd.RequestedSOPClass)
+	}
+	writeCommandUS(&elements, elemCommandField, cmd.CommandField)
+	if cmd.IsResponse() || cmd.CommandField == CommandCCancelRQ {
+		writeCommandUS(&elements, elemMessageIDBeingRespondedTo, cmd.MessageIDBeingRespondedTo)
+	} else {
+		writeCommandUS(&elements, elemMessageID, cmd.MessageID)
+	}
+	if cmd.CommandField == CommandCMoveRQ {
+		writeCommandAE(&elements, elemMoveDestination, cmd.MoveDestination)
+	}
+	if cmd.hasPriority() {
+		writeCommandUS(&elements, elemPriority, cmd.Priority)
+	}
+	writeCommandUS(&elements, elemCommandDataSetType, cmd.DataSetType)
+	if cmd.IsResponse() {
+		writeCommandUS(&elements, elemStatus, cmd.Status)
+		if len(cmd.OffendingElements) > 0 {
+			writeCommandAT(&elements, elemOffendingElement, cmd.OffendingElements)
+		}
+		if cmd.ErrorComment != "" {
+			writeCommandLO(&elements, elemErrorComment, cmd.ErrorComment)
+		}
+	}
+	if cmd.AffectedSOPInstance != "" {
+		writeCommandUID(&elements, elemAffectedSOPInstanceUID, cmd.AffectedSOPInstance)
+	}
+	if cmd.RequestedSOPInstance != "" {
+		writeCommandUID(&elements, elemRequestedSOPInstanceUID, cmd.RequestedSOPInstance)
+	}
+	if cmd.CommandField == CommandNEventReportRQ || (cmd.CommandField == CommandNEventReportRSP && cmd.EventTypeID != 0) {
+		writeCommandUS(&elements, elemEventTypeID, cmd.EventTypeID)
+	}
+	if cmd.CommandField == CommandNActionRQ || (cmd.CommandField == CommandNActionRSP && cmd.ActionTypeID != 0) {
+		writeCommandUS(&elements, elemActionTypeID, cmd.ActionTypeID)
+	}
+	if cmd.CommandField == CommandCGetRSP || cmd.CommandField == CommandCMoveRSP {
+		writeCommandUS(&elements, elemRemainingSubOperations, cmd.RemainingSubOps)
+		writeCommandUS(&elements, elemCompletedSubOperations, cmd.CompletedSubOps)
+		writeCommandUS(&elements, elemFailedSubOperations, cmd.FailedSubOps)
+		writeCommandUS(&elements, elemWarningSubOperations, cmd.WarningSubOps)
+	}
 
-	// Update Command Group Length
-	groupLength := uint32(cmd.Len() - 8) // Exclude group length element
-	binary.BigEndian.PutUint32(cmd.Bytes()[groupLengthPos:], groupLength)
+	// Command Group Length (0000,0000) precedes the other elements
+	groupLength := make([]byte, 4)
+	binary.LittleEndian.PutUint32(groupLength, uint32(elements.Len()))
 
-	// Send as P-DATA-TF PDU
-	return c.sendPDataTF(1, 0x03, cmd.Bytes()) // PC ID=1, Command+Last Fragment
+	var out bytes.Buffer
+	writeCommandElement(&out, elemCommandGroupLength, groupLength)
+	out.Write(elements.Bytes())
+	return out.Bytes()
 }
 
-// sendPDataTF sends a P-DATA-TF PDU with the given data
-func (c *Client) sendPDataTF(presentationContextID uint8, messageControlHeader uint8, data []byte) error {
-	var pdu bytes.Buffer
-
-	// P-DATA-TF PDU Header
-	pdu.WriteByte(PDUTypeDataTF) // PDU Type
-	pdu.WriteByte(0x00)          // Reserved
+// decodeCommand decodes an Implicit VR Little Endian command set
+func decodeCommand(data []byte) (*DIMSECommand, error) {
+	cmd := &DIMSECommand{}
+
+	for pos := 0; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, fmt.Errorf("truncated command element at offset %d", pos)
+		}
+		group := binary.LittleEndian.Uint16(data[pos:])
+		element := binary.LittleEndian.Uint16(data[pos+2:])
+		length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		pos += 8
+		if pos+length > len(data) {
+			return nil, fmt.Errorf("command element (%04X,%04X) exceeds command set", group, element)
+		}
+		value := data[pos : pos+length]
+		pos += length
+
+		if group != 0x0000 {
+			continue
+		}
+
+		switch element {
+		case elemAffectedSOPClassUID:
+			cmd.AffectedSOPClass = trimUID(value)
+		case elemCommandField:
+			cmd.CommandField = commandUS(value)
+		case elemMessageID:
+			cmd.MessageID = commandUS(value)
+		case elemMessageIDBeingRespondedTo:
+			cmd.MessageIDBeingRespondedTo = commandUS(value)
+		case elemPriority:
+			cmd.Priority = commandUS(value)
+		case elemCommandDataSetType:
+			cmd.DataSetType = commandUS(value)
+		case elemStatus:
+			cmd.Status = commandUS(value)
+		case elemOffendingElement:
+			cmd.OffendingElements = commandAT(value)
+		case elemErrorComment:
+			cmd.ErrorComment = string(bytes.TrimRight(value, "\x00 "))
+		case elemAffectedSOPInstanceUID:
+			cmd.AffectedSOPInstance = trimUID(value)
+		case elemRequestedSOPClassUID:
+			cmd.RequestedSOPClass = trimUID(value)
+		case elemRequestedSOPInstanceUID:
+			cmd.RequestedSOPInstance = trimUID(value)
+		case elemEventTypeID:
+			cmd.EventTypeID = commandUS(value)
+		case elemActionTypeID:
+			cmd.ActionTypeID = commandUS(value)
+		case elemMoveDestination:
+			cmd.MoveDestination = trimUID(value)
+		case elemRemainingSubOperations:
+			cmd.RemainingSubOps = commandUS(value)
+		case elemCompletedSubOperations:
+			cmd.CompletedSubOps = commandUS(value)
+		case elemFailedSubOperations:
+			cmd.FailedSubOps = commandUS(value)
+		case elemWarningSubOperations:
+			cmd.WarningSubOps = commandUS(value)
+		}
+	}
 
-	// Calculate lengths
-	pdvLength := uint32(2 + len(data)) // PDV header + data
-	pduLength := 4 + pdvLength         // PDV length field + PDV
+	return cmd, nil
+}
 
-	// PDU Length
-	pduLengthBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(pduLengthBytes, pduLength)
-	pdu.Write(pduLengthBytes)
+// writeMessage sends a command and optional dataset as P-DATA-TF PDUs
+func writeMessage(w io.Writer, contextID uint8, cmd *DIMSECommand, data []byte, maxPDULength uint32) error {
+	for _, body := range fragmentPDVs(contextID, true, cmd.encode(), maxPDULength) {
+		if err := writePDU(w, PDUTypeDataTF, body); err != nil {
+			return fmt.Errorf("failed to send command: %w", err)
+		}
+	}
 
-	// PDV Item Length
-	pdvLengthBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(pdvLengthBytes, pdvLength)
-	pdu.Write(pdvLengthBytes)
+	if cmd.HasDataSet() {
+		for _, body := range fragmentPDVs(contextID, false, data, maxPDULength) {
+			if err := writePDU(w, PDUTypeDataTF, body); err != nil {
+				return fmt.Errorf("failed to send dataset: %w", err)
+			}
+		}
+	}
 
-	// PDV Header
-	pdu.WriteByte(presentationContextID) // Presentation Context ID
-	pdu.WriteByte(messageControlHeader)  // Message Control Header
+	return nil
+}
 
-	// PDV Data
-	pdu.Write(data)
+// readMessage reads P-DATA-TF PDUs until a complete command, and its
+// dataset when one is announced, has been reassembled
+func readMessage(r io.Reader) (*message, error) {
+	var commandData, dataset bytes.Buffer
+	msg := &message{}
+
+	for {
+		pdu, err := readPDU(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch pdu.Type {
+		case PDUTypeDataTF:
+		case PDUTypeReleaseRQ:
+			return nil, errReleaseRequested
+		case PDUTypeAbortRQ:
+			return nil, errAborted
+		default:
+			return nil, fmt.Errorf("unexpected PDU type 0x%02X while awaiting P-DATA-TF", pdu.Type)
+		}
+
+		pdvs, err := parsePDVs(pdu.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pdv := range pdvs {
+			msg.ContextID = pdv.ContextID
+
+			if pdv.Command {
+				commandData.Write(pdv.Data)
+				if !pdv.Last {
+					continue
+				}
+				cmd, err := decodeCommand(commandData.Bytes())
+				if err != nil {
+					return nil, err
+				}
+				msg.Command = cmd
+				if !cmd.HasDataSet() {
+					return msg, nil
+				}
+				continue
+			}
+
+			if msg.Command == nil {
+				return nil, fmt.Errorf("received dataset fragment before command")
+			}
+			dataset.Write(pdv.Data)
+			if pdv.Last {
+				msg.Data = dataset.Bytes()
+				return msg, nil
+			}
+		}
+	}
+}
 
-	logrus.Debugf("Sending P-DATA-TF: %d bytes, PC=%d, MCH=0x%02X", pdu.Len(), presentationContextID, messageControlHeader)
+// sendCEcho sends a C-ECHO request and waits for the response
+func (c *Client) sendCEcho(ctx context.Context) error {
+	logrus.Info("Sending C-ECHO request")
 
-	// Send PDU
-	if _, err := c.conn.Write(pdu.Bytes()); err != nil {
-		return fmt.Errorf("failed to send P-DATA-TF: %w", err)
+	cmd := &DIMSECommand{
+		CommandField:     CommandCEchoRQ,
+		MessageID:        c.nextMessageID(),
+		AffectedSOPClass: SOPClassVerification,
+		DataSetType:      DataSetAbsent,
 	}
 
-	// Read response with timeout
-	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-
-	response := make([]byte, 4096)
-	n, err := c.conn.Read(response)
+	pc, err := c.selectPresentationContext(SOPClassVerification, ImplicitVRLittleEndian)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return err
+	}
+	if err := c.sendMessage(ctx, pc.ID, cmd, nil); err != nil {
+		return err
 	}
 
-	logrus.Debugf("Received response: %d bytes, PDU type: 0x%02X", n, response[0])
-
-	// Check response type
-	if response[0] == PDUTypeDataTF {
-		logrus.Info("Received P-DATA-TF response")
-		return nil
-	} else if response[0] == PDUTypeAbortRQ {
-		return fmt.Errorf("received A-ABORT from PACS")
-	} else {
-		return fmt.Errorf("unexpected response PDU type: 0x%02X", response[0])
+	rsp, err := c.receiveMessage(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read C-ECHO response: %w", err)
 	}
+
+	return c.parseDIMSEResponse(rsp, CommandCEchoRSP)
 }
 
-// sendCStore sends a C-STORE request using minimal DIMSE format
-func (c *Client) sendCStore(ctx context.Context, dicomData []byte, sopInstanceUID string, sopClassUID string) error {
+// sendCStore sends a C-STORE request and returns its message ID
+func (c *Client) sendCStore(ctx context.Context, contextID uint8, dataset []byte, sopInstanceUID string, sopClassUID string) (uint16, error) {
 	logrus.Infof("Sending C-STORE for SOP Instance: %s", sopInstanceUID)
 
-	// Find appropriate presentation context
-	contextID := c.findPresentationContext(sopClassUID)
-	if contextID == 0 {
-		contextID = 15 // Fallback to Secondary Capture
-		logrus.Warnf("Using fallback presentation context %d for SOP class %s", contextID, sopClassUID)
+	cmd := &DIMSECommand{
+		CommandField:        CommandCStoreRQ,
+		MessageID:           c.nextMessageID(),
+		AffectedSOPClass:    sopClassUID,
+		AffectedSOPInstance: sopInstanceUID,
+		DataSetType:         DataSetPresent,
 	}
 
-	// Build C-STORE command
-	var cmd bytes.Buffer
+	if err := c.sendMessage(ctx, contextID, cmd, dataset); err != nil {
+		return 0, err
+	}
+	return cmd.MessageID, nil
+}
 
-	// Command Group Length (0000,0000)
-	cmd.Write([]byte{0x00, 0x00, 0x00, 0x00}) // Tag
-	groupLengthPos := cmd.Len()
-	cmd.Write([]byte{0x00, 0x00, 0x00, 0x00}) // Length placeholder
+// writeCommandElement writes an Implicit VR Little Endian group 0000 element
+func writeCommandElement(buf *bytes.Buffer, element uint16, value []byte) {
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint16(header[2:], element)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(value)))
+	buf.Write(header)
+	buf.Write(value)
+}
 
-	// Affected SOP Class UID (0000,0002)
-	cmd.Write([]byte{0x00, 0x00, 0x00, 0x02}) // Tag
-	sopLen := len(sopClassUID)
-	if sopLen%2 == 1 {
-		sopClassUID += "\x00" // Pad to even length
-		sopLen++
+// writeCommandUID writes a UID element padded to even length
+func writeCommandUID(buf *bytes.Buffer, element uint16, uid string) {
+	value := []byte(uid)
+	if len(value)%2 == 1 {
+		value = append(value, 0x00)
 	}
-	cmd.Write([]byte{byte(sopLen >> 8), byte(sopLen)}) // Length
-	cmd.WriteString(sopClassUID)
+	writeCommandElement(buf, element, value)
+}
 
-	// Command Field (0000,0100)
-	cmd.Write([]byte{0x00, 0x00, 0x01, 0x00}) // Tag
-	cmd.Write([]byte{0x00, 0x02})             // Length
-	cmd.Write([]byte{0x00, 0x01})             // C-STORE-RQ
+// writeCommandAE writes an application entity title padded with spaces to even length
+func writeCommandAE(buf *bytes.Buffer, element uint16, ae string) {
+	value := []byte(ae)
+	if len(value)%2 == 1 {
+		value = append(value, ' ')
+	}
+	writeCommandElement(buf, element, value)
+}
 
-	// Message ID (0000,0110)
-	cmd.Write([]byte{0x00, 0x00, 0x01, 0x10}) // Tag
-	cmd.Write([]byte{0x00, 0x02})             // Length
-	cmd.Write([]byte{0x00, 0x01})             // Message ID = 1
+// writeCommandUS writes an unsigned short element
+func writeCommandUS(buf *bytes.Buffer, element uint16, v uint16) {
+	value := make([]byte, 2)
+	binary.LittleEndian.PutUint16(value, v)
+	writeCommandElement(buf, element, value)
+}
 
-	// Affected SOP Instance UID (0000,1000)
-	cmd.Write([]byte{0x00, 0x00, 0x10, 0x00}) // Tag
-	sopInstLen := len(sopInstanceUID)
-	if sopInstLen%2 == 1 {
-		sopInstanceUID += "\x00" // Pad to even length
-		sopInstLen++
+// writeCommandLO writes a long string element, truncated to 64 characters and padded with a space
+func writeCommandLO(buf *bytes.Buffer, element uint16, s string) {
+	if len(s) > 64 {
+		s = s[:64]
 	}
-	cmd.Write([]byte{byte(sopInstLen >> 8), byte(sopInstLen)}) // Length
-	cmd.WriteString(sopInstanceUID)
-
-	// Data Set Type (0000,0800)
-	cmd.Write([]byte{0x00, 0x00, 0x08, 0x00}) // Tag
-	cmd.Write([]byte{0x00, 0x02})             // Length
-	cmd.Write([]byte{0x00, 0x01})             // Data Set Present
+	value := []byte(s)
+	if len(value)%2 == 1 {
+		value = append(value, ' ')
+	}
+	writeCommandElement(buf, element, value)
+}
 
-	// Update Command Group Length
-	groupLength := uint32(cmd.Len() - 8)
-	binary.BigEndian.PutUint32(cmd.Bytes()[groupLengthPos:], groupLength)
+// writeCommandAT writes an attribute tag element holding one or more tags
+func writeCommandAT(buf *bytes.Buffer, element uint16, tags []dicom.Tag) {
+	value := make([]byte, 0, 4*len(tags))
+	for _, t := range tags {
+		value = binary.LittleEndian.AppendUint16(value, t.Group)
+		value = binary.LittleEndian.AppendUint16(value, t.Element)
+	}
+	writeCommandElement(buf, element, value)
+}
 
-	// Send command first
-	if err := c.sendPDataTF(contextID, 0x01, cmd.Bytes()); err != nil { // Command, not last
-		return fmt.Errorf("failed to send C-STORE command: %w", err)
+// commandAT decodes the tags of an attribute tag element
+func commandAT(value []byte) []dicom.Tag {
+	tags := make([]dicom.Tag, 0, len(value)/4)
+	for pos := 0; pos+4 <= len(value); pos += 4 {
+		tags = append(tags, dicom.Tag{
+			Group:   binary.LittleEndian.Uint16(value[pos:]),
+			Element: binary.LittleEndian.Uint16(value[pos+2:]),
+		})
 	}
+	return tags
+}
 
-	// Send dataset
-	if err := c.sendPDataTF(contextID, 0x02, dicomData); err != nil { // Dataset, last fragment
-		return fmt.Errorf("failed to send C-STORE dataset: %w", err)
+// commandUS decodes an unsigned short value
+func commandUS(value []byte) uint16 {
+	if len(value) < 2 {
+		return 0
 	}
+	return binary.LittleEndian.Uint16(value)
+}
 
-	logrus.Info("C-STORE completed successfully")
-	return nil
+// trimUID strips the NUL or space padding of a UID value
+func trimUID(value []byte) string {
+	return string(bytes.TrimRight(value, "\x00 "))
 }
@@ -0,0 +1,150 @@
+package pacs
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+)
+
+// User Identity types (PS3.7 D.3.3.7)
+const (
+	UserIdentityUsername         uint8 = 1
+	UserIdentityUsernamePasscode uint8 = 2
+	UserIdentityKerberos         uint8 = 3
+	UserIdentitySAML             uint8 = 4
+	UserIdentityJWT              uint8 = 5
+)
+
+// UserIdentity is the User Identity sub-item of an association request
+type UserIdentity struct {
+	Type                      uint8
+	PositiveResponseRequested bool
+	PrimaryField              []byte // Username, or the Kerberos ticket, SAML assertion or JWT
+	SecondaryField            []byte // Passcode of a username and passcode identity
+}
+
+// NewUserIdentity builds the User Identity of a destination, or returns nil
+// when it has none. Without an explicit type it is inferred from the fields set.
+func NewUserIdentity(cfg config.IdentityConfig) (*UserIdentity, error) {
+	identityType := cfg.Type
+	if identityType == "" {
+		switch {
+		case cfg.Token != "":
+			identityType = "jwt"
+		case cfg.Password != "":
+			identityType = "username-password"
+		case cfg.Username != "":
+			identityType = "username"
+		default:
+			return nil, nil
+		}
+	}
+
+	identity := &UserIdentity{PositiveResponseRequested: cfg.PositiveResponse}
+	switch identityType {
+	case "username":
+		identity.Type = UserIdentityUsername
+		identity.PrimaryField = []byte(cfg.Username)
+	case "username-password":
+		identity.Type = UserIdentityUsernamePasscode
+		identity.PrimaryField = []byte(cfg.Username)
+		identity.SecondaryField = []byte(cfg.Password)
+	case "jwt":
+		identity.Type = UserIdentityJWT
+		identity.PrimaryField = []byte(cfg.Token)
+	default:
+		return nil, fmt.Errorf("unsupported user identity type %q (supported: username, username-password, jwt)", identityType)
+	}
+
+	if len(identity.PrimaryField) == 0 {
+		return nil, fmt.Errorf("%s user identity requires a %s", identityType, identity.primaryFieldName())
+	}
+	if identity.Type == UserIdentityUsernamePasscode && len(identity.SecondaryField) == 0 {
+		return nil, fmt.Errorf("username-password user identity requires a password")
+	}
+	if len(identity.PrimaryField) > 0xFFFF || len(identity.SecondaryField) > 0xFFFF {
+		return nil, fmt.Errorf("user identity fields are limited to 65535 bytes")
+	}
+	return identity, nil
+}
+
+// String describes the identity without its secrets
+func (u *UserIdentity) String() string {
+	switch u.Type {
+	case UserIdentityUsername, UserIdentityUsernamePasscode:
+		return fmt.Sprintf("%s %q", u.typeName(), u.PrimaryField)
+	default:
+		return fmt.Sprintf("%s (%d bytes)", u.typeName(), len(u.PrimaryField))
+	}
+}
+
+// Username returns the username of username identities, or an empty string
+func (u *UserIdentity) Username() string {
+	if u.Type == UserIdentityUsername || u.Type == UserIdentityUsernamePasscode {
+		return string(u.PrimaryField)
+	}
+	return ""
+}
+
+// typeName returns the name of the identity type
+func (u *UserIdentity) typeName() string {
+	switch u.Type {
+	case UserIdentityUsername:
+		return "username"
+	case UserIdentityUsernamePasscode:
+		return "username and passcode"
+	case UserIdentityKerberos:
+		return "Kerberos"
+	case UserIdentitySAML:
+		return "SAML"
+	case UserIdentityJWT:
+		return "JWT"
+	default:
+		return fmt.Sprintf("type %d", u.Type)
+	}
+}
+
+// primaryFieldName names the primary field of the identity type
+func (u *UserIdentity) primaryFieldName() string {
+	if u.Type == UserIdentityJWT {
+		return "token"
+	}
+	return "username"
+}
+
+// encode returns the body of the User Identity sub-item
+func (u *UserIdentity) encode() []byte {
+	data := []byte{u.Type, 0}
+	if u.PositiveResponseRequested {
+		data[1] = 1
+	}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(u.PrimaryField)))
+	data = append(data, u.PrimaryField...)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(u.SecondaryField)))
+	return append(data, u.SecondaryField...)
+}
+
+// decodeUserIdentity parses the body of a User Identity sub-item
+func decodeUserIdentity(data []byte) (*UserIdentity, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("user identity sub-item too short")
+	}
+	identity := &UserIdentity{Type: data[0], PositiveResponseRequested: data[1] == 1}
+
+	primaryLength := int(binary.BigEndian.Uint16(data[2:]))
+	if len(data) < 4+primaryLength+2 {
+		return nil, fmt.Errorf("user identity primary field truncated")
+	}
+	identity.PrimaryField = data[4 : 4+primaryLength]
+
+	rest := data[4+primaryLength:]
+	secondaryLength := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+secondaryLength {
+		return nil, fmt.Errorf("user identity secondary field truncated")
+	}
+	if secondaryLength > 0 {
+		identity.SecondaryField = rest[2 : 2+secondaryLength]
+	}
+	return identity, nil
+}
@@ -0,0 +1,138 @@
+package pacs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUserIdentity(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.IdentityConfig
+		expected *UserIdentity
+		wantErr  bool
+	}{
+		{"none", config.IdentityConfig{}, nil, false},
+		{"username", config.IdentityConfig{Username: "tech"},
+			&UserIdentity{Type: UserIdentityUsername, PrimaryField: []byte("tech")}, false},
+		{"username and passcode", config.IdentityConfig{Username: "tech", Password: "secret", PositiveResponse: true},
+			&UserIdentity{Type: UserIdentityUsernamePasscode, PositiveResponseRequested: true, PrimaryField: []byte("tech"), SecondaryField: []byte("secret")}, false},
+		{"jwt", config.IdentityConfig{Token: "eyJhbGciOi.e30.sig"},
+			&UserIdentity{Type: UserIdentityJWT, PrimaryField: []byte("eyJhbGciOi.e30.sig")}, false},
+		{"explicit type", config.IdentityConfig{Type: "username", Username: "tech", Password: "ignored"},
+			&UserIdentity{Type: UserIdentityUsername, PrimaryField: []byte("tech")}, false},
+		{"password without username", config.IdentityConfig{Password: "secret"}, nil, true},
+		{"jwt without token", config.IdentityConfig{Type: "jwt"}, nil, true},
+		{"username-password without password", config.IdentityConfig{Type: "username-password", Username: "tech"}, nil, true},
+		{"unsupported type", config.IdentityConfig{Type: "kerberos", Token: "ticket"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := NewUserIdentity(tt.cfg)
+			assert.Equal(t, tt.wantErr, err != nil, "error: %v", err)
+			assert.Equal(t, tt.expected, identity)
+		})
+	}
+}
+
+func TestUserIdentitySubItemRoundTrip(t *testing.T) {
+	identities := []*UserIdentity{
+		{Type: UserIdentityUsername, PrimaryField: []byte("tech")},
+		{Type: UserIdentityUsernamePasscode, PositiveResponseRequested: true, PrimaryField: []byte("tech"), SecondaryField: []byte("secret")},
+		{Type: UserIdentityJWT, PositiveResponseRequested: true, PrimaryField: []byte("eyJhbGciOi.e30.sig")},
+	}
+
+	for _, identity := range identities {
+		t.Run(identity.typeName(), func(t *testing.T) {
+			items, err := parseItems(buildUserInformation(userInformation{identity: identity}))
+			if !assert.NoError(t, err) || !assert.Len(t, items, 1) {
+				return
+			}
+			parsed, ok := parseUserIdentity(items[0].Data)
+			assert.True(t, ok)
+			assert.Equal(t, identity, parsed)
+
+			_, ok = parseUserIdentityResponse(items[0].Data)
+			assert.False(t, ok)
+		})
+	}
+
+	items, err := parseItems(buildUserInformation(userInformation{identityResponse: []byte("ticket")}))
+	if assert.NoError(t, err) {
+		response, ok := parseUserIdentityResponse(items[0].Data)
+		assert.True(t, ok)
+		assert.Equal(t, []byte("ticket"), response)
+	}
+}
+
+func TestServerVerifiesUserIdentity(t *testing.T) {
+	_, cfg := startServer(t, "TEST_SCP", t.TempDir(), func(s *Server) {
+		s.SetIdentityHandler(func(callingAE string, identity *UserIdentity) ([]byte, error) {
+			switch {
+			case identity == nil:
+				return nil, fmt.Errorf("user identity required")
+			case identity.Type == UserIdentityJWT && string(identity.PrimaryField) == "valid.jwt.token":
+				return []byte("session-42"), nil
+			case identity.Type == UserIdentityUsernamePasscode && identity.Username() == "tech" && string(identity.SecondaryField) == "secret":
+				return nil, nil
+			}
+			return nil, fmt.Errorf("invalid credentials for %s", identity)
+		})
+	})
+
+	tests := []struct {
+		name     string
+		identity config.IdentityConfig
+		response []byte
+		wantErr  bool
+	}{
+		{"username and passcode", config.IdentityConfig{Username: "tech", Password: "secret", PositiveResponse: true}, []byte{}, false},
+		{"without positive response", config.IdentityConfig{Username: "tech", Password: "secret"}, nil, false},
+		{"jwt with server response", config.IdentityConfig{Token: "valid.jwt.token", PositiveResponse: true}, []byte("session-42"), false},
+		{"wrong passcode", config.IdentityConfig{Username: "tech", Password: "guess"}, nil, true},
+		{"unknown token", config.IdentityConfig{Token: "forged.jwt.token"}, nil, true},
+		{"no identity", config.IdentityConfig{}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pacsConfig := *cfg
+			pacsConfig.Identity = tt.identity
+
+			client := NewClient(&pacsConfig)
+			err := client.Connect(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer client.Disconnect()
+			assert.Equal(t, tt.response, client.IdentityResponse())
+			assert.NoError(t, client.CEcho(context.Background()))
+		})
+	}
+}
+
+func TestClientRequiresIdentityAcknowledgement(t *testing.T) {
+	// The server does not verify identities, so it never acknowledges one
+	_, cfg := startServer(t, "TEST_SCP", t.TempDir())
+
+	cfg.Identity = config.IdentityConfig{Username: "tech"}
+	client := NewClient(cfg)
+	if assert.NoError(t, client.Connect(context.Background())) {
+		client.Disconnect()
+	}
+
+	cfg.Identity.PositiveResponse = true
+	err := NewClient(cfg).Connect(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "did not acknowledge")
+	}
+}
@@ -0,0 +1,230 @@
+package pacs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/sirupsen/logrus"
+)
+
+// Performed Procedure Step Status values
+const (
+	MPPSInProgress   = "IN PROGRESS"
+	MPPSCompleted    = "COMPLETED"
+	MPPSDiscontinued = "DISCONTINUED"
+)
+
+// PerformedSeries is a series acquired during a performed procedure step
+type PerformedSeries struct {
+	SeriesInstanceUID string
+	SeriesDescription string
+	ProtocolName      string
+	Instances         []ReferencedInstance
+}
+
+// PerformedProcedureStep holds the attributes of a Modality Performed
+// Procedure Step reported to the RIS or PACS
+type PerformedProcedureStep struct {
+	SOPInstanceUID string // Assigned by the peer on N-CREATE when empty
+	ID             string
+	Status         string
+	Description    string
+	StationAETitle string
+	StationName    string
+	StartTime      time.Time
+	EndTime        time.Time
+
+	PatientName      string
+	PatientID        string
+	PatientBirthDate string
+	PatientSex       string
+
+	StudyInstanceUID              string
+	StudyID                       string
+	AccessionNumber               string
+	RequestedProcedureID          string
+	RequestedProcedureDescription string
+	ScheduledProcedureStepID      string
+	Modality                      string
+
+	RetrieveAETitle string
+	Series          []PerformedSeries
+}
+
+// AddInstance records an instance acquired in a series of the step
+func (s *PerformedProcedureStep) AddInstance(seriesUID, seriesDescription string, instance ReferencedInstance) {
+	for i := range s.Series {
+		if s.Series[i].SeriesInstanceUID == seriesUID {
+			s.Series[i].Instances = append(s.Series[i].Instances, instance)
+			return
+		}
+	}
+	s.Series = append(s.Series, PerformedSeries{
+		SeriesInstanceUID: seriesUID,
+		SeriesDescription: seriesDescription,
+		ProtocolName:      seriesDescription,
+		Instances:         []ReferencedInstance{instance},
+	})
+}
+
+// CreatePerformedProcedureStep announces the step as IN PROGRESS with an N-CREATE request
+func (c *Client) CreatePerformedProcedureStep(ctx context.Context, step *PerformedProcedureStep) error {
+	step.Status = MPPSInProgress
+	if step.StartTime.IsZero() {
+		step.StartTime = time.Now()
+	}
+
+	logrus.Infof("Creating performed procedure step %s (IN PROGRESS)", step.ID)
+
+	cmd := &DIMSECommand{
+		CommandField:        CommandNCreateRQ,
+		MessageID:           c.nextMessageID(),
+		AffectedSOPClass:    SOPClassModalityPerformedProcedureStep,
+		AffectedSOPInstance: step.SOPInstanceUID,
+		DataSetType:         DataSetPresent,
+	}
+	rsp, err := c.performedProcedureStepRequest(ctx, cmd, step.createDataset(), CommandNCreateRSP)
+	if err != nil {
+		return fmt.Errorf("MPPS N-CREATE failed: %w", err)
+	}
+
+	if step.SOPInstanceUID == "" {
+		step.SOPInstanceUID = rsp.Command.AffectedSOPInstance
+	}
+	if step.SOPInstanceUID == "" {
+		return fmt.Errorf("MPPS N-CREATE response did not assign a SOP Instance UID")
+	}
+	return nil
+}
+
+// UpdatePerformedProcedureStep reports the final status of the step, with
+// the performed series and their instances, in an N-SET request
+func (c *Client) UpdatePerformedProcedureStep(ctx context.Context, step *PerformedProcedureStep, status string) error {
+	if step.SOPInstanceUID == "" {
+		return fmt.Errorf("performed procedure step has not been created")
+	}
+	step.Status = status
+	if step.EndTime.IsZero() {
+		step.EndTime = time.Now()
+	}
+
+	logrus.Infof("Setting performed procedure step %s to %s with %d series", step.ID, status, len(step.Series))
+
+	cmd := &DIMSECommand{
+		CommandField:         CommandNSetRQ,
+		MessageID:            c.nextMessageID(),
+		RequestedSOPClass:    SOPClassModalityPerformedProcedureStep,
+		RequestedSOPInstance: step.SOPInstanceUID,
+		DataSetType:          DataSetPresent,
+	}
+	if _, err := c.performedProcedureStepRequest(ctx, cmd, step.setDataset(), CommandNSetRSP); err != nil {
+		return fmt.Errorf("MPPS N-SET failed: %w", err)
+	}
+	return nil
+}
+
+// performedProcedureStepRequest sends an MPPS request with its dataset and reads the response
+func (c *Client) performedProcedureStepRequest(ctx context.Context, cmd *DIMSECommand, ds *dicom.Dataset, expected uint16) (*message, error) {
+	if !c.associated {
+		return nil, fmt.Errorf("not associated with PACS server")
+	}
+
+	pc, err := c.selectPresentationContext(SOPClassModalityPerformedProcedureStep, ExplicitVRLittleEndian)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := dicom.EncodeDataset(ds, pc.TransferSyntax)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode performed procedure step: %w", err)
+	}
+	if err := c.sendMessage(ctx, pc.ID, cmd, data); err != nil {
+		return nil, err
+	}
+
+	rsp, err := c.receiveMessage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := c.parseDIMSEResponse(rsp, expected); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// createDataset builds the N-CREATE attribute list of the step
+func (s *PerformedProcedureStep) createDataset() *dicom.Dataset {
+	scheduled := dicom.NewDataset()
+	scheduled.SetString(dicom.TagAccessionNumber, s.AccessionNumber)
+	scheduled.SetSequence(dicom.TagReferencedStudySequence, nil)
+	scheduled.SetString(dicom.TagStudyInstanceUID, s.StudyInstanceUID)
+	scheduled.SetString(dicom.TagRequestedProcedureDescription, s.RequestedProcedureDescription)
+	scheduled.SetString(dicom.TagScheduledProcedureStepDescription, "")
+	scheduled.SetSequence(dicom.TagScheduledProtocolCodeSequence, nil)
+	scheduled.SetString(dicom.TagScheduledProcedureStepID, s.ScheduledProcedureStepID)
+	scheduled.SetString(dicom.TagRequestedProcedureID, s.RequestedProcedureID)
+
+	ds := dicom.NewDataset()
+	ds.SetString(dicom.TagModality, s.Modality)
+	ds.SetSequence(dicom.TagProcedureCodeSequence, nil)
+	ds.SetSequence(dicom.TagReferencedPatientSequence, nil)
+	ds.SetString(dicom.TagPatientName, s.PatientName)
+	ds.SetString(dicom.TagPatientID, s.PatientID)
+	ds.SetString(dicom.TagPatientBirthDate, s.PatientBirthDate)
+	ds.SetString(dicom.TagPatientSex, s.PatientSex)
+	ds.SetString(dicom.TagStudyID, s.StudyID)
+	ds.SetString(dicom.TagPerformedStationAETitle, s.StationAETitle)
+	ds.SetString(dicom.TagPerformedStationName, s.StationName)
+	ds.SetString(dicom.TagPerformedLocation, "")
+	ds.SetString(dicom.TagPerformedProcedureStepStartDate, s.StartTime.Format("20060102"))
+	ds.SetString(dicom.TagPerformedProcedureStepStartTime, s.StartTime.Format("150405"))
+	ds.SetString(dicom.TagPerformedProcedureStepEndDate, "")
+	ds.SetString(dicom.TagPerformedProcedureStepEndTime, "")
+	ds.SetString(dicom.TagPerformedProcedureStepStatus, s.Status)
+	ds.SetString(dicom.TagPerformedProcedureStepID, s.ID)
+	ds.SetString(dicom.TagPerformedProcedureStepDescription, s.Description)
+	ds.SetString(dicom.TagPerformedProcedureTypeDescription, "")
+	ds.SetSequence(dicom.TagPerformedProtocolCodeSequence, nil)
+	ds.SetSequence(dicom.TagScheduledStepAttributesSequence, []*dicom.Dataset{scheduled})
+	ds.SetSequence(dicom.TagPerformedSeriesSequence, nil)
+	return ds
+}
+
+// setDataset builds the N-SET modification list that closes the step
+func (s *PerformedProcedureStep) setDataset() *dicom.Dataset {
+	series := make([]*dicom.Dataset, 0, len(s.Series))
+	for _, performed := range s.Series {
+		images := make([]*dicom.Dataset, 0, len(performed.Instances))
+		for _, instance := range performed.Instances {
+			image := dicom.NewDataset()
+			image.SetString(dicom.TagReferencedSOPClassUID, instance.SOPClassUID)
+			image.SetString(dicom.TagReferencedSOPInstanceUID, instance.SOPInstanceUID)
+			images = append(images, image)
+		}
+
+		protocol := performed.ProtocolName
+		if protocol == "" {
+			protocol = s.Modality
+		}
+
+		item := dicom.NewDataset()
+		item.SetString(dicom.TagRetrieveAETitle, s.RetrieveAETitle)
+		item.SetString(dicom.TagSeriesDescription, performed.SeriesDescription)
+		item.SetString(dicom.TagPerformingPhysicianName, "")
+		item.SetString(dicom.TagOperatorsName, "")
+		item.SetSequence(dicom.TagReferencedImageSequence, images)
+		item.SetString(dicom.TagProtocolName, protocol)
+		item.SetString(dicom.TagSeriesInstanceUID, performed.SeriesInstanceUID)
+		item.SetSequence(dicom.TagReferencedNonImageCompositeSOPSequence, nil)
+		series = append(series, item)
+	}
+
+	ds := dicom.NewDataset()
+	ds.SetString(dicom.TagPerformedProcedureStepEndDate, s.EndTime.Format("20060102"))
+	ds.SetString(dicom.TagPerformedProcedureStepEndTime, s.EndTime.Format("150405"))
+	ds.SetString(dicom.TagPerformedProcedureStepStatus, s.Status)
+	ds.SetSequence(dicom.TagPerformedSeriesSequence, series)
+	return ds
+}
@@ -0,0 +1,89 @@
+package pacs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPerformedProcedureStepCreateAndComplete(t *testing.T) {
+	client, peer := newPipeClient(SOPClassModalityPerformedProcedureStep, dicom.ExplicitVRLittleEndian)
+	defer peer.Close()
+
+	// respond answers an MPPS request after checking its dataset
+	respond := func(expected, rspField uint16, check func(cmd *DIMSECommand, ds *dicom.Dataset) bool) error {
+		req, err := readMessage(peer)
+		if err != nil {
+			return err
+		}
+		ds, err := dicom.ParseDataset(req.Data, dicom.ExplicitVRLittleEndian)
+		if err != nil {
+			return err
+		}
+		if req.Command.CommandField != expected || !check(req.Command, ds) {
+			return assert.AnError
+		}
+
+		rsp := &DIMSECommand{
+			CommandField:              rspField,
+			MessageIDBeingRespondedTo: req.Command.MessageID,
+			AffectedSOPClass:          SOPClassModalityPerformedProcedureStep,
+			AffectedSOPInstance:       req.Command.AffectedSOPInstance + req.Command.RequestedSOPInstance,
+			DataSetType:               DataSetAbsent,
+			Status:                    StatusSuccess,
+		}
+		return writeMessage(peer, req.ContextID, rsp, nil, 0)
+	}
+
+	peerErr := make(chan error, 1)
+	go func() {
+		err := respond(CommandNCreateRQ, CommandNCreateRSP, func(cmd *DIMSECommand, ds *dicom.Dataset) bool {
+			scheduled := ds.Sequence(dicom.TagScheduledStepAttributesSequence)
+			return cmd.AffectedSOPInstance == "1.2.3.9" &&
+				ds.String(dicom.TagPerformedProcedureStepStatus) == MPPSInProgress &&
+				ds.String(dicom.TagPatientID) == "PID001" &&
+				len(scheduled) == 1 && scheduled[0].String(dicom.TagAccessionNumber) == "ACC001"
+		})
+		if err != nil {
+			peerErr <- err
+			return
+		}
+
+		peerErr <- respond(CommandNSetRQ, CommandNSetRSP, func(cmd *DIMSECommand, ds *dicom.Dataset) bool {
+			series := ds.Sequence(dicom.TagPerformedSeriesSequence)
+			return cmd.RequestedSOPInstance == "1.2.3.9" &&
+				ds.String(dicom.TagPerformedProcedureStepStatus) == MPPSCompleted &&
+				len(series) == 2 &&
+				len(series[0].Sequence(dicom.TagReferencedImageSequence)) == 2 &&
+				series[1].String(dicom.TagSeriesInstanceUID) == "1.2.3.2"
+		})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	step := &PerformedProcedureStep{
+		SOPInstanceUID:   "1.2.3.9",
+		ID:               "PPS001",
+		PatientID:        "PID001",
+		StudyInstanceUID: "1.2.3",
+		AccessionNumber:  "ACC001",
+		Modality:         "CT",
+	}
+	if !assert.NoError(t, client.CreatePerformedProcedureStep(ctx, step)) {
+		return
+	}
+	assert.Equal(t, MPPSInProgress, step.Status)
+
+	step.AddInstance("1.2.3.1", "AXIAL", ReferencedInstance{SOPClassUID: SOPClassCTImageStorage, SOPInstanceUID: "1.2.3.1.1"})
+	step.AddInstance("1.2.3.1", "AXIAL", ReferencedInstance{SOPClassUID: SOPClassCTImageStorage, SOPInstanceUID: "1.2.3.1.2"})
+	step.AddInstance("1.2.3.2", "CORONAL", ReferencedInstance{SOPClassUID: SOPClassCTImageStorage, SOPInstanceUID: "1.2.3.2.1"})
+
+	assert.NoError(t, client.UpdatePerformedProcedureStep(ctx, step, MPPSCompleted))
+	assert.NoError(t, <-peerErr)
+	assert.Equal(t, MPPSCompleted, step.Status)
+	assert.False(t, step.EndTime.IsZero())
+}
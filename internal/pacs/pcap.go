@@ -0,0 +1,292 @@
+package pacs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// libpcap capture constants
+const (
+	pcapMagic        = 0xA1B2C3D4
+	pcapLinkEthernet = 1
+	pcapSnapLength   = 0x40000
+
+	// An IPv4 packet holds at most 65535 bytes including the IPv4 and TCP headers
+	pcapMaxSegment = 0xFFFF - 40
+)
+
+// TCP header flags
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+// pcapWriter writes traced associations as a libpcap capture. The capture
+// is synthesized from the bytes of the association, so TLS connections
+// appear as plain DICOM and the TCP framing carries no real segments.
+type pcapWriter struct {
+	file  *os.File
+	ipID  uint16
+	flows int
+	err   error
+}
+
+// pcapEndpoint is an IPv4 address and port of a synthetic TCP connection
+type pcapEndpoint struct {
+	ip   [4]byte
+	port uint16
+	mac  [6]byte
+}
+
+// pcapFlow is the synthetic TCP connection of a traced association
+type pcapFlow struct {
+	local, remote       pcapEndpoint
+	localSeq, remoteSeq uint32
+
+	// Bytes held back until the first PDU of each direction is complete, so
+	// the secrets of an A-ASSOCIATE-RQ or -AC can be masked
+	localHeld, remoteHeld     []byte
+	localPassed, remotePassed bool
+}
+
+// newPCAPWriter creates a capture file at path with an Ethernet link type
+func newPCAPWriter(path string) (*pcapWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pcap file: %w", err)
+	}
+
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header, pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:], 2) // Version 2.4
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLength)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkEthernet)
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write pcap header: %w", err)
+	}
+	return &pcapWriter{file: file}, nil
+}
+
+// Close closes the capture, returning the first write error
+func (w *pcapWriter) Close() error {
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// open starts a flow between the addresses of a connection with a TCP
+// handshake initiated by the requestor
+func (w *pcapWriter) open(local, remote net.Addr, requestor bool) *pcapFlow {
+	w.flows++
+	flow := &pcapFlow{
+		local:  w.endpoint(local, requestor),
+		remote: w.endpoint(remote, !requestor),
+	}
+
+	now := time.Now()
+	w.packet(flow, requestor, tcpSYN, nil, now)
+	w.packet(flow, !requestor, tcpSYN|tcpACK, nil, now)
+	w.packet(flow, requestor, tcpACK, nil, now)
+	return flow
+}
+
+// segment writes data sent from the local or the remote side of a flow.
+// The User Identity secrets of the association negotiation are masked.
+func (w *pcapWriter) segment(flow *pcapFlow, fromLocal bool, data []byte, ts time.Time) {
+	held, passed := &flow.localHeld, &flow.localPassed
+	if !fromLocal {
+		held, passed = &flow.remoteHeld, &flow.remotePassed
+	}
+	if !*passed {
+		*held = append(*held, data...)
+		if len(*held) < 6 {
+			return
+		}
+		length := binary.BigEndian.Uint32((*held)[2:])
+		negotiation := (*held)[0] == PDUTypeAssociationRQ || (*held)[0] == PDUTypeAssociationAC
+		if negotiation && length <= maxIncomingPDULength && uint32(len(*held)-6) < length {
+			return
+		}
+		if negotiation && uint32(len(*held)-6) >= length {
+			maskUserIdentity((*held)[6 : 6+length])
+		}
+		data, *held, *passed = *held, nil, true
+	}
+
+	for len(data) > 0 {
+		n := min(len(data), pcapMaxSegment)
+		w.packet(flow, fromLocal, tcpPSH|tcpACK, data[:n], ts)
+		data = data[n:]
+	}
+}
+
+// close ends a flow with a FIN from the local side
+func (w *pcapWriter) close(flow *pcapFlow) {
+	now := time.Now()
+	w.packet(flow, true, tcpFIN|tcpACK, nil, now)
+	w.packet(flow, false, tcpACK, nil, now)
+}
+
+// maskUserIdentity overwrites the passcode, token or server response of the
+// User Identity sub-items of an A-ASSOCIATE-RQ or -AC body in place, keeping
+// every length so the capture still decodes. Usernames are kept, as in the
+// JSON trace.
+func maskUserIdentity(body []byte) {
+	if len(body) < 68 {
+		return
+	}
+	items, err := parseItems(body[68:])
+	if err != nil {
+		return
+	}
+
+	mask := func(secret []byte) {
+		for i := range secret {
+			secret[i] = '*'
+		}
+	}
+	for _, it := range items {
+		if it.Type != ItemTypeUserInformation {
+			continue
+		}
+		subItems, err := parseItems(it.Data)
+		if err != nil {
+			return
+		}
+		for _, sub := range subItems {
+			switch sub.Type {
+			case ItemTypeUserIdentityRQ:
+				identity, err := decodeUserIdentity(sub.Data)
+				if err != nil {
+					continue
+				}
+				switch identity.Type {
+				case UserIdentityUsername:
+				case UserIdentityUsernamePasscode:
+					mask(identity.SecondaryField)
+				default:
+					mask(identity.PrimaryField)
+				}
+			case ItemTypeUserIdentityAC:
+				if len(sub.Data) >= 2 {
+					mask(sub.Data[2:])
+				}
+			}
+		}
+	}
+}
+
+// endpoint returns the IPv4 endpoint of an address. Addresses that are not
+// IPv4 TCP addresses are replaced by documentation addresses, with the
+// acceptor on the well-known DICOM port.
+func (w *pcapWriter) endpoint(addr net.Addr, requestor bool) pcapEndpoint {
+	var ep pcapEndpoint
+	if requestor {
+		ep = pcapEndpoint{ip: [4]byte{192, 0, 2, 1}, port: uint16(49152 + w.flows%16384)}
+	} else {
+		ep = pcapEndpoint{ip: [4]byte{192, 0, 2, 2}, port: 104}
+	}
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		if ip := tcpAddr.IP.To4(); ip != nil {
+			copy(ep.ip[:], ip)
+			ep.port = uint16(tcpAddr.Port)
+		}
+	}
+	ep.mac = [6]byte{0x02, 0, 0, 0, 0, ep.ip[3]}
+	if requestor {
+		ep.mac[4] = 1
+	}
+	return ep
+}
+
+// packet writes an Ethernet frame holding a TCP segment of the flow and
+// advances the sequence number of its sender
+func (w *pcapWriter) packet(flow *pcapFlow, fromLocal bool, flags uint8, payload []byte, ts time.Time) {
+	src, dst := &flow.local, &flow.remote
+	seq, ack := &flow.localSeq, &flow.remoteSeq
+	if !fromLocal {
+		src, dst = dst, src
+		seq, ack = ack, seq
+	}
+
+	frame := make([]byte, 14+20+20, 14+20+20+len(payload))
+	copy(frame[0:6], dst.mac[:])
+	copy(frame[6:12], src.mac[:])
+	binary.BigEndian.PutUint16(frame[12:], 0x0800) // IPv4
+
+	w.ipID++
+	ip := frame[14:34]
+	ip[0] = 0x45 // Version 4, 20-byte header
+	binary.BigEndian.PutUint16(ip[2:], uint16(40+len(payload)))
+	binary.BigEndian.PutUint16(ip[4:], w.ipID)
+	binary.BigEndian.PutUint16(ip[6:], 0x4000) // Don't fragment
+	ip[8] = 64                                 // TTL
+	ip[9] = 6                                  // TCP
+	copy(ip[12:16], src.ip[:])
+	copy(ip[16:20], dst.ip[:])
+	binary.BigEndian.PutUint16(ip[10:], checksum(0, ip))
+
+	tcp := frame[34:54]
+	binary.BigEndian.PutUint16(tcp[0:], src.port)
+	binary.BigEndian.PutUint16(tcp[2:], dst.port)
+	binary.BigEndian.PutUint32(tcp[4:], *seq)
+	if flags&tcpACK != 0 {
+		binary.BigEndian.PutUint32(tcp[8:], *ack)
+	}
+	tcp[12] = 5 << 4 // 20-byte header
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 0xFFFF) // Window
+	frame = append(frame, payload...)
+
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:4], src.ip[:])
+	copy(pseudo[4:8], dst.ip[:])
+	pseudo[9] = 6
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(20+len(payload)))
+	binary.BigEndian.PutUint16(tcp[16:], checksum(sum16(sum16(0, pseudo), frame[34:]), nil))
+
+	*seq += uint32(len(payload))
+	if flags&(tcpSYN|tcpFIN) != 0 {
+		*seq++
+	}
+
+	if w.err != nil {
+		return
+	}
+	record := make([]byte, 16, 16+len(frame))
+	binary.LittleEndian.PutUint32(record, uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))
+	if _, err := w.file.Write(append(record, frame...)); err != nil {
+		w.err = fmt.Errorf("failed to write pcap record: %w", err)
+	}
+}
+
+// sum16 adds data to a running ones' complement sum of 16-bit words
+func sum16(sum uint32, data []byte) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// checksum returns the Internet checksum of data added to a running sum
+func checksum(sum uint32, data []byte) uint16 {
+	sum = sum16(sum, data)
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
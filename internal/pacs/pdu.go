@@ -0,0 +1,326 @@
+package pacs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+)
+
+// Item types used in A-ASSOCIATE-RQ/AC PDUs
+const (
+	ItemTypeApplicationContext        = 0x10
+	ItemTypePresentationContextRQ     = 0x20
+	ItemTypePresentationContextAC     = 0x21
+	ItemTypeAbstractSyntax            = 0x30
+	ItemTypeTransferSyntax            = 0x40
+	ItemTypeUserInformation           = 0x50
+	ItemTypeMaximumLength             = 0x51
+	ItemTypeImplementationClassUID    = 0x52
+	ItemTypeAsyncOperationsWindow     = 0x53
+	ItemTypeRoleSelection             = 0x54
+	ItemTypeImplementationVersionName = 0x55
+	ItemTypeUserIdentityRQ            = 0x58
+	ItemTypeUserIdentityAC            = 0x59
+)
+
+// PDV message control header bits
+const (
+	pdvFlagCommand = 0x01
+	pdvFlagLast    = 0x02
+)
+
+// maxIncomingPDULength bounds the size of a PDU we are willing to buffer
+const maxIncomingPDULength = 64 * 1024 * 1024
+
+// PDU represents a DICOM Upper Layer protocol data unit
+type PDU struct {
+	Type uint8
+	Data []byte // PDU body following the 6-byte header
+}
+
+// PDV represents a Presentation Data Value item of a P-DATA-TF PDU
+type PDV struct {
+	ContextID uint8
+	Command   bool
+	Last      bool
+	Data      []byte
+}
+
+// item represents a variable item or sub-item of an association PDU
+type item struct {
+	Type uint8
+	Data []byte
+}
+
+// readPDU reads a complete PDU from r
+func readPDU(r io.Reader) (*PDU, error) {
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read PDU header: %w", err)
+	}
+
+	length := binary.BigEndian.Uint32(header[2:])
+	if length > maxIncomingPDULength {
+		return nil, fmt.Errorf("PDU length %d exceeds limit of %d bytes", length, maxIncomingPDULength)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("failed to read PDU body: %w", err)
+	}
+
+	return &PDU{Type: header[0], Data: data}, nil
+}
+
+// writePDU writes a PDU with the given type and body to w
+func writePDU(w io.Writer, pduType uint8, data []byte) error {
+	buf := make([]byte, 6, 6+len(data))
+	buf[0] = pduType
+	binary.BigEndian.PutUint32(buf[2:], uint32(len(data)))
+	buf = append(buf, data...)
+
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("failed to write PDU: %w", err)
+	}
+	return nil
+}
+
+// parsePDVs splits the body of a P-DATA-TF PDU into its PDV items
+func parsePDVs(data []byte) ([]PDV, error) {
+	var pdvs []PDV
+	for pos := 0; pos < len(data); {
+		if pos+6 > len(data) {
+			return nil, fmt.Errorf("truncated PDV item header")
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if length < 2 || pos+4+length > len(data) {
+			return nil, fmt.Errorf("invalid PDV item length %d", length)
+		}
+
+		control := data[pos+5]
+		pdvs = append(pdvs, PDV{
+			ContextID: data[pos+4],
+			Command:   control&pdvFlagCommand != 0,
+			Last:      control&pdvFlagLast != 0,
+			Data:      data[pos+6 : pos+4+length],
+		})
+		pos += 4 + length
+	}
+	return pdvs, nil
+}
+
+// fragmentPDVs splits a command or dataset into P-DATA-TF PDU bodies, each
+// holding a single PDV that fits within the peer's maximum PDU length
+func fragmentPDVs(contextID uint8, command bool, data []byte, maxPDULength uint32) [][]byte {
+	// A zero maximum length means the peer imposes no limit
+	if maxPDULength == 0 {
+		maxPDULength = MaxPDULength
+	}
+	// The PDV item header (length, context ID, control) takes 6 bytes of the PDU body
+	chunkSize := int(maxPDULength) - 6
+	if chunkSize < 1 {
+		chunkSize = MaxPDULength - 6
+	}
+
+	var bodies [][]byte
+	for offset := 0; ; offset += chunkSize {
+		end := offset + chunkSize
+		last := end >= len(data)
+		if last {
+			end = len(data)
+		}
+
+		control := byte(0)
+		if command {
+			control |= pdvFlagCommand
+		}
+		if last {
+			control |= pdvFlagLast
+		}
+
+		fragment := data[offset:end]
+		body := make([]byte, 6, 6+len(fragment))
+		binary.BigEndian.PutUint32(body, uint32(2+len(fragment)))
+		body[4] = contextID
+		body[5] = control
+		bodies = append(bodies, append(body, fragment...))
+
+		if last {
+			return bodies
+		}
+	}
+}
+
+// parseItems splits association PDU variable fields into items
+func parseItems(data []byte) ([]item, error) {
+	var items []item
+	for pos := 0; pos < len(data); {
+		if pos+4 > len(data) {
+			return nil, fmt.Errorf("truncated item header")
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if pos+4+length > len(data) {
+			return nil, fmt.Errorf("item 0x%02X length %d exceeds PDU", data[pos], length)
+		}
+		items = append(items, item{Type: data[pos], Data: data[pos+4 : pos+4+length]})
+		pos += 4 + length
+	}
+	return items, nil
+}
+
+// writeItem appends an item with a 2-byte length to buf
+func writeItem(buf *bytes.Buffer, itemType uint8, data []byte) {
+	buf.WriteByte(itemType)
+	buf.WriteByte(0x00)
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(data)))
+	buf.Write(length)
+	buf.Write(data)
+}
+
+// userInformation holds the optional sub-items of a User Information item
+type userInformation struct {
+	// scpRoles lists the SOP classes for which the SCP role is proposed or accepted
+	scpRoles []string
+
+	// Asynchronous Operations Window, sent when maxOpsInvoked is not 0
+	maxOpsInvoked   uint16
+	maxOpsPerformed uint16
+
+	// identity is the User Identity of a request; identityResponse is the
+	// server response of an acceptance, sent when not nil
+	identity         *UserIdentity
+	identityResponse []byte
+}
+
+// buildUserInformation builds the User Information item advertising our
+// maximum receive PDU length and implementation identification. A role
+// selection sub-item proposing the SCP role is added for each SOP class in
+// scpRoles, allowing the peer to send C-STORE requests for C-GET.
+func buildUserInformation(info userInformation) []byte {
+	var subItems bytes.Buffer
+
+	maxLength := make([]byte, 4)
+	binary.BigEndian.PutUint32(maxLength, MaxPDULength)
+	writeItem(&subItems, ItemTypeMaximumLength, maxLength)
+	writeItem(&subItems, ItemTypeImplementationClassUID, []byte(dicom.ImplementationClassUID))
+	if info.maxOpsInvoked != 0 {
+		window := make([]byte, 4)
+		binary.BigEndian.PutUint16(window, info.maxOpsInvoked)
+		binary.BigEndian.PutUint16(window[2:], info.maxOpsPerformed)
+		writeItem(&subItems, ItemTypeAsyncOperationsWindow, window)
+	}
+	for _, sopClass := range info.scpRoles {
+		role := make([]byte, 2, 4+len(sopClass))
+		binary.BigEndian.PutUint16(role, uint16(len(sopClass)))
+		role = append(role, sopClass...)
+		role = append(role, 0x00, 0x01) // SCU role not proposed, SCP role proposed
+		writeItem(&subItems, ItemTypeRoleSelection, role)
+	}
+	writeItem(&subItems, ItemTypeImplementationVersionName, []byte(dicom.ImplementationVersionName))
+	if info.identity != nil {
+		writeItem(&subItems, ItemTypeUserIdentityRQ, info.identity.encode())
+	}
+	if info.identityResponse != nil {
+		response := binary.BigEndian.AppendUint16(nil, uint16(len(info.identityResponse)))
+		writeItem(&subItems, ItemTypeUserIdentityAC, append(response, info.identityResponse...))
+	}
+
+	var userInfo bytes.Buffer
+	writeItem(&userInfo, ItemTypeUserInformation, subItems.Bytes())
+	return userInfo.Bytes()
+}
+
+// parseSCPRoles returns the SOP classes of the SCP/SCU Role Selection
+// sub-items of a User Information item that propose the SCP role
+func parseSCPRoles(userInfo []byte) []string {
+	subItems, err := parseItems(userInfo)
+	if err != nil {
+		return nil
+	}
+
+	var sopClasses []string
+	for _, sub := range subItems {
+		if sub.Type != ItemTypeRoleSelection || len(sub.Data) < 2 {
+			continue
+		}
+		uidLength := int(binary.BigEndian.Uint16(sub.Data))
+		if len(sub.Data) < 2+uidLength+2 {
+			continue
+		}
+		if sub.Data[2+uidLength+1] == 0x01 {
+			sopClasses = append(sopClasses, strings.TrimRight(string(sub.Data[2:2+uidLength]), "\x00 "))
+		}
+	}
+	return sopClasses
+}
+
+// parseAsyncOperationsWindow extracts the Asynchronous Operations Window
+// sub-item from a User Information item; ok is false when it is absent
+func parseAsyncOperationsWindow(userInfo []byte) (invoked, performed uint16, ok bool) {
+	subItems, err := parseItems(userInfo)
+	if err != nil {
+		return 0, 0, false
+	}
+	for _, sub := range subItems {
+		if sub.Type == ItemTypeAsyncOperationsWindow && len(sub.Data) == 4 {
+			return binary.BigEndian.Uint16(sub.Data), binary.BigEndian.Uint16(sub.Data[2:]), true
+		}
+	}
+	return 0, 0, false
+}
+
+// parseUserIdentity extracts the User Identity sub-item of a request's User
+// Information item; ok is false when it is absent or malformed
+func parseUserIdentity(userInfo []byte) (identity *UserIdentity, ok bool) {
+	subItems, err := parseItems(userInfo)
+	if err != nil {
+		return nil, false
+	}
+	for _, sub := range subItems {
+		if sub.Type != ItemTypeUserIdentityRQ {
+			continue
+		}
+		identity, err := decodeUserIdentity(sub.Data)
+		return identity, err == nil
+	}
+	return nil, false
+}
+
+// parseUserIdentityResponse extracts the server response of the User
+// Identity sub-item of an acceptance; ok is false when it is absent
+func parseUserIdentityResponse(userInfo []byte) (response []byte, ok bool) {
+	subItems, err := parseItems(userInfo)
+	if err != nil {
+		return nil, false
+	}
+	for _, sub := range subItems {
+		if sub.Type != ItemTypeUserIdentityAC || len(sub.Data) < 2 {
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(sub.Data))
+		if len(sub.Data) < 2+length {
+			return nil, false
+		}
+		return sub.Data[2 : 2+length], true
+	}
+	return nil, false
+}
+
+// parseMaxPDULength extracts the Maximum Length sub-item from a User Information item
+func parseMaxPDULength(userInfo []byte) (uint32, error) {
+	subItems, err := parseItems(userInfo)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user information item: %w", err)
+	}
+	for _, sub := range subItems {
+		if sub.Type == ItemTypeMaximumLength && len(sub.Data) == 4 {
+			return binary.BigEndian.Uint32(sub.Data), nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,59 @@
+package pacs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteReadMessageFragmentation(t *testing.T) {
+	data := make([]byte, 50000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	cmd := &DIMSECommand{
+		CommandField:        CommandCStoreRQ,
+		MessageID:           7,
+		AffectedSOPClass:    SOPClassMGImageStorage,
+		AffectedSOPInstance: "1.2.3.4.5",
+		DataSetType:         DataSetPresent,
+	}
+
+	var conn bytes.Buffer
+	assert.NoError(t, writeMessage(&conn, 9, cmd, data, 4096))
+
+	// Every PDU must respect the negotiated maximum length
+	wire := bytes.NewReader(conn.Bytes())
+	pdus := 0
+	for wire.Len() > 0 {
+		pdu, err := readPDU(wire)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.LessOrEqual(t, len(pdu.Data), 4096)
+		pdus++
+	}
+	assert.Greater(t, pdus, 10)
+
+	msg, err := readMessage(bytes.NewReader(conn.Bytes()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, uint8(9), msg.ContextID)
+	assert.Equal(t, cmd, msg.Command)
+	assert.Equal(t, data, msg.Data)
+}
+
+func TestParseMaxPDULength(t *testing.T) {
+	userInfo := buildUserInformation(userInformation{})
+	items, err := parseItems(userInfo)
+	if !assert.NoError(t, err) || !assert.Len(t, items, 1) {
+		return
+	}
+
+	maxLength, err := parseMaxPDULength(items[0].Data)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(MaxPDULength), maxLength)
+}
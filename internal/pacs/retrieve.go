@@ -0,0 +1,160 @@
+package pacs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/sirupsen/logrus"
+)
+
+// RetrieveResult summarises the sub-operations of a C-GET or C-MOVE
+type RetrieveResult struct {
+	Completed int
+	Failed    int
+	Warning   int
+	Status    uint16
+}
+
+// getSOPClass returns the C-GET SOP class of the information model
+func (m QueryModel) getSOPClass() string {
+	if m == PatientRoot {
+		return SOPClassPatientRootQueryRetrieveGet
+	}
+	return SOPClassStudyRootQueryRetrieveGet
+}
+
+// moveSOPClass returns the C-MOVE SOP class of the information model
+func (m QueryModel) moveSOPClass() string {
+	if m == PatientRoot {
+		return SOPClassPatientRootQueryRetrieveMove
+	}
+	return SOPClassStudyRootQueryRetrieveMove
+}
+
+// CGet retrieves the instances matching identifier over this association
+// using the Study Root model. Each received instance is passed to onStore.
+// EnableCGet must be called before Connect.
+func (c *Client) CGet(ctx context.Context, level QueryLevel, identifier *dicom.Dataset, onStore StoreHandler) (*RetrieveResult, error) {
+	if !c.cgetEnabled {
+		return nil, fmt.Errorf("C-GET failed: C-GET was not enabled before the association was negotiated")
+	}
+	result, err := c.retrieve(ctx, CommandCGetRQ, StudyRoot.getSOPClass(), level, identifier, "", onStore)
+	if err != nil {
+		return result, fmt.Errorf("C-GET failed: %w", err)
+	}
+	return result, nil
+}
+
+// CMove asks the peer to send the instances matching identifier to the
+// destination AE title using the Study Root model. The instances arrive on a
+// separate association, e.g. at a Server registered with the peer under destination.
+func (c *Client) CMove(ctx context.Context, level QueryLevel, identifier *dicom.Dataset, destination string) (*RetrieveResult, error) {
+	result, err := c.retrieve(ctx, CommandCMoveRQ, StudyRoot.moveSOPClass(), level, identifier, destination, nil)
+	if err != nil {
+		return result, fmt.Errorf("C-MOVE failed: %w", err)
+	}
+	return result, nil
+}
+
+// retrieve sends a C-GET or C-MOVE request and follows its responses until the
+// final one, answering C-STORE sub-operations received in between
+func (c *Client) retrieve(ctx context.Context, commandField uint16, sopClass string, level QueryLevel, identifier *dicom.Dataset, destination string, onStore StoreHandler) (*RetrieveResult, error) {
+	if !c.associated {
+		return nil, fmt.Errorf("not associated with PACS server")
+	}
+	if level == QueryLevelPatient {
+		return nil, fmt.Errorf("the Study Root model does not support PATIENT level retrieval")
+	}
+
+	pc, err := c.selectPresentationContext(sopClass, ExplicitVRLittleEndian)
+	if err != nil {
+		return nil, err
+	}
+
+	query := identifier.Clone()
+	query.SetString(dicom.TagQueryRetrieveLevel, string(level))
+	data, err := dicom.EncodeDataset(query, pc.TransferSyntax)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode identifier: %w", err)
+	}
+
+	cmd := &DIMSECommand{
+		CommandField:     commandField,
+		MessageID:        c.nextMessageID(),
+		AffectedSOPClass: sopClass,
+		MoveDestination:  destination,
+		DataSetType:      DataSetPresent,
+	}
+	if err := c.sendMessage(ctx, pc.ID, cmd, data); err != nil {
+		return nil, err
+	}
+
+	result := &RetrieveResult{}
+	for {
+		msg, err := c.receiveMessage(ctx)
+		if err != nil {
+			return result, fmt.Errorf("failed to read response: %w", err)
+		}
+
+		if msg.Command.CommandField == CommandCStoreRQ {
+			if err := c.handleStoreSubOperation(ctx, msg, onStore); err != nil {
+				return result, err
+			}
+			continue
+		}
+		if msg.Command.CommandField != commandField|0x8000 {
+			return result, fmt.Errorf("unexpected DIMSE command 0x%04X in response", msg.Command.CommandField)
+		}
+
+		rsp := msg.Command
+		result.Completed = int(rsp.CompletedSubOps)
+		result.Failed = int(rsp.FailedSubOps)
+		result.Warning = int(rsp.WarningSubOps)
+		result.Status = rsp.Status
+
+		switch rsp.Status {
+		case StatusPending:
+			logrus.Debugf("Retrieve pending: %d remaining, %d completed, %d failed",
+				rsp.RemainingSubOps, rsp.CompletedSubOps, rsp.FailedSubOps)
+		case StatusSuccess:
+			logrus.Infof("Retrieve completed: %d instances", result.Completed)
+			return result, nil
+		case StatusSubOpsWarning:
+			logrus.Warnf("Retrieve completed with %d failed and %d warning sub-operations", result.Failed, result.Warning)
+			return result, nil
+		case StatusCancel:
+			return result, fmt.Errorf("retrieve cancelled by peer")
+		default:
+			return result, fmt.Errorf("DIMSE status 0x%04X", rsp.Status)
+		}
+	}
+}
+
+// handleStoreSubOperation stores an instance sent by the peer during C-GET and
+// answers with a C-STORE response
+func (c *Client) handleStoreSubOperation(ctx context.Context, msg *message, onStore StoreHandler) error {
+	cmd := msg.Command
+	pc := c.presentationContext(msg.ContextID)
+	if pc == nil || !pc.Accepted() {
+		return fmt.Errorf("C-STORE received on unaccepted presentation context %d", msg.ContextID)
+	}
+
+	status := StatusSuccess
+	if onStore != nil {
+		if err := onStore(cmd.AffectedSOPClass, cmd.AffectedSOPInstance, pc.TransferSyntax, msg.Data); err != nil {
+			logrus.Errorf("Failed to store %s: %v", cmd.AffectedSOPInstance, err)
+			status = StatusCannotProcess
+		}
+	}
+
+	rsp := &DIMSECommand{
+		CommandField:              CommandCStoreRSP,
+		MessageIDBeingRespondedTo: cmd.MessageID,
+		AffectedSOPClass:          cmd.AffectedSOPClass,
+		AffectedSOPInstance:       cmd.AffectedSOPInstance,
+		DataSetType:               DataSetAbsent,
+		Status:                    status,
+	}
+	return c.sendMessage(ctx, msg.ContextID, rsp, nil)
+}
@@ -0,0 +1,480 @@
+package pacs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/sirupsen/logrus"
+)
+
+// Association reject reasons (service-user source)
+const (
+	rejectReasonNoReason         = 0x01
+	rejectReasonCalledAENotKnown = 0x07
+)
+
+// ServerConfig configures the built-in DICOM SCP
+type ServerConfig struct {
+	AETitle string      // Called AE title to accept; any title is accepted when empty
+	Timeout int         // Seconds to wait for the next PDU before dropping an association
+	TLS     *tls.Config // Serve DICOM over TLS when set
+}
+
+// StoreHandler receives the dataset of each C-STORE request, encoded with the
+// transfer syntax negotiated for its presentation context. A returned
+// *StatusError selects the failure status, otherwise 0xC000 is sent.
+type StoreHandler func(sopClassUID, sopInstanceUID, transferSyntaxUID string, dataset []byte) error
+
+// FindHandler returns the matches for a C-FIND identifier at a query level
+type FindHandler func(level QueryLevel, identifier *dicom.Dataset) ([]*dicom.Dataset, error)
+
+// IdentityHandler verifies the User Identity of an association request, which
+// is nil when the requester sent none, and returns the server response for
+// requests asking for a positive response. An error rejects the association.
+type IdentityHandler func(callingAE string, identity *UserIdentity) (response []byte, err error)
+
+// Server is a DICOM SCP accepting associations for verification and storage,
+// and for Query/Retrieve C-FIND when a find handler is set
+type Server struct {
+	config  *ServerConfig
+	onStore StoreHandler
+	onFind  FindHandler
+	onEvent CommitmentHandler
+	onUser  IdentityHandler
+
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// serverAssociation holds the negotiated state of an accepted association
+type serverAssociation struct {
+	conn         net.Conn
+	callingAE    string
+	contexts     map[uint8]*PresentationContext
+	maxPDULength uint32
+}
+
+// NewServer creates a new DICOM server that passes received instances to onStore
+func NewServer(cfg *ServerConfig, onStore StoreHandler) *Server {
+	return &Server{
+		config:  cfg,
+		onStore: onStore,
+	}
+}
+
+// SetFindHandler enables the Patient and Study Root C-FIND SOP classes.
+// It must be called before Serve.
+func (s *Server) SetFindHandler(onFind FindHandler) {
+	s.onFind = onFind
+}
+
+// SetCommitmentHandler enables receiving Storage Commitment N-EVENT-REPORTs,
+// which peers send on a new association when the requesting one was released.
+// It must be called before Serve.
+func (s *Server) SetCommitmentHandler(onEvent CommitmentHandler) {
+	s.onEvent = onEvent
+}
+
+// SetIdentityHandler makes the server verify the User Identity of each
+// association. It must be called before Serve.
+func (s *Server) SetIdentityHandler(onUser IdentityHandler) {
+	s.onUser = onUser
+}
+
+// Listen binds the server to a TCP address such as "127.0.0.1:11112"
+func (s *Server) Listen(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+	if s.config.TLS != nil {
+		listener = tls.NewListener(listener, s.config.TLS)
+	}
+	s.listener = listener
+	logrus.Infof("DICOM server %s listening on %s (TLS: %t)", s.config.AETitle, listener.Addr(), s.config.TLS != nil)
+	return nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Serve accepts associations until ctx is cancelled or the server is closed,
+// then waits for open associations to finish. Associations are recorded by
+// the tracer of ctx, if any.
+func (s *Server) Serve(ctx context.Context) error {
+	if s.listener == nil {
+		return fmt.Errorf("server is not listening")
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.listener.Close()
+	}()
+
+	tracer := TracerFromContext(ctx)
+	defer s.wg.Wait()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		if tracer != nil {
+			conn = tracer.wrap(conn, false)
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			if err := s.handleAssociation(conn); err != nil {
+				logrus.Warnf("Association from %s ended with error: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Close stops accepting new associations
+func (s *Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// handleAssociation negotiates an association and serves its DIMSE requests
+func (s *Server) handleAssociation(conn net.Conn) error {
+	s.setDeadline(conn)
+
+	pdu, err := readPDU(conn)
+	if err != nil {
+		return err
+	}
+	if pdu.Type != PDUTypeAssociationRQ {
+		writePDU(conn, PDUTypeAbortRQ, make([]byte, 4))
+		return fmt.Errorf("unexpected PDU type 0x%02X, expected A-ASSOCIATE-RQ", pdu.Type)
+	}
+
+	assoc, response, err := s.negotiate(pdu.Data)
+	if err != nil {
+		return err
+	}
+	assoc.conn = conn
+	if err := writePDU(conn, response.Type, response.Data); err != nil {
+		return err
+	}
+	if response.Type == PDUTypeAssociationRJ {
+		return fmt.Errorf("association rejected")
+	}
+
+	logrus.Infof("Accepted association from %s (%s)", assoc.callingAE, conn.RemoteAddr())
+
+	for {
+		s.setDeadline(conn)
+		msg, err := readMessage(conn)
+		switch {
+		case errors.Is(err, errReleaseRequested):
+			logrus.Infof("Association from %s released", assoc.callingAE)
+			return writePDU(conn, PDUTypeReleaseRP, make([]byte, 4))
+		case errors.Is(err, errAborted):
+			logrus.Infof("Association from %s aborted", assoc.callingAE)
+			return nil
+		case err != nil:
+			return err
+		}
+
+		if err := s.handleMessage(assoc, msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handleMessage dispatches a DIMSE request and sends its response
+func (s *Server) handleMessage(assoc *serverAssociation, msg *message) error {
+	cmd := msg.Command
+	rsp := &DIMSECommand{
+		CommandField:              cmd.CommandField | 0x8000,
+		MessageIDBeingRespondedTo: cmd.MessageID,
+		AffectedSOPClass:          cmd.AffectedSOPClass,
+		AffectedSOPInstance:       cmd.AffectedSOPInstance,
+		DataSetType:               DataSetAbsent,
+	}
+
+	pc := assoc.contexts[msg.ContextID]
+	if pc == nil {
+		return fmt.Errorf("message received on unaccepted presentation context %d", msg.ContextID)
+	}
+
+	switch cmd.CommandField {
+	case CommandCEchoRQ:
+		logrus.Infof("C-ECHO from %s", assoc.callingAE)
+		rsp.Status = StatusSuccess
+
+	case CommandCStoreRQ:
+		rsp.Status, rsp.ErrorComment = s.handleStore(assoc, pc, cmd, msg.Data)
+
+	case CommandCFindRQ:
+		status, err := s.handleFind(assoc, msg, pc)
+		if err != nil {
+			return err
+		}
+		rsp.Status = status
+
+	case CommandNEventReportRQ:
+		result, status := parseCommitmentReport(msg, pc.TransferSyntax)
+		if result != nil {
+			s.onEvent(assoc.callingAE, result)
+		}
+		rsp.EventTypeID = cmd.EventTypeID
+		rsp.Status = status
+
+	case CommandCCancelRQ:
+		// Matches are sent as soon as they are found, so there is nothing left to cancel
+		return nil
+
+	default:
+		logrus.Warnf("Unsupported DIMSE command 0x%04X from %s", cmd.CommandField, assoc.callingAE)
+		rsp.Status = 0x0211 // Unrecognized operation
+	}
+
+	return writeMessage(assoc.conn, msg.ContextID, rsp, nil, assoc.maxPDULength)
+}
+
+// handleStore passes a received instance to the store handler and returns
+// the C-STORE status with an error comment on failure
+func (s *Server) handleStore(assoc *serverAssociation, pc *PresentationContext, cmd *DIMSECommand, data []byte) (uint16, string) {
+	logrus.Infof("C-STORE from %s: %s", assoc.callingAE, cmd.AffectedSOPInstance)
+
+	if s.onStore == nil {
+		// Storage contexts are rejected without a handler, so this is never acknowledged
+		return StatusCannotProcess, "storage is not supported by this server"
+	}
+	if err := s.onStore(cmd.AffectedSOPClass, cmd.AffectedSOPInstance, pc.TransferSyntax, data); err != nil {
+		logrus.Errorf("Failed to store %s: %v", cmd.AffectedSOPInstance, err)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			return statusErr.Status.Code, statusErr.Status.ErrorComment
+		}
+		return StatusCannotProcess, err.Error()
+	}
+	return StatusSuccess, ""
+}
+
+// handleFind sends a pending C-FIND response for each match and returns the final status
+func (s *Server) handleFind(assoc *serverAssociation, msg *message, pc *PresentationContext) (uint16, error) {
+	cmd := msg.Command
+	identifier, err := dicom.ParseDataset(msg.Data, pc.TransferSyntax)
+	if err != nil {
+		logrus.Errorf("Invalid C-FIND identifier from %s: %v", assoc.callingAE, err)
+		return StatusIdentifierMismatch, nil
+	}
+
+	level := QueryLevel(identifier.String(dicom.TagQueryRetrieveLevel))
+	logrus.Infof("C-FIND from %s at %s level", assoc.callingAE, level)
+
+	matches, err := s.onFind(level, identifier)
+	if err != nil {
+		logrus.Errorf("C-FIND from %s failed: %v", assoc.callingAE, err)
+		return StatusCannotProcess, nil
+	}
+
+	for _, match := range matches {
+		data, err := dicom.EncodeDataset(match, pc.TransferSyntax)
+		if err != nil {
+			logrus.Errorf("Failed to encode C-FIND response: %v", err)
+			return StatusCannotProcess, nil
+		}
+		pending := &DIMSECommand{
+			CommandField:              CommandCFindRSP,
+			MessageIDBeingRespondedTo: cmd.MessageID,
+			AffectedSOPClass:          cmd.AffectedSOPClass,
+			DataSetType:               DataSetPresent,
+			Status:                    StatusPending,
+		}
+		if err := writeMessage(assoc.conn, msg.ContextID, pending, data, assoc.maxPDULength); err != nil {
+			return 0, err
+		}
+	}
+
+	logrus.Infof("C-FIND from %s returned %d matches", assoc.callingAE, len(matches))
+	return StatusSuccess, nil
+}
+
+// negotiate parses an A-ASSOCIATE-RQ and builds the A-ASSOCIATE-AC or -RJ response
+func (s *Server) negotiate(data []byte) (*serverAssociation, *PDU, error) {
+	// Protocol version, reserved, AE titles and reserved field precede the items
+	if len(data) < 68 {
+		return nil, nil, fmt.Errorf("association request too short")
+	}
+
+	calledAE := strings.TrimSpace(string(data[4:20]))
+	callingAE := strings.TrimSpace(string(data[20:36]))
+	assoc := &serverAssociation{
+		callingAE: callingAE,
+		contexts:  make(map[uint8]*PresentationContext),
+	}
+
+	if s.config.AETitle != "" && calledAE != s.config.AETitle {
+		logrus.Warnf("Rejecting association from %s: called AE %q is not %q", callingAE, calledAE, s.config.AETitle)
+		return assoc, &PDU{Type: PDUTypeAssociationRJ, Data: []byte{0x00, 0x01, 0x01, rejectReasonCalledAENotKnown}}, nil
+	}
+
+	items, err := parseItems(data[68:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid association request: %w", err)
+	}
+
+	var body bytes.Buffer
+	body.Write(data[:68]) // Echo protocol version and AE titles
+	writeItem(&body, ItemTypeApplicationContext, []byte(ApplicationContextName))
+
+	accepted := 0
+	var info userInformation
+	var identity *UserIdentity
+	for _, it := range items {
+		switch it.Type {
+		case ItemTypePresentationContextRQ:
+			pc, err := parsePresentationContextRQ(it.Data)
+			if err != nil {
+				return nil, nil, err
+			}
+			s.negotiateContext(pc)
+			if pc.Accepted() {
+				assoc.contexts[pc.ID] = pc
+				accepted++
+			}
+
+			result := []byte{pc.ID, 0x00, pc.Result, 0x00}
+			var ts bytes.Buffer
+			writeItem(&ts, ItemTypeTransferSyntax, []byte(pc.TransferSyntax))
+			writeItem(&body, ItemTypePresentationContextAC, append(result, ts.Bytes()...))
+
+		case ItemTypeUserInformation:
+			maxLength, err := parseMaxPDULength(it.Data)
+			if err != nil {
+				return nil, nil, err
+			}
+			assoc.maxPDULength = maxLength
+
+			// Accept the SCP role the requester proposes for supported SOP classes
+			for _, sopClass := range parseSCPRoles(it.Data) {
+				if s.supportsAbstractSyntax(sopClass) {
+					info.scpRoles = append(info.scpRoles, sopClass)
+				}
+			}
+
+			// Requests are handled in order as they arrive, so any window the
+			// requester proposes can be accepted; we never invoke operations
+			if invoked, _, ok := parseAsyncOperationsWindow(it.Data); ok {
+				info.maxOpsInvoked = invoked
+				info.maxOpsPerformed = 1
+			}
+
+			identity, _ = parseUserIdentity(it.Data)
+		}
+	}
+
+	if accepted == 0 {
+		logrus.Warnf("Rejecting association from %s: no acceptable presentation contexts", callingAE)
+		return assoc, &PDU{Type: PDUTypeAssociationRJ, Data: []byte{0x00, 0x01, 0x01, rejectReasonNoReason}}, nil
+	}
+
+	if s.onUser != nil {
+		response, err := s.onUser(callingAE, identity)
+		if err != nil {
+			logrus.Warnf("Rejecting association from %s: user identity not accepted: %v", callingAE, err)
+			return assoc, &PDU{Type: PDUTypeAssociationRJ, Data: []byte{0x00, 0x01, 0x01, rejectReasonNoReason}}, nil
+		}
+		if identity != nil && identity.PositiveResponseRequested {
+			info.identityResponse = append([]byte{}, response...)
+		}
+	}
+
+	body.Write(buildUserInformation(info))
+	return assoc, &PDU{Type: PDUTypeAssociationAC, Data: body.Bytes()}, nil
+}
+
+// negotiateContext decides the result and transfer syntax of a proposed presentation context
+func (s *Server) negotiateContext(pc *PresentationContext) {
+	if !s.supportsAbstractSyntax(pc.AbstractSyntax) {
+		pc.Result = PresentationContextAbstractSyntaxNotSupported
+		return
+	}
+
+	for _, ts := range pc.TransferSyntaxes {
+		switch ts {
+		case ImplicitVRLittleEndian, ExplicitVRLittleEndian, ExplicitVRBigEndian:
+			pc.Result = PresentationContextAccepted
+			pc.TransferSyntax = ts
+			return
+		}
+		// Compressed images are stored as received
+		if isStorageSOPClass(pc.AbstractSyntax) && dicom.IsEncapsulatedTransferSyntax(ts) {
+			pc.Result = PresentationContextAccepted
+			pc.TransferSyntax = ts
+			return
+		}
+	}
+	pc.Result = PresentationContextTransferSyntaxNotSupported
+}
+
+// supportsAbstractSyntax reports whether the server provides a SOP class
+func (s *Server) supportsAbstractSyntax(sopClass string) bool {
+	switch sopClass {
+	case SOPClassVerification:
+		return true
+	case SOPClassPatientRootQueryRetrieveFind, SOPClassStudyRootQueryRetrieveFind:
+		return s.onFind != nil
+	case SOPClassStorageCommitmentPushModel:
+		return s.onEvent != nil
+	}
+	return s.onStore != nil && isStorageSOPClass(sopClass)
+}
+
+// setDeadline applies the configured inactivity timeout to a connection
+func (s *Server) setDeadline(conn net.Conn) {
+	timeout := time.Duration(s.config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+}
+
+// parsePresentationContextRQ parses a presentation context item of an A-ASSOCIATE-RQ
+func parsePresentationContextRQ(data []byte) (*PresentationContext, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("presentation context item too short")
+	}
+
+	subItems, err := parseItems(data[4:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid presentation context %d: %w", data[0], err)
+	}
+
+	pc := &PresentationContext{ID: data[0]}
+	for _, sub := range subItems {
+		value := strings.TrimRight(string(sub.Data), "\x00 ")
+		switch sub.Type {
+		case ItemTypeAbstractSyntax:
+			pc.AbstractSyntax = value
+		case ItemTypeTransferSyntax:
+			pc.TransferSyntaxes = append(pc.TransferSyntaxes, value)
+		}
+	}
+	return pc, nil
+}
@@ -0,0 +1,285 @@
+package pacs
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/stretchr/testify/assert"
+)
+
+// testInstance builds an encoded MR instance dataset
+func testInstance(studyUID, seriesUID, sopInstanceUID string) *dicom.Dataset {
+	ds := dicom.NewDataset()
+	ds.SetString(dicom.TagSOPClassUID, SOPClassMRImageStorage)
+	ds.SetString(dicom.TagSOPInstanceUID, sopInstanceUID)
+	ds.SetString(dicom.TagModality, "MR")
+	ds.SetString(dicom.TagPatientName, "TEST^PATIENT")
+	ds.SetString(dicom.TagStudyInstanceUID, studyUID)
+	ds.SetString(dicom.TagSeriesInstanceUID, seriesUID)
+	ds.SetString(dicom.TagInstanceNumber, "1")
+	return ds
+}
+
+// startServer starts a server on a random local port storing into dir,
+// applying the configure functions before it starts serving
+func startServer(t *testing.T, aeTitle, dir string, configure ...func(*Server)) (*Server, *config.PACSConfig) {
+	store := dicom.NewStudyStore(dir, "CRGODICOM")
+	server := NewServer(&ServerConfig{AETitle: aeTitle, Timeout: 5}, func(sopClass, sopInstance, ts string, data []byte) error {
+		_, err := store.Save(sopClass, sopInstance, ts, data)
+		return err
+	})
+	server.SetFindHandler(func(level QueryLevel, identifier *dicom.Dataset) ([]*dicom.Dataset, error) {
+		return store.Find(string(level), identifier)
+	})
+	for _, fn := range configure {
+		fn(server)
+	}
+	if !assert.NoError(t, server.Listen("127.0.0.1:0")) {
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		server.Serve(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	addr := server.Addr().(*net.TCPAddr)
+	return server, &config.PACSConfig{Host: "127.0.0.1", Port: addr.Port, AEC: "TEST_SCU", AET: aeTitle, Timeout: 5}
+}
+
+func TestServerEchoAndStore(t *testing.T) {
+	dir := t.TempDir()
+	_, cfg := startServer(t, "TEST_SCP", dir)
+
+	// Explicit VR Big Endian files are transcoded to the negotiated syntax
+	ds := testInstance("1.2.3", "1.2.3.1", "1.2.3.1.1")
+	encoded, err := dicom.EncodeDataset(ds, dicom.ExplicitVRBigEndian)
+	assert.NoError(t, err)
+	file, err := dicom.EncodeFile("CRGODICOM", SOPClassMRImageStorage, "1.2.3.1.1", dicom.ExplicitVRBigEndian, encoded)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	client := NewClient(cfg)
+	if !assert.NoError(t, client.Connect(ctx)) {
+		return
+	}
+	assert.NoError(t, client.CEcho(ctx))
+	_, err = client.CStore(ctx, file, "1.2.3.1.1")
+	assert.NoError(t, err)
+	assert.NoError(t, client.Disconnect())
+
+	stored, err := dicom.ReadFile(filepath.Join(dir, "1.2.3", "series_001", "image_001.dcm"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, dicom.ExplicitVRLittleEndian, stored.TransferSyntaxUID)
+	assert.Equal(t, "1.2.3.1.1", stored.SOPInstanceUID())
+	assert.Equal(t, "TEST^PATIENT", stored.Dataset.String(dicom.TagPatientName))
+}
+
+func TestServerAsyncOperationsWindow(t *testing.T) {
+	dir := t.TempDir()
+	_, cfg := startServer(t, "TEST_SCP", dir)
+
+	ctx := context.Background()
+	client := NewClient(cfg)
+	client.SetAsyncOperations(4)
+	if !assert.NoError(t, client.Connect(ctx)) {
+		return
+	}
+	defer client.Disconnect()
+	assert.Equal(t, 4, client.MaxOperations())
+
+	// Every request is sent before the first response is read
+	sops := []string{"1.2.3.1.1", "1.2.3.1.2", "1.2.3.1.3"}
+	pending := make(map[uint16]bool)
+	for _, sop := range sops {
+		encoded, err := dicom.EncodeDataset(testInstance("1.2.3", "1.2.3.1", sop), dicom.ExplicitVRLittleEndian)
+		assert.NoError(t, err)
+		file, err := dicom.EncodeFile("CRGODICOM", SOPClassMRImageStorage, sop, dicom.ExplicitVRLittleEndian, encoded)
+		assert.NoError(t, err)
+
+		messageID, err := client.CStoreAsync(ctx, file, sop)
+		if !assert.NoError(t, err) {
+			return
+		}
+		pending[messageID] = true
+	}
+
+	for range sops {
+		messageID, status, err := client.CStoreResponse(ctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, pending[messageID])
+		assert.Equal(t, CategorySuccess, status.Category())
+		delete(pending, messageID)
+	}
+	assert.Empty(t, pending)
+}
+
+func TestServerFind(t *testing.T) {
+	_, cfg := startServer(t, "TEST_SCP", t.TempDir())
+
+	ctx := context.Background()
+	client := NewClient(cfg)
+	if !assert.NoError(t, client.Connect(ctx)) {
+		return
+	}
+	defer client.Disconnect()
+
+	for _, sop := range []string{"1.2.3.1.1", "1.2.3.1.2", "1.2.3.2.1"} {
+		series := sop[:len(sop)-2]
+		encoded, err := dicom.EncodeDataset(testInstance("1.2.3", series, sop), dicom.ExplicitVRLittleEndian)
+		assert.NoError(t, err)
+		file, err := dicom.EncodeFile("CRGODICOM", SOPClassMRImageStorage, sop, dicom.ExplicitVRLittleEndian, encoded)
+		assert.NoError(t, err)
+		_, err = client.CStore(ctx, file, sop)
+		assert.NoError(t, err)
+	}
+
+	tests := []struct {
+		name        string
+		level       QueryLevel
+		patientName string
+		matches     int
+	}{
+		{"study wildcard", QueryLevelStudy, "TEST*", 1},
+		{"series", QueryLevelSeries, "", 2},
+		{"image", QueryLevelImage, "", 3},
+		{"no match", QueryLevelStudy, "OTHER^PATIENT", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identifier := dicom.NewDataset()
+			identifier.SetString(dicom.TagModalitiesInStudy, "")
+			identifier.SetString(dicom.TagPatientName, tt.patientName)
+			identifier.SetString(dicom.TagStudyInstanceUID, "1.2.3")
+			identifier.SetString(dicom.TagNumberOfStudyRelatedInstances, "")
+
+			var results []*dicom.Dataset
+			for result := range client.CFind(ctx, tt.level, identifier) {
+				if !assert.NoError(t, result.Err) {
+					return
+				}
+				results = append(results, result.Dataset)
+			}
+
+			assert.Len(t, results, tt.matches)
+			if tt.level == QueryLevelStudy && len(results) == 1 {
+				assert.Equal(t, "MR", results[0].String(dicom.TagModalitiesInStudy))
+				assert.Equal(t, 3, results[0].Int(dicom.TagNumberOfStudyRelatedInstances))
+				assert.Equal(t, "TEST^PATIENT", results[0].String(dicom.TagPatientName))
+			}
+		})
+	}
+}
+
+func TestServerRejectsUnknownCalledAE(t *testing.T) {
+	_, cfg := startServer(t, "TEST_SCP", t.TempDir())
+	cfg.AET = "SOMEONE_ELSE"
+
+	client := NewClient(cfg)
+	err := client.Connect(context.Background())
+	assert.Error(t, err)
+}
+
+func TestCGetStoresSubOperations(t *testing.T) {
+	client, peer := newPipeClient(SOPClassStudyRootQueryRetrieveGet, dicom.ExplicitVRLittleEndian)
+	defer peer.Close()
+	client.cgetEnabled = true
+	client.contexts = append(client.contexts, &PresentationContext{
+		ID:             3,
+		AbstractSyntax: SOPClassMRImageStorage,
+		TransferSyntax: dicom.ExplicitVRLittleEndian,
+	})
+
+	peerErr := make(chan error, 1)
+	go func() {
+		req, err := readMessage(peer)
+		if err != nil {
+			peerErr <- err
+			return
+		}
+
+		data, _ := dicom.EncodeDataset(testInstance("1.2.3", "1.2.3.1", "1.2.3.1.1"), dicom.ExplicitVRLittleEndian)
+		store := &DIMSECommand{
+			CommandField:        CommandCStoreRQ,
+			MessageID:           1,
+			AffectedSOPClass:    SOPClassMRImageStorage,
+			AffectedSOPInstance: "1.2.3.1.1",
+			DataSetType:         DataSetPresent,
+		}
+		if err := writeMessage(peer, 3, store, data, 0); err != nil {
+			peerErr <- err
+			return
+		}
+		if rsp, err := readMessage(peer); err != nil || rsp.Command.Status != StatusSuccess {
+			peerErr <- assert.AnError
+			return
+		}
+
+		final := &DIMSECommand{
+			CommandField:              CommandCGetRSP,
+			MessageIDBeingRespondedTo: req.Command.MessageID,
+			AffectedSOPClass:          SOPClassStudyRootQueryRetrieveGet,
+			DataSetType:               DataSetAbsent,
+			Status:                    StatusSuccess,
+			CompletedSubOps:           1,
+		}
+		peerErr <- writeMessage(peer, req.ContextID, final, nil, 0)
+	}()
+
+	var received []string
+	identifier := dicom.NewDataset()
+	identifier.SetString(dicom.TagStudyInstanceUID, "1.2.3")
+	result, err := client.CGet(context.Background(), QueryLevelStudy, identifier, func(sopClass, sopInstance, ts string, data []byte) error {
+		received = append(received, sopInstance)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, <-peerErr)
+	assert.Equal(t, []string{"1.2.3.1.1"}, received)
+	if assert.NotNil(t, result) {
+		assert.Equal(t, 1, result.Completed)
+	}
+}
+
+func TestServerNegotiatesOnlyHandledSOPClasses(t *testing.T) {
+	commitmentOnly := NewServer(&ServerConfig{AETitle: "COMMIT_SCU"}, nil)
+	commitmentOnly.SetCommitmentHandler(func(string, *CommitmentResult) {})
+	storage := NewServer(&ServerConfig{AETitle: "TEST_SCP"}, func(string, string, string, []byte) error { return nil })
+
+	tests := []struct {
+		name           string
+		server         *Server
+		abstractSyntax string
+		want           uint8
+	}{
+		{"storage without store handler", commitmentOnly, SOPClassMRImageStorage, PresentationContextAbstractSyntaxNotSupported},
+		{"commitment", commitmentOnly, SOPClassStorageCommitmentPushModel, PresentationContextAccepted},
+		{"verification", commitmentOnly, SOPClassVerification, PresentationContextAccepted},
+		{"storage", storage, SOPClassMRImageStorage, PresentationContextAccepted},
+		{"commitment without handler", storage, SOPClassStorageCommitmentPushModel, PresentationContextAbstractSyntaxNotSupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc := &PresentationContext{AbstractSyntax: tt.abstractSyntax, TransferSyntaxes: []string{ImplicitVRLittleEndian}}
+			tt.server.negotiateContext(pc)
+			assert.Equal(t, tt.want, pc.Result)
+		})
+	}
+}
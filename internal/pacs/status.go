@@ -0,0 +1,94 @@
+package pacs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+)
+
+// StatusCategory is the class of a DIMSE status as defined in PS3.7 Annex C
+type StatusCategory string
+
+// DIMSE status categories
+const (
+	CategorySuccess StatusCategory = "Success"
+	CategoryWarning StatusCategory = "Warning"
+	CategoryFailure StatusCategory = "Failure"
+	CategoryRefused StatusCategory = "Refused"
+	CategoryCancel  StatusCategory = "Cancel"
+	CategoryPending StatusCategory = "Pending"
+)
+
+// Status is the status of a DIMSE response with its optional error details
+type Status struct {
+	Code              uint16
+	ErrorComment      string
+	OffendingElements []dicom.Tag
+}
+
+// StatusError is returned for DIMSE responses with a Failure, Refused or Cancel status
+type StatusError struct {
+	Status Status
+}
+
+// Error returns the status code, category and error details
+func (e *StatusError) Error() string {
+	return "DIMSE status " + e.Status.String()
+}
+
+// ClassifyStatus returns the category of a DIMSE status code
+func ClassifyStatus(code uint16) StatusCategory {
+	switch {
+	case code == StatusSuccess:
+		return CategorySuccess
+	case code == StatusPending || code == StatusPendingWarning:
+		return CategoryPending
+	case code == StatusCancel:
+		return CategoryCancel
+	case code&0xF000 == 0xB000, code == 0x0001, code == 0x0107, code == 0x0116:
+		// Coercion, elements discarded, data set mismatch and attribute warnings
+		return CategoryWarning
+	case code&0xFF00 == StatusOutOfResources, code == StatusSOPClassNotSupported, code == StatusNotAuthorized:
+		// Refused: Out of Resources (PS3.4), SOP class not supported and not authorized
+		return CategoryRefused
+	default:
+		return CategoryFailure
+	}
+}
+
+// Category returns the category of the status code
+func (s Status) Category() StatusCategory {
+	return ClassifyStatus(s.Code)
+}
+
+// Retryable reports whether the operation may succeed when retried later.
+// Only Refused: Out of Resources (A7xx) is, as the peer is usually busy.
+func (s Status) Retryable() bool {
+	return s.Code&0xFF00 == StatusOutOfResources
+}
+
+// String formats the status as code, category and error details
+func (s Status) String() string {
+	text := fmt.Sprintf("0x%04X (%s)", s.Code, s.Category())
+	if s.ErrorComment != "" {
+		text += ": " + s.ErrorComment
+	}
+	if len(s.OffendingElements) > 0 {
+		tags := make([]string, len(s.OffendingElements))
+		for i, t := range s.OffendingElements {
+			tags[i] = t.String()
+		}
+		text += " [offending " + strings.Join(tags, " ") + "]"
+	}
+	return text
+}
+
+// status returns the status and error details of a response command
+func (cmd *DIMSECommand) status() Status {
+	return Status{
+		Code:              cmd.Status,
+		ErrorComment:      cmd.ErrorComment,
+		OffendingElements: cmd.OffendingElements,
+	}
+}
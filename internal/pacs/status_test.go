@@ -0,0 +1,143 @@
+package pacs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	tests := []struct {
+		code     uint16
+		expected StatusCategory
+	}{
+		{0x0000, CategorySuccess},
+		{0xB000, CategoryWarning}, // Coercion of data elements
+		{0xB006, CategoryWarning}, // Elements discarded
+		{0xB007, CategoryWarning}, // Data set does not match SOP class
+		{0xA700, CategoryRefused}, // Out of resources
+		{0xA702, CategoryRefused}, // Out of resources, unable to perform sub-operations
+		{0xA7FF, CategoryRefused},
+		{0xA900, CategoryFailure}, // Data set does not match SOP class
+		{0xC000, CategoryFailure}, // Cannot understand
+		{0xC123, CategoryFailure},
+		{0x0122, CategoryRefused}, // SOP class not supported
+		{0x0124, CategoryRefused}, // Not authorized
+		{0xFE00, CategoryCancel},
+		{0xFF00, CategoryPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("0x%04X", tt.code), func(t *testing.T) {
+			assert.Equal(t, tt.expected, ClassifyStatus(tt.code))
+		})
+	}
+}
+
+func TestStatusRetryable(t *testing.T) {
+	tests := []struct {
+		code      uint16
+		retryable bool
+	}{
+		{0xA700, true},
+		{0xA701, true},
+		{0xA7FF, true},
+		{0xA801, false}, // Move destination unknown
+		{0xA900, false},
+		{0xC000, false},
+		{0x0122, false},
+		{0x0124, false},
+		{0xB000, false},
+		{0x0000, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("0x%04X", tt.code), func(t *testing.T) {
+			assert.Equal(t, tt.retryable, Status{Code: tt.code}.Retryable())
+		})
+	}
+}
+
+func TestStatusErrorDetailsRoundTrip(t *testing.T) {
+	cmd := &DIMSECommand{
+		CommandField:              CommandCStoreRSP,
+		MessageIDBeingRespondedTo: 3,
+		AffectedSOPClass:          SOPClassCTImageStorage,
+		AffectedSOPInstance:       "1.2.3.4",
+		DataSetType:               DataSetAbsent,
+		Status:                    0xA900,
+		ErrorComment:              "Patient ID does not match",
+		OffendingElements:         []dicom.Tag{dicom.TagPatientID, dicom.TagPatientName},
+	}
+
+	var conn bytes.Buffer
+	assert.NoError(t, writeMessage(&conn, 1, cmd, nil, 0))
+	msg, err := readMessage(&conn)
+	if assert.NoError(t, err) {
+		assert.Equal(t, cmd, msg.Command)
+	}
+}
+
+func TestCStoreSurfacesStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   uint16
+		comment  string
+		wantErr  bool
+		category StatusCategory
+	}{
+		{"success", StatusSuccess, "", false, CategorySuccess},
+		{"coercion warning", 0xB000, "Patient name coerced", false, CategoryWarning},
+		{"rejected", 0xA900, "Data set does not match SOP class", true, CategoryFailure},
+		{"refused", StatusNotAuthorized, "", true, CategoryRefused},
+	}
+
+	ds := testInstance("1.2.3", "1.2.3.1", "1.2.3.1.1")
+	encoded, err := dicom.EncodeDataset(ds, dicom.ExplicitVRLittleEndian)
+	assert.NoError(t, err)
+	file, err := dicom.EncodeFile("CRGODICOM", SOPClassMRImageStorage, "1.2.3.1.1", dicom.ExplicitVRLittleEndian, encoded)
+	assert.NoError(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, peer := newPipeClient(SOPClassMRImageStorage, dicom.ExplicitVRLittleEndian)
+			defer peer.Close()
+
+			go func() {
+				req, err := readMessage(peer)
+				if err != nil {
+					return
+				}
+				rsp := &DIMSECommand{
+					CommandField:              CommandCStoreRSP,
+					MessageIDBeingRespondedTo: req.Command.MessageID,
+					AffectedSOPClass:          req.Command.AffectedSOPClass,
+					AffectedSOPInstance:       req.Command.AffectedSOPInstance,
+					DataSetType:               DataSetAbsent,
+					Status:                    tt.status,
+					ErrorComment:              tt.comment,
+				}
+				writeMessage(peer, req.ContextID, rsp, nil, 0)
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			status, err := client.CStore(ctx, file, "1.2.3.1.1")
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.category, status.Category())
+			assert.Equal(t, tt.comment, status.ErrorComment)
+
+			var statusErr *StatusError
+			if tt.wantErr && assert.True(t, errors.As(err, &statusErr)) {
+				assert.Equal(t, tt.status, statusErr.Status.Code)
+			}
+		})
+	}
+}
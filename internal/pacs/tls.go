@@ -0,0 +1,97 @@
+package pacs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+)
+
+// DefaultTLSPort is the port registered for DICOM over TLS
+const DefaultTLSPort = 2762
+
+// ClientTLSConfig builds the TLS configuration for connecting to host. The
+// server certificate is verified against the CA bundle, or the system roots
+// when none is given, and the client certificate is presented when set.
+func ClientTLSConfig(cfg config.TLSConfig, host string) (*tls.Config, error) {
+	minVersion, err := tlsVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: minVersion,
+		ServerName: host,
+	}
+	if cfg.ServerName != "" {
+		tlsConfig.ServerName = cfg.ServerName
+	}
+	if cfg.CAFile != "" {
+		if tlsConfig.RootCAs, err = loadCertPool(cfg.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// ServerTLSConfig builds the TLS configuration of an SCP. The certificate and
+// key are required; with a CA bundle, clients must present a certificate it signed.
+func ServerTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	minVersion, err := tlsVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("TLS server requires a certificate and key")
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS server certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   minVersion,
+		Certificates: []tls.Certificate{cert},
+	}
+	if cfg.CAFile != "" {
+		if tlsConfig.ClientCAs, err = loadCertPool(cfg.CAFile); err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// tlsVersion parses a minimum TLS version, defaulting to TLS 1.2
+func tlsVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS minimum version %q (supported: 1.2, 1.3)", version)
+	}
+}
+
+// loadCertPool reads a PEM bundle of CA certificates
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,152 @@
+package pacs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// testPKI holds the PEM files of a CA with a server and a client certificate
+type testPKI struct {
+	caFile, serverCert, serverKey, clientCert, clientKey string
+}
+
+// newTestPKI writes a CA, a server certificate for dicom.test and a client certificate to dir
+func newTestPKI(t *testing.T, dir string) testPKI {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test DICOM CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	pki := testPKI{caFile: filepath.Join(dir, "ca.pem")}
+	writePEM(t, pki.caFile, "CERTIFICATE", caDER)
+
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		assert.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		assert.NoError(t, err)
+
+		certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+		writePEM(t, certFile, "CERTIFICATE", der)
+		writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+		return certFile, keyFile
+	}
+	pki.serverCert, pki.serverKey = issue("dicom.test", 2, x509.ExtKeyUsageServerAuth)
+	pki.clientCert, pki.clientKey = issue("modality.test", 3, x509.ExtKeyUsageClientAuth)
+	return pki
+}
+
+// writePEM writes a single PEM block to path
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	assert.NoError(t, os.WriteFile(path, data, 0600))
+}
+
+func TestTLSAssociation(t *testing.T) {
+	pki := newTestPKI(t, t.TempDir())
+
+	// A TLS 1.2 stand-in that requires client certificates signed by the CA
+	serverTLS, err := ServerTLSConfig(config.TLSConfig{CAFile: pki.caFile, CertFile: pki.serverCert, KeyFile: pki.serverKey})
+	if !assert.NoError(t, err) {
+		return
+	}
+	serverTLS.MaxVersion = tls.VersionTLS12
+	_, cfg := startServer(t, "TEST_SCP", t.TempDir(), func(s *Server) {
+		s.config.TLS = serverTLS
+	})
+
+	valid := config.TLSConfig{
+		Enabled:    true,
+		CAFile:     pki.caFile,
+		CertFile:   pki.clientCert,
+		KeyFile:    pki.clientKey,
+		ServerName: "dicom.test",
+	}
+	tests := []struct {
+		name    string
+		tls     func(*config.TLSConfig)
+		wantErr bool
+	}{
+		{"mutual TLS", func(*config.TLSConfig) {}, false},
+		{"plain TCP", func(c *config.TLSConfig) { c.Enabled = false }, true},
+		{"untrusted server", func(c *config.TLSConfig) { c.CAFile = "" }, true},
+		{"server name mismatch", func(c *config.TLSConfig) { c.ServerName = "" }, true},
+		{"no client certificate", func(c *config.TLSConfig) { c.CertFile, c.KeyFile = "", "" }, true},
+		{"minimum version above the server", func(c *config.TLSConfig) { c.MinVersion = "1.3" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pacsConfig := *cfg
+			pacsConfig.TLS = valid
+			tt.tls(&pacsConfig.TLS)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			client := NewClient(&pacsConfig)
+			err := client.Connect(ctx)
+			if err == nil {
+				err = client.CEcho(ctx)
+				client.Disconnect()
+			}
+			assert.Equal(t, tt.wantErr, err != nil, "error: %v", err)
+		})
+	}
+}
+
+func TestTLSConfigValidation(t *testing.T) {
+	_, err := ClientTLSConfig(config.TLSConfig{MinVersion: "1.0"}, "localhost")
+	assert.Error(t, err)
+
+	_, err = ClientTLSConfig(config.TLSConfig{CAFile: "missing.pem"}, "localhost")
+	assert.Error(t, err)
+
+	_, err = ServerTLSConfig(config.TLSConfig{})
+	assert.Error(t, err)
+
+	tlsConfig, err := ClientTLSConfig(config.TLSConfig{MinVersion: "1.3"}, "pacs.local")
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+		assert.Equal(t, "pacs.local", tlsConfig.ServerName)
+	}
+}
@@ -0,0 +1,584 @@
+package pacs
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Tracer records every PDU sent and received on the associations it is
+// attached to as JSON Lines with decoded fields, and optionally as a libpcap
+// capture with synthetic TCP framing that Wireshark's DICOM dissector reads
+type Tracer struct {
+	mu           sync.Mutex
+	out          *os.File
+	enc          *json.Encoder
+	pcap         *pcapWriter
+	associations int
+	failed       bool
+}
+
+// traceRecord is a line of the JSON Lines trace: a PDU or a connection event
+type traceRecord struct {
+	Time        time.Time `json:"time"`
+	Association int       `json:"association"`
+	Event       string    `json:"event,omitempty"`
+	Role        string    `json:"role,omitempty"`
+	Local       string    `json:"local,omitempty"`
+	Remote      string    `json:"remote,omitempty"`
+	Direction   string    `json:"direction,omitempty"`
+
+	PDU     string `json:"pdu,omitempty"`
+	PDUType uint8  `json:"pdu_type,omitempty"`
+	Length  int    `json:"length,omitempty"`
+
+	// A-ASSOCIATE-RQ/AC fields
+	CalledAE             string                     `json:"called_ae,omitempty"`
+	CallingAE            string                     `json:"calling_ae,omitempty"`
+	ApplicationContext   string                     `json:"application_context,omitempty"`
+	PresentationContexts []tracePresentationContext `json:"presentation_contexts,omitempty"`
+	UserInformation      *traceUserInformation      `json:"user_information,omitempty"`
+
+	// A-ASSOCIATE-RJ and A-ABORT fields
+	Result *uint8 `json:"result,omitempty"`
+	Source *uint8 `json:"source,omitempty"`
+	Reason *uint8 `json:"reason,omitempty"`
+	Detail string `json:"detail,omitempty"`
+
+	// P-DATA-TF fields; commands are decoded from their last fragment
+	PDVs     []tracePDV     `json:"pdvs,omitempty"`
+	Commands []traceCommand `json:"commands,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// tracePresentationContext is a proposed or negotiated presentation context
+type tracePresentationContext struct {
+	ID               uint8    `json:"id"`
+	AbstractSyntax   string   `json:"abstract_syntax,omitempty"`
+	TransferSyntaxes []string `json:"transfer_syntaxes,omitempty"`
+	Result           string   `json:"result,omitempty"`
+}
+
+// traceUserInformation holds the decoded sub-items of a User Information item
+type traceUserInformation struct {
+	MaxPDULength              uint32   `json:"max_pdu_length"`
+	ImplementationClassUID    string   `json:"implementation_class_uid,omitempty"`
+	ImplementationVersionName string   `json:"implementation_version_name,omitempty"`
+	MaxOperationsInvoked      *uint16  `json:"max_operations_invoked,omitempty"`
+	MaxOperationsPerformed    *uint16  `json:"max_operations_performed,omitempty"`
+	SCPRoles                  []string `json:"scp_roles,omitempty"`
+	UserIdentity              string   `json:"user_identity,omitempty"`
+	UserIdentityResponse      bool     `json:"user_identity_response,omitempty"`
+}
+
+// tracePDV describes a Presentation Data Value item without its value
+type tracePDV struct {
+	ContextID uint8  `json:"context_id"`
+	Type      string `json:"type"`
+	Last      bool   `json:"last"`
+	Length    int    `json:"length"`
+}
+
+// traceCommand holds the decoded elements of a DIMSE command set
+type traceCommand struct {
+	ContextID                 uint8  `json:"context_id"`
+	Command                   string `json:"command"`
+	CommandField              string `json:"command_field"`
+	MessageID                 uint16 `json:"message_id,omitempty"`
+	MessageIDBeingRespondedTo uint16 `json:"message_id_being_responded_to,omitempty"`
+	AffectedSOPClass          string `json:"affected_sop_class,omitempty"`
+	AffectedSOPInstance       string `json:"affected_sop_instance,omitempty"`
+	RequestedSOPClass         string `json:"requested_sop_class,omitempty"`
+	RequestedSOPInstance      string `json:"requested_sop_instance,omitempty"`
+	MoveDestination           string `json:"move_destination,omitempty"`
+	DataSet                   bool   `json:"data_set"`
+	Status                    string `json:"status,omitempty"`
+	RemainingSubOperations    uint16 `json:"remaining_sub_operations,omitempty"`
+	CompletedSubOperations    uint16 `json:"completed_sub_operations,omitempty"`
+	FailedSubOperations       uint16 `json:"failed_sub_operations,omitempty"`
+	WarningSubOperations      uint16 `json:"warning_sub_operations,omitempty"`
+}
+
+// tracerKey is the context key of the tracer
+type tracerKey struct{}
+
+// NewTracer creates a tracer writing JSON Lines to path and a libpcap
+// capture to pcapPath; either may be empty to skip that output
+func NewTracer(path, pcapPath string) (*Tracer, error) {
+	t := &Tracer{}
+	if path != "" {
+		out, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create trace file: %w", err)
+		}
+		t.out = out
+		t.enc = json.NewEncoder(out)
+	}
+	if pcapPath != "" {
+		pcap, err := newPCAPWriter(pcapPath)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.pcap = pcap
+	}
+	return t, nil
+}
+
+// Close closes the trace files; a nil tracer is ignored
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var firstErr error
+	if t.out != nil {
+		firstErr = t.out.Close()
+		t.out, t.enc = nil, nil
+	}
+	if t.pcap != nil {
+		if err := t.pcap.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		t.pcap = nil
+	}
+	return firstErr
+}
+
+// WithTracer returns a context that makes clients connecting and servers
+// serving with it record their associations with t
+func WithTracer(ctx context.Context, t *Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// TracerFromContext returns the tracer of ctx, or nil when it has none
+func TracerFromContext(ctx context.Context) *Tracer {
+	t, _ := ctx.Value(tracerKey{}).(*Tracer)
+	return t
+}
+
+// wrap returns a connection recording its traffic. The requestor is the side
+// that opened the connection, which the pcap capture shows as the TCP client.
+func (t *Tracer) wrap(conn net.Conn, requestor bool) net.Conn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.associations++
+	tc := &traceConn{
+		Conn:        conn,
+		tracer:      t,
+		association: t.associations,
+		sent:        &traceStream{direction: "sent", commands: make(map[uint8][]byte)},
+		received:    &traceStream{direction: "received", commands: make(map[uint8][]byte)},
+	}
+
+	role := "acceptor"
+	if requestor {
+		role = "requestor"
+	}
+	t.write(&traceRecord{
+		Time:        time.Now(),
+		Association: tc.association,
+		Event:       "connect",
+		Role:        role,
+		Local:       conn.LocalAddr().String(),
+		Remote:      conn.RemoteAddr().String(),
+	})
+	if t.pcap != nil {
+		tc.flow = t.pcap.open(conn.LocalAddr(), conn.RemoteAddr(), requestor)
+	}
+	return tc
+}
+
+// write appends a record to the JSON Lines trace, warning once when it fails
+func (t *Tracer) write(record *traceRecord) {
+	if t.enc == nil || t.failed {
+		return
+	}
+	if err := t.enc.Encode(record); err != nil {
+		t.failed = true
+		logrus.Warnf("Failed to write protocol trace: %v", err)
+	}
+}
+
+// traceConn is a connection whose reads and writes are recorded by a tracer
+type traceConn struct {
+	net.Conn
+	tracer      *Tracer
+	association int
+	sent        *traceStream
+	received    *traceStream
+	flow        *pcapFlow
+	closed      bool
+}
+
+// Read records the bytes received from the peer
+func (tc *traceConn) Read(p []byte) (int, error) {
+	n, err := tc.Conn.Read(p)
+	if n > 0 {
+		tc.record(tc.received, p[:n])
+	}
+	return n, err
+}
+
+// Write records the bytes sent to the peer
+func (tc *traceConn) Write(p []byte) (int, error) {
+	n, err := tc.Conn.Write(p)
+	if n > 0 {
+		tc.record(tc.sent, p[:n])
+	}
+	return n, err
+}
+
+// Close records the end of the connection
+func (tc *traceConn) Close() error {
+	err := tc.Conn.Close()
+
+	t := tc.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !tc.closed {
+		tc.closed = true
+		t.write(&traceRecord{Time: time.Now(), Association: tc.association, Event: "close"})
+		if t.pcap != nil {
+			t.pcap.close(tc.flow)
+		}
+	}
+	return err
+}
+
+// record passes a chunk of the byte stream to the capture and decodes the
+// PDUs it completes
+func (tc *traceConn) record(stream *traceStream, data []byte) {
+	t := tc.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.pcap != nil {
+		t.pcap.segment(tc.flow, stream == tc.sent, data, now)
+	}
+	for _, record := range stream.feed(data) {
+		record.Time = now
+		record.Association = tc.association
+		t.write(record)
+	}
+}
+
+// traceStream reassembles the PDUs of one direction of a connection
+type traceStream struct {
+	direction string
+	buffer    []byte
+	broken    bool
+
+	// Command fragments awaiting their last PDV, by presentation context
+	commands map[uint8][]byte
+}
+
+// feed appends data to the stream and returns a record for each PDU completed
+func (s *traceStream) feed(data []byte) []*traceRecord {
+	if s.broken {
+		return nil
+	}
+	s.buffer = append(s.buffer, data...)
+
+	var records []*traceRecord
+	for len(s.buffer) >= 6 {
+		length := binary.BigEndian.Uint32(s.buffer[2:])
+		if length > maxIncomingPDULength {
+			s.broken = true
+			s.buffer = nil
+			return append(records, &traceRecord{
+				Direction: s.direction,
+				Error:     fmt.Sprintf("PDU length %d exceeds limit; trace of this direction stopped", length),
+			})
+		}
+		if uint32(len(s.buffer)-6) < length {
+			break
+		}
+		records = append(records, s.decode(s.buffer[0], s.buffer[6:6+length]))
+		s.buffer = s.buffer[6+length:]
+	}
+	if len(s.buffer) == 0 {
+		s.buffer = nil
+	}
+	return records
+}
+
+// decode describes a complete PDU
+func (s *traceStream) decode(pduType uint8, data []byte) *traceRecord {
+	record := &traceRecord{
+		Direction: s.direction,
+		PDU:       pduTypeName(pduType),
+		PDUType:   pduType,
+		Length:    6 + len(data),
+	}
+
+	var err error
+	switch pduType {
+	case PDUTypeAssociationRQ, PDUTypeAssociationAC:
+		err = decodeAssociationTrace(record, data)
+	case PDUTypeAssociationRJ:
+		if len(data) >= 4 {
+			result, source, reason := data[1], data[2], data[3]
+			record.Result, record.Source, record.Reason = &result, &source, &reason
+			record.Detail = rejectReasonString(data[2], data[3])
+		}
+	case PDUTypeAbortRQ:
+		if len(data) >= 4 {
+			source, reason := data[2], data[3]
+			record.Source, record.Reason = &source, &reason
+			record.Detail = abortReasonString(data[2], data[3])
+		}
+	case PDUTypeDataTF:
+		err = s.decodeData(record, data)
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// decodeAssociationTrace decodes the AE titles and items of an A-ASSOCIATE-RQ or -AC
+func decodeAssociationTrace(record *traceRecord, data []byte) error {
+	if len(data) < 68 {
+		return fmt.Errorf("association PDU too short")
+	}
+	record.CalledAE = strings.TrimSpace(string(data[4:20]))
+	record.CallingAE = strings.TrimSpace(string(data[20:36]))
+
+	items, err := parseItems(data[68:])
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		switch it.Type {
+		case ItemTypeApplicationContext:
+			record.ApplicationContext = strings.TrimRight(string(it.Data), "\x00 ")
+		case ItemTypePresentationContextRQ, ItemTypePresentationContextAC:
+			pc, err := parsePresentationContextRQ(it.Data)
+			if err != nil {
+				return err
+			}
+			traced := tracePresentationContext{
+				ID:               pc.ID,
+				AbstractSyntax:   pc.AbstractSyntax,
+				TransferSyntaxes: pc.TransferSyntaxes,
+			}
+			if it.Type == ItemTypePresentationContextAC {
+				traced.Result = presentationContextResultString(it.Data[2])
+			}
+			record.PresentationContexts = append(record.PresentationContexts, traced)
+		case ItemTypeUserInformation:
+			info, err := decodeUserInformationTrace(it.Data)
+			if err != nil {
+				return err
+			}
+			record.UserInformation = info
+		}
+	}
+	return nil
+}
+
+// decodeUserInformationTrace decodes the sub-items of a User Information
+// item; identities are described without their secrets
+func decodeUserInformationTrace(data []byte) (*traceUserInformation, error) {
+	subItems, err := parseItems(data)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &traceUserInformation{SCPRoles: parseSCPRoles(data)}
+	for _, sub := range subItems {
+		switch sub.Type {
+		case ItemTypeMaximumLength:
+			if len(sub.Data) == 4 {
+				info.MaxPDULength = binary.BigEndian.Uint32(sub.Data)
+			}
+		case ItemTypeImplementationClassUID:
+			info.ImplementationClassUID = strings.TrimRight(string(sub.Data), "\x00 ")
+		case ItemTypeImplementationVersionName:
+			info.ImplementationVersionName = strings.TrimRight(string(sub.Data), "\x00 ")
+		case ItemTypeUserIdentityAC:
+			info.UserIdentityResponse = true
+		}
+	}
+	if invoked, performed, ok := parseAsyncOperationsWindow(data); ok {
+		info.MaxOperationsInvoked, info.MaxOperationsPerformed = &invoked, &performed
+	}
+	if identity, ok := parseUserIdentity(data); ok {
+		info.UserIdentity = identity.String()
+	}
+	return info, nil
+}
+
+// decodeData describes the PDVs of a P-DATA-TF PDU and decodes the command
+// sets they complete
+func (s *traceStream) decodeData(record *traceRecord, data []byte) error {
+	pdvs, err := parsePDVs(data)
+	if err != nil {
+		return err
+	}
+
+	for _, pdv := range pdvs {
+		pdvType := "data-set"
+		if pdv.Command {
+			pdvType = "command"
+		}
+		record.PDVs = append(record.PDVs, tracePDV{
+			ContextID: pdv.ContextID,
+			Type:      pdvType,
+			Last:      pdv.Last,
+			Length:    len(pdv.Data),
+		})
+		if !pdv.Command {
+			continue
+		}
+
+		fragments := append(s.commands[pdv.ContextID], pdv.Data...)
+		if !pdv.Last {
+			s.commands[pdv.ContextID] = fragments
+			continue
+		}
+		delete(s.commands, pdv.ContextID)
+
+		cmd, err := decodeCommand(fragments)
+		if err != nil {
+			return fmt.Errorf("invalid command set on context %d: %w", pdv.ContextID, err)
+		}
+		record.Commands = append(record.Commands, newTraceCommand(pdv.ContextID, cmd))
+	}
+	return nil
+}
+
+// newTraceCommand describes a decoded DIMSE command
+func newTraceCommand(contextID uint8, cmd *DIMSECommand) traceCommand {
+	traced := traceCommand{
+		ContextID:                 contextID,
+		Command:                   commandName(cmd.CommandField),
+		CommandField:              fmt.Sprintf("0x%04X", cmd.CommandField),
+		MessageID:                 cmd.MessageID,
+		MessageIDBeingRespondedTo: cmd.MessageIDBeingRespondedTo,
+		AffectedSOPClass:          cmd.AffectedSOPClass,
+		AffectedSOPInstance:       cmd.AffectedSOPInstance,
+		RequestedSOPClass:         cmd.RequestedSOPClass,
+		RequestedSOPInstance:      cmd.RequestedSOPInstance,
+		MoveDestination:           cmd.MoveDestination,
+		DataSet:                   cmd.HasDataSet(),
+		RemainingSubOperations:    cmd.RemainingSubOps,
+		CompletedSubOperations:    cmd.CompletedSubOps,
+		FailedSubOperations:       cmd.FailedSubOps,
+		WarningSubOperations:      cmd.WarningSubOps,
+	}
+	if cmd.IsResponse() {
+		traced.Status = cmd.status().String()
+	}
+	return traced
+}
+
+// pduTypeName returns the name of a PDU type
+func pduTypeName(pduType uint8) string {
+	switch pduType {
+	case PDUTypeAssociationRQ:
+		return "A-ASSOCIATE-RQ"
+	case PDUTypeAssociationAC:
+		return "A-ASSOCIATE-AC"
+	case PDUTypeAssociationRJ:
+		return "A-ASSOCIATE-RJ"
+	case PDUTypeDataTF:
+		return "P-DATA-TF"
+	case PDUTypeReleaseRQ:
+		return "A-RELEASE-RQ"
+	case PDUTypeReleaseRP:
+		return "A-RELEASE-RP"
+	case PDUTypeAbortRQ:
+		return "A-ABORT"
+	default:
+		return fmt.Sprintf("unknown (0x%02X)", pduType)
+	}
+}
+
+// commandName returns the name of a DIMSE command field
+func commandName(field uint16) string {
+	names := map[uint16]string{
+		CommandCStoreRQ:        "C-STORE-RQ",
+		CommandCStoreRSP:       "C-STORE-RSP",
+		CommandCGetRQ:          "C-GET-RQ",
+		CommandCGetRSP:         "C-GET-RSP",
+		CommandCFindRQ:         "C-FIND-RQ",
+		CommandCFindRSP:        "C-FIND-RSP",
+		CommandCMoveRQ:         "C-MOVE-RQ",
+		CommandCMoveRSP:        "C-MOVE-RSP",
+		CommandCEchoRQ:         "C-ECHO-RQ",
+		CommandCEchoRSP:        "C-ECHO-RSP",
+		CommandCCancelRQ:       "C-CANCEL-RQ",
+		CommandNEventReportRQ:  "N-EVENT-REPORT-RQ",
+		CommandNEventReportRSP: "N-EVENT-REPORT-RSP",
+		CommandNSetRQ:          "N-SET-RQ",
+		CommandNSetRSP:         "N-SET-RSP",
+		CommandNActionRQ:       "N-ACTION-RQ",
+		CommandNActionRSP:      "N-ACTION-RSP",
+		CommandNCreateRQ:       "N-CREATE-RQ",
+		CommandNCreateRSP:      "N-CREATE-RSP",
+	}
+	if name, ok := names[field]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (0x%04X)", field)
+}
+
+// rejectReasonString describes the source and reason of an A-ASSOCIATE-RJ (PS3.8 9.3.4)
+func rejectReasonString(source, reason uint8) string {
+	switch {
+	case source == 1 && reason == 1, source == 2 && reason == 1:
+		return "no-reason-given"
+	case source == 1 && reason == 2:
+		return "application-context-name-not-supported"
+	case source == 1 && reason == 3:
+		return "calling-AE-title-not-recognized"
+	case source == 1 && reason == 7:
+		return "called-AE-title-not-recognized"
+	case source == 2 && reason == 2:
+		return "protocol-version-not-supported"
+	case source == 3 && reason == 1:
+		return "temporary-congestion"
+	case source == 3 && reason == 2:
+		return "local-limit-exceeded"
+	default:
+		return fmt.Sprintf("source %d reason %d", source, reason)
+	}
+}
+
+// abortReasonString describes the source and reason of an A-ABORT (PS3.8 9.3.8)
+func abortReasonString(source, reason uint8) string {
+	if source != 2 {
+		return "service-user abort"
+	}
+	switch reason {
+	case 0:
+		return "reason-not-specified"
+	case 1:
+		return "unrecognized-PDU"
+	case 2:
+		return "unexpected-PDU"
+	case 4:
+		return "unrecognized-PDU-parameter"
+	case 5:
+		return "unexpected-PDU-parameter"
+	case 6:
+		return "invalid-PDU-parameter-value"
+	default:
+		return fmt.Sprintf("service-provider reason %d", reason)
+	}
+}
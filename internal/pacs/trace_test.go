@@ -0,0 +1,218 @@
+package pacs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flatmapit/crgodicom/internal/config"
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/stretchr/testify/assert"
+)
+
+// readTrace returns the records of a JSON Lines trace
+func readTrace(t *testing.T, path string) []traceRecord {
+	file, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer file.Close()
+
+	var records []traceRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record traceRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestTraceAssociation(t *testing.T) {
+	dir := t.TempDir()
+	_, cfg := startServer(t, "TEST_SCP", t.TempDir())
+
+	tracePath, pcapPath := filepath.Join(dir, "trace.jsonl"), filepath.Join(dir, "trace.pcap")
+	tracer, err := NewTracer(tracePath, pcapPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	encoded, err := dicom.EncodeDataset(testInstance("1.2.3", "1.2.3.1", "1.2.3.1.1"), dicom.ExplicitVRLittleEndian)
+	assert.NoError(t, err)
+	file, err := dicom.EncodeFile("CRGODICOM", SOPClassMRImageStorage, "1.2.3.1.1", dicom.ExplicitVRLittleEndian, encoded)
+	assert.NoError(t, err)
+
+	ctx := WithTracer(context.Background(), tracer)
+	client := NewClient(cfg)
+	if !assert.NoError(t, client.Connect(ctx)) {
+		return
+	}
+	assert.NoError(t, client.CEcho(ctx))
+	_, err = client.CStore(ctx, file, "1.2.3.1.1")
+	assert.NoError(t, err)
+	assert.NoError(t, client.Disconnect())
+	assert.NoError(t, tracer.Close())
+
+	var pdus, commands []string
+	records := readTrace(t, tracePath)
+	for _, record := range records {
+		assert.Equal(t, 1, record.Association)
+		if record.PDU != "" {
+			pdus = append(pdus, record.Direction+" "+record.PDU)
+		}
+		for _, cmd := range record.Commands {
+			commands = append(commands, cmd.Command+" "+cmd.Status)
+		}
+	}
+	assert.Equal(t, []string{
+		"sent A-ASSOCIATE-RQ", "received A-ASSOCIATE-AC",
+		"sent P-DATA-TF", "received P-DATA-TF",
+		"sent P-DATA-TF", "sent P-DATA-TF", "received P-DATA-TF",
+		"sent A-RELEASE-RQ", "received A-RELEASE-RP",
+	}, pdus)
+	assert.Equal(t, []string{
+		"C-ECHO-RQ ", "C-ECHO-RSP 0x0000 (Success)",
+		"C-STORE-RQ ", "C-STORE-RSP 0x0000 (Success)",
+	}, commands)
+	assert.Equal(t, "connect", records[0].Event)
+	assert.Equal(t, "requestor", records[0].Role)
+	assert.Equal(t, "close", records[len(records)-1].Event)
+
+	request, accept := records[1], records[2]
+	assert.Equal(t, "TEST_SCP", request.CalledAE)
+	assert.Equal(t, cfg.AEC, request.CallingAE)
+	assert.Equal(t, ApplicationContextName, request.ApplicationContext)
+	assert.NotEmpty(t, request.PresentationContexts)
+	if assert.NotNil(t, request.UserInformation) {
+		assert.Equal(t, uint32(MaxPDULength), request.UserInformation.MaxPDULength)
+		assert.Equal(t, dicom.ImplementationClassUID, request.UserInformation.ImplementationClassUID)
+	}
+	assert.Len(t, accept.PresentationContexts, len(request.PresentationContexts))
+	assert.Equal(t, "acceptance", accept.PresentationContexts[0].Result)
+
+	store := records[5]
+	if assert.Len(t, store.Commands, 1) {
+		assert.Equal(t, "1.2.3.1.1", store.Commands[0].AffectedSOPInstance)
+		assert.Equal(t, SOPClassMRImageStorage, store.Commands[0].AffectedSOPClass)
+		assert.True(t, store.Commands[0].DataSet)
+	}
+	assert.Equal(t, "data-set", records[6].PDVs[0].Type)
+
+	// The capture replays the stream sent by the client after a TCP handshake
+	capture, err := os.ReadFile(pcapPath)
+	if !assert.NoError(t, err) || !assert.Greater(t, len(capture), 24) {
+		return
+	}
+	assert.Equal(t, uint32(pcapMagic), binary.LittleEndian.Uint32(capture))
+	assert.Equal(t, uint32(pcapLinkEthernet), binary.LittleEndian.Uint32(capture[20:]))
+
+	var flags []uint8
+	var sent []byte
+	for pos := 24; pos+16 <= len(capture); {
+		length := int(binary.LittleEndian.Uint32(capture[pos+8:]))
+		frame := capture[pos+16 : pos+16+length]
+		pos += 16 + length
+
+		assert.Equal(t, uint16(0), checksum(0, frame[14:34]), "IPv4 header checksum")
+		flags = append(flags, frame[47])
+		if binary.BigEndian.Uint16(frame[36:]) == uint16(cfg.Port) {
+			sent = append(sent, frame[54:]...)
+		}
+	}
+	assert.Equal(t, []uint8{tcpSYN, tcpSYN | tcpACK, tcpACK}, flags[:3])
+	assert.Equal(t, uint8(tcpFIN|tcpACK), flags[len(flags)-2])
+	if assert.NotEmpty(t, sent) {
+		assert.Equal(t, uint8(PDUTypeAssociationRQ), sent[0])
+		assert.Equal(t, uint8(PDUTypeReleaseRQ), sent[len(sent)-10])
+	}
+}
+
+func TestTraceStreamReassemblesPDUs(t *testing.T) {
+	var stream bytes.Buffer
+	cmd := &DIMSECommand{
+		CommandField:        CommandCStoreRQ,
+		MessageID:           7,
+		AffectedSOPClass:    SOPClassCTImageStorage,
+		AffectedSOPInstance: "1.2.3.4.5",
+		DataSetType:         DataSetPresent,
+	}
+	// A small maximum PDU length splits the command set across PDUs
+	assert.NoError(t, writeMessage(&stream, 3, cmd, []byte("dataset"), 32))
+	assert.NoError(t, writePDU(&stream, PDUTypeAssociationRJ, []byte{0, 1, 1, rejectReasonCalledAENotKnown}))
+	assert.NoError(t, writePDU(&stream, PDUTypeAbortRQ, []byte{0, 0, 2, 2}))
+
+	// Feeding one byte at a time exercises the reassembly of each PDU
+	traced := &traceStream{direction: "received", commands: make(map[uint8][]byte)}
+	var records []*traceRecord
+	for _, b := range stream.Bytes() {
+		records = append(records, traced.feed([]byte{b})...)
+	}
+
+	var commands []traceCommand
+	for _, record := range records[:len(records)-2] {
+		assert.Equal(t, "P-DATA-TF", record.PDU)
+		assert.Empty(t, record.Error)
+		commands = append(commands, record.Commands...)
+	}
+	if assert.Len(t, commands, 1) {
+		assert.Equal(t, "C-STORE-RQ", commands[0].Command)
+		assert.Equal(t, "0x0001", commands[0].CommandField)
+		assert.Equal(t, uint16(7), commands[0].MessageID)
+		assert.Equal(t, "1.2.3.4.5", commands[0].AffectedSOPInstance)
+		assert.Empty(t, commands[0].Status)
+	}
+
+	reject, abort := records[len(records)-2], records[len(records)-1]
+	assert.Equal(t, "A-ASSOCIATE-RJ", reject.PDU)
+	assert.Equal(t, "called-AE-title-not-recognized", reject.Detail)
+	assert.Equal(t, "A-ABORT", abort.PDU)
+	assert.Equal(t, "unexpected-PDU", abort.Detail)
+	assert.Empty(t, traced.buffer)
+}
+
+func TestTracePCAPMasksUserIdentity(t *testing.T) {
+	_, cfg := startServer(t, "TEST_SCP", t.TempDir(), func(s *Server) {
+		s.SetIdentityHandler(func(callingAE string, identity *UserIdentity) ([]byte, error) {
+			return []byte("session-42"), nil
+		})
+	})
+
+	for _, identity := range []config.IdentityConfig{
+		{Username: "tech", Password: "secret", PositiveResponse: true},
+		{Token: "valid.jwt.token", PositiveResponse: true},
+	} {
+		pcapPath := filepath.Join(t.TempDir(), "trace.pcap")
+		tracer, err := NewTracer("", pcapPath)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		pacsConfig := *cfg
+		pacsConfig.Identity = identity
+		ctx := WithTracer(context.Background(), tracer)
+		client := NewClient(&pacsConfig)
+		if assert.NoError(t, client.Connect(ctx)) {
+			assert.Equal(t, []byte("session-42"), client.IdentityResponse())
+			assert.NoError(t, client.CEcho(ctx))
+			client.Disconnect()
+		}
+		assert.NoError(t, tracer.Close())
+
+		capture, err := os.ReadFile(pcapPath)
+		assert.NoError(t, err)
+		for _, secret := range []string{"secret", "valid.jwt.token", "session-42"} {
+			assert.False(t, bytes.Contains(capture, []byte(secret)), "capture contains %q", secret)
+		}
+		// Masked fields keep their length, and usernames are kept
+		assert.True(t, bytes.Contains(capture, []byte("**********")))
+		if identity.Username != "" {
+			assert.True(t, bytes.Contains(capture, []byte("\x00\x04tech\x00\x06******")))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package pacs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/sirupsen/logrus"
+)
+
+// WorklistQuery holds the matching keys of a Modality Worklist query.
+// Empty keys match every scheduled procedure step.
+type WorklistQuery struct {
+	ScheduledStationAETitle string
+	Modality                string
+	ScheduledDate           string // Single date or range such as 20250101-20250131
+	PatientID               string
+	AccessionNumber         string
+}
+
+// WorklistItem is a single scheduled procedure step returned by a worklist SCP
+type WorklistItem struct {
+	PatientName                       string
+	PatientID                         string
+	PatientBirthDate                  string
+	PatientSex                        string
+	AccessionNumber                   string
+	StudyInstanceUID                  string
+	RequestedProcedureID              string
+	RequestedProcedureDescription     string
+	ScheduledProcedureStepID          string
+	ScheduledProcedureStepDescription string
+	ScheduledStationAETitle           string
+	ScheduledStartDate                string
+	ScheduledStartTime                string
+	Modality                          string
+}
+
+// FindWorklist queries the Modality Worklist and returns one item per
+// scheduled procedure step of the matching requested procedures
+func (c *Client) FindWorklist(ctx context.Context, query WorklistQuery) ([]WorklistItem, error) {
+	identifier := worklistIdentifier(query)
+
+	logrus.Info("Sending Modality Worklist C-FIND request")
+
+	var items []WorklistItem
+	err := c.find(ctx, SOPClassModalityWorklistFind, identifier, func(ds *dicom.Dataset) error {
+		items = append(items, worklistItems(ds)...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("worklist C-FIND failed: %w", err)
+	}
+	return items, nil
+}
+
+// worklistIdentifier builds the C-FIND identifier requesting the attributes of WorklistItem
+func worklistIdentifier(query WorklistQuery) *dicom.Dataset {
+	step := dicom.NewDataset()
+	step.SetString(dicom.TagModality, query.Modality)
+	step.SetString(dicom.TagScheduledStationAETitle, query.ScheduledStationAETitle)
+	step.SetString(dicom.TagScheduledProcedureStepStartDate, query.ScheduledDate)
+	step.SetString(dicom.TagScheduledProcedureStepStartTime, "")
+	step.SetString(dicom.TagScheduledProcedureStepDescription, "")
+	step.SetString(dicom.TagScheduledProcedureStepID, "")
+
+	identifier := dicom.NewDataset()
+	identifier.SetString(dicom.TagAccessionNumber, query.AccessionNumber)
+	identifier.SetString(dicom.TagPatientName, "")
+	identifier.SetString(dicom.TagPatientID, query.PatientID)
+	identifier.SetString(dicom.TagPatientBirthDate, "")
+	identifier.SetString(dicom.TagPatientSex, "")
+	identifier.SetString(dicom.TagStudyInstanceUID, "")
+	identifier.SetString(dicom.TagRequestedProcedureDescription, "")
+	identifier.SetSequence(dicom.TagScheduledProcedureStepSequence, []*dicom.Dataset{step})
+	identifier.SetString(dicom.TagRequestedProcedureID, "")
+	return identifier
+}
+
+// worklistItems converts a worklist response into one item per scheduled procedure step
+func worklistItems(ds *dicom.Dataset) []WorklistItem {
+	base := WorklistItem{
+		PatientName:                   ds.String(dicom.TagPatientName),
+		PatientID:                     ds.String(dicom.TagPatientID),
+		PatientBirthDate:              ds.String(dicom.TagPatientBirthDate),
+		PatientSex:                    ds.String(dicom.TagPatientSex),
+		AccessionNumber:               ds.String(dicom.TagAccessionNumber),
+		StudyInstanceUID:              ds.String(dicom.TagStudyInstanceUID),
+		RequestedProcedureID:          ds.String(dicom.TagRequestedProcedureID),
+		RequestedProcedureDescription: ds.String(dicom.TagRequestedProcedureDescription),
+	}
+
+	steps := ds.Sequence(dicom.TagScheduledProcedureStepSequence)
+	if len(steps) == 0 {
+		return []WorklistItem{base}
+	}
+
+	items := make([]WorklistItem, 0, len(steps))
+	for _, step := range steps {
+		item := base
+		item.ScheduledProcedureStepID = step.String(dicom.TagScheduledProcedureStepID)
+		item.ScheduledProcedureStepDescription = step.String(dicom.TagScheduledProcedureStepDescription)
+		item.ScheduledStationAETitle = step.String(dicom.TagScheduledStationAETitle)
+		item.ScheduledStartDate = step.String(dicom.TagScheduledProcedureStepStartDate)
+		item.ScheduledStartTime = step.String(dicom.TagScheduledProcedureStepStartTime)
+		item.Modality = step.String(dicom.TagModality)
+		items = append(items, item)
+	}
+	return items
+}
@@ -0,0 +1,96 @@
+package pacs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flatmapit/crgodicom/internal/dicom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindWorklistReturnsOneItemPerStep(t *testing.T) {
+	client, peer := newPipeClient(SOPClassModalityWorklistFind, dicom.ExplicitVRLittleEndian)
+	defer peer.Close()
+
+	peerErr := make(chan error, 1)
+	go func() {
+		req, err := readMessage(peer)
+		if err != nil {
+			peerErr <- err
+			return
+		}
+
+		// The matching keys are sent inside the scheduled procedure step
+		identifier, err := dicom.ParseDataset(req.Data, dicom.ExplicitVRLittleEndian)
+		if err != nil {
+			peerErr <- err
+			return
+		}
+		steps := identifier.Sequence(dicom.TagScheduledProcedureStepSequence)
+		if len(steps) != 1 || steps[0].String(dicom.TagScheduledStationAETitle) != "CT01" {
+			peerErr <- assert.AnError
+			return
+		}
+
+		match := dicom.NewDataset()
+		match.SetString(dicom.TagAccessionNumber, "ACC001")
+		match.SetString(dicom.TagPatientName, "DOE^JANE")
+		match.SetString(dicom.TagPatientID, "PID001")
+		match.SetString(dicom.TagStudyInstanceUID, "1.2.3.4")
+		match.SetString(dicom.TagRequestedProcedureDescription, "CT CHEST")
+		match.SetString(dicom.TagRequestedProcedureID, "RP001")
+		var items []*dicom.Dataset
+		for _, id := range []string{"SPS001", "SPS002"} {
+			step := dicom.NewDataset()
+			step.SetString(dicom.TagModality, "CT")
+			step.SetString(dicom.TagScheduledStationAETitle, "CT01")
+			step.SetString(dicom.TagScheduledProcedureStepID, id)
+			items = append(items, step)
+		}
+		match.SetSequence(dicom.TagScheduledProcedureStepSequence, items)
+		data, _ := dicom.EncodeDataset(match, dicom.ExplicitVRLittleEndian)
+
+		rsp := &DIMSECommand{
+			CommandField:              CommandCFindRSP,
+			MessageIDBeingRespondedTo: req.Command.MessageID,
+			AffectedSOPClass:          SOPClassModalityWorklistFind,
+			DataSetType:               DataSetPresent,
+			Status:                    StatusPending,
+		}
+		if err := writeMessage(peer, req.ContextID, rsp, data, 0); err != nil {
+			peerErr <- err
+			return
+		}
+
+		final := &DIMSECommand{
+			CommandField:              CommandCFindRSP,
+			MessageIDBeingRespondedTo: req.Command.MessageID,
+			AffectedSOPClass:          SOPClassModalityWorklistFind,
+			DataSetType:               DataSetAbsent,
+			Status:                    StatusSuccess,
+		}
+		peerErr <- writeMessage(peer, req.ContextID, final, nil, 0)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	items, err := client.FindWorklist(ctx, WorklistQuery{ScheduledStationAETitle: "CT01"})
+	assert.NoError(t, err)
+	assert.NoError(t, <-peerErr)
+
+	if assert.Len(t, items, 2) {
+		for i, id := range []string{"SPS001", "SPS002"} {
+			assert.Equal(t, "DOE^JANE", items[i].PatientName)
+			assert.Equal(t, "PID001", items[i].PatientID)
+			assert.Equal(t, "ACC001", items[i].AccessionNumber)
+			assert.Equal(t, "1.2.3.4", items[i].StudyInstanceUID)
+			assert.Equal(t, "RP001", items[i].RequestedProcedureID)
+			assert.Equal(t, "CT CHEST", items[i].RequestedProcedureDescription)
+			assert.Equal(t, "CT01", items[i].ScheduledStationAETitle)
+			assert.Equal(t, "CT", items[i].Modality)
+			assert.Equal(t, id, items[i].ScheduledProcedureStepID)
+		}
+	}
+}
@@ -18,14 +18,20 @@ var SOPClassUIDs = map[string]string{
 
 // TransferSyntaxUIDs defines common transfer syntax UIDs
 var TransferSyntaxUIDs = map[string]string{
-	"ImplicitVRLittleEndian": "1.2.840.10008.1.2",
-	"ExplicitVRLittleEndian": "1.2.840.10008.1.2.1",
+	"ImplicitVRLittleEndian":         "1.2.840.10008.1.2",
+	"ExplicitVRLittleEndian":         "1.2.840.10008.1.2.1",
+	"DeflatedExplicitVRLittleEndian": "1.2.840.10008.1.2.1.99",
+	"ExplicitVRBigEndian":            "1.2.840.10008.1.2.2",
+	"JPEGBaseline8Bit":               "1.2.840.10008.1.2.4.50",
+	"JPEGLosslessSV1":                "1.2.840.10008.1.2.4.70",
+	"JPEGLSLossless":                 "1.2.840.10008.1.2.4.80",
+	"RLELossless":                    "1.2.840.10008.1.2.5",
 }
 
 // ImageDimensions defines standard image dimensions by modality
 var ImageDimensions = map[string]ImageSize{
 	"CR": {Width: 2048, Height: 2048, BitsPerPixel: 16},
-	"CT": {Width: 512, Height: 512, BitsPerPixel: 16},
+	"CT": {Width: 512, Height: 512, BitsPerPixel: 16, BitsStored: 12},
 	"MR": {Width: 256, Height: 256, BitsPerPixel: 16},
 	"US": {Width: 640, Height: 480, BitsPerPixel: 8},
 	"DX": {Width: 2048, Height: 2048, BitsPerPixel: 16},
@@ -37,6 +43,7 @@ type ImageSize struct {
 	Width        int
 	Height       int
 	BitsPerPixel int
+	BitsStored   int // Significant bits of each pixel; 0 when all are
 }
 
 // Study represents a DICOM study
@@ -50,6 +57,12 @@ type Study struct {
 	PatientID        string
 	PatientBirthDate string
 	Series           []Series
+
+	// Scheduled procedure the study was acquired for, when created from a worklist
+	RequestedProcedureID          string
+	RequestedProcedureDescription string
+	ScheduledProcedureStepID      string
+	ScheduledStationAETitle       string
 }
 
 // Series represents a DICOM series
@@ -59,6 +72,9 @@ type Series struct {
 	Modality          string
 	SeriesDescription string
 	Images            []Image
+
+	// Frame of reference shared by the slices of CT and MR volumes
+	FrameOfReferenceUID string
 }
 
 // Image represents a DICOM image
@@ -70,7 +86,36 @@ type Image struct {
 	Width          int
 	Height         int
 	BitsPerPixel   int
+	BitsStored     int // Significant bits of each pixel; 0 when all are
 	Modality       string
+
+	// Modality LUT mapping stored values to output units such as Hounsfield
+	// units, and the window to display them in; a zero slope or width
+	// means the image has none
+	RescaleIntercept float64
+	RescaleSlope     float64
+	WindowCenter     float64
+	WindowWidth      float64
+
+	// Position of the slice in patient space; nil for projection images
+	Geometry *ImageGeometry
+}
+
+// SignificantBits returns the Bits Stored of the image
+func (i *Image) SignificantBits() int {
+	if i.BitsStored > 0 {
+		return i.BitsStored
+	}
+	return i.BitsPerPixel
+}
+
+// ImageGeometry locates a slice in the patient coordinate system (LPS, in mm)
+type ImageGeometry struct {
+	ImagePosition    [3]float64 // Center of the first pixel
+	ImageOrientation [6]float64 // Direction cosines of the rows and columns
+	PixelSpacing     [2]float64 // Spacing between rows, then between columns
+	SliceThickness   float64
+	SliceLocation    float64
 }
 
 // PatientInfo represents patient information
@@ -140,6 +185,17 @@ type StudyParams struct {
 	StudyDescription string
 	OutputDir        string
 	Template         interface{} // Template configuration
+	TemplateName     string      // Name of the template, recorded in the study manifest
+
+	// Transfer syntax of the written files; Implicit VR Little Endian when empty
+	TransferSyntaxUID string
+
+	// Worklist attributes; StudyInstanceUID is generated when empty
+	StudyInstanceUID              string
+	RequestedProcedureID          string
+	RequestedProcedureDescription string
+	ScheduledProcedureStepID      string
+	ScheduledStationAETitle       string
 }
 
 // ValidationError represents a DICOM validation error